@@ -1,6 +1,7 @@
 package core
 
 import (
+	"go-ray-tracing/tracer"
 	"math"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
@@ -8,6 +9,15 @@ import (
 
 type PerspectiveCamera struct {
 	Camera rl.Camera3D
+
+	// Physical parameters, see tracer.PhysicalCamera. The tracer renders depth of
+	// field from the aperture and both views apply the exposure. The focal length
+	// follows from Camera.Fovy and the sensor, see SetFocalLength.
+	SensorHeight  float32 // mm
+	FStop         float32 // 0 is a pinhole
+	FocusDistance float32 // 0 focuses on Camera.Target
+	ShutterTime   float32 // seconds
+	ISO           float32
 }
 
 func NewPerspectiveCamera() *PerspectiveCamera {
@@ -21,9 +31,42 @@ func NewPerspectiveCamera() *PerspectiveCamera {
 
 	camera_3d.Camera = camera
 
+	physical := tracer.DefaultPhysicalCamera()
+	camera_3d.SensorHeight = float32(physical.SensorHeight)
+	camera_3d.FStop = float32(physical.FStop)
+	camera_3d.ShutterTime = float32(physical.ShutterTime)
+	camera_3d.ISO = float32(physical.ISO)
+
 	return &camera_3d
 }
 
+// FocalLength is the lens focal length in mm giving the current field of view.
+func (c *PerspectiveCamera) FocalLength() float32 {
+	return float32(tracer.FocalLengthFromFovy(float64(c.Camera.Fovy), float64(c.SensorHeight)))
+}
+
+// SetFocalLength changes the lens, which changes the field of view.
+func (c *PerspectiveCamera) SetFocalLength(mm float32) {
+	c.Camera.Fovy = float32(2 * math.Atan(float64(c.SensorHeight/(2*mm))) * 180 / math.Pi)
+}
+
+// Physical returns the camera's physical parameters.
+func (c *PerspectiveCamera) Physical() tracer.PhysicalCamera {
+	return tracer.PhysicalCamera{
+		FocalLength:   float64(c.FocalLength()),
+		SensorHeight:  float64(c.SensorHeight),
+		FStop:         float64(c.FStop),
+		FocusDistance: float64(c.FocusDistance),
+		ShutterTime:   float64(c.ShutterTime),
+		ISO:           float64(c.ISO),
+	}
+}
+
+// Exposure is the exposure in stops relative to sunny 16.
+func (c *PerspectiveCamera) Exposure() float32 {
+	return float32(c.Physical().ExposureStops())
+}
+
 func UpdateCameraManually(cam *rl.Camera3D, speed float32, rotSpeed float32) {
 	dt := rl.GetFrameTime()
 
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"go-ray-tracing/imageio"
+	"go-ray-tracing/materials"
+	"go-ray-tracing/tracer"
+	"math"
+)
+
+// Environment is an HDR image surrounding the scene. The tracer importance samples
+// it as a light and the raster view shows it as a skybox.
+type Environment struct {
+	Path      string
+	Map       *tracer.EnvironmentMap
+	Rotation  float32 // degrees about +Y
+	Intensity float32
+
+	skybox *materials.Skybox // uploaded on first draw, on the GL thread
+
+	// Map converted into the working space, kept so the traced view sees the same
+	// map every frame
+	spaceMap *tracer.EnvironmentMap
+	space    imageio.ColorSpace
+}
+
+// LoadEnvironment reads an equirectangular .hdr, .exr or .pfm image.
+func LoadEnvironment(path string) (*Environment, error) {
+	m, err := tracer.LoadEnvironmentMap(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Environment{Path: path, Map: m, Intensity: 1}, nil
+}
+
+// SetEnvironment replaces the scene's environment and releases the old skybox.
+func (s *Scene3D) SetEnvironment(env *Environment) {
+	if s.Environment != nil && s.Environment != env {
+		s.Environment.Unload()
+	}
+	s.Environment = env
+}
+
+// Draw renders the environment as a skybox around the camera, converted into the
+// working space.
+func (e *Environment) Draw(camera *PerspectiveCamera, space imageio.ColorSpace) {
+	if e.skybox == nil {
+		e.skybox = materials.NewSkybox(e.Map.Width, e.Map.Height, e.Map.RGB())
+	}
+	exposure := float32(math.Exp2(float64(camera.Exposure())))
+	e.skybox.Draw(camera.Camera.Position, e.Rotation, e.Intensity*exposure, space.FromLinearSRGB())
+}
+
+// mapIn returns the environment map converted into a working space.
+func (e *Environment) mapIn(space imageio.ColorSpace) *tracer.EnvironmentMap {
+	if e.spaceMap == nil || e.space != space {
+		e.spaceMap, e.space = e.Map.InSpace(space), space
+	}
+	return e.spaceMap
+}
+
+// Unload releases the skybox's GPU resources. The environment can still be drawn,
+// it uploads again.
+func (e *Environment) Unload() {
+	if e.skybox != nil {
+		e.skybox.Unload()
+		e.skybox = nil
+	}
+}
+
+// tracerEnvironment returns the tracer light for the scene's environment, or nil.
+func (s *Scene3D) tracerEnvironment() *tracer.EnvironmentLight {
+	if s.Environment == nil {
+		return nil
+	}
+	light := tracer.NewEnvironmentLight(s.Environment.mapIn(s.Display.WorkingSpace))
+	light.Rotation = float64(s.Environment.Rotation)
+	light.Intensity = float64(s.Environment.Intensity)
+	return light
+}
+
+func sameEnvironment(a, b *tracer.EnvironmentLight) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
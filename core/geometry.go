@@ -1,6 +1,9 @@
 package core
 
 import (
+	"go-ray-tracing/imageio"
+	"go-ray-tracing/materials"
+	"go-ray-tracing/tracer"
 	"math"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
@@ -23,6 +26,19 @@ type Geometry struct {
 	Axis          rl.Vector3 // Rotation axis (usually 0, 1, 0 for Y-up)
 	UseQuaternion bool       // Flag to determine which rotation to use
 	Visibility    bool
+	MeshVersion   int              // Bumped whenever the model's mesh data is replaced
+	Surface       *tracer.Material // PBR material shared by the raster shader and the tracer
+
+	// ShutterOpen is where the geometry was when the shutter opened. The tracer blurs
+	// it from there to its current pose. Nil means it stands still.
+	ShutterOpen *GeometryTransform
+
+	// Interior is the medium the tracer fills the geometry with, which needs a closed
+	// mesh. NullSurface hides the surface so only the medium is seen, the raster view
+	// does not draw it at all and the debug view outlines it. Replace the
+	// medium rather than editing it so the traced view notices.
+	Interior    tracer.Medium
+	NullSurface bool
 }
 
 func NewGeometry(model *rl.Model, name string) *Geometry {
@@ -36,6 +52,7 @@ func NewGeometry(model *rl.Model, name string) *Geometry {
 		Axis:          rl.NewVector3(0, 1, 0),
 		UseQuaternion: false,
 		Visibility:    true,
+		Surface:       tracer.DefaultMaterial(),
 	}
 	return &geom
 }
@@ -52,6 +69,7 @@ func NewSphereGeometry() *Geometry {
 		Axis:          rl.NewVector3(0, 1, 0),
 		UseQuaternion: false,
 		Visibility:    true,
+		Surface:       tracer.DefaultMaterial(),
 	}
 	return &geom
 }
@@ -68,6 +86,7 @@ func NewPlaneGeometry() *Geometry {
 		Axis:          rl.NewVector3(0, 1, 0),
 		UseQuaternion: false,
 		Visibility:    true,
+		Surface:       tracer.DefaultMaterial(),
 	}
 	return &geom
 }
@@ -86,6 +105,29 @@ func (g *Geometry) SetScale(x, y, z float32) {
 	g.Scale = rl.NewVector3(x, y, z)
 }
 
+func (g *Geometry) SetSurface(m *tracer.Material) {
+	g.Surface = m
+}
+
+// SurfaceParams converts the geometry's material into the default shader's uniforms,
+// with colors in the working space.
+func (g *Geometry) SurfaceParams(space imageio.ColorSpace) materials.SurfaceParams {
+	m := g.tracerMaterial(space)
+	emission := m.Emitted()
+	// The shader has no complex Fresnel, conductor presets use their reflectance at
+	// normal incidence as the Schlick base of the metal part
+	metalColor := m.MetalColor()
+	return materials.SurfaceParams{
+		BaseColor:    [3]float32{float32(m.BaseColor.X), float32(m.BaseColor.Y), float32(m.BaseColor.Z)},
+		MetalColor:   [3]float32{float32(metalColor.X), float32(metalColor.Y), float32(metalColor.Z)},
+		Metallic:     float32(m.Metallic),
+		Roughness:    float32(m.Roughness),
+		Emission:     [3]float32{float32(emission.X), float32(emission.Y), float32(emission.Z)},
+		IOR:          float32(m.IOR),
+		Transmission: float32(m.Transmission),
+	}
+}
+
 func (g *Geometry) SetAxis(x, y, z float32) {
 	g.Axis = rl.NewVector3(x, y, z)
 }
@@ -150,7 +192,7 @@ func (g *Geometry) Cleanup() {
 }
 
 func (geom *Geometry) Draw() {
-	if geom.Visibility {
+	if geom.Visibility && !geom.NullSurface {
 		if geom.UseQuaternion {
 			// Convert quaternion to axis-angle for drawing
 			axis, angle := QuaternionToAxisAngle(geom.Quaternion)
@@ -203,3 +245,22 @@ func QuaternionToAxisAngle(q rl.Vector4) (rl.Vector3, float32) {
 		z/s,
 	), angle * rl.Rad2deg
 }
+
+// AxisAngle returns the rotation Draw applies, in degrees.
+func (geom *Geometry) AxisAngle() (rl.Vector3, float32) {
+	if geom.UseQuaternion {
+		return QuaternionToAxisAngle(geom.Quaternion)
+	}
+	return geom.Axis, geom.Rotation.Y
+}
+
+// TransformMatrix returns the model matrix Draw uses, scale then rotation then
+// translation, applied after the model's own transform.
+func (geom *Geometry) TransformMatrix() rl.Matrix {
+	axis, angle := geom.AxisAngle()
+	matScale := rl.MatrixScale(geom.Scale.X, geom.Scale.Y, geom.Scale.Z)
+	matRotation := rl.MatrixRotate(axis, angle*rl.Deg2rad)
+	matTranslation := rl.MatrixTranslate(geom.Position.X, geom.Position.Y, geom.Position.Z)
+	transform := rl.MatrixMultiply(rl.MatrixMultiply(matScale, matRotation), matTranslation)
+	return rl.MatrixMultiply(geom.Model.Transform, transform)
+}
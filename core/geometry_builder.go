@@ -89,5 +89,34 @@ func UpdateGeometryFromMeshData(geom *Geometry, data *GeoData) {
 
 	// Update geometry
 	geom.Model = model
+	geom.MeshVersion++
 	fmt.Printf("Updated Geometry with received mesh data : %v\n", geom.Name)
 }
+
+// ComputeNormals replaces the normals with smooth ones averaged from the triangles
+// around each vertex, weighted by area. With a consistent winding they point the
+// same way as the tracer's geometric normals, which closed meshes filled with a
+// medium or a subsurface material rely on.
+func (data *GeoData) ComputeNormals() {
+	normals := make([]rl.Vector3, len(data.Vertices))
+	for i := 0; i+2 < len(data.Indices); i += 3 {
+		a, b, c := data.Indices[i], data.Indices[i+1], data.Indices[i+2]
+		if min(a, b, c) < 0 || int(max(a, b, c)) >= len(data.Vertices) {
+			continue
+		}
+		p0, p1, p2 := data.Vertices[a], data.Vertices[b], data.Vertices[c]
+		// The cross product's length is twice the area
+		n := rl.Vector3CrossProduct(rl.Vector3Subtract(p1, p0), rl.Vector3Subtract(p2, p0))
+		for _, v := range [3]int32{a, b, c} {
+			normals[v] = rl.Vector3Add(normals[v], n)
+		}
+	}
+	for i, n := range normals {
+		if rl.Vector3Length(n) > 0 {
+			normals[i] = rl.Vector3Normalize(n)
+		} else {
+			normals[i] = rl.NewVector3(0, 1, 0)
+		}
+	}
+	data.Normals = normals
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"go-ray-tracing/tracer"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// GeometryTransform is one pose of a geometry, used as a motion blur keyframe.
+type GeometryTransform struct {
+	Position   rl.Vector3
+	Quaternion rl.Vector4 // (x, y, z, w)
+	Scale      rl.Vector3
+}
+
+// Pose returns the geometry's current transform. Euler rotations are converted to a
+// quaternion.
+func (g *Geometry) Pose() GeometryTransform {
+	q := g.Quaternion
+	if !g.UseQuaternion {
+		q = rl.QuaternionFromAxisAngle(rl.Vector3Normalize(g.Axis), g.Rotation.Y*rl.Deg2rad)
+	}
+	return GeometryTransform{Position: g.Position, Quaternion: q, Scale: g.Scale}
+}
+
+// SetPose moves the geometry without touching its motion blur.
+func (g *Geometry) SetPose(t GeometryTransform) {
+	g.Position = t.Position
+	g.SetRotationFromQuaternion(t.Quaternion)
+	g.Scale = t.Scale
+}
+
+// SetShutterTransforms blurs the geometry from open to close while the tracer's
+// shutter is open. The viewport draws it at close.
+func (g *Geometry) SetShutterTransforms(open, close GeometryTransform) {
+	g.ShutterOpen = &open
+	g.SetPose(close)
+}
+
+// PushTransform moves the geometry to t, keeping where it was as the shutter open
+// pose. Feeding it every incoming transform blurs along the last step.
+func (g *Geometry) PushTransform(t GeometryTransform) {
+	g.SetShutterTransforms(g.Pose(), t)
+}
+
+// ClearMotion stops the geometry from blurring.
+func (g *Geometry) ClearMotion() {
+	g.ShutterOpen = nil
+}
+
+// IsMoving reports whether the tracer blurs the geometry.
+func (g *Geometry) IsMoving() bool {
+	return g.ShutterOpen != nil && *g.ShutterOpen != g.Pose()
+}
+
+// placeObject puts a tracer object where the geometry is, with motion blur when it moves.
+func (g *Geometry) placeObject(obj *tracer.Object) {
+	if !g.IsMoving() {
+		obj.SetTransform(MatrixToTracer(g.TransformMatrix()))
+		return
+	}
+	obj.SetMotion(g.ShutterOpen.tracer(), g.Pose().tracer(), MatrixToTracer(g.Model.Transform))
+}
+
+func (t GeometryTransform) tracer() tracer.TRS {
+	q := t.Quaternion
+	return tracer.TRS{
+		Translation: Vector3ToTracer(t.Position),
+		Rotation:    tracer.Quat{X: float64(q.X), Y: float64(q.Y), Z: float64(q.Z), W: float64(q.W)},
+		Scale:       Vector3ToTracer(t.Scale),
+	}
+}
+
+// tracePlacement is what a traceEntry compares to notice that a geometry moved.
+type tracePlacement struct {
+	transform   rl.Matrix
+	shutterOpen GeometryTransform
+	moving      bool
+}
+
+func (g *Geometry) placement() tracePlacement {
+	p := tracePlacement{transform: g.TransformMatrix(), moving: g.IsMoving()}
+	if p.moving {
+		p.shutterOpen = *g.ShutterOpen
+	}
+	return p
+}
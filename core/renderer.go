@@ -1,25 +1,81 @@
 package core
 
 import (
+	"fmt"
+	"go-ray-tracing/materials"
+	"sort"
+
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
 
+// Renderer is a backend that draws a Scene3D. The main loop only talks to this
+// interface, so a scene can swap backends at runtime with Scene3D.SetRenderer.
+type Renderer interface {
+	// Name is the key the renderer is registered under.
+	Name() string
+	// Init is called when the renderer becomes the scene's active renderer.
+	Init(scene *Scene3D)
+	// RunPreRenderProcess runs every frame before BeginDrawing (offscreen passes).
+	RunPreRenderProcess(scene *Scene3D)
+	// Render draws the frame between BeginDrawing and EndDrawing.
+	Render(scene *Scene3D)
+	// RunPostRenderProcess releases what Init allocated. It is called when the
+	// renderer is swapped out and once more when the main loop exits.
+	RunPostRenderProcess(scene *Scene3D)
+}
+
+var rendererFactories = make(map[string]func() Renderer)
+
+// RegisterRenderer makes a renderer available to NewRendererByName and Scene3D.SetRenderer.
+func RegisterRenderer(name string, factory func() Renderer) {
+	rendererFactories[name] = factory
+}
+
+func NewRendererByName(name string) (Renderer, error) {
+	factory, ok := rendererFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown renderer %q", name)
+	}
+	return factory(), nil
+}
+
+// RendererNames lists the registered renderers in a stable order.
+func RendererNames() []string {
+	names := make([]string, 0, len(rendererFactories))
+	for name := range rendererFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterRenderer("raster", func() Renderer { return NewRenderer() })
+}
+
+// Renderer3D is the raylib rasterizer with a shadow-map pass. Lighting is drawn into
+// a float target and reaches the screen through the scene's display transform.
 type Renderer3D struct {
+	post *materials.PostProcess
 }
 
 func NewRenderer() *Renderer3D {
 	return &Renderer3D{}
 }
 
-func (r *Renderer3D) CalculateLighting(scene *Scene3D) {
-	// Update light uniforms with camera position
-	scene.Material.UpdateLightUniforms(scene.Camera.Camera.Position)
+func (r *Renderer3D) Name() string {
+	return "raster"
+}
 
-	// Get shader uniform locations
-	objColorLoc := rl.GetShaderLocation(*scene.DefaultShader, "objectColor")
+func (r *Renderer3D) Init(scene *Scene3D) {
+	r.post = materials.NewPostProcess()
+}
 
-	// Set object color (you might want to make this per-object)
-	rl.SetShaderValue(*scene.DefaultShader, objColorLoc, []float32{0.7, 0.7, 0.7}, rl.ShaderUniformVec3)
+func (r *Renderer3D) CalculateLighting(scene *Scene3D) {
+	// Update light uniforms with camera position
+	sunDir := rl.Vector3Subtract(scene.LightCamera.Target, scene.LightCamera.Position)
+	scene.Material.UpdateLightUniforms(scene.Camera.Camera.Position, sunDir)
+	scene.Material.SetExposure(scene.Camera.Exposure())
 
 	// Bind shadow map texture
 	shadowMapLoc := rl.GetShaderLocation(*scene.DefaultShader, "shadowMap")
@@ -31,27 +87,41 @@ func (r *Renderer3D) RunPreRenderProcess(scene *Scene3D) {
 	scene.Material.UpdateLightCamera(scene.LightCamera.Position, scene.LightCamera.Target)
 
 	// PASS 1: Render shadow map from light's perspective
-	scene.Renderer.RenderShadowMap(scene)
+	r.RenderShadowMap(scene)
 }
 
 func (r *Renderer3D) RunPostRenderProcess(scene *Scene3D) {
-	rl.UnloadShader(*scene.DefaultShader)
-	for _, geom := range scene.Geometries {
-		geom.Cleanup()
+	// The shaders and shadow map belong to the scene material, only the post-process
+	// is ours
+	if r.post != nil {
+		r.post.Unload()
+		r.post = nil
 	}
 }
 
+// rasterBackground is rl.DarkGray decoded from sRGB, so it looks the same once the
+// display transform encodes it again.
+var rasterBackground = rl.NewColor(20, 20, 20, 255)
+
 func (r *Renderer3D) Render(scene *Scene3D) {
+	r.post.Begin(int32(rl.GetScreenWidth()), int32(rl.GetScreenHeight()), rasterBackground)
 	rl.BeginMode3D(scene.Camera.Camera)
+	if scene.Environment != nil {
+		scene.Environment.Draw(scene.Camera, scene.Display.WorkingSpace)
+	}
 
-	scene.Renderer.CalculateLighting(scene)
+	r.CalculateLighting(scene)
 	rl.DrawGrid(20, 10.0)
 
 	for _, geom := range scene.Geometries {
+		// Surface uniforms are per object
+		scene.Material.SetSurface(geom.SurfaceParams(scene.Display.WorkingSpace))
 		geom.Draw()
 	}
 	rl.EndMode3D()
+	r.post.End()
 
+	r.post.Draw(scene.Display)
 }
 
 func (r *Renderer3D) RenderShadowMap(scene *Scene3D) {
@@ -66,7 +136,7 @@ func (r *Renderer3D) RenderShadowMap(scene *Scene3D) {
 	rl.SetShaderValueMatrix(scene.Material.DepthShader, lightSpaceLoc, scene.Material.LightSpaceMatrix)
 	// Simple rendering for shadow map - just draw the models
 	for _, geom := range scene.Geometries {
-		if geom.Visibility {
+		if geom.Visibility && !geom.NullSurface {
 			// Set model matrix for depth shader
 			modelMatrix := rl.MatrixIdentity()
 			modelMatrix = rl.MatrixMultiply(modelMatrix, rl.MatrixTranslate(geom.Position.X, geom.Position.Y, geom.Position.Z))
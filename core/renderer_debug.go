@@ -0,0 +1,92 @@
+package core
+
+import (
+	"go-ray-tracing/tracer"
+	"math"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+func init() {
+	RegisterRenderer("debug", func() Renderer { return NewDebugRenderer() })
+}
+
+// DebugRenderer draws wireframes, pivots and the light camera without any shading.
+type DebugRenderer struct {
+}
+
+func NewDebugRenderer() *DebugRenderer {
+	return &DebugRenderer{}
+}
+
+func (r *DebugRenderer) Name() string {
+	return "debug"
+}
+
+func (r *DebugRenderer) Init(scene *Scene3D) {
+}
+
+func (r *DebugRenderer) RunPreRenderProcess(scene *Scene3D) {
+}
+
+func (r *DebugRenderer) Render(scene *Scene3D) {
+	rl.BeginMode3D(scene.Camera.Camera)
+	rl.DrawGrid(20, 10.0)
+
+	for _, geom := range scene.Geometries {
+		if !geom.Visibility {
+			continue
+		}
+		axis, angle := geom.AxisAngle()
+		rl.DrawModelWiresEx(geom.Model, geom.Position, axis, angle, geom.Scale, rl.Green)
+		rl.DrawSphere(geom.Position, 0.05, rl.Red)
+	}
+
+	// Light camera position and direction
+	rl.DrawSphere(scene.LightCamera.Position, 0.15, rl.Yellow)
+	rl.DrawLine3D(scene.LightCamera.Position, scene.LightCamera.Target, rl.Yellow)
+
+	for _, light := range scene.Lights {
+		drawLightOutline(light)
+	}
+	rl.EndMode3D()
+}
+
+// drawLightOutline draws an area light's shape and the direction it faces.
+func drawLightOutline(light tracer.Light) {
+	toRl := func(v tracer.Vec3) rl.Vector3 {
+		return rl.NewVector3(float32(v.X), float32(v.Y), float32(v.Z))
+	}
+	switch l := light.(type) {
+	case *tracer.QuadLight:
+		p0 := l.Corner
+		p1 := p0.Add(l.Edge1)
+		p2 := p1.Add(l.Edge2)
+		p3 := p0.Add(l.Edge2)
+		rl.DrawLine3D(toRl(p0), toRl(p1), rl.Yellow)
+		rl.DrawLine3D(toRl(p1), toRl(p2), rl.Yellow)
+		rl.DrawLine3D(toRl(p2), toRl(p3), rl.Yellow)
+		rl.DrawLine3D(toRl(p3), toRl(p0), rl.Yellow)
+		center := p0.Add(p2).Scale(0.5)
+		normal := l.Edge1.Cross(l.Edge2).Normalize()
+		rl.DrawLine3D(toRl(center), toRl(center.Add(normal.Scale(0.5))), rl.Yellow)
+	case *tracer.DiskLight:
+		// DrawCircle3D draws in the XY plane, rotate +Z onto the disk normal
+		axis := tracer.NewVec3(0, 0, 1).Cross(l.Normal)
+		angle := math.Acos(clampUnit(l.Normal.Z)) * 180 / math.Pi
+		if axis.IsBlack() {
+			axis = tracer.NewVec3(1, 0, 0)
+		}
+		rl.DrawCircle3D(toRl(l.Center), float32(l.Radius), toRl(axis.Normalize()), float32(angle), rl.Yellow)
+		rl.DrawLine3D(toRl(l.Center), toRl(l.Center.Add(l.Normal.Scale(0.5))), rl.Yellow)
+	case *tracer.SphereLight:
+		rl.DrawSphereWires(toRl(l.Center), float32(l.Radius), 8, 8, rl.Yellow)
+	}
+}
+
+func clampUnit(v float64) float64 {
+	return math.Max(-1, math.Min(1, v))
+}
+
+func (r *DebugRenderer) RunPostRenderProcess(scene *Scene3D) {
+}
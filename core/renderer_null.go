@@ -0,0 +1,38 @@
+package core
+
+func init() {
+	RegisterRenderer("null", func() Renderer { return NewNullRenderer() })
+}
+
+// NullRenderer draws nothing and only counts lifecycle calls. Useful for tests and
+// for running a scene without a GL context.
+type NullRenderer struct {
+	InitCalls       int
+	PreRenderCalls  int
+	RenderCalls     int
+	PostRenderCalls int
+}
+
+func NewNullRenderer() *NullRenderer {
+	return &NullRenderer{}
+}
+
+func (r *NullRenderer) Name() string {
+	return "null"
+}
+
+func (r *NullRenderer) Init(scene *Scene3D) {
+	r.InitCalls++
+}
+
+func (r *NullRenderer) RunPreRenderProcess(scene *Scene3D) {
+	r.PreRenderCalls++
+}
+
+func (r *NullRenderer) Render(scene *Scene3D) {
+	r.RenderCalls++
+}
+
+func (r *NullRenderer) RunPostRenderProcess(scene *Scene3D) {
+	r.PostRenderCalls++
+}
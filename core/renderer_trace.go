@@ -0,0 +1,364 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"go-ray-tracing/imageio"
+	"go-ray-tracing/tracer"
+	"image/color"
+	"slices"
+	"sync"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+func init() {
+	RegisterRenderer("traced", func() Renderer { return NewTraceRenderer() })
+}
+
+// TraceRenderer is a progressive ray-traced viewport. Passes of a few samples per
+// pixel render in the background as tracer.RenderJobs and are averaged into an
+// accumulation buffer, which is streamed into a texture tile by tile. Accumulation
+// starts over when the camera or light moves or live-link updates change the
+// geometry. With Denoise set the passes also collect the denoiser's guide AOVs and
+// the accumulated image is run through tracer.Denoise every few passes, on the
+// render goroutine; N toggles it to compare with the raw image. B switches between
+// the path tracer and the bidirectional one, C turns photon-mapped caustics on and
+// off for the path tracer. Pixels reach the screen through the
+// scene's display transform, like the raster view.
+type TraceRenderer struct {
+	Downscale      int // window pixels per traced pixel
+	MaxPasses      int // stop refining after this many passes
+	Options        tracer.Options
+	Denoise        bool
+	DenoiseOptions tracer.DenoiseOptions
+
+	texture rl.Texture2D
+	pixels  []color.RGBA
+	width   int
+	height  int
+	display imageio.DisplayTransform // transform the pixels were made with
+
+	mutex    sync.Mutex // guards accum, guides, denoised, pixels and dirty while a pass runs
+	accum    *tracer.Accumulator
+	guides   map[tracer.AOV]*tracer.Accumulator // albedo, normal and depth for the denoiser
+	denoised *tracer.Image                      // last denoised image, nil when out of date
+	dirty    bool                               // pixels changed since the last texture upload
+	pass     int
+	cancel   context.CancelFunc
+	done     chan struct{}
+	camera   PerspectiveCamera // camera the accumulated passes were rendered from
+	light    rl.Camera3D       // light camera the accumulated passes were rendered with
+	// integrator the accumulated passes were rendered with
+	integrator tracer.Integrator
+	// photon map of the accumulated passes, traced by the first pass after a reset
+	caustics *tracer.PhotonMap
+}
+
+// viewportPhotons are traced when C turns caustics on. The map is kept until the
+// view changes, so it can afford more photons than a single pass would.
+const viewportPhotons = 100000
+
+func NewTraceRenderer() *TraceRenderer {
+	opts := tracer.DefaultOptions()
+	opts.Samples = 1
+	opts.MaxDepth = 5
+	return &TraceRenderer{
+		Downscale:      2,
+		MaxPasses:      1024,
+		Options:        opts,
+		DenoiseOptions: tracer.DefaultDenoiseOptions(),
+	}
+}
+
+// guideAOVs are the passes the denoiser is guided by, collected while Denoise is set.
+var guideAOVs = []tracer.AOV{tracer.AOVAlbedo, tracer.AOVNormal, tracer.AOVDepth}
+
+func (r *TraceRenderer) Name() string {
+	return "traced"
+}
+
+func (r *TraceRenderer) Init(scene *Scene3D) {
+}
+
+func (r *TraceRenderer) RunPreRenderProcess(scene *Scene3D) {
+	width := max(rl.GetScreenWidth()/r.Downscale, 1)
+	height := max(rl.GetScreenHeight()/r.Downscale, 1)
+	resized := width != r.width || height != r.height
+	if rl.IsKeyPressed(rl.KeyN) {
+		r.SetDenoise(!r.Denoise)
+	}
+	if rl.IsKeyPressed(rl.KeyB) {
+		if r.Options.Integrator == tracer.IntegratorPath {
+			r.Options.Integrator = tracer.IntegratorBidirectional
+		} else {
+			r.Options.Integrator = tracer.IntegratorPath
+		}
+	}
+	toggled := false
+	if rl.IsKeyPressed(rl.KeyC) {
+		if r.Options.Photons > 0 {
+			r.Options.Photons = 0
+		} else {
+			r.Options.Photons = viewportPhotons
+		}
+		toggled = true
+	}
+	changed := *scene.Camera != r.camera || scene.LightCamera != r.light || r.Options.Integrator != r.integrator ||
+		toggled || scene.TraceSceneDirty()
+	if scene.Display != r.display {
+		r.SetDisplay(scene.Display)
+	}
+
+	if resized || changed {
+		r.stop()
+		if resized {
+			r.resize(width, height)
+		}
+		// The old image stays on screen until the new pass overwrites it
+		r.accum.Reset()
+		for _, g := range r.guides {
+			g.Reset()
+		}
+		r.denoised = nil
+		r.caustics = nil
+		r.pass = 0
+		r.camera, r.light, r.integrator = *scene.Camera, scene.LightCamera, r.Options.Integrator
+	}
+
+	// The tracer scene can only be synced while no pass is reading it
+	if !r.isRunning() && r.pass < r.MaxPasses {
+		ts, _ := scene.SyncTraceScene()
+		r.startPass(ts)
+	}
+
+	r.mutex.Lock()
+	if r.dirty {
+		rl.UpdateTexture(r.texture, r.pixels)
+		r.dirty = false
+	}
+	r.mutex.Unlock()
+}
+
+func (r *TraceRenderer) Render(scene *Scene3D) {
+	src := rl.NewRectangle(0, 0, float32(r.width), float32(r.height))
+	dst := rl.NewRectangle(0, 0, float32(rl.GetScreenWidth()), float32(rl.GetScreenHeight()))
+	rl.DrawTexturePro(r.texture, src, dst, rl.NewVector2(0, 0), 0, rl.White)
+
+	status := fmt.Sprintf("Traced: %d spp", r.pass*r.Options.Samples)
+	if r.Options.Integrator != tracer.IntegratorPath {
+		status += fmt.Sprintf(" (%v)", r.Options.Integrator)
+	}
+	if r.Options.Photons > 0 && r.Options.Integrator == tracer.IntegratorPath {
+		status += " (caustics)"
+	}
+	if r.Denoise {
+		status += " (denoised)"
+	}
+	rl.DrawText(status, int32(rl.GetScreenWidth())-rl.MeasureText(status, 16)-10, 10, 16, rl.White)
+}
+
+func (r *TraceRenderer) RunPostRenderProcess(scene *Scene3D) {
+	r.stop()
+	if r.texture.ID != 0 {
+		rl.UnloadTexture(r.texture)
+		r.texture = rl.Texture2D{}
+	}
+	r.width, r.height = 0, 0
+	r.pass = 0
+}
+
+// startPass renders one more pass in the background. Each pass continues the
+// sample sequence of the ones before, stratified over all MaxPasses passes, so the
+// accumulated image converges like a single render of that many samples. The photon
+// map is traced by the first pass and reused by the later ones.
+func (r *TraceRenderer) startPass(ts *tracer.Scene) {
+	opts := r.Options
+	opts.Width, opts.Height = r.width, r.height
+	opts.FirstSample = r.pass * opts.Samples
+	opts.SampleTarget = r.MaxPasses * opts.Samples
+	if r.Denoise {
+		opts.AOVs = slices.Concat(opts.AOVs, guideAOVs)
+	}
+	r.pass++
+	pass := r.pass
+
+	job := tracer.NewRenderJob(ts, opts)
+	job.Caustics = r.caustics
+	job.OnProgress = func(p tracer.TileProgress) {
+		r.storeTile(p, job.AOVs, opts.Samples)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	r.cancel, r.done = cancel, done
+
+	go func() {
+		defer close(done)
+		_, err := job.Run(ctx)
+		// Only read after done is closed
+		r.caustics = job.Caustics
+		if err == nil && r.Denoise && r.denoisePass(pass) {
+			r.showDenoised()
+		}
+	}()
+}
+
+// denoisePass tells whether to denoise after a pass. Early passes change the image a
+// lot, later ones are denoised less often so most of the time goes into sampling.
+func (r *TraceRenderer) denoisePass(pass int) bool {
+	return pass&(pass-1) == 0 || pass%64 == 0 || pass == r.MaxPasses
+}
+
+// SetDenoise switches between the denoised and the raw image. The running pass is
+// stopped so the display can be rebuilt from the accumulated samples right away.
+// Guides are only collected from then on, until they cover the frame the image is
+// denoised without them.
+func (r *TraceRenderer) SetDenoise(on bool) {
+	r.stop()
+	r.Denoise = on
+	r.denoised = nil
+	r.redisplay()
+}
+
+// SetDisplay changes the display transform, redrawing the accumulated samples with
+// it. The running pass is stopped like in SetDenoise.
+func (r *TraceRenderer) SetDisplay(d imageio.DisplayTransform) {
+	r.stop()
+	r.display = d
+	r.redisplay()
+}
+
+// redisplay rebuilds the display pixels from the accumulated samples, or from the
+// denoised image when denoising. An image that still has to be denoised is filtered
+// in the background like a pass, so the window keeps responding. It must not run
+// while a pass is adding tiles.
+func (r *TraceRenderer) redisplay() {
+	if r.accum == nil {
+		return
+	}
+	if r.Denoise && r.denoised == nil {
+		done := make(chan struct{})
+		r.cancel, r.done = func() {}, done
+		go func() {
+			defer close(done)
+			r.showDenoised()
+		}()
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			c := r.accum.At(x, y)
+			if r.Denoise {
+				c = r.denoised.At(x, y)
+			}
+			r.pixels[y*r.width+x] = r.displayColor(c)
+		}
+	}
+	r.dirty = true
+}
+
+// showDenoised denoises the accumulated image into the display pixels. It runs on
+// the render goroutine and must not run while a pass is adding tiles.
+func (r *TraceRenderer) showDenoised() {
+	r.mutex.Lock()
+	color := r.accum.Image()
+	albedo, normal, depth := r.guide(tracer.AOVAlbedo), r.guide(tracer.AOVNormal), r.guide(tracer.AOVDepth)
+	r.mutex.Unlock()
+
+	img := tracer.Denoise(color, albedo, normal, depth, r.DenoiseOptions)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.denoised = img
+	for i, c := range img.Pix {
+		r.pixels[i] = r.displayColor(c)
+	}
+	r.dirty = true
+}
+
+// stop cancels the running pass and waits for its workers to exit.
+func (r *TraceRenderer) stop() {
+	if r.done == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+	r.cancel, r.done = nil, nil
+}
+
+func (r *TraceRenderer) isRunning() bool {
+	if r.done == nil {
+		return false
+	}
+	select {
+	case <-r.done:
+		r.cancel()
+		r.cancel, r.done = nil, nil
+		return false
+	default:
+		return true
+	}
+}
+
+// guide returns the accumulated AOV, nil until every pixel has samples of it.
+func (r *TraceRenderer) guide(a tracer.AOV) *tracer.Image {
+	g := r.guides[a]
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			if g.Samples(x, y) == 0 {
+				return nil
+			}
+		}
+	}
+	return g.Image()
+}
+
+// storeTile runs on a worker goroutine for every finished tile. When denoising the
+// display is only updated once the pass is done.
+func (r *TraceRenderer) storeTile(p tracer.TileProgress, aovs *tracer.AOVBuffers, samples int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.accum.AddTile(p.Image, p.Tile, samples)
+	if aovs != nil {
+		for a, g := range r.guides {
+			if layer := aovs.Layers[a]; layer != nil {
+				g.AddTile(layer, p.Tile, samples)
+			}
+		}
+	}
+	if r.Denoise {
+		return
+	}
+	for y := p.Tile.Y0; y < p.Tile.Y1; y++ {
+		for x := p.Tile.X0; x < p.Tile.X1; x++ {
+			r.pixels[y*r.width+x] = r.displayColor(r.accum.At(x, y))
+		}
+	}
+	r.dirty = true
+}
+
+func (r *TraceRenderer) resize(width, height int) {
+	if r.texture.ID != 0 {
+		rl.UnloadTexture(r.texture)
+	}
+	img := rl.GenImageColor(width, height, rl.Black)
+	r.texture = rl.LoadTextureFromImage(img)
+	rl.UnloadImage(img)
+	r.pixels = make([]color.RGBA, width*height)
+	r.accum = tracer.NewAccumulator(width, height)
+	r.guides = make(map[tracer.AOV]*tracer.Accumulator)
+	for _, a := range guideAOVs {
+		r.guides[a] = tracer.NewAccumulator(width, height)
+	}
+	r.width, r.height = width, height
+}
+
+// displayColor encodes radiance with the same display transform the raster view's
+// post-process applies, so both renderers look alike.
+func (r *TraceRenderer) displayColor(c tracer.Vec3) color.RGBA {
+	p := r.display.Encode(c.X, c.Y, c.Z)
+	return color.RGBA{R: p[0], G: p[1], B: p[2], A: 255}
+}
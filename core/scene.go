@@ -1,7 +1,11 @@
 package core
 
 import (
+	"fmt"
+	"go-ray-tracing/imageio"
 	"go-ray-tracing/materials"
+	"go-ray-tracing/tracer"
+	"slices"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -19,7 +23,31 @@ type Scene3D struct {
 	Material      *materials.Material
 	DefaultShader *rl.Shader
 	LightCamera   rl.Camera
-	Renderer      Renderer3D
+	Renderer      Renderer
+
+	// Area lights seen by the tracer. Emissive geometries light the scene on their
+	// own. Replace a light rather than editing it so the traced view notices.
+	Lights []tracer.Light
+
+	// HDR backdrop, lights the traced view and draws as a skybox. Nil shows the
+	// constant grey background.
+	Environment *Environment
+
+	// Fog is the tracer's medium outside all geometries, limited to FogBounds or to
+	// the bounds of the geometries when those are empty. Nil is clear air.
+	Fog       tracer.Medium
+	FogBounds tracer.AABB
+
+	// Display turns rendered values into screen pixels for every renderer. Its
+	// working space is also the space the tracer and the raster shader compute in.
+	Display imageio.DisplayTransform
+
+	// LiveLinkMotionBlur keeps the previous live-link transform of a geometry as its
+	// shutter open pose, so the tracer blurs along the last move. The blur stops when
+	// the same pose is sent again or no move follows for a moment.
+	LiveLinkMotionBlur bool
+
+	traceSync *TraceSync
 }
 
 func NewScene3D() *Scene3D {
@@ -29,7 +57,8 @@ func NewScene3D() *Scene3D {
 	scene.DefaultShader = &scene.Material.Shader
 	scene.Geometries = make([]*Geometry, 0)
 	scene.LightCamera = rl.Camera3D{}
-	scene.Renderer = *NewRenderer()
+	scene.Renderer = NewRenderer()
+	scene.FogBounds = tracer.EmptyAABB()
 	return &scene
 }
 
@@ -50,11 +79,66 @@ func (s *Scene3D) InitScene() {
 	s.LightCamera.Target = rl.NewVector3(0.0, 0.0, 0.0)     // Looking at origin
 	s.LightCamera.Up = rl.NewVector3(0.0, 1.0, 0.0)         // Up vector
 	s.LightCamera.Projection = rl.CameraOrthographic
+
+	s.Renderer.Init(s)
 }
 
 func (s *Scene3D) UpdateScene() {
 	rl.UpdateCamera(&s.Camera.Camera, rl.CameraMode(rl.CameraFirstPerson))
 
+	// Cycle through the registered renderers
+	if rl.IsKeyPressed(rl.KeyTab) {
+		s.CycleRenderer()
+	}
+	// Cycle through the tone mapping operators
+	if rl.IsKeyPressed(rl.KeyT) {
+		s.Display.ToneMap = s.Display.ToneMap.Next()
+		fmt.Printf("Tone mapping: %s\n", s.Display.ToneMap)
+	}
+}
+
+// SetRenderer swaps the active renderer for the one registered under name.
+func (s *Scene3D) SetRenderer(name string) error {
+	renderer, err := NewRendererByName(name)
+	if err != nil {
+		return err
+	}
+	if s.Renderer != nil {
+		s.Renderer.RunPostRenderProcess(s)
+	}
+	s.Renderer = renderer
+	s.Renderer.Init(s)
+	fmt.Printf("Renderer switched to: %s\n", name)
+	return nil
+}
+
+// CycleRenderer switches to the next registered renderer in name order, skipping
+// the null renderer, which leaves the window blank.
+func (s *Scene3D) CycleRenderer() {
+	names := slices.DeleteFunc(RendererNames(), func(name string) bool {
+		return name == "null"
+	})
+	next := 0
+	for i, name := range names {
+		if s.Renderer != nil && name == s.Renderer.Name() {
+			next = (i + 1) % len(names)
+			break
+		}
+	}
+	if err := s.SetRenderer(names[next]); err != nil {
+		fmt.Printf("Error switching renderer: %v\n", err)
+	}
+}
+
+// Unload frees the GPU resources owned by the scene.
+func (s *Scene3D) Unload() {
+	rl.UnloadShader(*s.DefaultShader)
+	if s.Environment != nil {
+		s.Environment.Unload()
+	}
+	for _, geom := range s.Geometries {
+		geom.Cleanup()
+	}
 }
 
 func (s *Scene3D) AddGeometry(model *rl.Model, name string) {
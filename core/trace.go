@@ -0,0 +1,206 @@
+package core
+
+import (
+	"go-ray-tracing/imageio"
+	"go-ray-tracing/tracer"
+	"math"
+	"unsafe"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// TraceScene converts the scene into the CPU path tracer's representation.
+// Mesh data is copied from the CPU side buffers raylib keeps after upload, so no
+// GL calls are made.
+func (s *Scene3D) TraceScene() *tracer.Scene {
+	ts := tracer.NewScene()
+	ts.WorkingSpace = s.Display.WorkingSpace
+	ts.Camera = TracerCamera(s.Camera)
+	ts.Sun = s.tracerSun()
+	ts.Lights = append([]tracer.Light(nil), s.Lights...)
+	ts.Environment = s.tracerEnvironment()
+	ts.Medium, ts.MediumBounds = s.Fog, s.FogBounds
+
+	for _, geom := range s.Geometries {
+		if !geom.Visibility || geom.Model.MeshCount == 0 {
+			continue
+		}
+		transform := MatrixToTracer(geom.TransformMatrix())
+		for _, mesh := range geom.Model.GetMeshes() {
+			m := MeshFromRaylib(mesh)
+			if m == nil {
+				continue
+			}
+			obj := tracer.NewObject(geom.Name, m, transform)
+			geom.placeObject(obj)
+			obj.Material = geom.tracerMaterial(s.Display.WorkingSpace)
+			geom.media(s.Display.WorkingSpace).apply(obj)
+			ts.AddObject(obj)
+		}
+	}
+	return ts
+}
+
+// RenderTraced path traces the current state of the scene.
+func (s *Scene3D) RenderTraced(opts tracer.Options) *tracer.Image {
+	return tracer.Render(s.TraceScene(), opts)
+}
+
+// tracerMaterial returns a copy of the surface converted into the working space, so
+// edits made on the main thread never race with a render in flight.
+func (geom *Geometry) tracerMaterial(space imageio.ColorSpace) *tracer.Material {
+	if geom.Surface == nil {
+		return tracer.DefaultMaterial().InSpace(space)
+	}
+	return geom.Surface.InSpace(space)
+}
+
+// traceMedia is what a traceEntry compares to notice a changed medium.
+type traceMedia struct {
+	interior    tracer.Medium
+	nullSurface bool
+	space       imageio.ColorSpace
+}
+
+func (geom *Geometry) media(space imageio.ColorSpace) traceMedia {
+	return traceMedia{interior: geom.Interior, nullSurface: geom.NullSurface, space: space}
+}
+
+// apply gives obj the medium, with a grid's emission converted into the working space.
+func (m traceMedia) apply(obj *tracer.Object) {
+	obj.Interior, obj.NullSurface = m.interior, m.nullSurface
+	if grid, ok := m.interior.(*tracer.GridMedium); ok {
+		obj.Interior = grid.InSpace(m.space)
+	}
+}
+
+// tracerSun turns the shadow-mapping light camera into a directional light so
+// traced shadows fall the same way as the raster ones.
+func (s *Scene3D) tracerSun() *tracer.DirectionalLight {
+	dir := Vector3ToTracer(rl.Vector3Subtract(s.LightCamera.Target, s.LightCamera.Position))
+	if dir.IsBlack() {
+		return nil
+	}
+	return &tracer.DirectionalLight{
+		Direction: dir.Normalize(),
+		// An irradiance of pi makes a Lambertian surface match the shader's diffuse term
+		Irradiance: tracer.ConvertColor(s.Display.WorkingSpace.FromLinearSRGB(), tracer.NewVec3(math.Pi, math.Pi, math.Pi)),
+	}
+}
+
+func TracerCamera(cam *PerspectiveCamera) tracer.Camera {
+	c := tracer.Camera{
+		Position: Vector3ToTracer(cam.Camera.Position),
+		Target:   Vector3ToTracer(cam.Camera.Target),
+		Up:       Vector3ToTracer(cam.Camera.Up),
+		Fovy:     float64(cam.Camera.Fovy),
+	}
+	if cam.SensorHeight > 0 {
+		cam.Physical().Apply(&c)
+	}
+	return c
+}
+
+func Vector3ToTracer(v rl.Vector3) tracer.Vec3 {
+	return tracer.NewVec3(float64(v.X), float64(v.Y), float64(v.Z))
+}
+
+func MatrixToTracer(m rl.Matrix) tracer.Mat4 {
+	return tracer.Mat4{
+		{float64(m.M0), float64(m.M4), float64(m.M8), float64(m.M12)},
+		{float64(m.M1), float64(m.M5), float64(m.M9), float64(m.M13)},
+		{float64(m.M2), float64(m.M6), float64(m.M10), float64(m.M14)},
+		{float64(m.M3), float64(m.M7), float64(m.M11), float64(m.M15)},
+	}
+}
+
+// MeshFromRaylib copies an rl.Mesh into a tracer mesh. Non-indexed meshes get
+// sequential indices. Returns nil when the mesh has no CPU side vertex data.
+func MeshFromRaylib(mesh rl.Mesh) *tracer.Mesh {
+	if mesh.Vertices == nil || mesh.VertexCount == 0 {
+		return nil
+	}
+	vertexCount := int(mesh.VertexCount)
+	verts := unsafe.Slice(mesh.Vertices, vertexCount*3)
+
+	m := &tracer.Mesh{
+		Positions: make([]tracer.Vec3, vertexCount),
+	}
+	for i := range m.Positions {
+		m.Positions[i] = tracer.NewVec3(float64(verts[i*3]), float64(verts[i*3+1]), float64(verts[i*3+2]))
+	}
+
+	if mesh.Normals != nil {
+		norms := unsafe.Slice(mesh.Normals, vertexCount*3)
+		m.Normals = make([]tracer.Vec3, vertexCount)
+		for i := range m.Normals {
+			m.Normals[i] = tracer.NewVec3(float64(norms[i*3]), float64(norms[i*3+1]), float64(norms[i*3+2]))
+		}
+	}
+
+	if mesh.Indices != nil {
+		inds := unsafe.Slice(mesh.Indices, int(mesh.TriangleCount)*3)
+		m.Indices = make([]int32, len(inds))
+		for i, idx := range inds {
+			m.Indices[i] = int32(idx)
+		}
+	} else {
+		m.Indices = make([]int32, vertexCount/3*3)
+		for i := range m.Indices {
+			m.Indices[i] = int32(i)
+		}
+	}
+	return m
+}
+
+// MeshFromGeoData copies live-link mesh data into a tracer mesh.
+func MeshFromGeoData(data *GeoData) *tracer.Mesh {
+	m := &tracer.Mesh{
+		Positions: make([]tracer.Vec3, len(data.Vertices)),
+		Indices:   make([]int32, len(data.Indices)/3*3),
+	}
+	for i, v := range data.Vertices {
+		m.Positions[i] = Vector3ToTracer(v)
+	}
+	if len(data.Normals) == len(data.Vertices) {
+		m.Normals = make([]tracer.Vec3, len(data.Normals))
+		for i, n := range data.Normals {
+			m.Normals[i] = Vector3ToTracer(n)
+		}
+	}
+	copy(m.Indices, data.Indices)
+	return m
+}
+
+// GeoDataFromMesh copies a tracer mesh into mesh data for upload, such as the box
+// that bounds a volume received over the live link.
+func GeoDataFromMesh(m *tracer.Mesh) *GeoData {
+	data := &GeoData{
+		Vertices:  make([]rl.Vector3, len(m.Positions)),
+		Normals:   make([]rl.Vector3, len(m.Normals)),
+		TexCoords: make([]rl.Vector2, len(m.Positions)),
+		Indices:   append([]int32(nil), m.Indices...),
+	}
+	for i, p := range m.Positions {
+		data.Vertices[i] = rl.NewVector3(float32(p.X), float32(p.Y), float32(p.Z))
+	}
+	for i, n := range m.Normals {
+		data.Normals[i] = rl.NewVector3(float32(n.X), float32(n.Y), float32(n.Z))
+	}
+	return data
+}
+
+// BuildGeoDataBVH builds the ray query structure for mesh data received over the live link.
+func BuildGeoDataBVH(data *GeoData) *tracer.BVH {
+	return MeshFromGeoData(data).BVH()
+}
+
+// BuildMeshBVH builds the ray query structure for a mesh already loaded in raylib.
+// Returns nil when the mesh has no CPU side data.
+func BuildMeshBVH(mesh rl.Mesh) *tracer.BVH {
+	m := MeshFromRaylib(mesh)
+	if m == nil {
+		return nil
+	}
+	return m.BVH()
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"go-ray-tracing/imageio"
+	"go-ray-tracing/tracer"
+)
+
+// TraceSync keeps a tracer.Scene in step with a Scene3D across frames. Live-link
+// transform updates only move objects, which rebuilds the tracer's small top level.
+// Mesh updates refit the affected object's BVH, or rebuild it if the topology changed.
+type TraceSync struct {
+	Scene   *tracer.Scene
+	entries map[*Geometry]*traceEntry
+}
+
+type traceEntry struct {
+	objects     []*tracer.Object
+	placement   tracePlacement
+	meshVersion int
+	material    tracer.Material
+	media       traceMedia
+}
+
+func NewTraceSync() *TraceSync {
+	return &TraceSync{
+		Scene:   tracer.NewScene(),
+		entries: make(map[*Geometry]*traceEntry),
+	}
+}
+
+// Sync applies every change made to scene since the last call and commits the
+// tracer scene. Returns true if any geometry, material or light changed.
+func (ts *TraceSync) Sync(scene *Scene3D) bool {
+	materialChanged := false
+	space := scene.Display.WorkingSpace
+	ts.Scene.WorkingSpace = space
+	ts.Scene.Camera = TracerCamera(scene.Camera)
+	ts.Scene.Sun = scene.tracerSun()
+	if !sameLights(ts.Scene.Lights, scene.Lights) {
+		ts.Scene.Lights = append([]tracer.Light(nil), scene.Lights...)
+	}
+	if env := scene.tracerEnvironment(); !sameEnvironment(ts.Scene.Environment, env) {
+		ts.Scene.Environment = env
+	}
+	if ts.Scene.Medium != scene.Fog || ts.Scene.MediumBounds != scene.FogBounds {
+		ts.Scene.Medium, ts.Scene.MediumBounds = scene.Fog, scene.FogBounds
+		materialChanged = true
+	}
+
+	seen := make(map[*Geometry]bool, len(scene.Geometries))
+	for _, geom := range scene.Geometries {
+		if !geom.Visibility || geom.Model.MeshCount == 0 {
+			continue
+		}
+		seen[geom] = true
+
+		entry, ok := ts.entries[geom]
+		if !ok {
+			ts.entries[geom] = ts.addGeometry(geom, space)
+			continue
+		}
+		if entry.meshVersion != geom.MeshVersion {
+			ts.updateMeshes(geom, entry, space)
+		}
+		if material := geom.tracerMaterial(space); *material != entry.material {
+			entry.material = *material
+			for _, obj := range entry.objects {
+				obj.Material = geom.tracerMaterial(space)
+			}
+			materialChanged = true
+		}
+		if media := geom.media(space); media != entry.media {
+			entry.media = media
+			for _, obj := range entry.objects {
+				media.apply(obj)
+			}
+			materialChanged = true
+		}
+		if placement := geom.placement(); placement != entry.placement {
+			entry.placement = placement
+			for _, obj := range entry.objects {
+				geom.placeObject(obj)
+			}
+		}
+	}
+
+	// Drop geometries that were removed or hidden
+	for geom, entry := range ts.entries {
+		if !seen[geom] {
+			for _, obj := range entry.objects {
+				ts.Scene.RemoveObject(obj)
+			}
+			delete(ts.entries, geom)
+		}
+	}
+
+	return ts.Scene.Commit() || materialChanged
+}
+
+// Dirty reports whether Sync would change any geometry or light, without touching the tracer
+// scene. It is safe to call while a render is reading the tracer scene.
+func (ts *TraceSync) Dirty(scene *Scene3D) bool {
+	if !sameLights(ts.Scene.Lights, scene.Lights) || !sameEnvironment(ts.Scene.Environment, scene.tracerEnvironment()) ||
+		ts.Scene.Medium != scene.Fog || ts.Scene.MediumBounds != scene.FogBounds {
+		return true
+	}
+	visible := 0
+	for _, geom := range scene.Geometries {
+		if !geom.Visibility || geom.Model.MeshCount == 0 {
+			continue
+		}
+		visible++
+		entry, ok := ts.entries[geom]
+		if !ok || entry.meshVersion != geom.MeshVersion || entry.placement != geom.placement() ||
+			entry.material != *geom.tracerMaterial(scene.Display.WorkingSpace) || entry.media != geom.media(scene.Display.WorkingSpace) {
+			return true
+		}
+	}
+	return visible != len(ts.entries)
+}
+
+func sameLights(a, b []tracer.Light) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ts *TraceSync) addGeometry(geom *Geometry, space imageio.ColorSpace) *traceEntry {
+	entry := &traceEntry{
+		placement:   geom.placement(),
+		meshVersion: geom.MeshVersion,
+		material:    *geom.tracerMaterial(space),
+		media:       geom.media(space),
+	}
+	for _, mesh := range geom.Model.GetMeshes() {
+		m := MeshFromRaylib(mesh)
+		if m == nil {
+			continue
+		}
+		obj := tracer.NewObject(geom.Name, m, MatrixToTracer(entry.placement.transform))
+		geom.placeObject(obj)
+		obj.Material = geom.tracerMaterial(space)
+		entry.media.apply(obj)
+		entry.objects = append(entry.objects, obj)
+		ts.Scene.AddObject(obj)
+	}
+	return entry
+}
+
+func (ts *TraceSync) updateMeshes(geom *Geometry, entry *traceEntry, space imageio.ColorSpace) {
+	meshes := geom.Model.GetMeshes()
+	if len(meshes) != len(entry.objects) {
+		// Mesh count changed, start over for this geometry only
+		for _, obj := range entry.objects {
+			ts.Scene.RemoveObject(obj)
+		}
+		*entry = *ts.addGeometry(geom, space)
+		return
+	}
+	for i, mesh := range meshes {
+		if m := MeshFromRaylib(mesh); m != nil {
+			entry.objects[i].UpdateMesh(m)
+		}
+	}
+	entry.meshVersion = geom.MeshVersion
+}
+
+// TraceSceneDirty reports whether the geometry changed since the last SyncTraceScene.
+func (s *Scene3D) TraceSceneDirty() bool {
+	return s.traceSync == nil || s.traceSync.Dirty(s)
+}
+
+// SyncTraceScene returns the scene's persistent tracer scene, updated to the current
+// frame, and whether any geometry changed since the previous call.
+func (s *Scene3D) SyncTraceScene() (*tracer.Scene, bool) {
+	if s.traceSync == nil {
+		s.traceSync = NewTraceSync()
+	}
+	changed := s.traceSync.Sync(s)
+	return s.traceSync.Scene, changed
+}
@@ -0,0 +1,105 @@
+package imageio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Matrix3 is a row-major 3x3 color matrix.
+type Matrix3 [3][3]float64
+
+func (m Matrix3) Apply(r, g, b float64) (float64, float64, float64) {
+	return m[0][0]*r + m[0][1]*g + m[0][2]*b,
+		m[1][0]*r + m[1][1]*g + m[1][2]*b,
+		m[2][0]*r + m[2][1]*g + m[2][2]*b
+}
+
+// Identity3 leaves colors unchanged.
+var Identity3 = Matrix3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// ColorSpace is a linear RGB working space: the primaries renders are computed in.
+// Colors are authored in linear sRGB and converted into the working space when a
+// scene is built; displays convert back.
+type ColorSpace int
+
+const (
+	LinearSRGB ColorSpace = iota // Rec. 709 primaries, D65 white
+	ACEScg                       // ACES AP1 primaries, D60 white
+)
+
+var colorSpaceNames = [...]string{
+	LinearSRGB: "linear-srgb",
+	ACEScg:     "acescg",
+}
+
+func (c ColorSpace) String() string {
+	if c < 0 || int(c) >= len(colorSpaceNames) {
+		return fmt.Sprintf("ColorSpace(%d)", int(c))
+	}
+	return colorSpaceNames[c]
+}
+
+func ParseColorSpace(name string) (ColorSpace, error) {
+	for i, n := range colorSpaceNames {
+		if strings.EqualFold(n, name) {
+			return ColorSpace(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown color space %q, want %s", name, strings.Join(colorSpaceNames[:], " or "))
+}
+
+// Rec. 709 to AP1 and back, with a Bradford adaptation between the white points.
+var (
+	srgbToACEScg = Matrix3{
+		{0.6130974024, 0.3395231462, 0.0473794514},
+		{0.0701937225, 0.9163538791, 0.0134523985},
+		{0.0206155929, 0.1095697729, 0.8698146342},
+	}
+	acescgToSRGB = Matrix3{
+		{1.7050509927, -0.6217921207, -0.0832588720},
+		{-0.1302564175, 1.1408047366, -0.0105483191},
+		{-0.0240033568, -0.1289689761, 1.1529723329},
+	}
+)
+
+// FromLinearSRGB converts linear sRGB colors into the space.
+func (c ColorSpace) FromLinearSRGB() Matrix3 {
+	if c == ACEScg {
+		return srgbToACEScg
+	}
+	return Identity3
+}
+
+// ToLinearSRGB converts colors in the space to linear sRGB.
+func (c ColorSpace) ToLinearSRGB() Matrix3 {
+	if c == ACEScg {
+		return acescgToSRGB
+	}
+	return Identity3
+}
+
+// chromaticities returns the CIE xy coordinates of the red, green and blue primaries
+// and the white point, as stored in an EXR chromaticities attribute.
+func (c ColorSpace) chromaticities() [8]float32 {
+	if c == ACEScg {
+		return [8]float32{0.713, 0.293, 0.165, 0.830, 0.128, 0.044, 0.32168, 0.33767}
+	}
+	return [8]float32{0.64, 0.33, 0.30, 0.60, 0.15, 0.06, 0.3127, 0.3290}
+}
+
+// SRGBOETF encodes a linear value in [0, 1] with the piecewise sRGB transfer function.
+func SRGBOETF(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// SRGBEOTF decodes an sRGB encoded value back to linear, the inverse of SRGBOETF.
+func SRGBEOTF(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
@@ -0,0 +1,148 @@
+package imageio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ToneMap is the operator compressing scene values into the displayable range. The
+// raster post-process in package materials implements the same operators in GLSL,
+// keep the two in step.
+type ToneMap int
+
+const (
+	ToneMapClamp    ToneMap = iota // linear, clipped at 1
+	ToneMapReinhard                // x/(1+x) per channel, never clips
+	ToneMapACES                    // Stephen Hill's fit of the ACES RRT and sRGB ODT
+	ToneMapAgX                     // Troy Sobotka's AgX base look, polynomial fit of the sigmoid
+)
+
+var toneMapNames = [...]string{
+	ToneMapClamp:    "clamp",
+	ToneMapReinhard: "reinhard",
+	ToneMapACES:     "aces",
+	ToneMapAgX:      "agx",
+}
+
+func (t ToneMap) String() string {
+	if t < 0 || int(t) >= len(toneMapNames) {
+		return fmt.Sprintf("ToneMap(%d)", int(t))
+	}
+	return toneMapNames[t]
+}
+
+func ParseToneMap(name string) (ToneMap, error) {
+	for i, n := range toneMapNames {
+		if strings.EqualFold(n, name) {
+			return ToneMap(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tone mapping %q, want one of %s", name, strings.Join(toneMapNames[:], ", "))
+}
+
+// Next returns the following operator, wrapping around, for cycling through them.
+func (t ToneMap) Next() ToneMap {
+	return (t + 1) % ToneMap(len(toneMapNames))
+}
+
+// Apply maps a non-negative linear sRGB color to linear display values in [0, 1].
+func (t ToneMap) Apply(r, g, b float64) (float64, float64, float64) {
+	switch t {
+	case ToneMapReinhard:
+		r, g, b = r/(1+r), g/(1+g), b/(1+b)
+	case ToneMapACES:
+		r, g, b = acesFilmic(r, g, b)
+	case ToneMapAgX:
+		r, g, b = agx(r, g, b)
+	}
+	return clamp01(r), clamp01(g), clamp01(b)
+}
+
+func clamp01(v float64) float64 {
+	return math.Min(math.Max(v, 0), 1)
+}
+
+// ACES fit matrices: sRGB to the RRT's saturated AP1 and back from the ODT.
+var (
+	acesInput = Matrix3{
+		{0.59719, 0.35458, 0.04823},
+		{0.07600, 0.90834, 0.01566},
+		{0.02840, 0.13383, 0.83777},
+	}
+	acesOutput = Matrix3{
+		{1.60475, -0.53108, -0.07367},
+		{-0.10208, 1.10813, -0.00605},
+		{-0.00327, -0.07276, 1.07602},
+	}
+)
+
+func acesFilmic(r, g, b float64) (float64, float64, float64) {
+	curve := func(v float64) float64 {
+		return (v*(v+0.0245786) - 0.000090537) / (v*(0.983729*v+0.4329510) + 0.238081)
+	}
+	r, g, b = acesInput.Apply(r, g, b)
+	return acesOutput.Apply(curve(r), curve(g), curve(b))
+}
+
+// AgX inset and outset matrices, in Rec. 709.
+var (
+	agxInset = Matrix3{
+		{0.842479062253094, 0.0784335999999992, 0.0792237451477643},
+		{0.0423282422610123, 0.878468636469772, 0.0791661274605434},
+		{0.0423756549057051, 0.0784336, 0.879142973793104},
+	}
+	agxOutset = Matrix3{
+		{1.19687900512017, -0.0980208811401368, -0.0990297440797205},
+		{-0.0528968517574562, 1.15190312990417, -0.0989611768448433},
+		{-0.0529716355144438, -0.0980434501171241, 1.15107367264116},
+	}
+)
+
+// The log2 range AgX maps onto its sigmoid, around middle grey.
+const (
+	agxMinEV = -12.47393
+	agxMaxEV = 4.026069
+)
+
+func agx(r, g, b float64) (float64, float64, float64) {
+	curve := func(v float64) float64 {
+		v = math.Log2(math.Max(v, 1e-10))
+		v = (math.Min(math.Max(v, agxMinEV), agxMaxEV) - agxMinEV) / (agxMaxEV - agxMinEV)
+		v2 := v * v
+		v4 := v2 * v2
+		return 15.5*v4*v2 - 40.14*v4*v + 31.96*v4 - 6.868*v2*v + 0.4298*v2 + 0.1191*v - 0.00232
+	}
+	r, g, b = agxInset.Apply(r, g, b)
+	r, g, b = agxOutset.Apply(curve(r), curve(g), curve(b))
+	// The sigmoid produces display encoded values, decode with the 2.2 power it assumes
+	decode := func(v float64) float64 { return math.Pow(math.Max(v, 0), 2.2) }
+	return decode(r), decode(g), decode(b)
+}
+
+// DisplayTransform turns rendered values into display pixels: exposure, conversion
+// from the working space to linear sRGB, tone mapping and the sRGB OETF. Both the
+// raster post-process and the tracer outputs are driven by one.
+type DisplayTransform struct {
+	Exposure     float64    // stops on top of the camera's exposure
+	ToneMap      ToneMap    // operator applied in linear sRGB
+	WorkingSpace ColorSpace // space the rendered values are in
+}
+
+// Linear returns the display color before encoding, linear sRGB in [0, 1].
+func (d DisplayTransform) Linear(r, g, b float64) (float64, float64, float64) {
+	scale := math.Exp2(d.Exposure)
+	r, g, b = d.WorkingSpace.ToLinearSRGB().Apply(r*scale, g*scale, b*scale)
+	// Out of gamut colors would go negative, clip them before the operator
+	return d.ToneMap.Apply(math.Max(r, 0), math.Max(g, 0), math.Max(b, 0))
+}
+
+// Encode returns the 8-bit sRGB display pixel for a rendered color.
+func (d DisplayTransform) Encode(r, g, b float64) [3]uint8 {
+	r, g, b = d.Linear(r, g, b)
+	return [3]uint8{quantize8(SRGBOETF(r)), quantize8(SRGBOETF(g)), quantize8(SRGBOETF(b))}
+}
+
+func quantize8(v float64) uint8 {
+	return uint8(clamp01(v)*255 + 0.5)
+}
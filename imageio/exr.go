@@ -0,0 +1,418 @@
+package imageio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Channel is one named plane of float samples, row-major from the top-left.
+// Layered files use dotted names such as "albedo.R".
+type Channel struct {
+	Name string
+	Data []float32
+}
+
+// RGBChannels splits interleaved RGB floats into R, G and B channels.
+func RGBChannels(rgb []float32) []Channel {
+	n := len(rgb) / 3
+	r := make([]float32, n)
+	g := make([]float32, n)
+	b := make([]float32, n)
+	for i := 0; i < n; i++ {
+		r[i], g[i], b[i] = rgb[i*3], rgb[i*3+1], rgb[i*3+2]
+	}
+	return []Channel{{"R", r}, {"G", g}, {"B", b}}
+}
+
+// EXRCompression is the compression method of an OpenEXR file.
+type EXRCompression uint8
+
+const (
+	EXRNone EXRCompression = 0 // uncompressed
+	EXRZIPS EXRCompression = 2 // zlib, one scanline per block, read only
+	EXRZIP  EXRCompression = 3 // zlib, 16 scanlines per block
+	EXRPIZ  EXRCompression = 4 // wavelet and Huffman, 32 scanlines per block
+)
+
+var exrCompressionNames = map[EXRCompression]string{
+	EXRNone: "none",
+	EXRZIPS: "zips",
+	EXRZIP:  "zip",
+	EXRPIZ:  "piz",
+}
+
+func (c EXRCompression) String() string {
+	if name, ok := exrCompressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("EXRCompression(%d)", uint8(c))
+}
+
+// ParseEXRCompression parses the compression names accepted by WriteEXR: "none",
+// "zip" or "piz".
+func ParseEXRCompression(name string) (EXRCompression, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return EXRNone, nil
+	case "zip":
+		return EXRZIP, nil
+	case "piz":
+		return EXRPIZ, nil
+	}
+	return 0, fmt.Errorf("unknown EXR compression %q, want none, zip or piz", name)
+}
+
+// linesPerBlock is the number of scanlines compressed together.
+func (c EXRCompression) linesPerBlock() int {
+	switch c {
+	case EXRZIP:
+		return 16
+	case EXRPIZ:
+		return 32
+	}
+	return 1
+}
+
+const exrMagic = 20000630
+
+// maxPixels bounds the size readers accept, so a corrupt header cannot make them
+// allocate gigabytes before the pixel data runs out.
+const maxPixels = 1 << 28
+
+// Channel sample types
+const (
+	exrPixelUint  = 0
+	exrPixelHalf  = 1
+	exrPixelFloat = 2
+)
+
+// Version field flags of files this package cannot read
+const (
+	exrFlagTiled     = 0x200
+	exrFlagDeep      = 0x800
+	exrFlagMultipart = 0x1000
+)
+
+// exrChannel is a channel as described by the file header.
+type exrChannel struct {
+	name      string
+	pixelType uint32
+}
+
+// size is the number of bytes per sample.
+func (c exrChannel) size() int {
+	if c.pixelType == exrPixelHalf {
+		return 2
+	}
+	return 4
+}
+
+// EXROptions controls WriteEXR.
+type EXROptions struct {
+	Compression EXRCompression // EXRNone, EXRZIP or EXRPIZ
+	Space       ColorSpace     // primaries of the values, stored as chromaticities unless linear sRGB
+}
+
+// WriteEXR writes a scanline OpenEXR file with 32-bit float channels.
+func WriteEXR(w io.Writer, width, height int, channels []Channel, opts EXROptions) error {
+	compression := opts.Compression
+	if compression != EXRNone && compression != EXRZIP && compression != EXRPIZ {
+		return fmt.Errorf("exr: cannot write %v compression", compression)
+	}
+	for _, c := range channels {
+		if len(c.Data) != width*height {
+			return fmt.Errorf("exr: channel %s has %d samples, want %d", c.Name, len(c.Data), width*height)
+		}
+	}
+	// The file format requires channels sorted by name
+	sorted := make([]Channel, len(channels))
+	copy(sorted, channels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+
+	var header []byte
+	header = le.AppendUint32(header, exrMagic)
+	header = le.AppendUint32(header, 2) // version 2, single part scanline
+
+	var chlist []byte
+	for _, c := range sorted {
+		chlist = append(chlist, c.Name...)
+		chlist = append(chlist, 0)
+		chlist = le.AppendUint32(chlist, exrPixelFloat)
+		chlist = append(chlist, 0, 0, 0, 0) // pLinear and reserved
+		chlist = le.AppendUint32(chlist, 1) // x sampling
+		chlist = le.AppendUint32(chlist, 1) // y sampling
+	}
+	chlist = append(chlist, 0)
+
+	box := le.AppendUint32(nil, 0)
+	box = le.AppendUint32(box, 0)
+	box = le.AppendUint32(box, uint32(width-1))
+	box = le.AppendUint32(box, uint32(height-1))
+
+	header = appendAttribute(header, "channels", "chlist", chlist)
+	if opts.Space != LinearSRGB {
+		var chromaticities []byte
+		for _, v := range opts.Space.chromaticities() {
+			chromaticities = le.AppendUint32(chromaticities, math.Float32bits(v))
+		}
+		header = appendAttribute(header, "chromaticities", "chromaticities", chromaticities)
+	}
+	header = appendAttribute(header, "compression", "compression", []byte{byte(compression)})
+	header = appendAttribute(header, "dataWindow", "box2i", box)
+	header = appendAttribute(header, "displayWindow", "box2i", box)
+	header = appendAttribute(header, "lineOrder", "lineOrder", []byte{0})
+	header = appendAttribute(header, "pixelAspectRatio", "float", le.AppendUint32(nil, math.Float32bits(1)))
+	header = appendAttribute(header, "screenWindowCenter", "v2f", make([]byte, 8))
+	header = appendAttribute(header, "screenWindowWidth", "float", le.AppendUint32(nil, math.Float32bits(1)))
+	header = append(header, 0)
+
+	// Compress every block first, the offset table in front of them needs their sizes
+	lines := compression.linesPerBlock()
+	blockCount := (height + lines - 1) / lines
+	blocks := make([][]byte, blockCount)
+	exrChannels := make([]exrChannel, len(sorted))
+	for i, c := range sorted {
+		exrChannels[i] = exrChannel{name: c.Name, pixelType: exrPixelFloat}
+	}
+	raw := make([]byte, 0, lines*width*4*len(sorted))
+	for b := range blocks {
+		y0 := b * lines
+		y1 := min(y0+lines, height)
+		raw = raw[:0]
+		for y := y0; y < y1; y++ {
+			for _, c := range sorted {
+				for _, v := range c.Data[y*width : (y+1)*width] {
+					raw = le.AppendUint32(raw, math.Float32bits(v))
+				}
+			}
+		}
+		data, err := compressEXRBlock(compression, raw, exrChannels, width, y1-y0)
+		if err != nil {
+			return fmt.Errorf("exr: %v", err)
+		}
+		// Blocks that do not shrink are stored as is, readers tell them apart by size
+		if len(data) >= len(raw) {
+			data = append([]byte(nil), raw...)
+		}
+		blocks[b] = data
+	}
+
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	offset := uint64(len(header) + blockCount*8)
+	var buf [8]byte
+	for _, data := range blocks {
+		le.PutUint64(buf[:], offset)
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+		offset += uint64(8 + len(data))
+	}
+	for b, data := range blocks {
+		le.PutUint32(buf[:4], uint32(b*lines))
+		le.PutUint32(buf[4:], uint32(len(data)))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func appendAttribute(b []byte, name, typ string, value []byte) []byte {
+	b = append(b, name...)
+	b = append(b, 0)
+	b = append(b, typ...)
+	b = append(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+// compressEXRBlock compresses the raw scanlines of one block.
+func compressEXRBlock(compression EXRCompression, raw []byte, channels []exrChannel, width, lines int) ([]byte, error) {
+	switch compression {
+	case EXRZIP, EXRZIPS:
+		return zipCompress(raw)
+	case EXRPIZ:
+		return pizCompress(raw, channels, width, lines)
+	}
+	return raw, nil
+}
+
+// decompressEXRBlock undoes compressEXRBlock, size is the uncompressed length.
+func decompressEXRBlock(compression EXRCompression, data []byte, channels []exrChannel, width, lines, size int) ([]byte, error) {
+	if len(data) == size {
+		return data, nil
+	}
+	switch compression {
+	case EXRZIP, EXRZIPS:
+		return zipDecompress(data, size)
+	case EXRPIZ:
+		return pizDecompress(data, channels, width, lines, size)
+	}
+	return nil, fmt.Errorf("block has %d bytes, want %d", len(data), size)
+}
+
+// ReadEXR decodes a single part scanline OpenEXR file that is uncompressed or
+// compressed with ZIPS, ZIP or PIZ. Half, float and uint samples are all converted
+// to float32; channels come back in file order, which is sorted by name.
+func ReadEXR(r io.Reader) (width, height int, channels []Channel, err error) {
+	file, err := io.ReadAll(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	le := binary.LittleEndian
+	if len(file) < 8 || le.Uint32(file) != exrMagic {
+		return 0, 0, nil, fmt.Errorf("exr: not an OpenEXR file")
+	}
+	version := le.Uint32(file[4:])
+	if version&0xff != 2 {
+		return 0, 0, nil, fmt.Errorf("exr: unsupported version %d", version&0xff)
+	}
+	if version&(exrFlagTiled|exrFlagDeep|exrFlagMultipart) != 0 {
+		return 0, 0, nil, fmt.Errorf("exr: only single part scanline files are supported")
+	}
+
+	// Attributes: name, type, size and value, up to an empty name
+	var fileChannels []exrChannel
+	compression := EXRNone
+	var xMin, yMin, xMax, yMax int32
+	haveWindow := false
+	p := file[8:]
+	for {
+		name, rest, ok := bytes.Cut(p, []byte{0})
+		if !ok {
+			return 0, 0, nil, fmt.Errorf("exr: truncated header")
+		}
+		if len(name) == 0 {
+			p = rest
+			break
+		}
+		_, rest, ok = bytes.Cut(rest, []byte{0})
+		if !ok || len(rest) < 4 {
+			return 0, 0, nil, fmt.Errorf("exr: truncated header")
+		}
+		size := int(le.Uint32(rest))
+		if size < 0 || size > len(rest)-4 {
+			return 0, 0, nil, fmt.Errorf("exr: truncated attribute %s", name)
+		}
+		value := rest[4 : 4+size]
+		p = rest[4+size:]
+
+		switch string(name) {
+		case "channels":
+			if fileChannels, err = parseEXRChannels(value); err != nil {
+				return 0, 0, nil, err
+			}
+		case "compression":
+			if size != 1 {
+				return 0, 0, nil, fmt.Errorf("exr: invalid compression attribute")
+			}
+			compression = EXRCompression(value[0])
+		case "dataWindow":
+			if size != 16 {
+				return 0, 0, nil, fmt.Errorf("exr: invalid data window")
+			}
+			xMin, yMin = int32(le.Uint32(value)), int32(le.Uint32(value[4:]))
+			xMax, yMax = int32(le.Uint32(value[8:])), int32(le.Uint32(value[12:]))
+			haveWindow = true
+		}
+	}
+	if fileChannels == nil || !haveWindow {
+		return 0, 0, nil, fmt.Errorf("exr: missing channels or data window")
+	}
+	if _, ok := exrCompressionNames[compression]; !ok {
+		return 0, 0, nil, fmt.Errorf("exr: unsupported compression %d", compression)
+	}
+	width, height = int(xMax)-int(xMin)+1, int(yMax)-int(yMin)+1
+	if width <= 0 || height <= 0 || width > maxPixels/height {
+		return 0, 0, nil, fmt.Errorf("exr: invalid size %dx%d", width, height)
+	}
+
+	channels = make([]Channel, len(fileChannels))
+	lineSize := 0
+	for i, c := range fileChannels {
+		channels[i] = Channel{Name: c.name, Data: make([]float32, width*height)}
+		lineSize += width * c.size()
+	}
+
+	lines := compression.linesPerBlock()
+	blockCount := (height + lines - 1) / lines
+	if len(p) < blockCount*8 {
+		return 0, 0, nil, fmt.Errorf("exr: truncated offset table")
+	}
+	for b := 0; b < blockCount; b++ {
+		offset := le.Uint64(p[b*8:])
+		if offset > uint64(len(file)-8) {
+			return 0, 0, nil, fmt.Errorf("exr: block %d out of range", b)
+		}
+		chunk := file[offset:]
+		y := int(int32(le.Uint32(chunk))) - int(yMin)
+		size := int(le.Uint32(chunk[4:]))
+		if y < 0 || y >= height || y%lines != 0 || size > len(chunk)-8 {
+			return 0, 0, nil, fmt.Errorf("exr: invalid block %d", b)
+		}
+		n := min(lines, height-y)
+		raw, err := decompressEXRBlock(compression, chunk[8:8+size], fileChannels, width, n, n*lineSize)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("exr: block %d: %v", b, err)
+		}
+		// Scanlines hold every channel in turn
+		for line := 0; line < n; line++ {
+			for i, c := range fileChannels {
+				out := channels[i].Data[(y+line)*width : (y+line+1)*width]
+				for x := range out {
+					switch c.pixelType {
+					case exrPixelHalf:
+						out[x] = halfToFloat(le.Uint16(raw[x*2:]))
+					case exrPixelFloat:
+						out[x] = math.Float32frombits(le.Uint32(raw[x*4:]))
+					default:
+						out[x] = float32(le.Uint32(raw[x*4:]))
+					}
+				}
+				raw = raw[width*c.size():]
+			}
+		}
+	}
+	return width, height, channels, nil
+}
+
+// parseEXRChannels decodes a chlist attribute.
+func parseEXRChannels(value []byte) ([]exrChannel, error) {
+	le := binary.LittleEndian
+	var channels []exrChannel
+	for {
+		name, rest, ok := bytes.Cut(value, []byte{0})
+		if !ok {
+			return nil, fmt.Errorf("exr: truncated channel list")
+		}
+		if len(name) == 0 {
+			return channels, nil
+		}
+		if len(rest) < 16 {
+			return nil, fmt.Errorf("exr: truncated channel list")
+		}
+		c := exrChannel{name: string(name), pixelType: le.Uint32(rest)}
+		if c.pixelType > exrPixelFloat {
+			return nil, fmt.Errorf("exr: channel %s has unknown type %d", c.name, c.pixelType)
+		}
+		if le.Uint32(rest[8:]) != 1 || le.Uint32(rest[12:]) != 1 {
+			return nil, fmt.Errorf("exr: channel %s is subsampled", c.name)
+		}
+		channels = append(channels, c)
+		value = rest[16:]
+	}
+}
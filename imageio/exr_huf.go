@@ -0,0 +1,421 @@
+package imageio
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+)
+
+// Canonical Huffman coding of 16-bit values as used by PIZ. A code is stored as its
+// length in the low 6 bits and its bits above them. The symbol after the largest
+// value present marks a run: the previous value repeats as often as the 8-bit count
+// that follows.
+
+const (
+	hufEncSize = 1<<16 + 1
+	hufDecBits = 14 // codes up to this long decode with a single table lookup
+	hufDecSize = 1 << hufDecBits
+
+	// Code length table run-length encoding of unused symbols
+	shortZeroCodeRun = 59
+	longZeroCodeRun  = 63
+	shortestLongRun  = 2 + longZeroCodeRun - shortZeroCodeRun
+	longestLongRun   = 255 + shortestLongRun
+)
+
+var errHufData = errors.New("invalid Huffman data")
+
+func hufLength(code uint64) int  { return int(code & 63) }
+func hufCode(code uint64) uint64 { return code >> 6 }
+
+// bitWriter packs codes most significant bit first.
+type bitWriter struct {
+	out []byte
+	c   uint64
+	lc  int
+}
+
+func (w *bitWriter) bits(n int, v uint64) {
+	w.c = w.c<<n | v
+	w.lc += n
+	for w.lc >= 8 {
+		w.lc -= 8
+		w.out = append(w.out, byte(w.c>>w.lc))
+	}
+}
+
+func (w *bitWriter) code(code uint64) {
+	w.bits(hufLength(code), hufCode(code))
+}
+
+// flush pads the last byte with zeros.
+func (w *bitWriter) flush() {
+	if w.lc > 0 {
+		w.out = append(w.out, byte(w.c<<(8-w.lc)))
+	}
+}
+
+// hufCanonicalCodeTable turns code lengths into canonical codes, longer codes get
+// the numerically smaller values.
+func hufCanonicalCodeTable(hcode []uint64) {
+	var n [59]uint64
+	for _, l := range hcode {
+		n[l]++
+	}
+	var c uint64
+	for i := 58; i > 0; i-- {
+		nc := (c + n[i]) >> 1
+		n[i] = c
+		c = nc
+	}
+	for i, l := range hcode {
+		if l > 0 {
+			hcode[i] = l | n[l]<<6
+			n[l]++
+		}
+	}
+}
+
+// hufHeap orders symbols by frequency.
+type hufHeap struct {
+	symbols []int
+	freq    []uint64
+}
+
+func (h *hufHeap) Len() int           { return len(h.symbols) }
+func (h *hufHeap) Less(i, j int) bool { return h.freq[h.symbols[i]] < h.freq[h.symbols[j]] }
+func (h *hufHeap) Swap(i, j int)      { h.symbols[i], h.symbols[j] = h.symbols[j], h.symbols[i] }
+func (h *hufHeap) Push(x any)         { h.symbols = append(h.symbols, x.(int)) }
+func (h *hufHeap) Pop() any {
+	x := h.symbols[len(h.symbols)-1]
+	h.symbols = h.symbols[:len(h.symbols)-1]
+	return x
+}
+
+// hufBuildEncTable returns the canonical codes for the frequencies and the range of
+// symbols in use, the last of which is the added run symbol.
+func hufBuildEncTable(freq []uint64) (hcode []uint64, im, iM int) {
+	for freq[im] == 0 {
+		im++
+	}
+	// Each symbol links to the next one merged into the same subtree, a symbol
+	// linking to itself ends the list
+	link := make([]int, hufEncSize)
+	h := &hufHeap{freq: freq}
+	for i := im; i < hufEncSize; i++ {
+		link[i] = i
+		if freq[i] != 0 {
+			h.symbols = append(h.symbols, i)
+			iM = i
+		}
+	}
+	iM++
+	freq[iM] = 1
+	h.symbols = append(h.symbols, iM)
+	heap.Init(h)
+
+	hcode = make([]uint64, hufEncSize)
+	for h.Len() > 1 {
+		mm := heap.Pop(h).(int)
+		m := heap.Pop(h).(int)
+		freq[m] += freq[mm]
+		heap.Push(h, m)
+		// Every symbol in both subtrees gets one bit longer, then the lists are joined
+		for j := m; ; j = link[j] {
+			hcode[j]++
+			if link[j] == j {
+				link[j] = mm
+				break
+			}
+		}
+		for j := mm; ; j = link[j] {
+			hcode[j]++
+			if link[j] == j {
+				break
+			}
+		}
+	}
+	hufCanonicalCodeTable(hcode)
+	return hcode, im, iM
+}
+
+// hufPackEncTable writes the code lengths of symbols im to iM in 6 bits each, runs
+// of unused symbols shortened.
+func hufPackEncTable(w *bitWriter, hcode []uint64, im, iM int) {
+	for ; im <= iM; im++ {
+		l := hufLength(hcode[im])
+		if l == 0 {
+			run := 1
+			for im < iM && run < longestLongRun && hufLength(hcode[im+1]) == 0 {
+				im++
+				run++
+			}
+			if run >= shortestLongRun {
+				w.bits(6, longZeroCodeRun)
+				w.bits(8, uint64(run-shortestLongRun))
+				continue
+			}
+			if run >= 2 {
+				w.bits(6, uint64(shortZeroCodeRun+run-2))
+				continue
+			}
+		}
+		w.bits(6, uint64(l))
+	}
+	w.flush()
+}
+
+// hufSendCode writes a value followed by count repeats of it, as a run when that is
+// shorter.
+func hufSendCode(w *bitWriter, code uint64, count int, runCode uint64) {
+	if hufLength(code)+hufLength(runCode)+8 < hufLength(code)*count {
+		w.code(code)
+		w.code(runCode)
+		w.bits(8, uint64(count))
+		return
+	}
+	for ; count >= 0; count-- {
+		w.code(code)
+	}
+}
+
+// hufCompress Huffman codes the values behind a 20 byte header: the symbol range,
+// the table length and the number of data bits.
+func hufCompress(data []uint16) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	freq := make([]uint64, hufEncSize)
+	for _, v := range data {
+		freq[v]++
+	}
+	hcode, im, iM := hufBuildEncTable(freq)
+
+	w := &bitWriter{out: make([]byte, 20, 20+len(data))}
+	hufPackEncTable(w, hcode, im, iM)
+	tableLength := len(w.out) - 20
+
+	// Encode the data
+	start := len(w.out)
+	w.c, w.lc = 0, 0
+	s, count := data[0], 0
+	for _, v := range data[1:] {
+		if v == s && count < 255 {
+			count++
+			continue
+		}
+		hufSendCode(w, hcode[s], count, hcode[iM])
+		s, count = v, 0
+	}
+	hufSendCode(w, hcode[s], count, hcode[iM])
+	nBits := (len(w.out)-start)*8 + w.lc
+	w.flush()
+
+	le := binary.LittleEndian
+	le.PutUint32(w.out[0:], uint32(im))
+	le.PutUint32(w.out[4:], uint32(iM))
+	le.PutUint32(w.out[8:], uint32(tableLength))
+	le.PutUint32(w.out[12:], uint32(nBits))
+	le.PutUint32(w.out[16:], 0)
+	return w.out
+}
+
+// bitReader reads bits most significant first.
+type bitReader struct {
+	in []byte
+	c  uint64
+	lc int
+}
+
+func (r *bitReader) fill() {
+	r.c = r.c<<8 | uint64(r.in[0])
+	r.in = r.in[1:]
+	r.lc += 8
+}
+
+func (r *bitReader) bits(n int) (uint64, error) {
+	for r.lc < n {
+		if len(r.in) == 0 {
+			return 0, errHufData
+		}
+		r.fill()
+	}
+	r.lc -= n
+	return r.c >> r.lc & (1<<n - 1), nil
+}
+
+// hufUnpackEncTable reads the table written by hufPackEncTable.
+func hufUnpackEncTable(r *bitReader, im, iM int) ([]uint64, error) {
+	hcode := make([]uint64, hufEncSize)
+	for ; im <= iM; im++ {
+		l, err := r.bits(6)
+		if err != nil {
+			return nil, err
+		}
+		run := 0
+		switch {
+		case l == longZeroCodeRun:
+			n, err := r.bits(8)
+			if err != nil {
+				return nil, err
+			}
+			run = int(n) + shortestLongRun
+		case l >= shortZeroCodeRun:
+			run = int(l) - shortZeroCodeRun + 2
+		default:
+			hcode[im] = l
+			continue
+		}
+		if im+run > iM+1 {
+			return nil, errHufData
+		}
+		im += run - 1
+	}
+	hufCanonicalCodeTable(hcode)
+	return hcode, nil
+}
+
+// hufDec is a decoding table entry: a short code's length and symbol, or for the
+// prefix of long codes, the symbols that start with it.
+type hufDec struct {
+	len  int
+	lit  int
+	long []int
+}
+
+func hufBuildDecTable(hcode []uint64, im, iM int) ([]hufDec, error) {
+	table := make([]hufDec, hufDecSize)
+	for ; im <= iM; im++ {
+		c, l := hufCode(hcode[im]), hufLength(hcode[im])
+		if c>>l != 0 {
+			return nil, errHufData
+		}
+		switch {
+		case l > hufDecBits:
+			d := &table[c>>(l-hufDecBits)]
+			if d.len != 0 {
+				return nil, errHufData
+			}
+			d.long = append(d.long, im)
+		case l > 0:
+			base := c << (hufDecBits - l)
+			for i := uint64(0); i < 1<<(hufDecBits-l); i++ {
+				d := &table[base+i]
+				if d.len != 0 || d.long != nil {
+					return nil, errHufData
+				}
+				d.len, d.lit = l, im
+			}
+		}
+	}
+	return table, nil
+}
+
+// hufUncompress decodes hufCompress output into out, which must have room for
+// exactly the number of values encoded.
+func hufUncompress(in []byte, out []uint16) error {
+	if len(in) == 0 {
+		if len(out) != 0 {
+			return errHufData
+		}
+		return nil
+	}
+	if len(in) < 20 {
+		return errHufData
+	}
+	le := binary.LittleEndian
+	im, iM := int(le.Uint32(in)), int(le.Uint32(in[4:]))
+	nBits := int(le.Uint32(in[12:]))
+	if im < 0 || im >= hufEncSize || iM < im || iM >= hufEncSize {
+		return errHufData
+	}
+	r := &bitReader{in: in[20:]}
+	hcode, err := hufUnpackEncTable(r, im, iM)
+	if err != nil {
+		return err
+	}
+	if nBits < 0 || nBits > 8*len(r.in) {
+		return errHufData
+	}
+	table, err := hufBuildDecTable(hcode, im, iM)
+	if err != nil {
+		return err
+	}
+	return hufDecode(hcode, table, r.in[:(nBits+7)/8], nBits, iM, out)
+}
+
+func hufDecode(hcode []uint64, table []hufDec, in []byte, nBits, rlc int, out []uint16) error {
+	n := 0
+	emit := func(r *bitReader, symbol int) error {
+		if symbol != rlc {
+			if n >= len(out) {
+				return errHufData
+			}
+			out[n] = uint16(symbol)
+			n++
+			return nil
+		}
+		count, err := r.bits(8)
+		if err != nil || n == 0 || n+int(count) > len(out) {
+			return errHufData
+		}
+		for s := out[n-1]; count > 0; count-- {
+			out[n] = s
+			n++
+		}
+		return nil
+	}
+
+	r := &bitReader{in: in}
+	for len(r.in) > 0 {
+		r.fill()
+		for r.lc >= hufDecBits {
+			d := table[r.c>>(r.lc-hufDecBits)&(hufDecSize-1)]
+			if d.len != 0 {
+				r.lc -= d.len
+				if err := emit(r, d.lit); err != nil {
+					return err
+				}
+				continue
+			}
+			// Long code, try every symbol sharing the prefix
+			found := false
+			for _, symbol := range d.long {
+				l := hufLength(hcode[symbol])
+				for r.lc < l && len(r.in) > 0 {
+					r.fill()
+				}
+				if r.lc >= l && hufCode(hcode[symbol]) == r.c>>(r.lc-l)&(1<<l-1) {
+					r.lc -= l
+					if err := emit(r, symbol); err != nil {
+						return err
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				return errHufData
+			}
+		}
+	}
+
+	// The remaining codes are short, drop the padding of the last byte first
+	pad := (8 - nBits) & 7
+	r.c >>= pad
+	r.lc -= pad
+	for r.lc > 0 {
+		d := table[r.c<<(hufDecBits-r.lc)&(hufDecSize-1)]
+		if d.len == 0 || d.len > r.lc {
+			return errHufData
+		}
+		r.lc -= d.len
+		if err := emit(r, d.lit); err != nil {
+			return err
+		}
+	}
+	if n != len(out) {
+		return errHufData
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package imageio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PIZ, OpenEXR's lossless wavelet method: the samples are read as 16-bit values,
+// the values actually present are renumbered densely, each channel is transformed
+// with a 2D Haar wavelet and the result is Huffman coded.
+
+const pizBitmapSize = 1 << 16 >> 3
+
+// pizChannel locates one channel's values inside the block buffer.
+type pizChannel struct {
+	start, nx, ny, size int
+}
+
+// pizLayout splits a block into per channel runs of 16-bit values. Float and uint
+// samples are two values each, halves one.
+func pizLayout(channels []exrChannel, width, lines int) ([]pizChannel, int) {
+	layout := make([]pizChannel, len(channels))
+	n := 0
+	for i, c := range channels {
+		size := c.size() / 2
+		layout[i] = pizChannel{start: n, nx: width, ny: lines, size: size}
+		n += width * lines * size
+	}
+	return layout, n
+}
+
+func pizCompress(raw []byte, channels []exrChannel, width, lines int) ([]byte, error) {
+	layout, n := pizLayout(channels, width, lines)
+	if n*2 != len(raw) {
+		return nil, fmt.Errorf("piz: block has %d bytes, want %d", len(raw), n*2)
+	}
+	le := binary.LittleEndian
+
+	// Gather each channel's scanlines together
+	data := make([]uint16, n)
+	fill := make([]int, len(layout))
+	for i, c := range layout {
+		fill[i] = c.start
+	}
+	for y := 0; y < lines; y++ {
+		for i, c := range layout {
+			for k := 0; k < c.nx*c.size; k++ {
+				data[fill[i]] = le.Uint16(raw)
+				raw = raw[2:]
+				fill[i]++
+			}
+		}
+	}
+
+	// Bitmap of the values present, zero is always assumed to be
+	var bitmap [pizBitmapSize]byte
+	for _, v := range data {
+		bitmap[v>>3] |= 1 << (v & 7)
+	}
+	bitmap[0] &^= 1
+	minNonZero, maxNonZero := pizBitmapSize-1, 0
+	for i, b := range bitmap {
+		if b != 0 {
+			minNonZero = min(minNonZero, i)
+			maxNonZero = max(maxNonZero, i)
+		}
+	}
+
+	var lut [1 << 16]uint16
+	k := 0
+	for i := range lut {
+		if i == 0 || bitmap[i>>3]&(1<<(i&7)) != 0 {
+			lut[i] = uint16(k)
+			k++
+		}
+	}
+	maxValue := uint16(k - 1)
+	for i, v := range data {
+		data[i] = lut[v]
+	}
+	for _, c := range layout {
+		for j := 0; j < c.size; j++ {
+			wav2Encode(data[c.start+j:], c.nx, c.size, c.ny, c.nx*c.size, maxValue)
+		}
+	}
+
+	out := le.AppendUint16(nil, uint16(minNonZero))
+	out = le.AppendUint16(out, uint16(maxNonZero))
+	if minNonZero <= maxNonZero {
+		out = append(out, bitmap[minNonZero:maxNonZero+1]...)
+	}
+	huf := hufCompress(data)
+	out = le.AppendUint32(out, uint32(len(huf)))
+	return append(out, huf...), nil
+}
+
+func pizDecompress(in []byte, channels []exrChannel, width, lines, size int) ([]byte, error) {
+	layout, n := pizLayout(channels, width, lines)
+	if n*2 != size {
+		return nil, fmt.Errorf("piz: block needs %d bytes, want %d", n*2, size)
+	}
+	le := binary.LittleEndian
+	if len(in) < 4 {
+		return nil, fmt.Errorf("piz: truncated block")
+	}
+	minNonZero, maxNonZero := int(le.Uint16(in)), int(le.Uint16(in[2:]))
+	in = in[4:]
+	if maxNonZero >= pizBitmapSize {
+		return nil, fmt.Errorf("piz: invalid bitmap range")
+	}
+	var bitmap [pizBitmapSize]byte
+	if minNonZero <= maxNonZero {
+		if len(in) < maxNonZero-minNonZero+1 {
+			return nil, fmt.Errorf("piz: truncated bitmap")
+		}
+		in = in[copy(bitmap[minNonZero:maxNonZero+1], in):]
+	}
+
+	var lut [1 << 16]uint16
+	k := 0
+	for i := 0; i < len(lut); i++ {
+		if i == 0 || bitmap[i>>3]&(1<<(i&7)) != 0 {
+			lut[k] = uint16(i)
+			k++
+		}
+	}
+	maxValue := uint16(k - 1)
+
+	if len(in) < 4 {
+		return nil, fmt.Errorf("piz: truncated block")
+	}
+	length := int(le.Uint32(in))
+	in = in[4:]
+	if length < 0 || length > len(in) {
+		return nil, fmt.Errorf("piz: truncated Huffman data")
+	}
+	data := make([]uint16, n)
+	if err := hufUncompress(in[:length], data); err != nil {
+		return nil, fmt.Errorf("piz: %v", err)
+	}
+	for _, c := range layout {
+		for j := 0; j < c.size; j++ {
+			wav2Decode(data[c.start+j:], c.nx, c.size, c.ny, c.nx*c.size, maxValue)
+		}
+	}
+	for i, v := range data {
+		data[i] = lut[v]
+	}
+
+	// Interleave the channels back into scanlines
+	raw := make([]byte, 0, size)
+	fill := make([]int, len(layout))
+	for i, c := range layout {
+		fill[i] = c.start
+	}
+	for y := 0; y < lines; y++ {
+		for i, c := range layout {
+			for k := 0; k < c.nx*c.size; k++ {
+				raw = le.AppendUint16(raw, data[fill[i]])
+				fill[i]++
+			}
+		}
+	}
+	return raw, nil
+}
+
+// Wavelet
+
+// wenc14 and wdec14 are the lossless Haar step for values below 1<<14, where the
+// sum and difference fit in a signed 16-bit value.
+func wenc14(a, b uint16) (l, h uint16) {
+	as, bs := int16(a), int16(b)
+	return uint16((int32(as) + int32(bs)) >> 1), uint16(as - bs)
+}
+
+func wdec14(l, h uint16) (a, b uint16) {
+	ls, hs := int32(int16(l)), int32(int16(h))
+	ai := ls + (hs & 1) + (hs >> 1)
+	return uint16(int16(ai)), uint16(int16(ai - hs))
+}
+
+// wenc16 and wdec16 handle the full 16-bit range with modular arithmetic.
+func wenc16(a, b uint16) (l, h uint16) {
+	const offset, mask = 1 << 15, 1<<16 - 1
+	ao := (int32(a) + offset) & mask
+	m := (ao + int32(b)) >> 1
+	d := ao - int32(b)
+	if d < 0 {
+		m = (m + offset) & mask
+	}
+	return uint16(m), uint16(d & mask)
+}
+
+func wdec16(l, h uint16) (a, b uint16) {
+	const offset, mask = 1 << 15, 1<<16 - 1
+	m, d := int32(l), int32(h)
+	bb := (m - (d >> 1)) & mask
+	aa := (d + bb - offset) & mask
+	return uint16(aa), uint16(bb)
+}
+
+// wav2Encode transforms an nx by ny grid whose values are ox apart along a row and
+// oy apart between rows, in place. mx is the largest value present.
+func wav2Encode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	enc := wenc16
+	if mx < 1<<14 {
+		enc = wenc14
+	}
+	n := min(nx, ny)
+	for p, p2 := 1, 2; p2 <= n; p, p2 = p2, p2<<1 {
+		oy1, oy2 := oy*p, oy*p2
+		ox1, ox2 := ox*p, ox*p2
+		py := 0
+		for ; py <= oy*(ny-p2); py += oy2 {
+			px := py
+			for ; px <= py+ox*(nx-p2); px += ox2 {
+				p01, p10 := px+ox1, px+oy1
+				p11 := p10 + ox1
+				i00, i01 := enc(in[px], in[p01])
+				i10, i11 := enc(in[p10], in[p11])
+				in[px], in[p10] = enc(i00, i10)
+				in[p01], in[p11] = enc(i01, i11)
+			}
+			if nx&p != 0 {
+				p10 := px + oy1
+				in[px], in[p10] = enc(in[px], in[p10])
+			}
+		}
+		if ny&p != 0 {
+			for px := py; px <= py+ox*(nx-p2); px += ox2 {
+				p01 := px + ox1
+				in[px], in[p01] = enc(in[px], in[p01])
+			}
+		}
+	}
+}
+
+// wav2Decode inverts wav2Encode.
+func wav2Decode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	dec := wdec16
+	if mx < 1<<14 {
+		dec = wdec14
+	}
+	n := min(nx, ny)
+	p := 1
+	for p <= n {
+		p <<= 1
+	}
+	p >>= 1
+	p2 := p
+	p >>= 1
+	for ; p >= 1; p2, p = p, p>>1 {
+		oy1, oy2 := oy*p, oy*p2
+		ox1, ox2 := ox*p, ox*p2
+		py := 0
+		for ; py <= oy*(ny-p2); py += oy2 {
+			px := py
+			for ; px <= py+ox*(nx-p2); px += ox2 {
+				p01, p10 := px+ox1, px+oy1
+				p11 := p10 + ox1
+				i00, i10 := dec(in[px], in[p10])
+				i01, i11 := dec(in[p01], in[p11])
+				in[px], in[p01] = dec(i00, i01)
+				in[p10], in[p11] = dec(i10, i11)
+			}
+			if nx&p != 0 {
+				p10 := px + oy1
+				in[px], in[p10] = dec(in[px], in[p10])
+			}
+		}
+		if ny&p != 0 {
+			for px := py; px <= py+ox*(nx-p2); px += ox2 {
+				p01 := px + ox1
+				in[px], in[p01] = dec(in[px], in[p01])
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package imageio
+
+import (
+	"bytes"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// testChannels fills RGB and a layered AOV channel with values spanning the float
+// range, including zeros, negatives and infinities that a codec might mangle.
+func testChannels(width, height int, rng *rand.Rand) []Channel {
+	names := []string{"R", "G", "B", "albedo.R"}
+	channels := make([]Channel, len(names))
+	for i, name := range names {
+		data := make([]float32, width*height)
+		for j := range data {
+			switch rng.IntN(8) {
+			case 0:
+				data[j] = 0
+			case 1:
+				data[j] = float32(math.Inf(1))
+			case 2:
+				data[j] = -rng.Float32()
+			default:
+				data[j] = float32(math.Exp(rng.Float64()*40 - 20))
+			}
+		}
+		channels[i] = Channel{Name: name, Data: data}
+	}
+	return channels
+}
+
+func TestEXRRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, compression := range []EXRCompression{EXRNone, EXRZIP, EXRPIZ} {
+		// Sizes below, at odd multiples of and past the lines per block
+		for _, size := range [][2]int{{1, 1}, {7, 3}, {65, 33}} {
+			width, height := size[0], size[1]
+			want := testChannels(width, height, rng)
+			var buf bytes.Buffer
+			if err := WriteEXR(&buf, width, height, want, EXROptions{Compression: compression}); err != nil {
+				t.Fatalf("%v %dx%d: write: %v", compression, width, height, err)
+			}
+			w, h, got, err := ReadEXR(&buf)
+			if err != nil {
+				t.Fatalf("%v %dx%d: read: %v", compression, width, height, err)
+			}
+			if w != width || h != height || len(got) != len(want) {
+				t.Fatalf("%v %dx%d: read %dx%d with %d channels", compression, width, height, w, h, len(got))
+			}
+			byName := map[string][]float32{}
+			for _, c := range got {
+				byName[c.Name] = c.Data
+			}
+			for _, c := range want {
+				data, ok := byName[c.Name]
+				if !ok {
+					t.Fatalf("%v %dx%d: channel %s missing", compression, width, height, c.Name)
+				}
+				for i, v := range c.Data {
+					if math.Float32bits(data[i]) != math.Float32bits(v) {
+						t.Fatalf("%v %dx%d: %s[%d] = %v, want %v", compression, width, height, c.Name, i, data[i], v)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package imageio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// zipCompress is OpenEXR's ZIP method: the bytes are split into even and odd
+// halves, delta encoded and deflated. Splitting puts the slowly varying high bytes
+// of neighbouring samples next to each other.
+func zipCompress(raw []byte) ([]byte, error) {
+	tmp := make([]byte, len(raw))
+	half := (len(raw) + 1) / 2
+	for i, b := range raw {
+		if i%2 == 0 {
+			tmp[i/2] = b
+		} else {
+			tmp[half+i/2] = b
+		}
+	}
+	for i := len(tmp) - 1; i > 0; i-- {
+		tmp[i] -= tmp[i-1] - 128
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(tmp); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zipDecompress undoes zipCompress, size is the uncompressed length.
+func zipDecompress(data []byte, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tmp := make([]byte, size)
+	if _, err := io.ReadFull(zr, tmp); err != nil {
+		return nil, fmt.Errorf("zip: %v", err)
+	}
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] += tmp[i-1] - 128
+	}
+	raw := make([]byte, size)
+	half := (size + 1) / 2
+	for i := range raw {
+		if i%2 == 0 {
+			raw[i] = tmp[i/2]
+		} else {
+			raw[i] = tmp[half+i/2]
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,163 @@
+package imageio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Framebuffer is a float32 RGBA image, four values per pixel, row-major from the
+// top-left. Color is linear, alpha is not premultiplied into it.
+type Framebuffer struct {
+	Width  int
+	Height int
+	Pix    []float32
+}
+
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{
+		Width:  width,
+		Height: height,
+		Pix:    make([]float32, width*height*4),
+	}
+}
+
+// FramebufferFromRGB wraps interleaved RGB floats with an opaque alpha.
+func FramebufferFromRGB(width, height int, rgb []float32) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	for i := 0; i < width*height; i++ {
+		copy(fb.Pix[i*4:i*4+3], rgb[i*3:i*3+3])
+		fb.Pix[i*4+3] = 1
+	}
+	return fb
+}
+
+func (fb *Framebuffer) At(x, y int) [4]float32 {
+	i := (y*fb.Width + x) * 4
+	return [4]float32(fb.Pix[i : i+4])
+}
+
+func (fb *Framebuffer) Set(x, y int, c [4]float32) {
+	i := (y*fb.Width + x) * 4
+	copy(fb.Pix[i:i+4], c[:])
+}
+
+// RGB returns the color as interleaved RGB floats, dropping alpha.
+func (fb *Framebuffer) RGB() []float32 {
+	n := fb.Width * fb.Height
+	rgb := make([]float32, n*3)
+	for i := 0; i < n; i++ {
+		copy(rgb[i*3:i*3+3], fb.Pix[i*4:i*4+3])
+	}
+	return rgb
+}
+
+// Channels splits the framebuffer into R, G, B and A planes for WriteEXR.
+func (fb *Framebuffer) Channels() []Channel {
+	n := fb.Width * fb.Height
+	channels := []Channel{{"R", make([]float32, n)}, {"G", make([]float32, n)}, {"B", make([]float32, n)}, {"A", make([]float32, n)}}
+	for i := 0; i < n; i++ {
+		for c := range channels {
+			channels[c].Data[i] = fb.Pix[i*4+c]
+		}
+	}
+	return channels
+}
+
+// FramebufferFromChannels picks the R, G, B and A planes out of decoded EXR channels.
+// A lone Y channel is read as grey, missing alpha as opaque.
+func FramebufferFromChannels(width, height int, channels []Channel) (*Framebuffer, error) {
+	byName := make(map[string][]float32, len(channels))
+	for _, c := range channels {
+		byName[c.Name] = c.Data
+	}
+	planes := [4][]float32{byName["R"], byName["G"], byName["B"], byName["A"]}
+	if planes[0] == nil || planes[1] == nil || planes[2] == nil {
+		y := byName["Y"]
+		if y == nil {
+			return nil, fmt.Errorf("no R, G, B or Y channels")
+		}
+		planes[0], planes[1], planes[2] = y, y, y
+	}
+	fb := NewFramebuffer(width, height)
+	for i := 0; i < width*height; i++ {
+		for c, plane := range planes {
+			if plane != nil {
+				fb.Pix[i*4+c] = plane[i]
+			} else {
+				fb.Pix[i*4+c] = 1
+			}
+		}
+	}
+	return fb, nil
+}
+
+// Load reads a float image, picking the format from the extension: .exr, .pfm or .hdr.
+func Load(path string) (*Framebuffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".exr":
+		width, height, channels, err := ReadEXR(f)
+		if err != nil {
+			return nil, err
+		}
+		return FramebufferFromChannels(width, height, channels)
+	case ".pfm":
+		width, height, rgb, err := ReadPFM(f)
+		if err != nil {
+			return nil, err
+		}
+		return FramebufferFromRGB(width, height, rgb), nil
+	case ".hdr":
+		width, height, rgb, err := ReadHDR(f)
+		if err != nil {
+			return nil, err
+		}
+		return FramebufferFromRGB(width, height, rgb), nil
+	default:
+		return nil, fmt.Errorf("unsupported image format %q, use .exr, .pfm or .hdr", ext)
+	}
+}
+
+// SaveOptions controls Save.
+type SaveOptions struct {
+	Compression EXRCompression // for .exr
+	// Display turns the values into .png pixels, its working space also tags .exr files
+	Display DisplayTransform
+}
+
+// Save writes a framebuffer, picking the format from the extension: .exr, .pfm and
+// .hdr keep the float values, .png goes through the display transform to 8-bit sRGB.
+func Save(path string, fb *Framebuffer, opts SaveOptions) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".exr" && ext != ".pfm" && ext != ".hdr" && ext != ".png" {
+		return fmt.Errorf("unsupported image format %q, use .exr, .pfm, .hdr or .png", ext)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch ext {
+	case ".exr":
+		err = WriteEXR(f, fb.Width, fb.Height, fb.Channels(), EXROptions{Compression: opts.Compression, Space: opts.Display.WorkingSpace})
+	case ".pfm":
+		err = WritePFM(f, fb.Width, fb.Height, fb.RGB())
+	case ".hdr":
+		err = WriteHDR(f, fb.Width, fb.Height, fb.RGB())
+	case ".png":
+		err = WritePNGDisplay(f, fb, opts.Display)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
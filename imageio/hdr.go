@@ -0,0 +1,221 @@
+package imageio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// ReadHDR decodes a Radiance RGBE (.hdr) image into linear RGB floats, three per
+// pixel, row-major from the top-left. Both run-length encoded and flat scanlines
+// are supported; the image must use the standard "-Y height +X width" orientation.
+func ReadHDR(r io.Reader) (width, height int, rgb []float32, err error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.ReadString('\n')
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("hdr: read header: %v", err)
+	}
+	if !strings.HasPrefix(magic, "#?") {
+		return 0, 0, nil, fmt.Errorf("hdr: not a Radiance file")
+	}
+
+	// Header variables end with an empty line
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("hdr: read header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return 0, 0, nil, fmt.Errorf("hdr: unsupported format %s", format)
+		}
+	}
+
+	resolution, err := br.ReadString('\n')
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("hdr: read resolution: %v", err)
+	}
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil {
+		return 0, 0, nil, fmt.Errorf("hdr: unsupported resolution line %q", strings.TrimSpace(resolution))
+	}
+	if width <= 0 || height <= 0 || width > maxPixels/height {
+		return 0, 0, nil, fmt.Errorf("hdr: invalid size %dx%d", width, height)
+	}
+
+	rgb = make([]float32, width*height*3)
+	scanline := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(br, scanline, width); err != nil {
+			return 0, 0, nil, fmt.Errorf("hdr: scanline %d: %v", y, err)
+		}
+		row := rgb[y*width*3 : (y+1)*width*3]
+		for x := 0; x < width; x++ {
+			decodeRGBE(scanline[x*4:x*4+4], row[x*3:x*3+3])
+		}
+	}
+	return width, height, rgb, nil
+}
+
+// LoadHDR reads a Radiance RGBE file from disk.
+func LoadHDR(path string) (width, height int, rgb []float32, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer f.Close()
+	return ReadHDR(f)
+}
+
+// readHDRScanline reads one scanline as interleaved RGBE bytes.
+func readHDRScanline(br *bufio.Reader, out []byte, width int) error {
+	head, err := br.Peek(4)
+	if err != nil {
+		return err
+	}
+	// New style run-length encoding starts with 2, 2 and the scanline width
+	if width < 8 || width > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		_, err := io.ReadFull(br, out)
+		return err
+	}
+	if int(head[2])<<8|int(head[3]) != width {
+		return fmt.Errorf("scanline width mismatch")
+	}
+	br.Discard(4)
+
+	// Each component is stored separately as runs and literals
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count) - 128
+				if x+n > width {
+					return fmt.Errorf("run overflows scanline")
+				}
+				v, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				for i := 0; i < n; i++ {
+					out[(x+i)*4+c] = v
+				}
+				x += n
+			} else {
+				n := int(count)
+				if n == 0 || x+n > width {
+					return fmt.Errorf("invalid literal length")
+				}
+				for i := 0; i < n; i++ {
+					v, err := br.ReadByte()
+					if err != nil {
+						return err
+					}
+					out[(x+i)*4+c] = v
+				}
+				x += n
+			}
+		}
+	}
+	return nil
+}
+
+// decodeRGBE converts a shared-exponent pixel to linear floats.
+func decodeRGBE(p []byte, out []float32) {
+	if p[3] == 0 {
+		out[0], out[1], out[2] = 0, 0, 0
+		return
+	}
+	f := math.Ldexp(1, int(p[3])-(128+8))
+	out[0] = float32((float64(p[0]) + 0.5) * f)
+	out[1] = float32((float64(p[1]) + 0.5) * f)
+	out[2] = float32((float64(p[2]) + 0.5) * f)
+}
+
+// WriteHDR encodes RGB floats as a run-length encoded Radiance RGBE file.
+func WriteHDR(w io.Writer, width, height int, rgb []float32) error {
+	if len(rgb) != width*height*3 {
+		return fmt.Errorf("hdr: %d values for a %dx%d image", len(rgb), width, height)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", height, width)
+
+	scanline := make([]byte, width*4)
+	planes := make([]byte, width)
+	for y := 0; y < height; y++ {
+		row := rgb[y*width*3 : (y+1)*width*3]
+		for x := 0; x < width; x++ {
+			encodeRGBE(row[x*3:x*3+3], scanline[x*4:x*4+4])
+		}
+		// Scanlines too short or long for run-length encoding are stored flat
+		if width < 8 || width > 0x7fff {
+			if _, err := bw.Write(scanline); err != nil {
+				return err
+			}
+			continue
+		}
+		bw.Write([]byte{2, 2, byte(width >> 8), byte(width)})
+		for c := 0; c < 4; c++ {
+			for x := range planes {
+				planes[x] = scanline[x*4+c]
+			}
+			writeHDRRuns(bw, planes)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeHDRRuns run-length encodes one component of a scanline. Runs of at least
+// four equal bytes are stored as runs, everything between them as literals.
+func writeHDRRuns(bw *bufio.Writer, data []byte) {
+	const minRun = 4
+	for cur := 0; cur < len(data); {
+		// Find the next run long enough to be worth encoding
+		start, run := cur, 0
+		for start < len(data) {
+			run = 1
+			for start+run < len(data) && run < 127 && data[start+run] == data[start] {
+				run++
+			}
+			if run >= minRun {
+				break
+			}
+			start += run
+		}
+		// Literals up to the run, at most 128 at a time
+		for cur < start {
+			n := min(start-cur, 128)
+			bw.WriteByte(byte(n))
+			bw.Write(data[cur : cur+n])
+			cur += n
+		}
+		if start < len(data) {
+			bw.WriteByte(byte(128 + run))
+			bw.WriteByte(data[start])
+			cur += run
+		}
+	}
+}
+
+// encodeRGBE packs a linear pixel into shared-exponent bytes.
+func encodeRGBE(in []float32, p []byte) {
+	v := math.Max(float64(in[0]), math.Max(float64(in[1]), float64(in[2])))
+	if v < 1e-32 {
+		p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+		return
+	}
+	m, e := math.Frexp(v)
+	scale := m * 256 / v
+	p[0] = byte(math.Max(float64(in[0]), 0) * scale)
+	p[1] = byte(math.Max(float64(in[1]), 0) * scale)
+	p[2] = byte(math.Max(float64(in[2]), 0) * scale)
+	p[3] = byte(e + 128)
+}
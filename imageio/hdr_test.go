@@ -0,0 +1,54 @@
+package imageio
+
+import (
+	"bytes"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestHDRRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	// Run-length encoding only covers widths from 8 to 32767, the rest are flat
+	for _, size := range [][2]int{{1, 1}, {7, 3}, {8, 2}, {65, 33}, {32767, 1}, {32768, 2}} {
+		width, height := size[0], size[1]
+		want := make([]float32, width*height*3)
+		for i := range want {
+			// Runs of equal pixels exercise the run-length encoder
+			if i >= 3 && rng.IntN(4) == 0 {
+				want[i] = want[i-3]
+				continue
+			}
+			want[i] = float32(math.Exp(rng.Float64()*20 - 10))
+		}
+		var buf bytes.Buffer
+		if err := WriteHDR(&buf, width, height, want); err != nil {
+			t.Fatalf("%dx%d: write: %v", width, height, err)
+		}
+		w, h, got, err := ReadHDR(&buf)
+		if err != nil {
+			t.Fatalf("%dx%d: read: %v", width, height, err)
+		}
+		if w != width || h != height {
+			t.Fatalf("%dx%d: read %dx%d", width, height, w, h)
+		}
+		// RGBE keeps 8 bits of mantissa relative to the brightest channel of a pixel
+		for p := 0; p < width*height; p++ {
+			hi := max(want[p*3], want[p*3+1], want[p*3+2])
+			for c := 0; c < 3; c++ {
+				i := p*3 + c
+				if math.Abs(float64(got[i]-want[i])) > float64(hi)/128 {
+					t.Fatalf("%dx%d: value %d = %v, want %v", width, height, i, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+// A header claiming a huge image must fail before the reader allocates for it.
+func TestHDRRejectsHugeSize(t *testing.T) {
+	data := []byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 100000 +X 100000\n")
+	if _, _, _, err := ReadHDR(bytes.NewReader(data)); err == nil {
+		t.Error("accepted a 100000x100000 image")
+	}
+}
@@ -0,0 +1,76 @@
+package imageio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ReadPFM decodes a Portable Float Map into RGB floats, three per pixel, row-major
+// from the top-left. Greyscale ("Pf") files are expanded to RGB.
+func ReadPFM(r io.Reader) (width, height int, rgb []float32, err error) {
+	br := bufio.NewReader(r)
+	var magic string
+	var scale float64
+	if _, err := fmt.Fscan(br, &magic, &width, &height, &scale); err != nil {
+		return 0, 0, nil, fmt.Errorf("pfm: read header: %v", err)
+	}
+	// Exactly one whitespace character separates the header from the data
+	if _, err := br.ReadByte(); err != nil {
+		return 0, 0, nil, fmt.Errorf("pfm: read header: %v", err)
+	}
+
+	channels := 3
+	switch magic {
+	case "PF":
+	case "Pf":
+		channels = 1
+	default:
+		return 0, 0, nil, fmt.Errorf("pfm: not a PFM file")
+	}
+	if width <= 0 || height <= 0 || width > maxPixels/height || scale == 0 {
+		return 0, 0, nil, fmt.Errorf("pfm: invalid header %dx%d scale %v", width, height, scale)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+
+	row := make([]byte, width*channels*4)
+	rgb = make([]float32, width*height*3)
+	// Rows are stored bottom to top
+	for y := height - 1; y >= 0; y-- {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return 0, 0, nil, fmt.Errorf("pfm: read pixels: %v", err)
+		}
+		out := rgb[y*width*3 : (y+1)*width*3]
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				src := x*channels + min(c, channels-1)
+				out[x*3+c] = math.Float32frombits(order.Uint32(row[src*4:]))
+			}
+		}
+	}
+	return width, height, rgb, nil
+}
+
+// WritePFM writes RGB floats as a little-endian color Portable Float Map.
+func WritePFM(w io.Writer, width, height int, rgb []float32) error {
+	if len(rgb) != width*height*3 {
+		return fmt.Errorf("pfm: %d values for a %dx%d image", len(rgb), width, height)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", width, height)
+	row := make([]byte, width*3*4)
+	for y := height - 1; y >= 0; y-- {
+		for i, v := range rgb[y*width*3 : (y+1)*width*3] {
+			binary.LittleEndian.PutUint32(row[i*4:], math.Float32bits(v))
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,54 @@
+package imageio
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestPFMRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	width, height := 7, 3
+	want := make([]float32, width*height*3)
+	for i := range want {
+		want[i] = rng.Float32()*100 - 10
+	}
+	var buf bytes.Buffer
+	if err := WritePFM(&buf, width, height, want); err != nil {
+		t.Fatal(err)
+	}
+	w, h, got, err := ReadPFM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != width || h != height {
+		t.Fatalf("read %dx%d, want %dx%d", w, h, width, height)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("value %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPFMGreyscale(t *testing.T) {
+	data := []byte("Pf\n2 1\n-1.0\n\x00\x00\x80\x3f\x00\x00\x00\x40")
+	_, _, rgb, err := ReadPFM(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float32{1, 1, 1, 2, 2, 2}
+	for i := range want {
+		if rgb[i] != want[i] {
+			t.Fatalf("got %v, want %v", rgb, want)
+		}
+	}
+}
+
+// A header claiming a huge image must fail before the reader allocates for it.
+func TestPFMRejectsHugeSize(t *testing.T) {
+	data := []byte("PF\n100000 100000\n-1.0\n")
+	if _, _, _, err := ReadPFM(bytes.NewReader(data)); err == nil {
+		t.Error("accepted a 100000x100000 image")
+	}
+}
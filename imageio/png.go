@@ -0,0 +1,45 @@
+package imageio
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// WritePNG encodes linear RGB floats (three per pixel, row-major from the top-left)
+// as an 8-bit sRGB PNG. Values are clamped to [0, 1] before encoding.
+func WritePNG(w io.Writer, width, height int, rgb []float32) error {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * 3
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: encodeSRGB8(rgb[i]),
+				G: encodeSRGB8(rgb[i+1]),
+				B: encodeSRGB8(rgb[i+2]),
+				A: 255,
+			})
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// encodeSRGB8 applies the sRGB transfer function and quantizes to 8 bits.
+func encodeSRGB8(v float32) uint8 {
+	return quantize8(SRGBOETF(clamp01(float64(v))))
+}
+
+// WritePNGDisplay encodes the framebuffer as an 8-bit sRGB PNG with alpha, through
+// the display transform.
+func WritePNGDisplay(w io.Writer, fb *Framebuffer, d DisplayTransform) error {
+	img := image.NewNRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			c := fb.At(x, y)
+			p := d.Encode(float64(c[0]), float64(c[1]), float64(c[2]))
+			img.SetNRGBA(x, y, color.NRGBA{R: p[0], G: p[1], B: p[2], A: quantize8(float64(c[3]))})
+		}
+	}
+	return png.Encode(w, img)
+}
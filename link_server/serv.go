@@ -2,10 +2,12 @@ package link_server
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"go-ray-tracing/core"
+	"go-ray-tracing/tracer"
 	"net"
 	"strings"
 	"sync"
@@ -33,6 +35,42 @@ type MeshData struct {
 	Normals   [][3]float32 `json:"normals"`
 	TexCoords [][2]float32 `json:"texCoords"`
 	Indices   []int32      `json:"indices"`
+
+	// Material optionally replaces the geometry's material, in the scene file's
+	// layout, e.g. {"baseColor": [0.8, 0.5, 0.4], "subsurface": 1, "subsurfaceScale": 0.02}
+	Material *tracer.SceneFileMaterial `json:"material"`
+}
+
+// CameraData drives the viewport camera from the DCC. Every field is optional so a
+// client can send only what changed.
+type CameraData struct {
+	Position      *[3]float32 `json:"position"`
+	Target        *[3]float32 `json:"target"`
+	Up            *[3]float32 `json:"up"`
+	Fovy          *float32    `json:"fovy"`
+	FocalLength   *float32    `json:"focalLength"`  // mm, overrides fovy
+	SensorHeight  *float32    `json:"sensorHeight"` // mm
+	FStop         *float32    `json:"fStop"`
+	FocusDistance *float32    `json:"focusDistance"`
+	ShutterTime   *float32    `json:"shutter"` // seconds
+	ISO           *float32    `json:"iso"`
+}
+
+// VolumeData fills a geometry with a voxel grid medium, such as a smoke or fire
+// cache. A VOLM message body is a 4-byte big-endian length, this header as JSON of
+// that length, then a voxel grid file as described in the tracer package. The
+// geometry becomes a box around the grid; coefficients are per unit length at
+// density 1 and every field but the name is optional.
+type VolumeData struct {
+	Name             string      `json:"name"`
+	Absorption       *[3]float32 `json:"absorption"`
+	Scattering       *[3]float32 `json:"scattering"`
+	G                float32     `json:"g"`
+	Emission         *[3]float32 `json:"emission"`         // per unit of the emission channel
+	Blackbody        float32     `json:"blackbody"`        // luminance at 1500 K
+	TemperatureScale *float32    `json:"temperatureScale"` // converts the temperature channel to Kelvin
+
+	grid *tracer.VoxelGrid
 }
 
 type LiveLinkServer struct {
@@ -46,8 +84,20 @@ type LiveLinkServer struct {
 	meshChan chan MeshData
 	// Channel for communicating transform data to main thread
 	transformChan chan TransformData
+	// Channel for communicating camera data to main thread
+	cameraChan chan CameraData
+	// Channel for communicating volume data to main thread
+	volumeChan chan VolumeData
+
+	// lastMotion is when each geometry blurred by live-link motion last moved
+	lastMotion map[string]time.Time
 }
 
+// motionSettle is how long a geometry keeps its motion blur after the last
+// transform moved it. Clients stop sending once an object stops, so without it the
+// last step would stay blurred.
+const motionSettle = 300 * time.Millisecond
+
 func NewLiveLinkServer(host, port string, scene *core.Scene3D) *LiveLinkServer {
 	return &LiveLinkServer{
 		host:          host,
@@ -56,6 +106,9 @@ func NewLiveLinkServer(host, port string, scene *core.Scene3D) *LiveLinkServer {
 		scene:         scene,
 		meshChan:      make(chan MeshData, 100),
 		transformChan: make(chan TransformData, 100),
+		cameraChan:    make(chan CameraData, 100),
+		volumeChan:    make(chan VolumeData, 8),
+		lastMotion:    make(map[string]time.Time),
 	}
 }
 
@@ -106,6 +159,8 @@ func (s *LiveLinkServer) Start() error {
 func (s *LiveLinkServer) ProcessStream() {
 	s.ProcessMeshUpdates()
 	s.ProcessTransformUpdates()
+	s.ProcessCameraUpdates()
+	s.ProcessVolumeUpdates()
 }
 
 func (s *LiveLinkServer) ProcessMeshUpdates() {
@@ -132,6 +187,49 @@ func (s *LiveLinkServer) ProcessTransformUpdates() {
 	default:
 		// No transform data to process
 	}
+	s.settleMotion()
+}
+
+// settleMotion stops the motion blur of geometries that have not moved for
+// motionSettle.
+func (s *LiveLinkServer) settleMotion() {
+	for name, t := range s.lastMotion {
+		if time.Since(t) < motionSettle {
+			continue
+		}
+		delete(s.lastMotion, name)
+		for _, geom := range s.scene.Geometries {
+			if geom.Name == name {
+				geom.ClearMotion()
+			}
+		}
+	}
+}
+
+func (s *LiveLinkServer) ProcessCameraUpdates() {
+	select {
+	case cameraData, ok := <-s.cameraChan:
+		if ok {
+			s.handleCameraDataMainThread(cameraData)
+		} else {
+			fmt.Println("Camera channel closed")
+		}
+	default:
+		// No camera data to process
+	}
+}
+
+func (s *LiveLinkServer) ProcessVolumeUpdates() {
+	select {
+	case volumeData, ok := <-s.volumeChan:
+		if ok {
+			s.handleVolumeDataMainThread(volumeData)
+		} else {
+			fmt.Println("Volume channel closed")
+		}
+	default:
+		// No volume data to process
+	}
 }
 
 func (s *LiveLinkServer) handleClient(conn net.Conn) {
@@ -226,6 +324,26 @@ func (s *LiveLinkServer) handleClient(conn net.Conn) {
 			// Send to main thread via channel
 			s.meshChan <- meshData
 
+		case "CAMR": // Camera data
+			var cameraData CameraData
+			if err := json.Unmarshal(messageData, &cameraData); err != nil {
+				fmt.Printf("Error parsing camera data from %s: %v\n", conn.RemoteAddr().String(), err)
+				continue
+			}
+			fmt.Printf("Received camera data\n")
+			// Send to main thread via channel
+			s.cameraChan <- cameraData
+
+		case "VOLM": // Volume data
+			volumeData, err := parseVolumeData(messageData)
+			if err != nil {
+				fmt.Printf("Error parsing volume data from %s: %v\n", conn.RemoteAddr().String(), err)
+				continue
+			}
+			fmt.Printf("Received volume for: %s, bricks: %d\n", volumeData.Name, volumeData.grid.BrickCount())
+			// Send to main thread via channel
+			s.volumeChan <- volumeData
+
 		case "PING": // Ping message for testing
 			fmt.Printf("Ping received from %s\n", conn.RemoteAddr().String())
 			response := []byte("PONG")
@@ -283,27 +401,22 @@ func (s *LiveLinkServer) handleTransformDataMainThread(data TransformData) {
 	for _, geom := range s.scene.Geometries {
 		if geom.Name == data.Name {
 			found = true
-			// Update position
-			geom.Position = rl.NewVector3(
-				data.Position[0],
-				data.Position[1],
-				data.Position[2],
-			)
-
-			// Update rotation (quaternion)
-			geom.SetQuaternion(
-				data.Rotation[0],
-				data.Rotation[1],
-				data.Rotation[2],
-				data.Rotation[3],
-			)
-
-			// Update scale
-			geom.Scale = rl.NewVector3(
-				data.Scale[0],
-				data.Scale[1],
-				data.Scale[2],
-			)
+			pose := core.GeometryTransform{
+				Position:   rl.NewVector3(data.Position[0], data.Position[1], data.Position[2]),
+				Quaternion: rl.NewVector4(data.Rotation[0], data.Rotation[1], data.Rotation[2], data.Rotation[3]),
+				Scale:      rl.NewVector3(data.Scale[0], data.Scale[1], data.Scale[2]),
+			}
+			if s.scene.LiveLinkMotionBlur && pose != geom.Pose() {
+				// The last two transforms become shutter open and close
+				geom.PushTransform(pose)
+				s.lastMotion[geom.Name] = time.Now()
+			} else if s.scene.LiveLinkMotionBlur {
+				// An unchanged pose means the object stopped
+				geom.ClearMotion()
+				delete(s.lastMotion, geom.Name)
+			} else {
+				geom.SetPose(pose)
+			}
 			fmt.Printf("Updated geometry %v: Position: %v, Rotation: %v, Scale %v\n", geom.Name, data.Position, data.Rotation, data.Scale)
 
 			break
@@ -318,6 +431,48 @@ func (s *LiveLinkServer) handleTransformDataMainThread(data TransformData) {
 	}
 }
 
+func (s *LiveLinkServer) handleCameraDataMainThread(data CameraData) {
+	if s.scene == nil || s.scene.Camera == nil {
+		fmt.Printf("Warning: Scene has no camera, cannot process camera data\n")
+		return
+	}
+	cam := s.scene.Camera
+
+	if data.Position != nil {
+		cam.Camera.Position = rl.NewVector3(data.Position[0], data.Position[1], data.Position[2])
+	}
+	if data.Target != nil {
+		cam.Camera.Target = rl.NewVector3(data.Target[0], data.Target[1], data.Target[2])
+	}
+	if data.Up != nil {
+		cam.Camera.Up = rl.NewVector3(data.Up[0], data.Up[1], data.Up[2])
+	}
+	if data.SensorHeight != nil && *data.SensorHeight > 0 {
+		cam.SensorHeight = *data.SensorHeight
+	}
+	if data.Fovy != nil {
+		cam.Camera.Fovy = *data.Fovy
+	}
+	if data.FocalLength != nil && *data.FocalLength > 0 {
+		cam.SetFocalLength(*data.FocalLength)
+	}
+	if data.FStop != nil {
+		cam.FStop = *data.FStop
+	}
+	if data.FocusDistance != nil {
+		cam.FocusDistance = *data.FocusDistance
+	}
+	if data.ShutterTime != nil {
+		cam.ShutterTime = *data.ShutterTime
+	}
+	if data.ISO != nil {
+		cam.ISO = *data.ISO
+	}
+
+	fmt.Printf("Updated camera: Fovy: %.2f, f/%.1f, Focus: %.2f, Shutter: %.4fs, ISO: %.0f\n",
+		cam.Camera.Fovy, cam.FStop, cam.FocusDistance, cam.ShutterTime, cam.ISO)
+}
+
 func (s *LiveLinkServer) handleMeshDataMainThread(data MeshData) {
 	// Add a safety check at the beginning
 	if s.scene == nil {
@@ -359,11 +514,8 @@ func (s *LiveLinkServer) handleMeshDataMainThread(data MeshData) {
 			meshData.Normals[i] = rl.NewVector3(n[0], n[1], n[2])
 		}
 	} else {
-		// Generate simple normals if not provided
-		meshData.Normals = make([]rl.Vector3, len(data.Vertices))
-		for i := range meshData.Normals {
-			meshData.Normals[i] = rl.NewVector3(0, 1, 0) // Default up normal
-		}
+		// Generate smooth normals from the triangles if not provided
+		meshData.ComputeNormals()
 	}
 
 	// Convert texture coordinates (if provided)
@@ -389,10 +541,76 @@ func (s *LiveLinkServer) handleMeshDataMainThread(data MeshData) {
 		if s.scene.DefaultShader != nil && geom.Model.MeshCount > 0 {
 			geom.Model.Materials.Shader = *s.scene.DefaultShader
 		}
+		if data.Material != nil {
+			if material, err := data.Material.Build(); err != nil {
+				fmt.Printf("Warning: Invalid material for %s: %v\n", data.Name, err)
+			} else {
+				geom.SetSurface(material)
+			}
+		}
 		fmt.Printf("Mesh updated successfully for: %s\n", data.Name)
 	}
 }
 
+// parseVolumeData splits a VOLM message into its header and grid. The grid is
+// decoded here so the main thread only swaps it in.
+func parseVolumeData(data []byte) (VolumeData, error) {
+	var v VolumeData
+	if len(data) < 4 {
+		return v, fmt.Errorf("missing header length")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return v, fmt.Errorf("header length %d exceeds message", n)
+	}
+	if err := json.Unmarshal(data[4:4+n], &v); err != nil {
+		return v, err
+	}
+	grid, err := tracer.ReadVoxelGrid(bytes.NewReader(data[4+n:]))
+	if err != nil {
+		return v, err
+	}
+	v.grid = grid
+	return v, nil
+}
+
+func (s *LiveLinkServer) handleVolumeDataMainThread(data VolumeData) {
+	if s.scene == nil {
+		fmt.Printf("Warning: Scene is nil, cannot process volume data for %s\n", data.Name)
+		return
+	}
+
+	vec := func(v [3]float32) tracer.Vec3 {
+		return tracer.NewVec3(float64(v[0]), float64(v[1]), float64(v[2]))
+	}
+	medium := tracer.NewGridMedium(data.grid)
+	if data.Absorption != nil {
+		medium.SigmaA = vec(*data.Absorption)
+	}
+	if data.Scattering != nil {
+		medium.SigmaS = vec(*data.Scattering)
+	}
+	medium.G = float64(data.G)
+	if data.Emission != nil {
+		medium.Emission = vec(*data.Emission)
+	}
+	medium.BlackbodyScale = float64(data.Blackbody)
+	if data.TemperatureScale != nil {
+		medium.TemperatureScale = float64(*data.TemperatureScale)
+	}
+
+	geom := s.findOrCreateGeometry(data.Name)
+	if geom != nil {
+		// The box only bounds the medium, as a null surface neither renderer draws it
+		core.UpdateGeometryFromMeshData(geom, core.GeoDataFromMesh(tracer.NewBoxMesh(data.grid.Bounds)))
+		if s.scene.DefaultShader != nil && geom.Model.MeshCount > 0 {
+			geom.Model.Materials.Shader = *s.scene.DefaultShader
+		}
+		geom.Interior, geom.NullSurface = medium, true
+		fmt.Printf("Volume updated successfully for: %s\n", data.Name)
+	}
+}
+
 func (s *LiveLinkServer) findOrCreateGeometry(name string) *core.Geometry {
 	// Try to find existing geometry
 	for _, geom := range s.scene.Geometries {
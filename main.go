@@ -1,14 +1,49 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"go-ray-tracing/core"
+	"go-ray-tracing/imageio"
 	"go-ray-tracing/link_server"
+	"go-ray-tracing/tracer"
+	"os"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
 
 func main() {
+	// Offline renders never open a window
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "render: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	envPath := flag.String("env", "", "equirectangular .hdr, .exr or .pfm environment map")
+	envRotation := flag.Float64("env-rotation", 0, "environment rotation about +Y in degrees")
+	envIntensity := flag.Float64("env-intensity", 1, "environment intensity")
+	motionBlur := flag.Bool("motion-blur", false, "blur live-link objects along their last transform update")
+	exposure := flag.Float64("exposure", 0, "display exposure in stops, on top of the camera's")
+	toneMapName := flag.String("tonemap", "clamp", "tone mapping: clamp, reinhard, aces or agx (T cycles)")
+	spaceName := flag.String("working-space", "linear-srgb", "color space rendering happens in: linear-srgb or acescg")
+	fog := flag.Float64("fog", 0, "scattering coefficient of grey fog around the traced scene, 0 for clear air")
+	fogG := flag.Float64("fog-g", 0.5, "fog phase function asymmetry, -1 to 1")
+	flag.Parse()
+
+	toneMap, err := imageio.ParseToneMap(*toneMapName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	space, err := imageio.ParseColorSpace(*spaceName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	// Init window
 	rl.SetConfigFlags(rl.FlagWindowResizable)
 	rl.InitWindow(800, 600, "GoEngine :: GameView")
@@ -20,6 +55,21 @@ func main() {
 
 	scene := core.NewScene3D()
 	scene.InitScene()
+	scene.LiveLinkMotionBlur = *motionBlur
+	scene.Display = imageio.DisplayTransform{Exposure: *exposure, ToneMap: toneMap, WorkingSpace: space}
+	if *fog > 0 {
+		scene.Fog = &tracer.HomogeneousMedium{SigmaS: tracer.NewVec3(*fog, *fog, *fog), G: *fogG}
+	}
+	if *envPath != "" {
+		env, err := core.LoadEnvironment(*envPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "environment: %v\n", err)
+			os.Exit(1)
+		}
+		env.Rotation = float32(*envRotation)
+		env.Intensity = float32(*envIntensity)
+		scene.SetEnvironment(env)
+	}
 
 	/*
 		server := link_server.Start_Server(scene)
@@ -46,6 +96,7 @@ func main() {
 	}
 
 	scene.Renderer.RunPostRenderProcess(scene)
+	scene.Unload()
 }
 
 func drawStatusInfo(scene *core.Scene3D, server *link_server.LiveLinkServer, font rl.Font) {
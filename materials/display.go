@@ -0,0 +1,174 @@
+package materials
+
+import (
+	"go-ray-tracing/imageio"
+	"unsafe"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Same display transform as imageio.DisplayTransform: exposure, working space to
+// linear sRGB, tone mapping and the sRGB OETF. toneMap takes the imageio.ToneMap values.
+const displayFragmentShaderCode = `
+#version 330
+
+in vec2 fragTexCoord;
+
+out vec4 finalColor;
+
+uniform sampler2D texture0;
+uniform float exposure;
+uniform float toneMap; // float, raylib-go only uploads float slices
+uniform mat4 toLinearSRGB;
+
+// Matrices are written row by row and applied as c * M
+
+vec3 ACESFilmic(vec3 c)
+{
+    const mat3 inputMat = mat3(
+        0.59719, 0.35458, 0.04823,
+        0.07600, 0.90834, 0.01566,
+        0.02840, 0.13383, 0.83777);
+    const mat3 outputMat = mat3(
+         1.60475, -0.53108, -0.07367,
+        -0.10208,  1.10813, -0.00605,
+        -0.00327, -0.07276,  1.07602);
+    c = c * inputMat;
+    c = (c * (c + 0.0245786) - 0.000090537) / (c * (0.983729 * c + 0.4329510) + 0.238081);
+    return c * outputMat;
+}
+
+vec3 AgX(vec3 c)
+{
+    const mat3 inset = mat3(
+        0.842479062253094, 0.0784335999999992, 0.0792237451477643,
+        0.0423282422610123, 0.878468636469772, 0.0791661274605434,
+        0.0423756549057051, 0.0784336, 0.879142973793104);
+    const mat3 outset = mat3(
+        1.19687900512017, -0.0980208811401368, -0.0990297440797205,
+        -0.0528968517574562, 1.15190312990417, -0.0989611768448433,
+        -0.0529716355144438, -0.0980434501171241, 1.15107367264116);
+    const float minEV = -12.47393;
+    const float maxEV = 4.026069;
+
+    c = c * inset;
+    c = (clamp(log2(max(c, 1e-10)), minEV, maxEV) - minEV) / (maxEV - minEV);
+    vec3 c2 = c * c;
+    vec3 c4 = c2 * c2;
+    c = 15.5 * c4 * c2 - 40.14 * c4 * c + 31.96 * c4 - 6.868 * c2 * c + 0.4298 * c2 + 0.1191 * c - 0.00232;
+    c = c * outset;
+    return pow(max(c, 0.0), vec3(2.2));
+}
+
+vec3 SRGBOETF(vec3 c)
+{
+    vec3 lo = 12.92 * c;
+    vec3 hi = 1.055 * pow(c, vec3(1.0 / 2.4)) - 0.055;
+    return mix(hi, lo, lessThanEqual(c, vec3(0.0031308)));
+}
+
+void main()
+{
+    vec3 c = texture(texture0, fragTexCoord).rgb * exp2(exposure);
+    c = max(mat3(toLinearSRGB) * c, 0.0);
+    int op = int(toneMap + 0.5);
+    if (op == 1) c = c / (1.0 + c);
+    else if (op == 2) c = ACESFilmic(c);
+    else if (op == 3) c = AgX(c);
+    finalColor = vec4(SRGBOETF(clamp(c, 0.0, 1.0)), 1.0);
+}
+`
+
+// PostProcess renders the scene into a float target and draws it to the screen
+// through the display transform, so lighting above 1 reaches the tone mapper.
+type PostProcess struct {
+	Shader rl.Shader
+	Target rl.RenderTexture2D
+
+	width, height int32
+}
+
+func NewPostProcess() *PostProcess {
+	return &PostProcess{Shader: rl.LoadShaderFromMemory("", displayFragmentShaderCode)}
+}
+
+// Begin redirects drawing into the float target, resized to width x height, and
+// clears it to the linear background color.
+func (p *PostProcess) Begin(width, height int32, background rl.Color) {
+	if width != p.width || height != p.height {
+		p.unloadTarget()
+		p.Target = loadFloatTarget(width, height)
+		p.width, p.height = width, height
+	}
+	rl.BeginTextureMode(p.Target)
+	rl.ClearBackground(background)
+}
+
+func (p *PostProcess) End() {
+	rl.EndTextureMode()
+}
+
+// Draw shows the target through the display transform, filling the screen.
+func (p *PostProcess) Draw(d imageio.DisplayTransform) {
+	rl.SetShaderValue(p.Shader, rl.GetShaderLocation(p.Shader, "exposure"), []float32{float32(d.Exposure)}, rl.ShaderUniformFloat)
+	rl.SetShaderValue(p.Shader, rl.GetShaderLocation(p.Shader, "toneMap"), []float32{float32(d.ToneMap)}, rl.ShaderUniformFloat)
+	rl.SetShaderValueMatrix(p.Shader, rl.GetShaderLocation(p.Shader, "toLinearSRGB"), Matrix3Uniform(d.WorkingSpace.ToLinearSRGB()))
+
+	rl.BeginShaderMode(p.Shader)
+	// Render targets are stored bottom-up
+	src := rl.NewRectangle(0, 0, float32(p.width), -float32(p.height))
+	rl.DrawTextureRec(p.Target.Texture, src, rl.NewVector2(0, 0), rl.White)
+	rl.EndShaderMode()
+}
+
+func (p *PostProcess) Unload() {
+	p.unloadTarget()
+	rl.UnloadShader(p.Shader)
+}
+
+func (p *PostProcess) unloadTarget() {
+	if p.Target.ID != 0 {
+		rl.UnloadRenderTexture(p.Target)
+		p.Target = rl.RenderTexture2D{}
+	}
+	p.width, p.height = 0, 0
+}
+
+// loadFloatTarget creates a framebuffer with a 32-bit float color texture and a
+// depth renderbuffer. raylib's LoadRenderTexture only makes 8-bit targets.
+func loadFloatTarget(width, height int32) rl.RenderTexture2D {
+	pixels := make([]float32, width*height*4)
+	img := rl.Image{
+		Data:    unsafe.Pointer(&pixels[0]),
+		Width:   width,
+		Height:  height,
+		Mipmaps: 1,
+		Format:  rl.UncompressedR32g32b32a32,
+	}
+	color := rl.LoadTextureFromImage(&img)
+	rl.SetTextureFilter(color, rl.FilterBilinear)
+
+	fbo := rl.LoadFramebuffer()
+	depth := rl.LoadTextureDepth(width, height, true)
+	rl.FramebufferAttach(fbo, color.ID, rl.AttachmentColorChannel0, rl.AttachmentTexture2d, 0)
+	rl.FramebufferAttach(fbo, depth, rl.AttachmentDepth, rl.AttachmentRenderbuffer, 0)
+	if !rl.FramebufferComplete(fbo) {
+		rl.TraceLog(rl.LogWarning, "post-process: float framebuffer is incomplete")
+	}
+	return rl.RenderTexture2D{
+		ID:      fbo,
+		Texture: color,
+		Depth:   rl.Texture2D{ID: depth, Width: width, Height: height, Mipmaps: 1},
+	}
+}
+
+// Matrix3Uniform packs a color matrix into the upper 3x3 of a mat4 uniform, read in
+// GLSL with mat3(m) * c.
+func Matrix3Uniform(m imageio.Matrix3) rl.Matrix {
+	return rl.Matrix{
+		M0: float32(m[0][0]), M4: float32(m[0][1]), M8: float32(m[0][2]),
+		M1: float32(m[1][0]), M5: float32(m[1][1]), M9: float32(m[1][2]),
+		M2: float32(m[2][0]), M6: float32(m[2][1]), M10: float32(m[2][2]),
+		M15: 1,
+	}
+}
@@ -36,10 +36,23 @@ in vec4 fragPosLightSpace;
 out vec4 finalColor;
 
 uniform vec3 lightDir;
-uniform vec3 objectColor;
 uniform sampler2D shadowMap;
 uniform vec3 viewPos;
 
+// Surface parameters, see SurfaceParams
+uniform vec3 baseColor;
+uniform vec3 metalColor; // reflectance of the metal part at normal incidence
+uniform float metallic;
+uniform float roughness;
+uniform vec3 emission;
+uniform float ior;
+uniform float transmission;
+
+// Camera exposure in stops, see core.PerspectiveCamera
+uniform float exposure;
+
+const float PI = 3.14159265359;
+
 float ShadowCalculation(vec4 fragPosLightSpace)
 {
     // Perform perspective divide
@@ -73,30 +86,80 @@ float ShadowCalculation(vec4 fragPosLightSpace)
     return shadowSmooth;
 }
 
+float D_GGX(float NoH, float alpha)
+{
+    float a2 = alpha * alpha;
+    float d = NoH * NoH * (a2 - 1.0) + 1.0;
+    return a2 / (PI * d * d);
+}
+
+float SmithLambda(float cosTheta, float alpha)
+{
+    float cos2 = cosTheta * cosTheta;
+    float tan2 = max(1.0 - cos2, 0.0) / max(cos2, 1e-7);
+    return 0.5 * (-1.0 + sqrt(1.0 + alpha * alpha * tan2));
+}
+
+// Unpolarized reflectance of a smooth dielectric, like the tracer's fresnelDielectric
+float FresnelDielectric(float cosI, float eta)
+{
+    cosI = clamp(cosI, 0.0, 1.0);
+    float sin2T = (1.0 - cosI * cosI) / (eta * eta);
+    if (sin2T >= 1.0) return 1.0;
+    float cosT = sqrt(1.0 - sin2T);
+    float rParl = (eta * cosI - cosT) / (eta * cosI + cosT);
+    float rPerp = (cosI - eta * cosT) / (cosI + eta * cosT);
+    return 0.5 * (rParl * rParl + rPerp * rPerp);
+}
+
+// Same metallic/roughness BRDF as the tracer's principledBSDF.Eval (tracer/bsdf.go)
+vec3 EvalBRDF(vec3 N, vec3 V, vec3 L)
+{
+    float NoV = dot(N, V);
+    float NoL = dot(N, L);
+    if (NoV <= 0.0 || NoL <= 0.0) return vec3(0.0);
+
+    vec3 H = normalize(V + L);
+    float VoH = dot(V, H);
+    float alpha = max(roughness * roughness, 1e-3);
+
+    float G2 = 1.0 / (1.0 + SmithLambda(NoV, alpha) + SmithLambda(NoL, alpha));
+    float spec = D_GGX(dot(N, H), alpha) * G2 / (4.0 * NoV * NoL);
+
+    float fw = pow(clamp(1.0 - VoH, 0.0, 1.0), 5.0);
+    float Fd = FresnelDielectric(VoH, ior);
+    vec3 Fm = metalColor + (1.0 - metalColor) * fw;
+
+    // Diffuse only receives what the specular layer lets through on the way in and out
+    float FdV = FresnelDielectric(NoV, ior);
+    float FdL = FresnelDielectric(NoL, ior);
+    vec3 diffuse = baseColor * (1.0 - transmission) * (1.0 - FdV) * (1.0 - FdL) / PI;
+    vec3 dielectric = diffuse + vec3(spec) * Fd;
+    vec3 metal = Fm * spec;
+    return mix(dielectric, metal, metallic);
+}
+
 void main()
 {
     vec3 norm = normalize(fragNormal);
+    vec3 viewDir = normalize(viewPos - fragPos);
     vec3 lightDirection = normalize(-lightDir);
-    
-    // Diffuse lighting
-    float diff = max(dot(norm, lightDirection), 0.0);
-    vec3 diffuse = diff * objectColor;
+
+    // Shade both sides like the tracer does
+    if (dot(norm, viewDir) < 0.0) norm = -norm;
+
+    // Sun with an irradiance of PI, so a white Lambertian surface reaches 1.0
+    vec3 direct = EvalBRDF(norm, viewDir, lightDirection) * PI * max(dot(norm, lightDirection), 0.0);
     
     // Ambient lighting
-    vec3 ambient = 0.2 * objectColor;
-    
-    // Specular lighting
-    vec3 viewDir = normalize(viewPos - fragPos);
-    vec3 reflectDir = reflect(-lightDirection, norm);
-    float spec = pow(max(dot(viewDir, reflectDir), 0.0), 32.0);
-    vec3 specular = spec * vec3(0.3);
+    vec3 ambient = 0.2 * mix(baseColor, metalColor, metallic);
     
     // Calculate shadow
     float shadow = ShadowCalculation(fragPosLightSpace);
     
     // Final color with shadows
-    vec3 lighting = ambient + (1.0 - shadow) * (diffuse + specular);
-    finalColor = vec4(lighting, 1.0);
+    vec3 lighting = ambient + (1.0 - shadow) * direct + emission;
+    finalColor = vec4(lighting * exp2(exposure), 1.0);
 }
 `
 
@@ -158,9 +221,12 @@ func NewMaterial() *Material {
 	return &shader
 }
 
-func (m *Material) UpdateLightUniforms(cameraPos rl.Vector3) {
-	// Set light direction (sun direction - pointing downward)
-	lightDir := []float32{-0.5, -1.0, -0.5}
+// UpdateLightUniforms uploads the sun direction, the direction light travels in,
+// and the view position for the next frame.
+func (m *Material) UpdateLightUniforms(cameraPos, sunDir rl.Vector3) {
+	// Same direction the shadow map is rendered from
+	sunDir = rl.Vector3Normalize(sunDir)
+	lightDir := []float32{sunDir.X, sunDir.Y, sunDir.Z}
 	lightDirLoc := rl.GetShaderLocation(m.Shader, "lightDir")
 	rl.SetShaderValue(m.Shader, lightDirLoc, lightDir, rl.ShaderUniformVec3)
 
@@ -174,6 +240,35 @@ func (m *Material) UpdateLightUniforms(cameraPos rl.Vector3) {
 	rl.SetShaderValueMatrix(m.Shader, lightSpaceLoc, m.LightSpaceMatrix)
 }
 
+// SurfaceParams are the per-object inputs of the default shader's metallic/roughness
+// BRDF. They mirror tracer.Material; MetalColor is its MetalColor and Emission is
+// already scaled by its strength.
+type SurfaceParams struct {
+	BaseColor    [3]float32
+	MetalColor   [3]float32
+	Metallic     float32
+	Roughness    float32
+	Emission     [3]float32
+	IOR          float32
+	Transmission float32
+}
+
+// SetSurface uploads the surface uniforms for the next object drawn.
+func (m *Material) SetSurface(p SurfaceParams) {
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "baseColor"), p.BaseColor[:], rl.ShaderUniformVec3)
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "metalColor"), p.MetalColor[:], rl.ShaderUniformVec3)
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "metallic"), []float32{p.Metallic}, rl.ShaderUniformFloat)
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "roughness"), []float32{p.Roughness}, rl.ShaderUniformFloat)
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "emission"), p.Emission[:], rl.ShaderUniformVec3)
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "ior"), []float32{p.IOR}, rl.ShaderUniformFloat)
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "transmission"), []float32{p.Transmission}, rl.ShaderUniformFloat)
+}
+
+// SetExposure sets the camera exposure in stops for the next frame.
+func (m *Material) SetExposure(stops float32) {
+	rl.SetShaderValue(m.Shader, rl.GetShaderLocation(m.Shader, "exposure"), []float32{stops}, rl.ShaderUniformFloat)
+}
+
 func (m *Material) UpdateLightCamera(lightPos, lightTarget rl.Vector3) {
 	lightProjection := rl.MatrixOrtho(-10.0, 10.0, -10.0, 10.0, 1.0, 50.0)
 	lightView := rl.MatrixLookAt(lightPos, lightTarget, rl.NewVector3(0.0, 1.0, 0.0))
@@ -0,0 +1,105 @@
+package materials
+
+import (
+	"go-ray-tracing/imageio"
+	"unsafe"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+const skyboxVertexShaderCode = `
+#version 330
+
+in vec3 vertexPosition;
+
+uniform mat4 mvp;
+
+out vec3 fragDir;
+
+void main()
+{
+    fragDir = vertexPosition;
+    gl_Position = mvp * vec4(vertexPosition, 1.0);
+}
+`
+
+// Same equirectangular mapping and rotation as tracer.EnvironmentLight
+const skyboxFragmentShaderCode = `
+#version 330
+
+in vec3 fragDir;
+
+out vec4 finalColor;
+
+uniform sampler2D texture0;
+uniform float rotation;
+uniform float intensity;
+uniform mat4 toWorking;
+
+const float PI = 3.14159265359;
+
+void main()
+{
+    vec3 d = normalize(fragDir);
+    float s = sin(-rotation);
+    float c = cos(-rotation);
+    d = vec3(c * d.x + s * d.z, d.y, -s * d.x + c * d.z);
+
+    float u = atan(d.z, d.x) / (2.0 * PI);
+    if (u < 0.0) u += 1.0;
+    float v = acos(clamp(d.y, -1.0, 1.0)) / PI;
+
+    vec3 c = mat3(toWorking) * texture(texture0, vec2(u, v)).rgb;
+    finalColor = vec4(c * intensity, 1.0);
+}
+`
+
+// Skybox draws an equirectangular HDR image behind the scene.
+type Skybox struct {
+	Shader  rl.Shader
+	Texture rl.Texture2D
+	Model   rl.Model
+}
+
+// NewSkybox uploads linear RGB floats (three per pixel, row-major from the top-left)
+// as a float texture. Needs a GL context.
+func NewSkybox(width, height int, rgb []float32) *Skybox {
+	img := rl.Image{
+		Data:    unsafe.Pointer(&rgb[0]),
+		Width:   int32(width),
+		Height:  int32(height),
+		Mipmaps: 1,
+		Format:  rl.UncompressedR32g32b32,
+	}
+	sky := &Skybox{
+		Shader:  rl.LoadShaderFromMemory(skyboxVertexShaderCode, skyboxFragmentShaderCode),
+		Texture: rl.LoadTextureFromImage(&img),
+		Model:   rl.LoadModelFromMesh(rl.GenMeshCube(1, 1, 1)),
+	}
+	rl.SetTextureFilter(sky.Texture, rl.FilterBilinear)
+	sky.Model.Materials.Shader = sky.Shader
+	sky.Model.Materials.Maps.Texture = sky.Texture
+	return sky
+}
+
+// Draw renders the skybox around the camera. intensity includes the camera exposure,
+// toWorking converts the linear sRGB image into the working space.
+// Call it first inside BeginMode3D; it does not write depth so the scene draws over it.
+func (s *Skybox) Draw(cameraPos rl.Vector3, rotationDeg, intensity float32, toWorking imageio.Matrix3) {
+	rl.SetShaderValue(s.Shader, rl.GetShaderLocation(s.Shader, "rotation"), []float32{rotationDeg * rl.Deg2rad}, rl.ShaderUniformFloat)
+	rl.SetShaderValue(s.Shader, rl.GetShaderLocation(s.Shader, "intensity"), []float32{intensity}, rl.ShaderUniformFloat)
+	rl.SetShaderValueMatrix(s.Shader, rl.GetShaderLocation(s.Shader, "toWorking"), Matrix3Uniform(toWorking))
+
+	// The cube is seen from inside
+	rl.DisableBackfaceCulling()
+	rl.DisableDepthMask()
+	rl.DrawModel(s.Model, cameraPos, 1, rl.White)
+	rl.EnableDepthMask()
+	rl.EnableBackfaceCulling()
+}
+
+func (s *Skybox) Unload() {
+	rl.UnloadModel(s.Model)
+	rl.UnloadTexture(s.Texture)
+	rl.UnloadShader(s.Shader)
+}
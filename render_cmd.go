@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-ray-tracing/imageio"
+	"go-ray-tracing/tracer"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const renderUsage = `usage: go-ray-tracing render -scene scene.json [options]
+
+Renders a scene file with the CPU path tracer and writes the image to disk.
+No window or GL context is created, so it can run in batch jobs and on CI.
+
+`
+
+// runRender implements the render subcommand.
+func runRender(args []string) error {
+	opts := tracer.DefaultOptions()
+
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), renderUsage)
+		fs.PrintDefaults()
+	}
+	scenePath := fs.String("scene", "", "scene file to render (JSON)")
+	output := fs.String("o", "render.png", "output image, .png (8-bit sRGB) or .exr, .pfm and .hdr (linear float)")
+	fs.IntVar(&opts.Width, "width", opts.Width, "image width in pixels")
+	fs.IntVar(&opts.Height, "height", opts.Height, "image height in pixels")
+	fs.IntVar(&opts.Samples, "spp", opts.Samples, "samples per pixel, the maximum with -noise-threshold")
+	fs.Float64Var(&opts.NoiseThreshold, "noise-threshold", opts.NoiseThreshold, "stop sampling pixels whose relative noise is below this, e.g. 0.01 (0 disables adaptive sampling)")
+	fs.IntVar(&opts.MinSamples, "min-spp", opts.MinSamples, "samples per pixel before adaptive sampling may stop a pixel")
+	fs.IntVar(&opts.MaxDepth, "depth", opts.MaxDepth, "maximum bounces per path")
+	fs.IntVar(&opts.Photons, "photons", opts.Photons, "photons traced for the caustics of smooth glass and metal (0 leaves them to the path tracer)")
+	fs.Float64Var(&opts.PhotonRadius, "photon-radius", opts.PhotonRadius, "radius photons are gathered in (0 picks one from the scene's size)")
+	fs.Uint64Var(&opts.Seed, "seed", opts.Seed, "random seed")
+	samplerName := fs.String("sampler", opts.Sampler.String(), "sample generator: independent, stratified, sobol or bluenoise")
+	integratorName := fs.String("integrator", opts.Integrator.String(), "light transport: path or bidirectional (for interiors lit through small openings)")
+	tileSize := fs.Int("tile", tracer.DefaultTileSize, "tile edge length in pixels")
+	threads := fs.Int("threads", runtime.GOMAXPROCS(0), "number of render threads (0 uses GOMAXPROCS)")
+	aovList := fs.String("aov", "", "comma separated AOVs to render, or \"all\": "+aovNames())
+	denoise := fs.Bool("denoise", false, "denoise the output, the raw render is kept as <output>.raw.<ext>")
+	aovSeparate := fs.Bool("aov-separate", false, "write each AOV to its own <output>.<aov>.exr instead of layers of an .exr output")
+	compressionName := fs.String("exr-compression", "zip", "EXR compression: none, zip or piz")
+	toneMapName := fs.String("tonemap", "clamp", "tone mapping of .png output: clamp, reinhard, aces or agx")
+	var save imageio.SaveOptions
+	fs.Float64Var(&save.Display.Exposure, "exposure", 0, "exposure of .png output in stops, on top of the camera's")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *scenePath == "" {
+		fs.Usage()
+		return fmt.Errorf("no scene file given")
+	}
+	if opts.Width <= 0 || opts.Height <= 0 || opts.Samples <= 0 {
+		return fmt.Errorf("width, height and spp must be positive")
+	}
+	if opts.Photons < 0 || opts.PhotonRadius < 0 {
+		return fmt.Errorf("photons and photon-radius must not be negative")
+	}
+	if *threads < 0 {
+		return fmt.Errorf("threads must not be negative")
+	}
+	if *threads == 0 {
+		*threads = runtime.GOMAXPROCS(0)
+	}
+	sampler, err := tracer.ParseSamplerType(*samplerName)
+	if err != nil {
+		return err
+	}
+	opts.Sampler = sampler
+	if opts.Integrator, err = tracer.ParseIntegrator(*integratorName); err != nil {
+		return err
+	}
+	if save.Compression, err = imageio.ParseEXRCompression(*compressionName); err != nil {
+		return err
+	}
+	if save.Display.ToneMap, err = imageio.ParseToneMap(*toneMapName); err != nil {
+		return err
+	}
+	aovs, err := tracer.ParseAOVs(*aovList)
+	if err != nil {
+		return err
+	}
+	opts.AOVs = aovs
+	if *denoise {
+		// The denoiser is guided by these, they are only written out when asked for
+		for _, a := range []tracer.AOV{tracer.AOVAlbedo, tracer.AOVNormal, tracer.AOVDepth} {
+			if !slices.Contains(opts.AOVs, a) {
+				opts.AOVs = append(opts.AOVs, a)
+			}
+		}
+	}
+
+	scene, err := tracer.LoadSceneFile(*scenePath)
+	if err != nil {
+		return err
+	}
+	save.Display.WorkingSpace = scene.WorkingSpace
+
+	// Ctrl+C stops the render after the tiles in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	job := tracer.NewRenderJob(scene, opts)
+	job.TileSize = *tileSize
+	job.Workers = *threads
+	job.OnProgress = func(p tracer.TileProgress) {
+		fmt.Printf("\rRendering... %3d%% (%d/%d tiles)", p.Done*100/p.Total, p.Done, p.Total)
+	}
+
+	fmt.Printf("Rendering %s at %dx%d, %d spp on %d threads\n", *scenePath, opts.Width, opts.Height, opts.Samples, job.Workers)
+	start := time.Now()
+	img, err := job.Run(ctx)
+	fmt.Println()
+	if err != nil {
+		return fmt.Errorf("render cancelled: %v", err)
+	}
+	fmt.Printf("Render finished in %v\n", time.Since(start).Round(time.Millisecond))
+	total := job.TotalSamples()
+	fmt.Printf("%d samples, %.1f per pixel on average\n", total, float64(total)/float64(opts.Width*opts.Height))
+
+	if *denoise {
+		raw := strings.TrimSuffix(*output, filepath.Ext(*output)) + ".raw" + filepath.Ext(*output)
+		if err := writeImage(raw, img, save); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", raw)
+
+		start = time.Now()
+		layers := job.AOVs.Layers
+		img = tracer.Denoise(img, layers[tracer.AOVAlbedo], layers[tracer.AOVNormal], layers[tracer.AOVDepth], tracer.DefaultDenoiseOptions())
+		fmt.Printf("Denoised in %v\n", time.Since(start).Round(time.Millisecond))
+		for a := range layers {
+			if !slices.Contains(aovs, a) {
+				delete(layers, a)
+			}
+		}
+		if len(layers) == 0 {
+			job.AOVs = nil
+		}
+	}
+
+	if job.AOVs != nil {
+		return writeAOVs(*output, img, job.AOVs, *aovSeparate, save)
+	}
+	if err := writeImage(*output, img, save); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", *output)
+	return nil
+}
+
+func aovNames() string {
+	var names []string
+	for _, a := range tracer.AllAOVs() {
+		names = append(names, a.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeAOVs writes the beauty image and the AOVs. An .exr output gets every AOV as
+// a layer unless separate is set; otherwise each AOV goes to <output>.<aov>.exr.
+// The object ID names are written to <output>.ids.json.
+func writeAOVs(path string, img *tracer.Image, aovs *tracer.AOVBuffers, separate bool, save imageio.SaveOptions) error {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	layered := !separate && strings.EqualFold(filepath.Ext(path), ".exr")
+
+	if layered {
+		channels := imageio.RGBChannels(img.RGB())
+		for _, a := range tracer.AllAOVs() {
+			if a != tracer.AOVBeauty {
+				channels = append(channels, aovs.Channels(a)...)
+			}
+		}
+		if err := writeEXR(path, img.Width, img.Height, channels, save); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+	} else {
+		if err := writeImage(path, img, save); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+		for _, a := range tracer.AllAOVs() {
+			if a == tracer.AOVBeauty || !aovs.Has(a) {
+				continue
+			}
+			// Separate files keep plain channel names so any viewer shows them
+			channels := aovs.Channels(a)
+			for i := range channels {
+				channels[i].Name = channels[i].Name[strings.LastIndexByte(channels[i].Name, '.')+1:]
+			}
+			name := base + "." + a.String() + ".exr"
+			if err := writeEXR(name, img.Width, img.Height, channels, save); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", name)
+		}
+	}
+
+	if aovs.Has(tracer.AOVObjectID) {
+		name := base + ".ids.json"
+		if err := writeObjectIDs(name, aovs); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", name)
+	}
+	return nil
+}
+
+func writeEXR(path string, width, height int, channels []imageio.Channel, save imageio.SaveOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := imageio.WriteEXR(f, width, height, channels, imageio.EXROptions{Compression: save.Compression, Space: save.Display.WorkingSpace}); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeObjectIDs writes the ID to geometry name mapping of the objectID AOV. ID 0
+// is the background.
+func writeObjectIDs(path string, aovs *tracer.AOVBuffers) error {
+	ids := make(map[string]string)
+	for id, name := range aovs.ObjectNames() {
+		ids[strconv.Itoa(id)] = name
+	}
+	data, err := json.MarshalIndent(map[string]any{"objectID": ids}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// writeImage picks the file format from the output extension.
+func writeImage(path string, img *tracer.Image, save imageio.SaveOptions) error {
+	return imageio.Save(path, img.Framebuffer(), save)
+}
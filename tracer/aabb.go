@@ -0,0 +1,117 @@
+package tracer
+
+import "math"
+
+// AABB is an axis aligned bounding box. The zero value is not empty, use EmptyAABB.
+type AABB struct {
+	Min, Max Vec3
+}
+
+func EmptyAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{
+		Min: Vec3{inf, inf, inf},
+		Max: Vec3{-inf, -inf, -inf},
+	}
+}
+
+func (b AABB) IsEmpty() bool {
+	return b.Min.X > b.Max.X || b.Min.Y > b.Max.Y || b.Min.Z > b.Max.Z
+}
+
+func (b AABB) Union(o AABB) AABB {
+	return AABB{Min: b.Min.Min(o.Min), Max: b.Max.Max(o.Max)}
+}
+
+func (b AABB) Extend(p Vec3) AABB {
+	return AABB{Min: b.Min.Min(p), Max: b.Max.Max(p)}
+}
+
+func (b AABB) Centroid() Vec3 {
+	return b.Min.Add(b.Max).Scale(0.5)
+}
+
+func (b AABB) Diagonal() Vec3 {
+	return b.Max.Sub(b.Min)
+}
+
+func (b AABB) SurfaceArea() float64 {
+	if b.IsEmpty() {
+		return 0
+	}
+	d := b.Diagonal()
+	return 2 * (d.X*d.Y + d.Y*d.Z + d.Z*d.X)
+}
+
+// Transform returns the box enclosing all eight transformed corners.
+func (b AABB) Transform(m Mat4) AABB {
+	if b.IsEmpty() {
+		return b
+	}
+	out := EmptyAABB()
+	for i := 0; i < 8; i++ {
+		p := b.Min
+		if i&1 != 0 {
+			p.X = b.Max.X
+		}
+		if i&2 != 0 {
+			p.Y = b.Max.Y
+		}
+		if i&4 != 0 {
+			p.Z = b.Max.Z
+		}
+		out = out.Extend(m.MulPoint(p))
+	}
+	return out
+}
+
+// hit runs the slab test. invDir is the per-component reciprocal of the ray direction.
+func (b AABB) hit(origin, invDir Vec3, tMax float64) bool {
+	tx1 := (b.Min.X - origin.X) * invDir.X
+	tx2 := (b.Max.X - origin.X) * invDir.X
+	tmin := math.Min(tx1, tx2)
+	tmax := math.Max(tx1, tx2)
+
+	ty1 := (b.Min.Y - origin.Y) * invDir.Y
+	ty2 := (b.Max.Y - origin.Y) * invDir.Y
+	tmin = math.Max(tmin, math.Min(ty1, ty2))
+	tmax = math.Min(tmax, math.Max(ty1, ty2))
+
+	tz1 := (b.Min.Z - origin.Z) * invDir.Z
+	tz2 := (b.Max.Z - origin.Z) * invDir.Z
+	tmin = math.Max(tmin, math.Min(tz1, tz2))
+	tmax = math.Min(tmax, math.Max(tz1, tz2))
+
+	return tmax >= math.Max(tmin, 0) && tmin < tMax
+}
+
+func inverseDir(d Vec3) Vec3 {
+	return Vec3{1 / d.X, 1 / d.Y, 1 / d.Z}
+}
+
+// clip returns the part of r between 0 and tMax inside the box.
+func (b AABB) clip(r Ray, tMax float64) (float64, float64, bool) {
+	if b.IsEmpty() {
+		return 0, 0, false
+	}
+	t0, t1 := 0.0, tMax
+	for i := 0; i < 3; i++ {
+		inv := 1 / r.Dir.Axis(i)
+		near := (b.Min.Axis(i) - r.Origin.Axis(i)) * inv
+		far := (b.Max.Axis(i) - r.Origin.Axis(i)) * inv
+		if near > far {
+			near, far = far, near
+		}
+		// NaN when the ray lies in a slab plane, which keeps the current range
+		if near > t0 {
+			t0 = near
+		}
+		if far < t1 {
+			t1 = far
+		}
+		if t0 > t1 {
+			return 0, 0, false
+		}
+	}
+	return t0, t1, true
+}
@@ -0,0 +1,60 @@
+package tracer
+
+// Accumulator averages successive render passes for progressive rendering. Passes
+// are added tile by tile, so every pixel keeps its own sample count.
+type Accumulator struct {
+	Width  int
+	Height int
+	sum    []Vec3
+	count  []int
+}
+
+func NewAccumulator(width, height int) *Accumulator {
+	return &Accumulator{
+		Width:  width,
+		Height: height,
+		sum:    make([]Vec3, width*height),
+		count:  make([]int, width*height),
+	}
+}
+
+// AddTile adds the tile's pixels from a finished pass, weighted by samples per pixel.
+func (a *Accumulator) AddTile(img *Image, tile Tile, samples int) {
+	for y := tile.Y0; y < tile.Y1; y++ {
+		for x := tile.X0; x < tile.X1; x++ {
+			i := y*a.Width + x
+			a.sum[i] = a.sum[i].Add(img.Pix[i].Scale(float64(samples)))
+			a.count[i] += samples
+		}
+	}
+}
+
+// At returns the running average of a pixel.
+func (a *Accumulator) At(x, y int) Vec3 {
+	i := y*a.Width + x
+	if a.count[i] == 0 {
+		return Vec3{}
+	}
+	return a.sum[i].Scale(1 / float64(a.count[i]))
+}
+
+// Samples returns how many samples a pixel has accumulated.
+func (a *Accumulator) Samples(x, y int) int {
+	return a.count[y*a.Width+x]
+}
+
+func (a *Accumulator) Reset() {
+	clear(a.sum)
+	clear(a.count)
+}
+
+// Image resolves the running averages into an image.
+func (a *Accumulator) Image() *Image {
+	img := NewImage(a.Width, a.Height)
+	for y := 0; y < a.Height; y++ {
+		for x := 0; x < a.Width; x++ {
+			img.Set(x, y, a.At(x, y))
+		}
+	}
+	return img
+}
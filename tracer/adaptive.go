@@ -0,0 +1,49 @@
+package tracer
+
+import "math"
+
+// pixelStats tracks the running mean and variance of a pixel's sample luminance with
+// Welford's algorithm, so adaptive sampling can tell when the pixel has converged.
+type pixelStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func (s *pixelStats) add(c Vec3) {
+	l := c.Luminance()
+	s.n++
+	d := l - s.mean
+	s.mean += d / float64(s.n)
+	s.m2 += d * (l - s.mean)
+}
+
+// error is the standard error of the pixel's mean relative to the square root of
+// its brightness, which roughly matches how visible noise is after display. Bright
+// pixels may keep more absolute noise than dark ones.
+func (s *pixelStats) error() float64 {
+	if s.n < 2 {
+		return math.Inf(1)
+	}
+	variance := s.m2 / float64(s.n-1)
+	return math.Sqrt(variance/float64(s.n)) / math.Sqrt(math.Max(s.mean, 0)+1e-4)
+}
+
+// blackSamples is how many times MinSamples a pixel that has seen no light takes
+// before it is taken to be black.
+const blackSamples = 4
+
+// done reports whether adaptive sampling can stop after the samples taken so far.
+// A pixel that has seen no light yet has no variance to go by, paths reaching a
+// small light or a caustic may just not have turned up, so it goes on for a while
+// longer; a pixel that stays black that long most likely is.
+func (s *pixelStats) done(opts *Options) bool {
+	minSamples := max(opts.MinSamples, 2)
+	if opts.NoiseThreshold <= 0 || s.n < minSamples {
+		return false
+	}
+	if s.mean <= 0 {
+		return s.m2 == 0 && s.n >= blackSamples*minSamples
+	}
+	return s.error() <= opts.NoiseThreshold
+}
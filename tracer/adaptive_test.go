@@ -0,0 +1,54 @@
+package tracer
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// A pixel that only now and then finds a light reads black for its first samples,
+// which must not be mistaken for a converged pixel.
+func TestAdaptiveSparseHits(t *testing.T) {
+	opts := Options{Samples: 256, MinSamples: 16, NoiseThreshold: 0.01}
+	var s pixelStats
+	for i := 0; i < opts.MinSamples; i++ {
+		s.add(Vec3{})
+	}
+	if s.done(&opts) {
+		t.Fatalf("stopped after %d black samples", s.n)
+	}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for !s.done(&opts) && s.n < opts.Samples {
+		if rng.IntN(20) == 0 {
+			s.add(NewVec3(5, 5, 5))
+		} else {
+			s.add(Vec3{})
+		}
+	}
+	if s.n < opts.Samples {
+		t.Errorf("a pixel hit one sample in 20 stopped after %d samples", s.n)
+	}
+}
+
+func TestAdaptiveConstantStops(t *testing.T) {
+	opts := Options{Samples: 256, MinSamples: 16, NoiseThreshold: 0.01}
+	var s pixelStats
+	for !s.done(&opts) && s.n < opts.Samples {
+		s.add(NewVec3(0.5, 0.5, 0.5))
+	}
+	if s.n != opts.MinSamples {
+		t.Errorf("a constant pixel took %d samples, want %d", s.n, opts.MinSamples)
+	}
+}
+
+// A pixel that never sees any light must not take the whole budget.
+func TestAdaptiveBlackStops(t *testing.T) {
+	opts := Options{Samples: 256, MinSamples: 16, NoiseThreshold: 0.01}
+	var s pixelStats
+	for !s.done(&opts) && s.n < opts.Samples {
+		s.add(Vec3{})
+	}
+	if s.n != blackSamples*opts.MinSamples {
+		t.Errorf("a black pixel took %d samples, want %d", s.n, blackSamples*opts.MinSamples)
+	}
+}
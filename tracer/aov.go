@@ -0,0 +1,265 @@
+package tracer
+
+import (
+	"fmt"
+	"go-ray-tracing/imageio"
+	"math"
+	"strings"
+)
+
+// AOV is an arbitrary output variable, a pass rendered alongside the beauty image
+// for compositing or denoising.
+type AOV int
+
+const (
+	AOVBeauty          AOV = iota // the final image, same as Render's result
+	AOVAlbedo                     // surface color at the first hit
+	AOVNormal                     // world space shading normal at the first hit
+	AOVPosition                   // world space position of the first hit
+	AOVDepth                      // distance from the camera to the first hit, +Inf for the background
+	AOVObjectID                   // AOVBuffers.Objects index plus one of the first hit, 0 for the background
+	AOVDiffuseDirect              // light scattered once by a diffuse lobe
+	AOVDiffuseIndirect            // light scattered more than once after a diffuse first bounce
+	AOVSpecular                   // light after a specular, metal or transmissive first bounce
+	AOVEmission                   // emitters and the environment seen directly
+	AOVConvergence                // samples taken and the final noise estimate of adaptive sampling, 0 below 2 samples
+	aovCount
+)
+
+var aovNames = [aovCount]string{
+	AOVBeauty:          "beauty",
+	AOVAlbedo:          "albedo",
+	AOVNormal:          "normal",
+	AOVPosition:        "position",
+	AOVDepth:           "depth",
+	AOVObjectID:        "objectID",
+	AOVDiffuseDirect:   "diffuseDirect",
+	AOVDiffuseIndirect: "diffuseIndirect",
+	AOVSpecular:        "specular",
+	AOVEmission:        "emission",
+	AOVConvergence:     "convergence",
+}
+
+// AllAOVs lists every AOV in a stable order.
+func AllAOVs() []AOV {
+	aovs := make([]AOV, aovCount)
+	for i := range aovs {
+		aovs[i] = AOV(i)
+	}
+	return aovs
+}
+
+func (a AOV) String() string {
+	if a < 0 || a >= aovCount {
+		return fmt.Sprintf("AOV(%d)", int(a))
+	}
+	return aovNames[a]
+}
+
+// channelNames returns the per channel suffixes of the AOV. Scalar AOVs are stored
+// in the X component of their image.
+func (a AOV) channelNames() []string {
+	switch a {
+	case AOVNormal, AOVPosition:
+		return []string{"X", "Y", "Z"}
+	case AOVDepth:
+		return []string{"Z"}
+	case AOVObjectID:
+		return []string{"ID"}
+	case AOVConvergence:
+		return []string{"samples", "error"}
+	}
+	return []string{"R", "G", "B"}
+}
+
+// ParseAOVs parses a comma separated list of AOV names, or "all".
+func ParseAOVs(list string) ([]AOV, error) {
+	if strings.TrimSpace(list) == "all" {
+		return AllAOVs(), nil
+	}
+	var aovs []AOV
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for i, n := range aovNames {
+			if strings.EqualFold(n, name) {
+				aovs = append(aovs, AOV(i))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown AOV %q", name)
+		}
+	}
+	return aovs, nil
+}
+
+// AOVBuffers holds the passes requested in Options.AOVs, one image per AOV.
+type AOVBuffers struct {
+	Width  int
+	Height int
+	Layers map[AOV]*Image
+	// Objects maps object IDs to names: ID i+1 is Objects[i]. Objects sharing a name,
+	// such as the meshes of one geometry, share an ID.
+	Objects []string
+
+	ids map[*Object]int
+}
+
+func newAOVBuffers(scene *Scene, width, height int, aovs []AOV) *AOVBuffers {
+	b := &AOVBuffers{
+		Width:  width,
+		Height: height,
+		Layers: make(map[AOV]*Image, len(aovs)),
+		ids:    make(map[*Object]int, len(scene.Objects)),
+	}
+	for _, a := range aovs {
+		b.Layers[a] = NewImage(width, height)
+	}
+	byName := make(map[string]int)
+	for _, obj := range scene.Objects {
+		id, ok := byName[obj.Name]
+		if !ok {
+			b.Objects = append(b.Objects, obj.Name)
+			id = len(b.Objects)
+			byName[obj.Name] = id
+		}
+		b.ids[obj] = id
+	}
+	return b
+}
+
+// Has reports whether the AOV was rendered.
+func (b *AOVBuffers) Has(a AOV) bool {
+	_, ok := b.Layers[a]
+	return ok
+}
+
+// ObjectNames returns the ID to name mapping, as written to the JSON sidecar.
+func (b *AOVBuffers) ObjectNames() map[int]string {
+	names := make(map[int]string, len(b.Objects))
+	for i, name := range b.Objects {
+		names[i+1] = name
+	}
+	return names
+}
+
+// Channels returns the AOV as image channels named "<aov>.<channel>", the beauty
+// pass without a layer prefix, ready for a layered EXR.
+func (b *AOVBuffers) Channels(a AOV) []imageio.Channel {
+	img := b.Layers[a]
+	if img == nil {
+		return nil
+	}
+	var channels []imageio.Channel
+	for c, suffix := range a.channelNames() {
+		name := a.String() + "." + suffix
+		if a == AOVBeauty {
+			name = suffix
+		}
+		data := make([]float32, len(img.Pix))
+		for i, v := range img.Pix {
+			data[i] = float32(v.Axis(c))
+		}
+		channels = append(channels, imageio.Channel{Name: name, Data: data})
+	}
+	return channels
+}
+
+// pathSample is what one camera path contributes to each AOV.
+type pathSample struct {
+	L Vec3
+
+	emission        Vec3
+	diffuseDirect   Vec3
+	diffuseIndirect Vec3
+	specular        Vec3
+
+	hit      bool
+	albedo   Vec3
+	normal   Vec3
+	position Vec3
+	depth    float64
+	object   *Object
+}
+
+// add adds light that reached the camera after the given number of bounces. share
+// is the part of it that left the first hit through the diffuse lobe.
+func (s *pathSample) add(c Vec3, bounces int, share Vec3) {
+	s.L = s.L.Add(c)
+	if bounces == 0 {
+		s.emission = s.emission.Add(c)
+		return
+	}
+	diffuse := c.Mul(share)
+	if bounces == 1 {
+		s.diffuseDirect = s.diffuseDirect.Add(diffuse)
+	} else {
+		s.diffuseIndirect = s.diffuseIndirect.Add(diffuse)
+	}
+	s.specular = s.specular.Add(c.Sub(diffuse))
+}
+
+// aovPixel averages the path samples of one pixel. Depth is the nearest hit and the
+// object ID comes from the first sample, so neither is blended across edges.
+type aovPixel struct {
+	sum    [aovCount]Vec3
+	depth  float64
+	object *Object
+	first  bool
+}
+
+func newAOVPixel() aovPixel {
+	return aovPixel{depth: math.Inf(1), first: true}
+}
+
+func (p *aovPixel) add(s *pathSample) {
+	p.sum[AOVBeauty] = p.sum[AOVBeauty].Add(s.L)
+	p.sum[AOVEmission] = p.sum[AOVEmission].Add(s.emission)
+	p.sum[AOVDiffuseDirect] = p.sum[AOVDiffuseDirect].Add(s.diffuseDirect)
+	p.sum[AOVDiffuseIndirect] = p.sum[AOVDiffuseIndirect].Add(s.diffuseIndirect)
+	p.sum[AOVSpecular] = p.sum[AOVSpecular].Add(s.specular)
+	if s.hit {
+		p.sum[AOVAlbedo] = p.sum[AOVAlbedo].Add(s.albedo)
+		p.sum[AOVNormal] = p.sum[AOVNormal].Add(s.normal)
+		p.sum[AOVPosition] = p.sum[AOVPosition].Add(s.position)
+		p.depth = math.Min(p.depth, s.depth)
+	}
+	if p.first {
+		p.object, p.first = s.object, false
+	}
+}
+
+// store writes the pixel's averages, scaled by the camera exposure for the light
+// passes, into the buffers.
+func (p *aovPixel) store(b *AOVBuffers, x, y int, stats *pixelStats, exposure float64) {
+	inv := 1 / float64(stats.n)
+	for a, img := range b.Layers {
+		var v Vec3
+		switch a {
+		case AOVDepth:
+			v = NewVec3(p.depth, 0, 0)
+		case AOVObjectID:
+			v = NewVec3(float64(b.ids[p.object]), 0, 0)
+		case AOVConvergence:
+			// A single sample has no noise estimate, keep the file finite
+			v = NewVec3(float64(stats.n), 0, 0)
+			if stats.n >= 2 {
+				v.Y = stats.error()
+			}
+		case AOVAlbedo, AOVPosition:
+			v = p.sum[a].Scale(inv)
+		case AOVNormal:
+			if !p.sum[a].IsBlack() {
+				v = p.sum[a].Normalize()
+			}
+		default:
+			v = p.sum[a].Scale(inv * exposure)
+		}
+		img.Set(x, y, v)
+	}
+}
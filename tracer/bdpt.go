@@ -0,0 +1,718 @@
+package tracer
+
+import "math"
+
+// Bidirectional path tracing traces a subpath from the camera and another from a
+// light, and joins every vertex of one to every vertex of the other. A path found
+// that way could have come from any of these strategies, so each is weighted by
+// multiple importance sampling with the power heuristic over how likely every
+// strategy was to find it. Light coming in through a small opening is then found
+// by the light subpaths that go through it, rather than by camera paths that
+// happen to. Light subpaths are not splatted onto the film, so the strategies that
+// join them to the camera itself are left out and pixels still render on their own.
+// Without those, caustics of the sun through smooth glass come out as slowly as
+// they do in the path tracer without its photon map.
+
+// vertexKind tells what a subpath vertex is.
+type vertexKind uint8
+
+const (
+	vertexCamera  vertexKind = iota
+	vertexLight              // a point on a light, or the direction of the sun, sky or environment
+	vertexSurface            // a surface the subpath scattered at
+	vertexMedium             // a scattering point in a medium
+)
+
+// pathVertex is a vertex of a camera or light subpath. pdfFwd is the density of
+// its own subpath generating it and pdfRev that of the other subpath, over area at
+// the vertex, or over solid angle for an infinite light. A subsurface random walk
+// has no density to speak of, it counts as 1 both ways and no strategy joins the
+// subpaths across one.
+type pathVertex struct {
+	kind   vertexKind
+	p      Vec3 // position, or the unit direction towards an infinite light
+	ng     Vec3 // geometric normal on the side the subpath arrived at, the emitting side on lights
+	hit    Hit
+	medium mediumRef // the subpath arrived through
+	beta   Vec3      // throughput of the subpath up to the vertex
+	Le     Vec3      // radiance a camera subpath vertex emits back along the subpath
+
+	pdfFwd   float64
+	pdfRev   float64
+	light    int  // index of the vertex's light in the integrator, -1 without one
+	infinite bool // at infinity, p is a direction
+	delta    bool // scattered by a delta lobe
+	walked   bool // reached from the previous vertex by a subsurface random walk
+}
+
+// scatters reports whether subpaths can be joined at the vertex.
+func (v *pathVertex) scatters() bool {
+	return (v.kind == vertexSurface || v.kind == vertexMedium) && !v.delta
+}
+
+// dirTo returns the unit direction from v towards u.
+func (v *pathVertex) dirTo(u *pathVertex) Vec3 {
+	if u.infinite {
+		return u.p
+	}
+	return u.p.Sub(v.p).Normalize()
+}
+
+// bsdf returns the BSDF at a surface vertex for light leaving towards wo, with its
+// shading frame and the geometric normal on wo's side, the way radiance sets them
+// up. Seen from inside a subsurface material light only leaves through the exit of
+// the walk.
+func (v *pathVertex) bsdf(wo Vec3) (BSDF, Frame, Vec3) {
+	hit := &v.hit
+	ng := hit.GeoNormal
+	front := ng.Dot(wo) > 0
+	if !front {
+		ng = ng.Neg()
+	}
+	n := hit.Normal
+	if n.Dot(ng) < 0 {
+		n = n.Neg()
+	}
+	frame := NewFrame(n)
+	if frame.ToLocal(wo).Z <= 0 {
+		frame = NewFrame(ng)
+	}
+	material := hit.Object.Material
+	if !front && hit.Object.subsurface != nil && hit.Object.Interior == nil {
+		return material.subsurfaceExit(), frame, ng
+	}
+	return material.BSDF(front), frame, ng
+}
+
+// scatter is the BSDF times the shading cosine at wi, or the phase function in a
+// medium, for light arriving from wi and leaving towards wo. On light subpaths,
+// adjoint set, light arrives from the end the subpath traced. Interpolated normals
+// make the shading cosine non-reciprocal there, so it gets Veach's correction.
+func (v *pathVertex) scatter(wo, wi Vec3, adjoint bool) Vec3 {
+	if v.kind == vertexMedium {
+		p := v.medium.Phase().Eval(wo, wi)
+		return NewVec3(p, p, p)
+	}
+	bsdf, frame, ng := v.bsdf(wo)
+	woLocal, wiLocal := frame.ToLocal(wo), frame.ToLocal(wi)
+	if (wi.Dot(ng) > 0) != (wiLocal.Z > 0) {
+		return Vec3{}
+	}
+	f := bsdf.Eval(woLocal, wiLocal).Scale(math.Abs(wiLocal.Z))
+	if adjoint {
+		cosI := math.Abs(wi.Dot(ng))
+		if cosI == 0 {
+			return Vec3{}
+		}
+		f = f.Scale(math.Abs(wo.Dot(ng)) / cosI)
+	}
+	return f
+}
+
+// pdf is the solid angle density of the vertex sampling wi when its subpath arrived
+// from wo.
+func (v *pathVertex) pdf(wo, wi Vec3) float64 {
+	if v.kind == vertexMedium {
+		return v.medium.Phase().PDF(wo, wi)
+	}
+	bsdf, frame, ng := v.bsdf(wo)
+	wiLocal := frame.ToLocal(wi)
+	if (wi.Dot(ng) > 0) != (wiLocal.Z > 0) {
+		return 0
+	}
+	return bsdf.PDF(frame.ToLocal(wo), wiLocal)
+}
+
+// toArea converts a density over the directions leaving v into one over area at
+// next. Light from an infinite light starts on a disk across its direction, so its
+// density is over area already and only needs projecting.
+func (v *pathVertex) toArea(pdf float64, next *pathVertex) float64 {
+	if next.infinite {
+		return pdf
+	}
+	var w Vec3
+	if v.infinite {
+		w = v.p.Neg()
+	} else {
+		w = next.p.Sub(v.p)
+		dist2 := w.LengthSquared()
+		if dist2 == 0 {
+			return 0
+		}
+		w = w.Scale(1 / math.Sqrt(dist2))
+		pdf /= dist2
+	}
+	if next.kind == vertexSurface || next.kind == vertexLight {
+		pdf *= math.Abs(next.ng.Dot(w))
+	}
+	return pdf
+}
+
+// bidirectional is the bidirectional path tracer. Light subpaths start on the
+// scene's lights and the sun, picked by power.
+type bidirectional struct {
+	scene    *Scene
+	maxDepth int             // bounces per path
+	emitters []emitter       // of the scene's lights, nil for the environment
+	distrib  *distribution1D // over the scene's lights, then the sun
+	sun      int             // index of the sun in distrib, -1 without one
+
+	// Light from the sun and the environment enters the scene through a disk of the
+	// scene's radius across its direction, starting far enough out to pass through
+	// the scene's medium
+	center Vec3
+	radius float64
+	far    float64
+}
+
+func newBidirectional(s *Scene, opts *Options) *bidirectional {
+	// The path tracer samples lights from its last bounce, which makes one more
+	b := &bidirectional{scene: s, maxDepth: max(opts.MaxDepth, 0) + 1, sun: -1, radius: s.boundingRadius()}
+	if bounds := s.objectBounds(); !bounds.IsEmpty() {
+		b.center = bounds.Centroid()
+	}
+	b.far = b.radius
+	if s.Medium != nil && !s.mediumBounds.IsEmpty() {
+		b.far = math.Max(b.far, s.mediumBounds.Centroid().Sub(b.center).Length()+s.mediumBounds.Diagonal().Length()/2)
+	}
+
+	var power []float64
+	for i, l := range s.lights {
+		e, _ := l.(emitter)
+		b.emitters = append(b.emitters, e)
+		if e == nil && i != s.envIndex {
+			// Only found by camera subpaths
+			power = append(power, 0)
+			continue
+		}
+		power = append(power, l.Power())
+	}
+	if s.Sun != nil && !s.Sun.Irradiance.IsBlack() {
+		b.sun = len(power)
+		power = append(power, s.Sun.Irradiance.Luminance()*math.Pi*b.radius*b.radius)
+	}
+	if len(power) > 0 {
+		b.distrib = newDistribution1D(power)
+	}
+	return b
+}
+
+func (b *bidirectional) radiance(r Ray, sampler Sampler, split bool) pathSample {
+	var out pathSample
+	camera := make([]pathVertex, 1, b.maxDepth+2)
+	camera[0] = pathVertex{kind: vertexCamera, p: r.Origin, beta: NewVec3(1, 1, 1), light: -1}
+	camera, share := b.walk(camera, r, NewVec3(1, 1, 1), 0, b.scene.sceneMedium(), sampler, &out, split)
+	light := b.lightPath(r.Time, sampler)
+
+	// Strategy (s, t) joins the first s light subpath vertices to the first t camera
+	// subpath vertices, a path of s+t-2 bounces
+	for t := 2; t <= len(camera); t++ {
+		for s := 0; s <= max(len(light), 1) && s+t-2 <= b.maxDepth; s++ {
+			L, wi := b.connect(light, camera, s, t, r.Time, sampler)
+			if L.IsBlack() {
+				continue
+			}
+			pathShare := share
+			if t == 2 && split && s > 0 {
+				pathShare = camera[1].directShare(camera[1].dirTo(&camera[0]), wi)
+			}
+			out.add(L, s+t-2, pathShare)
+		}
+	}
+	return out
+}
+
+// directShare is the part of the light arriving along wi that the first hit sends
+// towards wo through its diffuse lobe. Phase functions scatter like one.
+func (v *pathVertex) directShare(wo, wi Vec3) Vec3 {
+	if v.kind == vertexMedium {
+		return NewVec3(1, 1, 1)
+	}
+	bsdf, frame, _ := v.bsdf(wo)
+	woLocal, wiLocal := frame.ToLocal(wo), frame.ToLocal(wi)
+	return diffuseShare(bsdf, woLocal, wiLocal, bsdf.Eval(woLocal, wiLocal))
+}
+
+// lightPath starts a subpath on a light picked by power and traces it.
+func (b *bidirectional) lightPath(time float64, sampler Sampler) []pathVertex {
+	if b.distrib == nil {
+		return nil
+	}
+	s := b.scene
+	i, pmf, _ := b.distrib.sample(sampler.Get1D())
+	u0, u1 := sampler.Get2D(), sampler.Get2D()
+	side := sampler.Get1D()
+	if pmf == 0 {
+		return nil
+	}
+	path := make([]pathVertex, 0, b.maxDepth+1)
+
+	if i == b.sun || i == s.envIndex {
+		var wi, Le Vec3
+		pdfDir := 1.0
+		if i == b.sun {
+			wi, Le = s.Sun.Direction.Neg().Normalize(), s.Sun.Irradiance
+		} else {
+			ls, ok := s.lights[i].SampleLi(b.center, u0)
+			if !ok || ls.PDF == 0 {
+				return nil
+			}
+			wi, Le, pdfDir = ls.Wi, ls.L, ls.PDF
+		}
+		dx, dy := uniformDisk(u1[0], u1[1])
+		origin := b.center.Add(NewFrame(wi).ToWorld(NewVec3(dx*b.radius, dy*b.radius, b.far+rayEpsilon)))
+		pdfPos := 1 / (math.Pi * b.radius * b.radius)
+		path = append(path, pathVertex{kind: vertexLight, p: wi, light: i, infinite: true, pdfFwd: pmf * pdfDir})
+		beta := Le.Scale(1 / (pmf * pdfDir * pdfPos))
+		path, _ = b.walk(path, Ray{Origin: origin, Dir: wi.Neg(), Time: time}, beta, pdfPos, s.sceneMedium(), sampler, nil, false)
+		return path
+	}
+
+	e := b.emitters[i]
+	if e == nil {
+		return nil
+	}
+	em, ok := e.sampleEmission(u0)
+	if !ok {
+		return nil
+	}
+	// Cosine weighted on the emitting side, either side evenly when both emit
+	local := cosineHemisphere(u1[0], u1[1])
+	n, pdfDir := em.n, local.Z/math.Pi
+	if em.twoSided {
+		if side < 0.5 {
+			n = n.Neg()
+		}
+		pdfDir /= 2
+	}
+	if pdfDir <= 0 {
+		return nil
+	}
+	path = append(path, pathVertex{kind: vertexLight, p: em.p, ng: em.n, light: i, pdfFwd: pmf * em.pdf})
+	beta := em.L.Scale(local.Z / (pmf * em.pdf * pdfDir))
+	path, _ = b.walk(path, spawnRay(em.p, n, NewFrame(n).ToWorld(local), time), beta, pdfDir, s.sceneMedium(), sampler, nil, false)
+	return path
+}
+
+// walk extends a subpath from its last vertex along r, which was sampled with the
+// solid angle density pdf, or the area density for the first ray of an infinite
+// light, until it has cap(path) vertices or ends. Camera subpaths, with out set,
+// also end on lights and the sky, with a vertex there that only emits, and gather
+// the light emitted by media into out. walk returns the part of the light that
+// left the first hit through the diffuse lobe, as radiance keeps it.
+func (b *bidirectional) walk(path []pathVertex, r Ray, beta Vec3, pdf float64, medium mediumRef, sampler Sampler, out *pathSample, split bool) ([]pathVertex, Vec3) {
+	s := b.scene
+	camera := out != nil
+	start := beta.MaxComponent()
+	crossings := 0
+	var share Vec3
+	for len(path) < cap(path) && start > 0 {
+		n := len(path)
+		var hit Hit
+		var ok bool
+		walked := medium.walk
+		if walked {
+			var w Vec3
+			if hit, r, w, ok = s.randomWalk(medium, r, sampler); !ok {
+				break
+			}
+			beta = beta.Mul(w)
+		} else {
+			hit, ok = s.Intersect(r)
+		}
+		tMax := math.Inf(1)
+		if ok {
+			tMax = hit.T
+		}
+		light, Le, hitLight := -1, Vec3{}, false
+		if camera {
+			var tLight float64
+			if light, tLight, Le, hitLight = s.intersectLights(r, tMax); hitLight {
+				tMax = tLight
+			}
+		}
+
+		v := pathVertex{beta: beta, medium: medium, light: -1, walked: walked}
+		scattered := false
+		if medium.Medium != nil && !walked {
+			ev := s.sampleMedium(medium, r, tMax, sampler)
+			if camera {
+				out.add(beta.Mul(ev.emitted), n-1, share)
+			}
+			beta = beta.Mul(ev.weight)
+			if ev.absorbed {
+				break
+			}
+			if scattered = ev.scattered; scattered {
+				v.kind, v.p, v.beta = vertexMedium, r.At(ev.t), beta
+			}
+		}
+
+		if !scattered {
+			switch {
+			case hitLight:
+				v.kind, v.p, v.Le, v.light = vertexLight, r.At(tMax), Le, light
+				if e := b.emitters[light]; e != nil {
+					v.ng = e.emissionAt(v.p).n
+				}
+				v.pdfFwd = path[n-1].toArea(pdf, &v)
+				return append(path, v), share
+			case !ok:
+				if camera {
+					Le, env := s.escaped(r.Dir)
+					v.kind, v.p, v.Le, v.light, v.infinite = vertexLight, r.Dir, Le, env, true
+					v.pdfFwd = pdf
+					path = append(path, v)
+				}
+				return path, share
+			case hit.Object.NullSurface:
+				// Crossing the boundary of a medium is not a bounce
+				medium = s.mediumBeyond(&hit, r.Dir, medium)
+				r = spawnRay(hit.Point, hit.GeoNormal, r.Dir, r.Time)
+				if crossings++; crossings > maxNullCrossings {
+					return path, share
+				}
+				continue
+			}
+			ng := hit.GeoNormal
+			entering := ng.Dot(r.Dir) < 0
+			if !entering {
+				ng = ng.Neg()
+			}
+			v.kind, v.p, v.ng, v.hit = vertexSurface, hit.Point, ng, hit
+			material := hit.Object.Material
+			if camera && n == 1 {
+				sn := hit.Normal
+				if sn.Dot(ng) < 0 {
+					sn = sn.Neg()
+				}
+				out.hit, out.object = true, hit.Object
+				out.albedo, out.normal, out.position, out.depth = material.Albedo(), sn, hit.Point, hit.T
+			}
+			if camera && entering && material.IsEmissive() {
+				v.Le = material.Emitted()
+				if i, ok := s.emissiveLight(&hit); ok {
+					v.light = i
+				}
+			}
+		}
+		if walked {
+			v.pdfFwd = 1
+		} else {
+			v.pdfFwd = path[n-1].toArea(pdf, &v)
+		}
+		path = append(path, v)
+		if len(path) == cap(path) {
+			break
+		}
+
+		// Continue in a direction picked by the BSDF or phase function
+		vp := &path[n]
+		wo := r.Dir.Neg()
+		var wi, weight Vec3
+		var pdfRev float64
+		if vp.kind == vertexMedium {
+			phase := medium.Phase()
+			wi, pdf = phase.Sample(wo, sampler.Get2D())
+			pdfRev = phase.PDF(wi, wo)
+			weight = NewVec3(1, 1, 1)
+			if camera && n == 1 && split {
+				share = NewVec3(1, 1, 1)
+			}
+			r = Ray{Origin: vp.p, Dir: wi, Time: r.Time}
+		} else {
+			bsdf, frame, ng := vp.bsdf(wo)
+			woLocal := frame.ToLocal(wo)
+			sample, ok := bsdf.Sample(woLocal, sampler.Get1D(), sampler.Get2D())
+			if !ok {
+				break
+			}
+			wi = frame.ToWorld(sample.Wi)
+			if (wi.Dot(ng) > 0) != (sample.Wi.Z > 0) {
+				break
+			}
+			weight, pdf, vp.delta = sample.Weight(), sample.PDF, sample.Delta
+			if vp.delta {
+				pdf = 0
+			} else {
+				pdfRev = vp.pdf(wi, wo)
+				if !camera {
+					weight = vp.scatter(wi, wo, true).Scale(1 / sample.PDF)
+				}
+			}
+			if camera && n == 1 && split && !sample.Delta {
+				share = diffuseShare(bsdf, woLocal, sample.Wi, sample.F)
+			}
+			if wi.Dot(ng) < 0 {
+				medium = s.mediumBeyond(&hit, wi, medium)
+			}
+			r = spawnRay(vp.p, ng, wi, r.Time)
+		}
+		// The density of the other subpath reaching the previous vertex from this one
+		if vp.walked {
+			path[n-1].pdfRev = 1
+		} else {
+			path[n-1].pdfRev = vp.toArea(pdfRev, &path[n-1])
+		}
+
+		// Light subpaths carry the light's power, roulette goes by what is left of it
+		relative, ok := russianRoulette(beta.Mul(weight).Scale(1/start), n-1, sampler)
+		if !ok {
+			break
+		}
+		beta = relative.Scale(start)
+	}
+	return path, share
+}
+
+// connect evaluates strategy (s, t), weighted against the others. It also returns
+// the direction from the last camera vertex towards the light side.
+func (b *bidirectional) connect(light, camera []pathVertex, s, t int, time float64, sampler Sampler) (Vec3, Vec3) {
+	pt, ptMinus := &camera[t-1], &camera[t-2]
+	var L, wi Vec3
+	var sampled pathVertex
+	switch {
+	case s == 0:
+		// The camera subpath found a light by itself
+		if pt.Le.IsBlack() {
+			return Vec3{}, Vec3{}
+		}
+		L = pt.beta.Mul(pt.Le)
+		if pt.light < 0 {
+			// Only camera subpaths find the sky, moving emitters and lights light
+			// subpaths cannot leave
+			return L, Vec3{}
+		}
+	case s == 1:
+		if !pt.scatters() || b.distrib == nil {
+			return Vec3{}, Vec3{}
+		}
+		if sampled, L, wi = b.connectLight(pt, pt.dirTo(ptMinus), time, sampler); L.IsBlack() {
+			return Vec3{}, Vec3{}
+		}
+	default:
+		qs := &light[s-1]
+		if !pt.scatters() || !qs.scatters() {
+			return Vec3{}, Vec3{}
+		}
+		d := qs.p.Sub(pt.p)
+		dist := d.Length()
+		if dist == 0 {
+			return Vec3{}, Vec3{}
+		}
+		wi = d.Scale(1 / dist)
+		fp := pt.scatter(pt.dirTo(ptMinus), wi, false)
+		if fp.IsBlack() {
+			return Vec3{}, Vec3{}
+		}
+		fq := qs.scatter(wi.Neg(), qs.dirTo(&light[s-2]), true)
+		if fq.IsBlack() {
+			return Vec3{}, Vec3{}
+		}
+		L = pt.beta.Mul(fp).Mul(fq).Mul(qs.beta).Scale(1 / (dist * dist))
+		if L.IsBlack() {
+			return Vec3{}, Vec3{}
+		}
+		if L = L.Mul(b.transmittance(pt, wi, dist, time, sampler)); L.IsBlack() {
+			return Vec3{}, Vec3{}
+		}
+	}
+	return L.Scale(b.misWeight(light, camera, &sampled, s, t)), wi
+}
+
+// connectLight is strategy s = 1, next-event estimation: it picks a point on a
+// light as seen from pt and returns it as a light vertex with the light arriving
+// at the camera through it and the direction towards it.
+func (b *bidirectional) connectLight(pt *pathVertex, wo Vec3, time float64, sampler Sampler) (pathVertex, Vec3, Vec3) {
+	s := b.scene
+	i, pmf, _ := b.distrib.sample(sampler.Get1D())
+	u := sampler.Get2D()
+	if pmf == 0 {
+		return pathVertex{}, Vec3{}, Vec3{}
+	}
+	q := pathVertex{kind: vertexLight, light: i}
+	var wi, Le Vec3
+	pdf, dist := 1.0, math.Inf(1)
+	if i == b.sun {
+		wi, Le = s.Sun.Direction.Neg().Normalize(), s.Sun.Irradiance
+	} else {
+		ls, ok := s.lights[i].SampleLi(pt.p, u)
+		if !ok || ls.PDF == 0 || ls.L.IsBlack() {
+			return pathVertex{}, Vec3{}, Vec3{}
+		}
+		wi, Le, pdf, dist = ls.Wi, ls.L, ls.PDF, ls.Dist
+	}
+	if math.IsInf(dist, 1) {
+		q.p, q.infinite = wi, true
+	} else {
+		q.p = pt.p.Add(wi.Scale(dist))
+		if e := b.emitters[i]; e != nil {
+			q.ng = e.emissionAt(q.p).n
+		}
+	}
+	q.pdfFwd = b.originPDF(&q)
+
+	f := pt.scatter(wo, wi, false)
+	if f.IsBlack() {
+		return pathVertex{}, Vec3{}, Vec3{}
+	}
+	T := b.transmittance(pt, wi, dist, time, sampler)
+	return q, pt.beta.Mul(f).Mul(Le).Mul(T).Scale(1 / (pmf * pdf)), wi
+}
+
+// transmittance is the fraction of light arriving at v from dist away along wi,
+// through v's surface when wi points that way.
+func (b *bidirectional) transmittance(v *pathVertex, wi Vec3, dist float64, time float64, sampler Sampler) Vec3 {
+	s := b.scene
+	if v.kind == vertexMedium {
+		return s.transmittance(Ray{Origin: v.p, Dir: wi, Time: time}, dist-rayEpsilon, v.medium, sampler)
+	}
+	medium := v.medium
+	if wi.Dot(v.ng) < 0 {
+		medium = s.mediumBeyond(&v.hit, wi, medium)
+	}
+	return s.transmittance(spawnRay(v.p, v.ng, wi, time), dist-2*rayEpsilon, medium, sampler)
+}
+
+// originPDF is the density of a light subpath starting at light vertex v.
+func (b *bidirectional) originPDF(v *pathVertex) float64 {
+	pmf := b.distrib.pmf(v.light)
+	switch {
+	case v.light == b.sun:
+		return pmf
+	case v.infinite:
+		return pmf * b.scene.lights[v.light].PDFLi(b.center, v.p)
+	}
+	if e := b.emitters[v.light]; e != nil {
+		return pmf * e.emissionAt(v.p).pdf
+	}
+	return 0
+}
+
+// emitPDF is the area density of a light subpath leaving light vertex v reaching
+// next.
+func (b *bidirectional) emitPDF(v, next *pathVertex) float64 {
+	if v.infinite {
+		return v.toArea(1/(math.Pi*b.radius*b.radius), next)
+	}
+	e := b.emitters[v.light]
+	if e == nil {
+		return 0
+	}
+	em := e.emissionAt(v.p)
+	cos := facing(em.n, v.dirTo(next), em.twoSided)
+	if cos <= 0 {
+		return 0
+	}
+	if em.twoSided {
+		cos /= 2
+	}
+	return v.toArea(cos/math.Pi, next)
+}
+
+// pdfNext is the area density of vertex v, reached from prev, sampling next.
+func (b *bidirectional) pdfNext(prev, v, next *pathVertex) float64 {
+	if v.kind == vertexLight {
+		return b.emitPDF(v, next)
+	}
+	return v.toArea(v.pdf(v.dirTo(prev), v.dirTo(next)), next)
+}
+
+// misWeight is the power heuristic weight of strategy (s, t) against every other
+// strategy that could have found the same path. sampled is the light vertex of
+// strategy s = 1, which picks its own. The densities of each strategy only differ
+// in the vertices the subpaths swap, so the weight is built from ratios of pdfRev
+// to pdfFwd, walking out from the connection along both subpaths.
+func (b *bidirectional) misWeight(light, camera []pathVertex, sampled *pathVertex, s, t int) float64 {
+	if s+t == 2 {
+		return 1
+	}
+	pt, ptMinus := &camera[t-1], &camera[t-2]
+	lightVertex := func(i int) *pathVertex {
+		if s == 1 {
+			return sampled
+		}
+		return &light[i]
+	}
+	var qs, qsMinus *pathVertex
+	if s > 0 {
+		qs = lightVertex(s - 1)
+	}
+	if s > 1 {
+		qsMinus = &light[s-2]
+	}
+
+	// Joining the subpaths gives the vertices next to the connection the reverse
+	// densities of the other side
+	var ptRev, ptMinusRev, qsRev, qsMinusRev float64
+	if s > 0 {
+		ptRev = b.pdfNext(qsMinus, qs, pt)
+		ptMinusRev = 1
+		if !pt.walked {
+			ptMinusRev = b.pdfNext(qs, pt, ptMinus)
+		}
+		qsRev = b.pdfNext(ptMinus, pt, qs)
+		if s > 1 {
+			qsMinusRev = 1
+			if !qs.walked {
+				qsMinusRev = b.pdfNext(pt, qs, qsMinus)
+			}
+		}
+	} else {
+		ptRev = b.originPDF(pt)
+		ptMinusRev = b.emitPDF(pt, ptMinus)
+	}
+	rev := func(v *pathVertex) float64 {
+		switch v {
+		case pt:
+			return ptRev
+		case ptMinus:
+			return ptMinusRev
+		case qs:
+			return qsRev
+		case qsMinus:
+			return qsMinusRev
+		}
+		return v.pdfRev
+	}
+	// The connection's own endpoints are never delta
+	delta := func(v *pathVertex) bool {
+		return v != pt && v != qs && v.delta
+	}
+	// Delta lobes and walks have no density, they are 1 on both sides of a ratio
+	remap := func(pdf float64) float64 {
+		if pdf == 0 {
+			return 1
+		}
+		return pdf
+	}
+
+	sum := 0.0
+	// Strategies with fewer camera vertices, down to two since the camera is not
+	// joined to light subpaths
+	ratio := 1.0
+	for i := t - 1; i > 1; i-- {
+		v := &camera[i]
+		ratio *= remap(rev(v)) / remap(v.pdfFwd)
+		if !delta(v) && !delta(&camera[i-1]) && !v.walked {
+			sum += ratio * ratio
+		}
+	}
+	// Strategies with fewer light vertices, down to the camera subpath hitting the
+	// light. Nothing hits the sun.
+	ratio = 1
+	for i := s - 1; i >= 0; i-- {
+		v := lightVertex(i)
+		ratio *= remap(rev(v)) / remap(v.pdfFwd)
+		joinable := !delta(v) && !v.walked
+		if i > 0 {
+			joinable = joinable && !delta(lightVertex(i-1))
+		} else {
+			joinable = joinable && v.light != b.sun
+		}
+		if joinable {
+			sum += ratio * ratio
+		}
+	}
+	return 1 / (1 + sum)
+}
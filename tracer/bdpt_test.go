@@ -0,0 +1,44 @@
+package tracer
+
+import (
+	"math"
+	"testing"
+)
+
+// Both integrators solve the same light transport, so on a diffuse box they must
+// agree up to noise. The frame is compared in blocks, each against the spread of
+// its estimates over a few seeds.
+func TestBidirectionalMatchesPathTracer(t *testing.T) {
+	scene := boxScene(t)
+	const seeds, block = 4, 4
+	opts := DefaultOptions()
+	opts.Width, opts.Height, opts.Samples = 16, 12, 64
+	bw, bh := opts.Width/block, opts.Height/block
+
+	blocks := func(integrator Integrator) []estimate {
+		est := make([]estimate, bw*bh)
+		for seed := 0; seed < seeds; seed++ {
+			opts.Integrator, opts.Seed = integrator, uint64(seed)
+			img := Render(scene, opts)
+			means := make([]float64, bw*bh)
+			for y := 0; y < img.Height; y++ {
+				for x := 0; x < img.Width; x++ {
+					means[y/block*bw+x/block] += img.At(x, y).Luminance() / (block * block)
+				}
+			}
+			for i, m := range means {
+				est[i].add(m)
+			}
+		}
+		return est
+	}
+	path, bdpt := blocks(IntegratorPath), blocks(IntegratorBidirectional)
+	for i := range path {
+		diff := math.Abs(path[i].mean() - bdpt[i].mean())
+		tolerance := 5*math.Hypot(path[i].stdErr(), bdpt[i].stdErr()) + 0.01*path[i].mean()
+		if diff > tolerance {
+			t.Errorf("block (%d, %d): path tracer %v, bidirectional %v (tolerance %v)",
+				i%bw, i/bw, path[i].mean(), bdpt[i].mean(), tolerance)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package tracer
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+// blueNoiseSize is the edge length of the tileable blue noise texture.
+const blueNoiseSize = 64
+
+// blueNoiseTile returns a blueNoiseSize squared tile of values in [0, 1), each one
+// used exactly once and spread so that similar values are never close together. It
+// is generated on first use and is the same in every run.
+var blueNoiseTile = sync.OnceValue(func() []float64 {
+	ranks := voidAndCluster(blueNoiseSize, 1.9, 0x5eed)
+	n := len(ranks)
+	tile := make([]float64, n)
+	for i, r := range ranks {
+		tile[i] = (float64(r) + 0.5) / float64(n)
+	}
+	return tile
+})
+
+// voidAndCluster ranks the pixels of a size x size toroidal grid with Ulichney's
+// void-and-cluster method: pixels are added one at a time where the existing ones
+// leave the largest gap, measured with a Gaussian of the given sigma.
+func voidAndCluster(size int, sigma float64, seed uint64) []int {
+	n := size * size
+
+	// Gaussian falloff for every toroidal offset
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			x := float64(min(dx, size-dx))
+			y := float64(min(dy, size-dy))
+			kernel[dy*size+dx] = math.Exp(-(x*x + y*y) / (2 * sigma * sigma))
+		}
+	}
+
+	set := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(p int, on bool) {
+		set[p] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		px, py := p%size, p/size
+		for y := 0; y < size; y++ {
+			row := ((y - py + size) % size) * size
+			for x := 0; x < size; x++ {
+				energy[y*size+x] += sign * kernel[row+(x-px+size)%size]
+			}
+		}
+	}
+	// tightest returns the set pixel with the most energy around it, largestVoid the
+	// free pixel with the least
+	tightest := func() int {
+		best, p := math.Inf(-1), -1
+		for i, e := range energy {
+			if set[i] && e > best {
+				best, p = e, i
+			}
+		}
+		return p
+	}
+	largestVoid := func() int {
+		best, p := math.Inf(1), -1
+		for i, e := range energy {
+			if !set[i] && e < best {
+				best, p = e, i
+			}
+		}
+		return p
+	}
+
+	// Start from a tenth of the pixels at random and spread them out until moving
+	// the tightest one into the largest void puts it back where it was
+	rng := rand.New(rand.NewPCG(seed, 0))
+	initial := n / 10
+	for count := 0; count < initial; {
+		if p := rng.IntN(n); !set[p] {
+			toggle(p, true)
+			count++
+		}
+	}
+	for i := 0; i < n; i++ {
+		cluster := tightest()
+		toggle(cluster, false)
+		void := largestVoid()
+		toggle(void, true)
+		if void == cluster {
+			break
+		}
+	}
+	prototype := append([]bool(nil), set...)
+	prototypeEnergy := append([]float64(nil), energy...)
+
+	ranks := make([]int, n)
+	// Lower ranks: take the initial points away, tightest first
+	for rank := initial - 1; rank >= 0; rank-- {
+		p := tightest()
+		toggle(p, false)
+		ranks[p] = rank
+	}
+	// Higher ranks: fill the remaining pixels, largest void first
+	copy(set, prototype)
+	copy(energy, prototypeEnergy)
+	for rank := initial; rank < n; rank++ {
+		p := largestVoid()
+		toggle(p, true)
+		ranks[p] = rank
+	}
+	return ranks
+}
@@ -0,0 +1,255 @@
+package tracer
+
+import "math"
+
+// BSDF scatters light at a surface point. Directions are in the local shading frame:
+// the normal is +Z and wo, the direction towards the viewer, always has wo.Z > 0.
+// Eval returns f without the cosine term.
+type BSDF interface {
+	Eval(wo, wi Vec3) Vec3
+	Sample(wo Vec3, uc float64, u [2]float64) (BSDFSample, bool)
+	PDF(wo, wi Vec3) float64
+}
+
+// diffuseLobe is implemented by BSDFs with a diffuse lobe. evalDiffuse returns the
+// part of Eval it contributes, which splits the diffuse and specular AOVs.
+type diffuseLobe interface {
+	evalDiffuse(wo, wi Vec3) Vec3
+}
+
+// diffuseShare is the fraction of f, per channel, that comes from the diffuse lobe.
+func diffuseShare(bsdf BSDF, wo, wi, f Vec3) Vec3 {
+	d, ok := bsdf.(diffuseLobe)
+	if !ok {
+		return Vec3{}
+	}
+	return partShare(d.evalDiffuse(wo, wi), f)
+}
+
+// partShare is the fraction of whole, per channel, that part makes up.
+func partShare(part, whole Vec3) Vec3 {
+	share := func(a, b float64) float64 {
+		if b <= 0 {
+			return 0
+		}
+		return math.Min(a/b, 1)
+	}
+	return NewVec3(share(part.X, whole.X), share(part.Y, whole.Y), share(part.Z, whole.Z))
+}
+
+// BSDFSample is a direction picked by BSDF.Sample. For delta lobes PDF is the lobe
+// selection probability and F already contains the Dirac term divided by |cos|.
+type BSDFSample struct {
+	Wi    Vec3
+	F     Vec3
+	PDF   float64
+	Delta bool
+}
+
+// Weight is the path throughput factor f*|cos|/pdf of the sample.
+func (s BSDFSample) Weight() Vec3 {
+	return s.F.Scale(math.Abs(s.Wi.Z) / s.PDF)
+}
+
+// principledBSDF evaluates Material. The same formula is implemented by EvalBRDF in
+// the raster fragment shader (materials/shader.go), keep both in sync. The shader
+// only sees front faces and no transmission, so it has no counterpart for the
+// refraction or subsurface lobes or for Fresnel seen from inside the surface.
+// Smooth surfaces reflect and refract through delta lobes, which Eval and PDF leave
+// out and only Sample returns.
+type principledBSDF struct {
+	baseColor    Vec3
+	metallic     float64
+	alpha        float64
+	smooth       bool
+	eta          float64 // relative index across the surface, from wo's side
+	transmission float64
+	subsurface   float64       // fraction of the diffuse base that scatters beneath the surface
+	conductor    *ConductorIOR // replaces the base color tinted metal Fresnel when set
+}
+
+// dielectricFresnel is the reflectance of the dielectric base for a microfacet at
+// cosine cosO with wo, with total internal reflection giving 1 from inside.
+func (b *principledBSDF) dielectricFresnel(cosO float64) float64 {
+	return fresnelDielectric(cosO, b.eta)
+}
+
+func (b *principledBSDF) metalFresnel(cosO float64) Vec3 {
+	if b.conductor != nil {
+		return b.conductor.Fresnel(cosO)
+	}
+	return b.baseColor.Add(NewVec3(1, 1, 1).Sub(b.baseColor).Scale(schlickWeight(cosO)))
+}
+
+func (b *principledBSDF) Eval(wo, wi Vec3) Vec3 {
+	if wo.Z <= 0 {
+		return Vec3{}
+	}
+	if wi.Z < 0 {
+		return b.evalTransmission(wo, wi)
+	}
+	if b.smooth {
+		return b.diffuse(wo, wi).Scale(1 - b.metallic)
+	}
+	spec, _, h, ok := microfacetReflection(wo, wi, b.alpha)
+	if !ok {
+		return Vec3{}
+	}
+	voh := wo.Dot(h)
+	fd := b.dielectricFresnel(voh)
+
+	dielectric := b.diffuse(wo, wi).Add(NewVec3(spec, spec, spec).Scale(fd))
+	metal := b.metalFresnel(voh).Scale(spec)
+	return Lerp(dielectric, metal, b.metallic)
+}
+
+// diffuse is the dielectric base's diffuse lobe. It only receives what the specular
+// layer lets through on the way in and out, which keeps the sum energy conserving
+// and reciprocal.
+func (b *principledBSDF) diffuse(wo, wi Vec3) Vec3 {
+	through := (1 - b.dielectricFresnel(wo.Z)) * (1 - b.dielectricFresnel(wi.Z))
+	return b.baseColor.Scale((1 - b.transmission) * (1 - b.subsurface) * through / math.Pi)
+}
+
+// subsurfaceLobe is the diffuse transmission of light that goes beneath the surface,
+// where the random walk takes over. It is white, the walk colors the light, and only
+// loses what the specular layer reflects at wo. Light is refracted into a denser
+// medium, so its radiance in there is raised by eta^2 and the lobe lowered by as
+// much. The walk leaves through subsurfaceExitBSDF rather than this lobe.
+func (b *principledBSDF) subsurfaceLobe(wo, wi Vec3) float64 {
+	if b.subsurface <= 0 || b.eta < 1 || wo.Z <= 0 || wi.Z >= 0 {
+		return 0
+	}
+	through := 1 - b.dielectricFresnel(wo.Z)
+	return (1 - b.transmission) * b.subsurface * through / (math.Pi * b.eta * b.eta)
+}
+
+// evalDiffuse counts the subsurface lobe as diffuse too.
+func (b *principledBSDF) evalDiffuse(wo, wi Vec3) Vec3 {
+	if wo.Z <= 0 || wi.Z == 0 {
+		return Vec3{}
+	}
+	if wi.Z < 0 {
+		sss := b.subsurfaceLobe(wo, wi) * (1 - b.metallic)
+		return NewVec3(sss, sss, sss)
+	}
+	return b.diffuse(wo, wi).Scale(1 - b.metallic)
+}
+
+// evalTransmission is the rough refraction lobe of the dielectric base, tinted by
+// the base color, plus the subsurface lobe.
+func (b *principledBSDF) evalTransmission(wo, wi Vec3) Vec3 {
+	sss := b.subsurfaceLobe(wo, wi) * (1 - b.metallic)
+	f := NewVec3(sss, sss, sss)
+	weight := (1 - b.metallic) * b.transmission
+	if weight <= 0 || b.smooth {
+		return f
+	}
+	ft, _, h, ok := microfacetTransmission(wo, wi, b.eta, b.alpha)
+	if !ok {
+		return f
+	}
+	return f.Add(b.baseColor.Scale(weight * (1 - b.dielectricFresnel(wo.Dot(h))) * ft))
+}
+
+// lobeProbabilities returns how often Sample picks the specular, diffuse,
+// subsurface and transmission lobes, estimated from the Fresnel weight at wo.
+func (b *principledBSDF) lobeProbabilities(wo Vec3) (float64, float64, float64, float64) {
+	fd := b.dielectricFresnel(wo.Z)
+	fm := b.metalFresnel(wo.Z)
+
+	dielectric := 1 - b.metallic
+	spec := dielectric*fd + b.metallic*fm.Luminance()
+	diff := dielectric * (1 - b.transmission) * (1 - b.subsurface) * (1 - fd) * b.baseColor.Luminance()
+	sss := 0.0
+	if b.eta >= 1 {
+		sss = dielectric * (1 - b.transmission) * b.subsurface * (1 - fd)
+	}
+	trans := dielectric * b.transmission * (1 - fd)
+	total := spec + diff + sss + trans
+	if total <= 0 {
+		return 0, 0, 0, 0
+	}
+	return spec / total, diff / total, sss / total, trans / total
+}
+
+func (b *principledBSDF) Sample(wo Vec3, uc float64, u [2]float64) (BSDFSample, bool) {
+	if wo.Z <= 0 {
+		return BSDFSample{}, false
+	}
+	pSpec, pDiff, pSSS, pTrans := b.lobeProbabilities(wo)
+	if b.smooth && (uc < pSpec || uc >= pSpec+pDiff+pSSS) {
+		return b.sampleSmooth(wo, uc < pSpec, pSpec, pTrans)
+	}
+
+	var wi Vec3
+	switch {
+	case uc < pSpec:
+		h := ggxSampleVisible(wo, b.alpha, u[0], u[1])
+		wi = reflect(wo, h)
+	case uc < pSpec+pDiff:
+		wi = cosineHemisphere(u[0], u[1])
+	case uc < pSpec+pDiff+pSSS:
+		wi = cosineHemisphere(u[0], u[1])
+		wi.Z = -wi.Z
+	default:
+		h := ggxSampleVisible(wo, b.alpha, u[0], u[1])
+		var ok bool
+		if wi, ok = refract(wo, h, b.eta); !ok || wi.Z >= 0 {
+			return BSDFSample{}, false
+		}
+	}
+	if wi.Z == 0 {
+		return BSDFSample{}, false
+	}
+	pdf := b.PDF(wo, wi)
+	if pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: b.Eval(wo, wi), PDF: pdf}, true
+}
+
+// sampleSmooth samples the mirror reflection of a smooth surface, or without mirror
+// its refraction. pSpec and pTrans are how often Sample picks either.
+func (b *principledBSDF) sampleSmooth(wo Vec3, mirror bool, pSpec, pTrans float64) (BSDFSample, bool) {
+	fd := b.dielectricFresnel(wo.Z)
+	if mirror {
+		wi := NewVec3(-wo.X, -wo.Y, wo.Z)
+		f := Lerp(NewVec3(fd, fd, fd), b.metalFresnel(wo.Z), b.metallic)
+		return BSDFSample{Wi: wi, F: f.Scale(1 / wi.Z), PDF: pSpec, Delta: true}, true
+	}
+	wi, ok := refract(wo, NewVec3(0, 0, 1), b.eta)
+	if !ok || pTrans <= 0 {
+		return BSDFSample{}, false
+	}
+	t := (1 - b.metallic) * b.transmission * (1 - fd) / (b.eta * b.eta)
+	return BSDFSample{Wi: wi, F: b.baseColor.Scale(t / -wi.Z), PDF: pTrans, Delta: true}, true
+}
+
+func (b *principledBSDF) PDF(wo, wi Vec3) float64 {
+	if wo.Z <= 0 {
+		return 0
+	}
+	pSpec, pDiff, pSSS, pTrans := b.lobeProbabilities(wo)
+	if b.smooth {
+		if wi.Z < 0 {
+			return pSSS * -wi.Z / math.Pi
+		}
+		return pDiff * wi.Z / math.Pi
+	}
+	if wi.Z < 0 {
+		pdf := pSSS * -wi.Z / math.Pi
+		if pTrans == 0 {
+			return pdf
+		}
+		if _, transPDF, _, ok := microfacetTransmission(wo, wi, b.eta, b.alpha); ok {
+			pdf += pTrans * transPDF
+		}
+		return pdf
+	}
+	_, specPDF, _, ok := microfacetReflection(wo, wi, b.alpha)
+	if !ok {
+		return 0
+	}
+	return pSpec*specPDF + pDiff*wi.Z/math.Pi
+}
@@ -0,0 +1,60 @@
+package tracer
+
+import "math"
+
+// ConductorBSDF is a GGX microfacet metal with complex Fresnel. Alphas below
+// smoothAlpha are treated as a perfect mirror.
+type ConductorBSDF struct {
+	IOR   ConductorIOR
+	Alpha float64
+}
+
+// Below this GGX alpha surfaces are rendered as perfectly smooth delta lobes.
+const smoothAlpha = 1e-3
+
+func NewConductorBSDF(ior ConductorIOR, roughness float64) *ConductorBSDF {
+	return &ConductorBSDF{IOR: ior, Alpha: roughness * roughness}
+}
+
+func (b *ConductorBSDF) smooth() bool {
+	return b.Alpha < smoothAlpha
+}
+
+func (b *ConductorBSDF) Eval(wo, wi Vec3) Vec3 {
+	if b.smooth() {
+		return Vec3{}
+	}
+	f, _, wm, ok := microfacetReflection(wo, wi, b.Alpha)
+	if !ok {
+		return Vec3{}
+	}
+	return b.IOR.Fresnel(wo.Dot(wm)).Scale(f)
+}
+
+func (b *ConductorBSDF) Sample(wo Vec3, uc float64, u [2]float64) (BSDFSample, bool) {
+	if wo.Z <= 0 {
+		return BSDFSample{}, false
+	}
+	if b.smooth() {
+		wi := NewVec3(-wo.X, -wo.Y, wo.Z)
+		return BSDFSample{Wi: wi, F: b.IOR.Fresnel(wo.Z).Scale(1 / wi.Z), PDF: 1, Delta: true}, true
+	}
+	wm := ggxSampleVisible(wo, b.Alpha, u[0], u[1])
+	wi := reflect(wo, wm)
+	f, pdf, _, ok := microfacetReflection(wo, wi, b.Alpha)
+	if !ok || pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: b.IOR.Fresnel(wo.Dot(wm)).Scale(f), PDF: pdf}, true
+}
+
+func (b *ConductorBSDF) PDF(wo, wi Vec3) float64 {
+	if b.smooth() {
+		return 0
+	}
+	_, pdf, _, ok := microfacetReflection(wo, wi, b.Alpha)
+	if !ok {
+		return 0
+	}
+	return math.Max(pdf, 0)
+}
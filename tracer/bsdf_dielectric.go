@@ -0,0 +1,99 @@
+package tracer
+
+// DielectricBSDF is a glass-like interface that reflects and refracts with exact
+// Fresnel weights, smooth or with GGX roughness.
+type DielectricBSDF struct {
+	Eta   float64 // index on the far side over the index on wo's side
+	Alpha float64
+	Tint  Vec3 // filters refracted light
+}
+
+func NewDielectricBSDF(eta, roughness float64) *DielectricBSDF {
+	return &DielectricBSDF{Eta: eta, Alpha: roughness * roughness, Tint: NewVec3(1, 1, 1)}
+}
+
+func (b *DielectricBSDF) smooth() bool {
+	return b.Alpha < smoothAlpha || b.Eta == 1
+}
+
+func (b *DielectricBSDF) Eval(wo, wi Vec3) Vec3 {
+	if b.smooth() {
+		return Vec3{}
+	}
+	if wi.Z > 0 {
+		f, _, wm, ok := microfacetReflection(wo, wi, b.Alpha)
+		if !ok {
+			return Vec3{}
+		}
+		r := fresnelDielectric(wo.Dot(wm), b.Eta) * f
+		return NewVec3(r, r, r)
+	}
+	f, _, wm, ok := microfacetTransmission(wo, wi, b.Eta, b.Alpha)
+	if !ok {
+		return Vec3{}
+	}
+	return b.Tint.Scale((1 - fresnelDielectric(wo.Dot(wm), b.Eta)) * f)
+}
+
+func (b *DielectricBSDF) Sample(wo Vec3, uc float64, u [2]float64) (BSDFSample, bool) {
+	if wo.Z <= 0 {
+		return BSDFSample{}, false
+	}
+	if b.smooth() {
+		return b.sampleSmooth(wo, uc)
+	}
+
+	wm := ggxSampleVisible(wo, b.Alpha, u[0], u[1])
+	r := fresnelDielectric(wo.Dot(wm), b.Eta)
+	if uc < r {
+		wi := reflect(wo, wm)
+		f, pdf, _, ok := microfacetReflection(wo, wi, b.Alpha)
+		if !ok || pdf <= 0 {
+			return BSDFSample{}, false
+		}
+		return BSDFSample{Wi: wi, F: NewVec3(r, r, r).Scale(f), PDF: pdf * r}, true
+	}
+
+	wi, ok := refract(wo, wm, b.Eta)
+	if !ok || wi.Z >= 0 {
+		return BSDFSample{}, false
+	}
+	f, pdf, _, ok := microfacetTransmission(wo, wi, b.Eta, b.Alpha)
+	if !ok || pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: b.Tint.Scale((1 - r) * f), PDF: pdf * (1 - r)}, true
+}
+
+// sampleSmooth picks mirror reflection or refraction in proportion to Fresnel.
+func (b *DielectricBSDF) sampleSmooth(wo Vec3, uc float64) (BSDFSample, bool) {
+	r := fresnelDielectric(wo.Z, b.Eta)
+	if uc < r {
+		wi := NewVec3(-wo.X, -wo.Y, wo.Z)
+		return BSDFSample{Wi: wi, F: NewVec3(r, r, r).Scale(1 / wi.Z), PDF: r, Delta: true}, true
+	}
+	wi, ok := refract(wo, NewVec3(0, 0, 1), b.Eta)
+	if !ok {
+		return BSDFSample{}, false
+	}
+	t := (1 - r) / (b.Eta * b.Eta)
+	return BSDFSample{Wi: wi, F: b.Tint.Scale(t / -wi.Z), PDF: 1 - r, Delta: true}, true
+}
+
+func (b *DielectricBSDF) PDF(wo, wi Vec3) float64 {
+	if b.smooth() {
+		return 0
+	}
+	if wi.Z > 0 {
+		_, pdf, wm, ok := microfacetReflection(wo, wi, b.Alpha)
+		if !ok {
+			return 0
+		}
+		return pdf * fresnelDielectric(wo.Dot(wm), b.Eta)
+	}
+	_, pdf, wm, ok := microfacetTransmission(wo, wi, b.Eta, b.Alpha)
+	if !ok {
+		return 0
+	}
+	return pdf * (1 - fresnelDielectric(wo.Dot(wm), b.Eta))
+}
@@ -0,0 +1,71 @@
+package tracer
+
+import "math"
+
+// LambertianBSDF is an ideal diffuse reflector.
+type LambertianBSDF struct {
+	R Vec3 // albedo
+}
+
+func (b *LambertianBSDF) Eval(wo, wi Vec3) Vec3 {
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return Vec3{}
+	}
+	return b.R.Scale(1 / math.Pi)
+}
+
+func (b *LambertianBSDF) Sample(wo Vec3, uc float64, u [2]float64) (BSDFSample, bool) {
+	wi := cosineHemisphere(u[0], u[1])
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: b.Eval(wo, wi), PDF: wi.Z / math.Pi}, true
+}
+
+func (b *LambertianBSDF) PDF(wo, wi Vec3) float64 {
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return 0
+	}
+	return wi.Z / math.Pi
+}
+
+func (b *LambertianBSDF) evalDiffuse(wo, wi Vec3) Vec3 {
+	return b.Eval(wo, wi)
+}
+
+// subsurfaceExitBSDF is where a subsurface random walk comes back out: an ideal
+// diffuse transmitter from the inside, wo's side, to the outside. Light reflected
+// back in by the surface would only walk on, so it is left out. eta is the relative
+// index from wo's side, below 1, and undoes the radiance the subsurface lobe of
+// principledBSDF raised on the way in.
+type subsurfaceExitBSDF struct {
+	eta float64
+}
+
+func (b *subsurfaceExitBSDF) Eval(wo, wi Vec3) Vec3 {
+	if wo.Z <= 0 || wi.Z >= 0 {
+		return Vec3{}
+	}
+	f := 1 / (math.Pi * b.eta * b.eta)
+	return NewVec3(f, f, f)
+}
+
+func (b *subsurfaceExitBSDF) Sample(wo Vec3, uc float64, u [2]float64) (BSDFSample, bool) {
+	wi := cosineHemisphere(u[0], u[1])
+	wi.Z = -wi.Z
+	if wo.Z <= 0 || wi.Z >= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: b.Eval(wo, wi), PDF: -wi.Z / math.Pi}, true
+}
+
+func (b *subsurfaceExitBSDF) PDF(wo, wi Vec3) float64 {
+	if wo.Z <= 0 || wi.Z >= 0 {
+		return 0
+	}
+	return -wi.Z / math.Pi
+}
+
+func (b *subsurfaceExitBSDF) evalDiffuse(wo, wi Vec3) Vec3 {
+	return b.Eval(wo, wi)
+}
@@ -0,0 +1,309 @@
+package tracer
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+type namedBSDF struct {
+	name string
+	bsdf BSDF
+}
+
+// testBSDFs covers every scattering model with a white (lossless where physically
+// possible) parameterization.
+func testBSDFs() []namedBSDF {
+	white := NewVec3(1, 1, 1)
+	plastic := &Material{BaseColor: white, Roughness: 0.4, IOR: 1.5}
+	metal := &Material{BaseColor: white, Metallic: 1, Roughness: 0.3, IOR: 1.5}
+	glass := &Material{BaseColor: white, Roughness: 0.3, IOR: 1.5, Transmission: 1}
+	skin := &Material{BaseColor: white, Roughness: 0.4, IOR: 1.4, Subsurface: 0.8}
+	return []namedBSDF{
+		{"lambertian", &LambertianBSDF{R: white}},
+		{"conductor/gold/rough", NewConductorBSDF(ConductorPresets["gold"], 0.5)},
+		{"conductor/silver/glossy", NewConductorBSDF(ConductorPresets["silver"], 0.15)},
+		{"dielectric/rough/entering", NewDielectricBSDF(1.5, 0.4)},
+		{"dielectric/rough/exiting", NewDielectricBSDF(1/1.5, 0.4)},
+		{"principled/plastic", plastic.principled(true)},
+		{"principled/metal", metal.principled(true)},
+		{"principled/glass/entering", glass.principled(true)},
+		{"principled/glass/exiting", glass.principled(false)},
+		{"principled/subsurface/entering", skin.principled(true)},
+		{"subsurface/exit", skin.subsurfaceExit()},
+	}
+}
+
+func testDirections() []Vec3 {
+	return []Vec3{
+		NewVec3(0, 0, 1),
+		NewVec3(0.5, 0.1, 0.86).Normalize(),
+		NewVec3(-0.7, 0.3, 0.4).Normalize(),
+		NewVec3(0.95, 0, 0.1).Normalize(),
+	}
+}
+
+// albedo estimates the directional albedo of b at wo by importance sampling. Samples
+// refracted out of a denser medium are scaled by eta^2 to undo the radiance
+// compression, so the estimate is the fraction of energy kept.
+func albedo(b BSDF, wo Vec3, eta float64, n int, rng *rand.Rand) float64 {
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		s, ok := b.Sample(wo, rng.Float64(), [2]float64{rng.Float64(), rng.Float64()})
+		if !ok {
+			continue
+		}
+		w := s.Weight().Luminance()
+		if s.Wi.Z < 0 {
+			w *= eta * eta
+		}
+		sum += w
+	}
+	return sum / float64(n)
+}
+
+func uniformSphere(u1, u2 float64) Vec3 {
+	z := 1 - 2*u1
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	sinPhi, cosPhi := math.Sincos(2 * math.Pi * u2)
+	return NewVec3(r*cosPhi, r*sinPhi, z)
+}
+
+func TestLambertianWhiteFurnace(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	b := &LambertianBSDF{R: NewVec3(1, 1, 1)}
+	for _, wo := range testDirections() {
+		// Cosine sampling makes every sample weigh exactly the albedo
+		if a := albedo(b, wo, 1, 1000, rng); math.Abs(a-1) > 1e-9 {
+			t.Errorf("wo=%v: albedo %v, want 1", wo, a)
+		}
+	}
+}
+
+func TestSmoothDielectricWhiteFurnace(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	for _, eta := range []float64{1.5, 1 / 1.5, 2.4} {
+		b := NewDielectricBSDF(eta, 0)
+		for _, wo := range testDirections() {
+			// Reflection and refraction (or total internal reflection) add up to one
+			if a := albedo(b, wo, eta, 2000, rng); math.Abs(a-1) > 1e-9 {
+				t.Errorf("eta=%v wo=%v: albedo %v, want 1", eta, wo, a)
+			}
+		}
+	}
+}
+
+func TestSmoothConductorReflectance(t *testing.T) {
+	ior := ConductorPresets["aluminium"]
+	b := NewConductorBSDF(ior, 0)
+	wo := NewVec3(0, 0, 1)
+	s, ok := b.Sample(wo, 0.5, [2]float64{0.5, 0.5})
+	if !ok || !s.Delta {
+		t.Fatalf("smooth conductor should return a delta sample, got %+v ok=%v", s, ok)
+	}
+	got, want := s.Weight(), ior.Fresnel(1)
+	if got.Sub(want).Length() > 1e-9 {
+		t.Errorf("weight %v, want Fresnel reflectance %v", got, want)
+	}
+}
+
+// Smooth principled surfaces scatter through delta lobes with exact Fresnel, so
+// white glass keeps all its energy, like DielectricBSDF, and a smooth coat over a
+// diffuse base loses none that the base does not absorb.
+func TestSmoothPrincipledWhiteFurnace(t *testing.T) {
+	rng := rand.New(rand.NewPCG(11, 12))
+	white := NewVec3(1, 1, 1)
+	glass := &Material{BaseColor: white, IOR: 1.5, Transmission: 1}
+	for _, entering := range []bool{true, false} {
+		b := glass.principled(entering)
+		for _, wo := range testDirections() {
+			if a := albedo(b, wo, b.eta, 2000, rng); math.Abs(a-1) > 1e-9 {
+				t.Errorf("glass entering=%v wo=%v: albedo %v, want 1", entering, wo, a)
+			}
+		}
+	}
+
+	plastic := (&Material{BaseColor: white, IOR: 1.5}).principled(true)
+	for _, wo := range testDirections() {
+		deltas := 0
+		for i := 0; i < 2000; i++ {
+			s, ok := plastic.Sample(wo, rng.Float64(), [2]float64{rng.Float64(), rng.Float64()})
+			if !ok || !s.Delta {
+				continue
+			}
+			deltas++
+			if want := NewVec3(-wo.X, -wo.Y, wo.Z); s.Wi.Sub(want).Length() > 1e-12 {
+				t.Fatalf("wo=%v: delta sample %v, want the mirror direction %v", wo, s.Wi, want)
+			}
+			// Eval and PDF only hold the diffuse lobe
+			_, pDiff, _, _ := plastic.lobeProbabilities(wo)
+			if pdf, want := plastic.PDF(wo, s.Wi), pDiff*s.Wi.Z/math.Pi; math.Abs(pdf-want) > 1e-12 {
+				t.Fatalf("wo=%v: PDF() %v, want the diffuse lobe's %v", wo, pdf, want)
+			}
+			if f, want := plastic.Eval(wo, s.Wi), plastic.diffuse(wo, s.Wi); f.Sub(want).Length() > 1e-12 {
+				t.Fatalf("wo=%v: Eval() %v, want the diffuse lobe's %v", wo, f, want)
+			}
+		}
+		if deltas == 0 {
+			t.Errorf("wo=%v: no delta sample", wo)
+		}
+		if a := albedo(plastic, wo, 1, 20000, rng); a > 1.02 || a < 0.5 {
+			t.Errorf("plastic wo=%v: albedo %v", wo, a)
+		}
+	}
+}
+
+// Material.BSDF hands the pure cases to their dedicated models.
+func TestMaterialBSDFModels(t *testing.T) {
+	white := NewVec3(1, 1, 1)
+	for _, tc := range []struct {
+		name     string
+		material Material
+		want     BSDF
+	}{
+		{"gold", Material{Metallic: 1, IOR: 1.5, Conductor: "gold"}, &ConductorBSDF{}},
+		{"glass", Material{BaseColor: white, IOR: 1.5, Transmission: 1}, &DielectricBSDF{}},
+		{"matte", Material{BaseColor: white, Roughness: 1, IOR: 1}, &LambertianBSDF{}},
+		{"plastic", Material{BaseColor: white, Roughness: 0.4, IOR: 1.5}, &principledBSDF{}},
+		{"half metal", Material{BaseColor: white, Metallic: 0.5, IOR: 1.5, Conductor: "gold"}, &principledBSDF{}},
+	} {
+		if got := tc.material.BSDF(true); fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tc.want) {
+			t.Errorf("%s: BSDF is %T, want %T", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestBSDFWhiteFurnace checks that no model creates energy. Single scattering
+// microfacet models lose some energy at high roughness, so only an upper bound holds.
+func TestBSDFWhiteFurnace(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	for _, tc := range testBSDFs() {
+		eta := 1.0
+		switch b := tc.bsdf.(type) {
+		case *DielectricBSDF:
+			eta = b.Eta
+		case *principledBSDF:
+			eta = b.eta
+		case *subsurfaceExitBSDF:
+			eta = b.eta
+		}
+		for _, wo := range testDirections() {
+			a := albedo(tc.bsdf, wo, eta, 50000, rng)
+			if a > 1.02 {
+				t.Errorf("%s wo=%v: albedo %v exceeds 1", tc.name, wo, a)
+			}
+			if a < 0.5 {
+				t.Errorf("%s wo=%v: albedo %v, lost too much energy", tc.name, wo, a)
+			}
+		}
+	}
+}
+
+// TestBSDFSamplingMatchesEval compares the importance sampled albedo with a uniform
+// sphere estimate of the integral of Eval, and the returned F and PDF with Eval() and
+// PDF(). The two estimates must agree within their combined standard error.
+func TestBSDFSamplingMatchesEval(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	const n = 200000
+	for _, tc := range testBSDFs() {
+		for _, wo := range testDirections()[:3] {
+			var sampled, uniform estimate
+			for i := 0; i < n; i++ {
+				s, ok := tc.bsdf.Sample(wo, rng.Float64(), [2]float64{rng.Float64(), rng.Float64()})
+				if !ok {
+					sampled.add(0)
+					continue
+				}
+				sampled.add(s.Weight().Luminance())
+				if pdf := tc.bsdf.PDF(wo, s.Wi); math.Abs(pdf-s.PDF) > 1e-6*math.Max(1, pdf) {
+					t.Fatalf("%s: sample pdf %v, PDF() %v", tc.name, s.PDF, pdf)
+				}
+				if f := tc.bsdf.Eval(wo, s.Wi); f.Sub(s.F).Length() > 1e-6*math.Max(1, f.Length()) {
+					t.Fatalf("%s: sample f %v, Eval() %v", tc.name, s.F, f)
+				}
+			}
+			for i := 0; i < n; i++ {
+				wi := uniformSphere(rng.Float64(), rng.Float64())
+				uniform.add(tc.bsdf.Eval(wo, wi).Luminance() * math.Abs(wi.Z) * 4 * math.Pi)
+			}
+
+			diff := math.Abs(sampled.mean() - uniform.mean())
+			tolerance := 5*math.Hypot(sampled.stdErr(), uniform.stdErr()) + 1e-3
+			if diff > tolerance {
+				t.Errorf("%s wo=%v: sampled albedo %v, uniform estimate %v (tolerance %v)",
+					tc.name, wo, sampled.mean(), uniform.mean(), tolerance)
+			}
+		}
+	}
+}
+
+// estimate accumulates a Monte Carlo mean and its standard error.
+type estimate struct {
+	n         int
+	sum, sum2 float64
+}
+
+func (e *estimate) add(v float64) {
+	e.n++
+	e.sum += v
+	e.sum2 += v * v
+}
+
+func (e *estimate) mean() float64 {
+	return e.sum / float64(e.n)
+}
+
+func (e *estimate) stdErr() float64 {
+	m := e.mean()
+	return math.Sqrt(math.Max(0, e.sum2/float64(e.n)-m*m) / float64(e.n))
+}
+
+// TestBSDFReciprocity checks f(wo, wi) = f(wi, wo) for reflection and the generalized
+// relation f(wo, wi) * eta^2 = f'(wi, wo) for refraction, where f' is the same
+// interface seen from the other side.
+func TestBSDFReciprocity(t *testing.T) {
+	rng := rand.New(rand.NewPCG(9, 10))
+	flip := func(v Vec3) Vec3 { return NewVec3(v.X, v.Y, -v.Z) }
+	white := NewVec3(1, 1, 1)
+
+	reflective := testBSDFs()
+	for _, tc := range reflective {
+		for i := 0; i < 2000; i++ {
+			wo := cosineHemisphere(rng.Float64(), rng.Float64())
+			wi := cosineHemisphere(rng.Float64(), rng.Float64())
+			a, b := tc.bsdf.Eval(wo, wi), tc.bsdf.Eval(wi, wo)
+			if a.Sub(b).Length() > 1e-6*math.Max(1, a.Length()) {
+				t.Fatalf("%s: f(wo,wi)=%v f(wi,wo)=%v", tc.name, a, b)
+			}
+		}
+	}
+
+	glass := &Material{BaseColor: white, Roughness: 0.3, IOR: 1.5, Transmission: 1}
+	pairs := []struct {
+		name        string
+		front, back BSDF
+		eta         float64
+	}{
+		{"dielectric", NewDielectricBSDF(1.5, 0.3), NewDielectricBSDF(1/1.5, 0.3), 1.5},
+		{"principled", glass.principled(true), glass.principled(false), 1.5},
+	}
+	for _, tc := range pairs {
+		checked := 0
+		for i := 0; i < 2000; i++ {
+			wo := cosineHemisphere(rng.Float64(), rng.Float64())
+			wi := flip(cosineHemisphere(rng.Float64(), rng.Float64()))
+			a := tc.front.Eval(wo, wi).Scale(tc.eta * tc.eta)
+			b := tc.back.Eval(flip(wi), flip(wo))
+			if a.Sub(b).Length() > 1e-6*math.Max(1, a.Length()) {
+				t.Fatalf("%s: f(wo,wi)*eta^2=%v f'(wi,wo)=%v", tc.name, a, b)
+			}
+			if !a.IsBlack() {
+				checked++
+			}
+		}
+		if checked == 0 {
+			t.Fatalf("%s: no refraction pair was evaluated", tc.name)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package tracer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	bvhBinCount          = 16
+	bvhMaxLeafSize       = 4
+	bvhTraversalCost     = 1.0
+	bvhIntersectCost     = 1.0
+	bvhParallelThreshold = 4096 // subtrees with at least this many primitives build on their own goroutine
+)
+
+// BVHStats describes the quality and size of a built hierarchy.
+type BVHStats struct {
+	PrimCount int
+	NodeCount int
+	LeafCount int
+	MaxDepth  int
+	SAHCost   float64 // expected cost of a random ray relative to the root bounds
+	BuildTime time.Duration
+}
+
+type bvhNode struct {
+	bounds AABB
+	offset int32 // first primitive for leaves, index of the second child for interior nodes
+	count  int32 // primitives in a leaf, 0 for interior nodes
+	axis   int32 // split axis, used to visit the nearer child first
+}
+
+// BVH is a bounding volume hierarchy built with binned SAH. Built over a mesh it
+// answers ray/triangle queries, built over arbitrary bounds it is used as the
+// top level over objects.
+type BVH struct {
+	Stats BVHStats
+	nodes []bvhNode
+	prims []int32 // primitive ids in leaf order
+	mesh  *Mesh
+}
+
+// TriangleHit is the result of a closest-hit query against a mesh BVH.
+type TriangleHit struct {
+	T    float64
+	U, V float64
+	Prim int
+}
+
+// BuildMeshBVH builds a BVH over the triangles of mesh.
+func BuildMeshBVH(mesh *Mesh) *BVH {
+	bounds := make([]AABB, mesh.TriangleCount())
+	for i := range bounds {
+		bounds[i] = mesh.triangleBounds(i)
+	}
+	bvh := NewBVH(bounds)
+	bvh.mesh = mesh
+	return bvh
+}
+
+// NewBVH builds a hierarchy over primitives given only their bounds.
+func NewBVH(bounds []AABB) *BVH {
+	start := time.Now()
+	b := &bvhBuilder{
+		bounds:    bounds,
+		centroids: make([]Vec3, len(bounds)),
+		prims:     make([]int32, len(bounds)),
+	}
+	for i, pb := range bounds {
+		b.centroids[i] = pb.Centroid()
+		b.prims[i] = int32(i)
+	}
+
+	bvh := &BVH{prims: b.prims}
+	if len(bounds) == 0 {
+		bvh.nodes = []bvhNode{{bounds: EmptyAABB()}}
+		bvh.Stats = BVHStats{NodeCount: 1, LeafCount: 1, BuildTime: time.Since(start)}
+		return bvh
+	}
+
+	root := b.build(0, len(bounds), 0)
+	bvh.nodes = make([]bvhNode, 0, 2*len(bounds))
+	bvh.flatten(root)
+	bvh.Stats = bvh.computeStats(root)
+	bvh.Stats.BuildTime = time.Since(start)
+	return bvh
+}
+
+// Bounds returns the bounds of everything in the hierarchy.
+func (bvh *BVH) Bounds() AABB {
+	return bvh.nodes[0].bounds
+}
+
+// Refit recomputes the node bounds of a mesh BVH after its vertices moved. The tree
+// layout is kept, so quality degrades with large deformations but no rebuild is needed.
+func (bvh *BVH) Refit() {
+	if bvh.mesh == nil {
+		return
+	}
+	// Children always come after their parent, so a reverse sweep is bottom-up
+	for i := len(bvh.nodes) - 1; i >= 0; i-- {
+		node := &bvh.nodes[i]
+		if node.count > 0 {
+			b := EmptyAABB()
+			for _, p := range bvh.prims[node.offset : node.offset+node.count] {
+				b = b.Union(bvh.mesh.triangleBounds(int(p)))
+			}
+			node.bounds = b
+		} else {
+			node.bounds = bvh.nodes[i+1].bounds.Union(bvh.nodes[node.offset].bounds)
+		}
+	}
+}
+
+// ClosestHit returns the nearest triangle hit before tMax. Only valid on mesh BVHs.
+func (bvh *BVH) ClosestHit(r Ray, tMax float64) (TriangleHit, bool) {
+	var hit TriangleHit
+	found := false
+	bvh.traverse(r, tMax, func(prim int32, tMax float64) (float64, bool) {
+		t, u, v, ok := bvh.mesh.intersectTriangle(int(prim), r, tMax)
+		if ok {
+			hit = TriangleHit{T: t, U: u, V: v, Prim: int(prim)}
+			found = true
+			return t, false
+		}
+		return tMax, false
+	})
+	return hit, found
+}
+
+// AnyHit reports whether any triangle is hit before tMax, stopping at the first one.
+func (bvh *BVH) AnyHit(r Ray, tMax float64) bool {
+	found := false
+	bvh.traverse(r, tMax, func(prim int32, tMax float64) (float64, bool) {
+		if _, _, _, ok := bvh.mesh.intersectTriangle(int(prim), r, tMax); ok {
+			found = true
+			return tMax, true
+		}
+		return tMax, false
+	})
+	return found
+}
+
+// traverse walks the nodes the ray overlaps, nearer child first. visit is called for
+// every primitive in an overlapped leaf and returns the new tMax and whether to stop.
+func (bvh *BVH) traverse(r Ray, tMax float64, visit func(prim int32, tMax float64) (float64, bool)) {
+	invDir := inverseDir(r.Dir)
+	negative := [3]bool{invDir.X < 0, invDir.Y < 0, invDir.Z < 0}
+
+	stack := make([]int32, 0, 64)
+	current := int32(0)
+	for {
+		node := &bvh.nodes[current]
+		if node.bounds.hit(r.Origin, invDir, tMax) {
+			if node.count > 0 {
+				for i := node.offset; i < node.offset+node.count; i++ {
+					var stop bool
+					tMax, stop = visit(bvh.prims[i], tMax)
+					if stop {
+						return
+					}
+				}
+			} else {
+				// Visit the child on the side the ray comes from first
+				if negative[node.axis] {
+					stack = append(stack, current+1)
+					current = node.offset
+				} else {
+					stack = append(stack, node.offset)
+					current = current + 1
+				}
+				continue
+			}
+		}
+		if len(stack) == 0 {
+			return
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+	}
+}
+
+func (bvh *BVH) flatten(n *buildNode) int32 {
+	index := int32(len(bvh.nodes))
+	bvh.nodes = append(bvh.nodes, bvhNode{bounds: n.bounds, axis: int32(n.axis)})
+	if n.left == nil {
+		bvh.nodes[index].offset = int32(n.start)
+		bvh.nodes[index].count = int32(n.count)
+		return index
+	}
+	bvh.flatten(n.left)
+	bvh.nodes[index].offset = bvh.flatten(n.right)
+	return index
+}
+
+func (bvh *BVH) computeStats(root *buildNode) BVHStats {
+	stats := BVHStats{PrimCount: len(bvh.prims), NodeCount: len(bvh.nodes)}
+	rootArea := root.bounds.SurfaceArea()
+	var walk func(n *buildNode, depth int)
+	walk = func(n *buildNode, depth int) {
+		stats.MaxDepth = max(stats.MaxDepth, depth)
+		area := 1.0
+		if rootArea > 0 {
+			area = n.bounds.SurfaceArea() / rootArea
+		}
+		if n.left == nil {
+			stats.LeafCount++
+			stats.SAHCost += area * bvhIntersectCost * float64(n.count)
+			return
+		}
+		stats.SAHCost += area * bvhTraversalCost
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(root, 1)
+	return stats
+}
+
+type buildNode struct {
+	bounds      AABB
+	left, right *buildNode
+	start       int
+	count       int
+	axis        int
+}
+
+type bvhBuilder struct {
+	bounds    []AABB
+	centroids []Vec3
+	prims     []int32
+}
+
+type bvhBin struct {
+	bounds AABB
+	count  int
+}
+
+// build creates the subtree for prims[start:end]. Sibling subtrees touch disjoint
+// ranges of prims, so large ones are built concurrently.
+func (b *bvhBuilder) build(start, end, depth int) *buildNode {
+	node := &buildNode{start: start, count: end - start}
+	bounds := EmptyAABB()
+	centroidBounds := EmptyAABB()
+	for _, p := range b.prims[start:end] {
+		bounds = bounds.Union(b.bounds[p])
+		centroidBounds = centroidBounds.Extend(b.centroids[p])
+	}
+	node.bounds = bounds
+
+	count := end - start
+	if count == 1 {
+		return node
+	}
+
+	axis, split, cost := b.findSplit(start, end, bounds, centroidBounds)
+	leafCost := bvhIntersectCost * float64(count)
+
+	var mid int
+	switch {
+	case axis < 0:
+		// All centroids coincide, SAH cannot separate them
+		if count <= bvhMaxLeafSize {
+			return node
+		}
+		axis = 0
+		mid = start + count/2
+	case count <= bvhMaxLeafSize && cost >= leafCost:
+		return node
+	default:
+		mid = b.partition(start, end, axis, split, centroidBounds)
+		if mid == start || mid == end {
+			mid = start + count/2
+		}
+	}
+
+	node.axis = axis
+	if count >= bvhParallelThreshold && depth < 16 {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.left = b.build(start, mid, depth+1)
+		}()
+		node.right = b.build(mid, end, depth+1)
+		wg.Wait()
+	} else {
+		node.left = b.build(start, mid, depth+1)
+		node.right = b.build(mid, end, depth+1)
+	}
+	return node
+}
+
+// findSplit evaluates the SAH for bvhBinCount bins on every axis and returns the best
+// axis, the bin boundary to split after and the cost. axis is -1 when the centroid
+// bounds are degenerate.
+func (b *bvhBuilder) findSplit(start, end int, bounds, centroidBounds AABB) (int, int, float64) {
+	bestAxis, bestSplit := -1, 0
+	bestCost := math.Inf(1)
+	area := bounds.SurfaceArea()
+
+	for axis := 0; axis < 3; axis++ {
+		lo := centroidBounds.Min.Axis(axis)
+		extent := centroidBounds.Max.Axis(axis) - lo
+		if extent <= 0 {
+			continue
+		}
+
+		var bins [bvhBinCount]bvhBin
+		for i := range bins {
+			bins[i].bounds = EmptyAABB()
+		}
+		for _, p := range b.prims[start:end] {
+			i := binIndex(b.centroids[p].Axis(axis), lo, extent)
+			bins[i].count++
+			bins[i].bounds = bins[i].bounds.Union(b.bounds[p])
+		}
+
+		// Sweep from the right to get the area and count right of each split
+		var rightArea [bvhBinCount - 1]float64
+		var rightCount [bvhBinCount - 1]int
+		acc := EmptyAABB()
+		n := 0
+		for i := bvhBinCount - 1; i > 0; i-- {
+			acc = acc.Union(bins[i].bounds)
+			n += bins[i].count
+			rightArea[i-1] = acc.SurfaceArea()
+			rightCount[i-1] = n
+		}
+
+		acc = EmptyAABB()
+		n = 0
+		for i := 0; i < bvhBinCount-1; i++ {
+			acc = acc.Union(bins[i].bounds)
+			n += bins[i].count
+			if n == 0 || rightCount[i] == 0 {
+				continue
+			}
+			cost := bvhTraversalCost
+			if area > 0 {
+				cost += bvhIntersectCost * (acc.SurfaceArea()*float64(n) + rightArea[i]*float64(rightCount[i])) / area
+			}
+			if cost < bestCost {
+				bestAxis, bestSplit, bestCost = axis, i, cost
+			}
+		}
+	}
+	return bestAxis, bestSplit, bestCost
+}
+
+// partition moves the primitives in bins up to split to the front and returns the
+// first index of the right half.
+func (b *bvhBuilder) partition(start, end, axis, split int, centroidBounds AABB) int {
+	lo := centroidBounds.Min.Axis(axis)
+	extent := centroidBounds.Max.Axis(axis) - lo
+	i, j := start, end-1
+	for i <= j {
+		if binIndex(b.centroids[b.prims[i]].Axis(axis), lo, extent) <= split {
+			i++
+		} else {
+			b.prims[i], b.prims[j] = b.prims[j], b.prims[i]
+			j--
+		}
+	}
+	return i
+}
+
+func binIndex(c, lo, extent float64) int {
+	i := int(bvhBinCount * (c - lo) / extent)
+	if i >= bvhBinCount {
+		i = bvhBinCount - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
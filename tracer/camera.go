@@ -0,0 +1,129 @@
+package tracer
+
+import "math"
+
+// Camera is a perspective camera described like raylib's Camera3D, with an optional
+// thin lens for depth of field and an exposure applied to the traced radiance.
+type Camera struct {
+	Position Vec3
+	Target   Vec3
+	Up       Vec3
+	Fovy     float64 // vertical field of view in degrees
+
+	LensRadius    float64 // 0 is a pinhole and keeps everything in focus
+	FocusDistance float64 // distance to the plane in focus, 0 focuses on Target
+	Exposure      float64 // in stops, 0 leaves the radiance unchanged
+}
+
+// exposureScale is the factor applied to radiance arriving at the film.
+func (c *Camera) exposureScale() float64 {
+	return math.Exp2(c.Exposure)
+}
+
+// generateRay maps a film position in pixels (origin top-left) to a world space ray.
+// lens picks the point on the aperture when the camera has a thin lens.
+func (c *Camera) generateRay(px, py float64, width, height int, lens [2]float64) Ray {
+	forward := c.Target.Sub(c.Position).Normalize()
+	right := forward.Cross(c.Up).Normalize()
+	up := right.Cross(forward)
+
+	tanHalf := math.Tan(c.Fovy * math.Pi / 360)
+	aspect := float64(width) / float64(height)
+	sx := (2*px/float64(width) - 1) * tanHalf * aspect
+	sy := (1 - 2*py/float64(height)) * tanHalf
+
+	dir := forward.Add(right.Scale(sx)).Add(up.Scale(sy)).Normalize()
+	if c.LensRadius <= 0 {
+		return NewRay(c.Position, dir)
+	}
+
+	// Every ray through the lens meets the pinhole ray on the focal plane
+	focus := c.FocusDistance
+	if focus <= 0 {
+		focus = c.Target.Sub(c.Position).Length()
+	}
+	focal := c.Position.Add(dir.Scale(focus / dir.Dot(forward)))
+	lx, ly := uniformDisk(lens[0], lens[1])
+	origin := c.Position.Add(right.Scale(lx * c.LensRadius)).Add(up.Scale(ly * c.LensRadius))
+	return NewRay(origin, focal.Sub(origin).Normalize())
+}
+
+// PhysicalCamera describes a camera body and lens the way a DCC or a photographer
+// would. Apply turns it into the field of view, thin lens and exposure of a Camera.
+// Scene units are meters.
+type PhysicalCamera struct {
+	FocalLength   float64 // mm, 0 keeps the camera's field of view
+	SensorHeight  float64 // mm, 0 is full frame
+	FStop         float64 // aperture f-number, 0 is a pinhole exposed like f/16
+	FocusDistance float64 // meters, 0 focuses on the camera target
+	ShutterTime   float64 // seconds
+	ISO           float64
+}
+
+// Exposure reference: the "sunny 16" rule. The sun in these scenes stands for
+// daylight, so f/16, 1/100 s and ISO 100 expose a sunlit white surface at 1.
+const (
+	referenceFStop   = 16
+	referenceShutter = 1.0 / 100
+	referenceISO     = 100
+)
+
+// fullFrameHeight is the height of a full frame sensor in mm.
+const fullFrameHeight = 24
+
+// DefaultPhysicalCamera matches the default 45 degree camera with sunny 16 exposure.
+func DefaultPhysicalCamera() PhysicalCamera {
+	return PhysicalCamera{
+		FocalLength:  FocalLengthFromFovy(45, fullFrameHeight),
+		SensorHeight: fullFrameHeight,
+		FStop:        referenceFStop,
+		ShutterTime:  referenceShutter,
+		ISO:          referenceISO,
+	}
+}
+
+// FocalLengthFromFovy returns the focal length giving a vertical field of view in
+// degrees on a sensor of the given height in mm.
+func FocalLengthFromFovy(fovy, sensorHeight float64) float64 {
+	return sensorHeight / (2 * math.Tan(fovy*math.Pi/360))
+}
+
+// ExposureStops is the exposure relative to the sunny 16 reference. Missing
+// parameters count as the reference value.
+func (p PhysicalCamera) ExposureStops() float64 {
+	n, t, iso := p.FStop, p.ShutterTime, p.ISO
+	if n <= 0 {
+		n = referenceFStop
+	}
+	if t <= 0 {
+		t = referenceShutter
+	}
+	if iso <= 0 {
+		iso = referenceISO
+	}
+	// Film exposure grows with shutter time and sensitivity and falls with the
+	// square of the f-number
+	return math.Log2((t / referenceShutter) * (iso / referenceISO) * (referenceFStop * referenceFStop) / (n * n))
+}
+
+// Apply sets the camera's field of view, lens and exposure.
+func (p PhysicalCamera) Apply(c *Camera) {
+	sensor := p.SensorHeight
+	if sensor <= 0 {
+		sensor = fullFrameHeight
+	}
+	if p.FocalLength > 0 {
+		c.Fovy = 2 * math.Atan(sensor/(2*p.FocalLength)) * 180 / math.Pi
+	}
+	c.LensRadius = 0
+	if p.FStop > 0 {
+		focal := p.FocalLength
+		if focal <= 0 {
+			focal = FocalLengthFromFovy(c.Fovy, sensor)
+		}
+		// Aperture diameter is focal length over f-number, in meters
+		c.LensRadius = focal / 1000 / p.FStop / 2
+	}
+	c.FocusDistance = p.FocusDistance
+	c.Exposure = p.ExposureStops()
+}
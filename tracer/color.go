@@ -0,0 +1,44 @@
+package tracer
+
+import "go-ray-tracing/imageio"
+
+// ConvertColor applies a color matrix, such as imageio.ColorSpace.FromLinearSRGB.
+func ConvertColor(m imageio.Matrix3, c Vec3) Vec3 {
+	r, g, b := m.Apply(c.X, c.Y, c.Z)
+	return NewVec3(r, g, b)
+}
+
+// InSpace returns a copy of the material with its base and emission colors converted
+// from linear sRGB into a working space. Conductor presets keep their sRGB values.
+func (m *Material) InSpace(space imageio.ColorSpace) *Material {
+	to := space.FromLinearSRGB()
+	converted := *m
+	converted.BaseColor = ConvertColor(to, m.BaseColor)
+	converted.Emission = ConvertColor(to, m.Emission)
+	return &converted
+}
+
+// InSpace returns the map converted from linear sRGB into a working space, the map
+// itself when that is linear sRGB.
+func (m *EnvironmentMap) InSpace(space imageio.ColorSpace) *EnvironmentMap {
+	if space == imageio.LinearSRGB {
+		return m
+	}
+	to := space.FromLinearSRGB()
+	rgb := make([]float32, len(m.Pix)*3)
+	for i, c := range m.Pix {
+		c = ConvertColor(to, c)
+		rgb[i*3], rgb[i*3+1], rgb[i*3+2] = float32(c.X), float32(c.Y), float32(c.Z)
+	}
+	converted, _ := NewEnvironmentMap(m.Width, m.Height, rgb)
+	return converted
+}
+
+// InSpace returns a copy of the medium with its emission and blackbody colors in a
+// working space.
+func (m *GridMedium) InSpace(space imageio.ColorSpace) *GridMedium {
+	converted := *m
+	converted.Emission = ConvertColor(space.FromLinearSRGB(), m.Emission)
+	converted.space = space
+	return &converted
+}
@@ -0,0 +1,236 @@
+package tracer
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+// DenoiseOptions tunes Denoise. Smaller sigmas preserve more detail and remove less noise.
+type DenoiseOptions struct {
+	Iterations  int     // à-trous passes, each doubling the filter footprint
+	ColorSigma  float64 // luminance difference, in standard deviations of the noise, that still blends
+	NormalPower float64 // exponent on the cosine between normals, higher keeps creases sharper
+	DepthSigma  float64 // relative depth difference that still blends, per pixel of filter step
+	AlbedoSigma float64 // albedo difference that still blends
+}
+
+func DefaultDenoiseOptions() DenoiseOptions {
+	return DenoiseOptions{
+		Iterations:  5,
+		ColorSigma:  4,
+		NormalPower: 64,
+		DepthSigma:  0.02,
+		AlbedoSigma: 0.1,
+	}
+}
+
+// atrousKernel is the B3 spline the à-trous filter spreads out at every iteration.
+var atrousKernel = [5]float64{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+// Denoise filters a noisy render with an edge-avoiding à-trous wavelet, guided by the
+// albedo, normal and depth AOVs of the same render. Lighting is divided by the albedo
+// before filtering and multiplied back after, so texture and color edges stay sharp.
+// Edges in the lighting itself are kept by comparing luminance against a running
+// estimate of the noise variance. Any of the guides may be nil.
+func Denoise(color, albedo, normal, depth *Image, opts DenoiseOptions) *Image {
+	w, h := color.Width, color.Height
+	n := w * h
+
+	// Demodulate the albedo, the background and black surfaces are filtered as is
+	light := make([]Vec3, n)
+	for i, c := range color.Pix {
+		light[i] = c
+		if albedo != nil {
+			light[i] = divideAlbedo(c, albedo.Pix[i])
+		}
+	}
+
+	f := &atrous{
+		width: w, height: h,
+		opts:   opts,
+		albedo: albedo, normal: normal, depth: depth,
+	}
+	variance := f.spatialVariance(light)
+
+	next := make([]Vec3, n)
+	nextVariance := make([]float64, n)
+	for it := 0; it < opts.Iterations; it++ {
+		f.iterate(light, variance, next, nextVariance, 1<<it)
+		light, next = next, light
+		variance, nextVariance = nextVariance, variance
+	}
+
+	out := NewImage(w, h)
+	for i, c := range light {
+		if albedo != nil {
+			c = multiplyAlbedo(c, albedo.Pix[i])
+		}
+		out.Pix[i] = c
+	}
+	return out
+}
+
+// albedoEpsilon keeps demodulation stable on very dark surfaces.
+const albedoEpsilon = 1e-3
+
+func divideAlbedo(c, a Vec3) Vec3 {
+	div := func(c, a float64) float64 {
+		if a < albedoEpsilon {
+			return c
+		}
+		return c / a
+	}
+	return NewVec3(div(c.X, a.X), div(c.Y, a.Y), div(c.Z, a.Z))
+}
+
+func multiplyAlbedo(c, a Vec3) Vec3 {
+	mul := func(c, a float64) float64 {
+		if a < albedoEpsilon {
+			return c
+		}
+		return c * a
+	}
+	return NewVec3(mul(c.X, a.X), mul(c.Y, a.Y), mul(c.Z, a.Z))
+}
+
+// atrous holds the guides shared by all iterations.
+type atrous struct {
+	width, height         int
+	opts                  DenoiseOptions
+	albedo, normal, depth *Image
+}
+
+// rows runs fn over the image rows on all cores.
+func (f *atrous) rows(fn func(y int)) {
+	workers := runtime.GOMAXPROCS(0)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := w; y < f.height; y += workers {
+				fn(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// spatialVariance estimates each pixel's noise from the luminance of its 3x3 neighbours
+// on the same surface.
+func (f *atrous) spatialVariance(light []Vec3) []float64 {
+	variance := make([]float64, len(light))
+	f.rows(func(y int) {
+		for x := 0; x < f.width; x++ {
+			p := y*f.width + x
+			var sum, sum2, weight float64
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					qx, qy := x+dx, y+dy
+					if qx < 0 || qy < 0 || qx >= f.width || qy >= f.height {
+						continue
+					}
+					q := qy*f.width + qx
+					wq := f.geometryWeight(p, q, 1)
+					l := light[q].Luminance()
+					sum += wq * l
+					sum2 += wq * l * l
+					weight += wq
+				}
+			}
+			mean := sum / weight
+			variance[p] = math.Max(sum2/weight-mean*mean, 0)
+		}
+	})
+	return variance
+}
+
+// iterate runs one à-trous pass with taps step pixels apart.
+func (f *atrous) iterate(light []Vec3, variance []float64, out []Vec3, outVariance []float64, step int) {
+	f.rows(func(y int) {
+		for x := 0; x < f.width; x++ {
+			p := y*f.width + x
+			lp := light[p].Luminance()
+			sigma := f.opts.ColorSigma*math.Sqrt(f.blurredVariance(variance, x, y)) + 1e-6
+
+			var sum Vec3
+			var sumVariance, weight float64
+			for ky := 0; ky < 5; ky++ {
+				qy := y + (ky-2)*step
+				if qy < 0 || qy >= f.height {
+					continue
+				}
+				for kx := 0; kx < 5; kx++ {
+					qx := x + (kx-2)*step
+					if qx < 0 || qx >= f.width {
+						continue
+					}
+					q := qy*f.width + qx
+					wq := atrousKernel[kx] * atrousKernel[ky] * f.geometryWeight(p, q, step)
+					if q != p {
+						wq *= math.Exp(-math.Abs(light[q].Luminance()-lp) / sigma)
+					}
+					sum = sum.Add(light[q].Scale(wq))
+					sumVariance += wq * wq * variance[q]
+					weight += wq
+				}
+			}
+			out[p] = sum.Scale(1 / weight)
+			outVariance[p] = sumVariance / (weight * weight)
+		}
+	})
+}
+
+// blurredVariance is a 3x3 Gaussian of the variance around a pixel, which steadies
+// the edge-stopping on the luminance.
+func (f *atrous) blurredVariance(variance []float64, x, y int) float64 {
+	kernel := [3]float64{0.25, 0.5, 0.25}
+	var sum, weight float64
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			qx, qy := x+dx, y+dy
+			if qx < 0 || qy < 0 || qx >= f.width || qy >= f.height {
+				continue
+			}
+			k := kernel[dx+1] * kernel[dy+1]
+			sum += k * variance[qy*f.width+qx]
+			weight += k
+		}
+	}
+	return sum / weight
+}
+
+// geometryWeight compares the guides of two pixels, 1 when they look like the same
+// surface and towards 0 across edges.
+func (f *atrous) geometryWeight(p, q, step int) float64 {
+	if p == q {
+		return 1
+	}
+	w := 1.0
+	if f.normal != nil {
+		np, nq := f.normal.Pix[p], f.normal.Pix[q]
+		switch {
+		case np.IsBlack() && nq.IsBlack():
+		case np.IsBlack() || nq.IsBlack():
+			return 0
+		default:
+			w *= math.Pow(math.Max(np.Normalize().Dot(nq.Normalize()), 0), f.opts.NormalPower)
+		}
+	}
+	if f.depth != nil {
+		zp, zq := f.depth.Pix[p].X, f.depth.Pix[q].X
+		switch {
+		case math.IsInf(zp, 1) && math.IsInf(zq, 1):
+		case math.IsInf(zp, 1) || math.IsInf(zq, 1):
+			return 0
+		default:
+			w *= math.Exp(-math.Abs(zp-zq) / (f.opts.DepthSigma*float64(step)*math.Max(zp, 1e-3) + 1e-9))
+		}
+	}
+	if f.albedo != nil {
+		d := f.albedo.Pix[p].Sub(f.albedo.Pix[q])
+		w *= math.Exp(-d.Length() / f.opts.AlbedoSigma)
+	}
+	return w
+}
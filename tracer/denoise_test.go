@@ -0,0 +1,106 @@
+package tracer
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// filled returns an image of the given size with every pixel set by f.
+func filled(width, height int, f func(x, y int) Vec3) *Image {
+	img := NewImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, f(x, y))
+		}
+	}
+	return img
+}
+
+// A flat image has nothing to remove: the filter weights sum to one, so whatever
+// the guides say, every pixel must come out as it went in.
+func TestDenoiseConstantImage(t *testing.T) {
+	const w, h = 37, 23
+	rng := rand.New(rand.NewPCG(1, 2))
+	color := NewVec3(0.8, 0.4, 0.1)
+	constant := func(c Vec3) func(x, y int) Vec3 {
+		return func(x, y int) Vec3 { return c }
+	}
+	img := filled(w, h, constant(color))
+	albedo := filled(w, h, constant(NewVec3(0.5, 0.5, 0.5)))
+	normal := filled(w, h, func(x, y int) Vec3 {
+		return uniformSphere(rng.Float64(), rng.Float64())
+	})
+	depth := filled(w, h, func(x, y int) Vec3 {
+		d := 1 + rng.Float64()*10
+		return NewVec3(d, d, d)
+	})
+
+	for _, tc := range []struct {
+		name                  string
+		albedo, normal, depth *Image
+	}{
+		{"no guides", nil, nil, nil},
+		{"albedo", albedo, nil, nil},
+		{"all guides", albedo, normal, depth},
+	} {
+		out := Denoise(img, tc.albedo, tc.normal, tc.depth, DefaultDenoiseOptions())
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if got := out.At(x, y); got.Sub(color).Length() > 1e-9 {
+					t.Fatalf("%s: pixel (%d, %d) is %v, want %v", tc.name, x, y, got, color)
+				}
+			}
+		}
+	}
+}
+
+// Noise on two flat regions split by a sharp edge in the guides: the denoiser must
+// bring the image closer to the clean one without bleeding one side into the other.
+func TestDenoiseGuideEdge(t *testing.T) {
+	const w, h, edge = 48, 32, 24
+	rng := rand.New(rand.NewPCG(3, 4))
+	side := func(left, right Vec3) func(x, y int) Vec3 {
+		return func(x, y int) Vec3 {
+			if x < edge {
+				return left
+			}
+			return right
+		}
+	}
+	albedo := filled(w, h, side(NewVec3(0.9, 0.9, 0.9), NewVec3(0.1, 0.1, 0.1)))
+	normal := filled(w, h, side(NewVec3(0, 0, 1), NewVec3(1, 0, 0)))
+	clean := filled(w, h, func(x, y int) Vec3 {
+		// Smooth lighting across the frame, times the albedo
+		return albedo.At(x, y).Scale(0.5 + 0.5*float64(y)/h)
+	})
+	noisy := filled(w, h, func(x, y int) Vec3 {
+		return clean.At(x, y).Scale(math.Max(1+0.4*rng.NormFloat64(), 0))
+	})
+
+	rmse := func(img *Image) float64 {
+		sum := 0.0
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				sum += img.At(x, y).Sub(clean.At(x, y)).LengthSquared()
+			}
+		}
+		return math.Sqrt(sum / (w * h))
+	}
+	out := Denoise(noisy, albedo, normal, nil, DefaultDenoiseOptions())
+	if before, after := rmse(noisy), rmse(out); after > 0.5*before {
+		t.Errorf("error against the clean image went from %.4f to %.4f", before, after)
+	}
+
+	// The columns on either side of the edge must keep their own brightness
+	for _, x := range []int{edge - 1, edge} {
+		got, want := 0.0, 0.0
+		for y := 0; y < h; y++ {
+			got += out.At(x, y).X
+			want += clean.At(x, y).X
+		}
+		if math.Abs(got-want) > 0.05*want {
+			t.Errorf("column %d averages %.4f, want %.4f", x, got/h, want/h)
+		}
+	}
+}
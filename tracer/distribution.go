@@ -0,0 +1,105 @@
+package tracer
+
+import "sort"
+
+// distribution1D draws indices in proportion to a list of non-negative weights.
+type distribution1D struct {
+	weights []float64
+	cdf     []float64 // len(weights)+1 entries, cdf[0] = 0 and cdf[n] = 1
+	total   float64
+}
+
+func newDistribution1D(weights []float64) *distribution1D {
+	d := &distribution1D{
+		weights: weights,
+		cdf:     make([]float64, len(weights)+1),
+	}
+	for i, w := range weights {
+		d.total += w
+		d.cdf[i+1] = d.total
+	}
+	if d.total == 0 {
+		// Nothing to prefer, fall back to a uniform choice
+		for i := range d.cdf {
+			d.cdf[i] = float64(i) / float64(len(weights))
+		}
+		return d
+	}
+	for i := range d.cdf {
+		d.cdf[i] /= d.total
+	}
+	return d
+}
+
+func (d *distribution1D) len() int {
+	return len(d.weights)
+}
+
+// sample returns the index whose CDF interval contains u, its probability and u
+// remapped to [0, 1) within that interval so it can be reused.
+func (d *distribution1D) sample(u float64) (int, float64, float64) {
+	n := len(d.weights)
+	i := sort.SearchFloat64s(d.cdf[1:], u)
+	for i < n-1 && d.cdf[i+1] <= u {
+		i++
+	}
+	if i >= n {
+		i = n - 1
+	}
+	pmf := d.cdf[i+1] - d.cdf[i]
+	remapped := 0.0
+	if pmf > 0 {
+		remapped = min((u-d.cdf[i])/pmf, 1-1e-16)
+	}
+	return i, pmf, remapped
+}
+
+func (d *distribution1D) pmf(i int) float64 {
+	if i < 0 || i >= len(d.weights) {
+		return 0
+	}
+	return d.cdf[i+1] - d.cdf[i]
+}
+
+// sampleContinuous treats the weights as a piecewise constant function over [0, 1)
+// and returns a point drawn from it, its density and the chosen index.
+func (d *distribution1D) sampleContinuous(u float64) (float64, float64, int) {
+	i, pmf, remapped := d.sample(u)
+	n := float64(len(d.weights))
+	return (float64(i) + remapped) / n, pmf * n, i
+}
+
+// distribution2D draws points of [0, 1)^2 in proportion to a piecewise constant
+// function on a grid: a marginal distribution picks the row, then that row's
+// conditional distribution picks the column.
+type distribution2D struct {
+	width, height int
+	rows          []*distribution1D
+	marginal      *distribution1D
+}
+
+// newDistribution2D builds the distribution of width*height weights stored row by row.
+func newDistribution2D(weights []float64, width, height int) *distribution2D {
+	d := &distribution2D{width: width, height: height, rows: make([]*distribution1D, height)}
+	rowTotals := make([]float64, height)
+	for y := 0; y < height; y++ {
+		d.rows[y] = newDistribution1D(weights[y*width : (y+1)*width])
+		rowTotals[y] = d.rows[y].total
+	}
+	d.marginal = newDistribution1D(rowTotals)
+	return d
+}
+
+// sample returns a point (u, v), v selecting the row, and its density over the unit square.
+func (d *distribution2D) sample(u1, u2 float64) (float64, float64, float64) {
+	v, pdfV, y := d.marginal.sampleContinuous(u2)
+	u, pdfU, _ := d.rows[y].sampleContinuous(u1)
+	return u, v, pdfU * pdfV
+}
+
+// pdf is the density of sample returning (u, v).
+func (d *distribution2D) pdf(u, v float64) float64 {
+	x := min(int(u*float64(d.width)), d.width-1)
+	y := min(int(v*float64(d.height)), d.height-1)
+	return d.marginal.pmf(y) * float64(d.height) * d.rows[y].pmf(x) * float64(d.width)
+}
@@ -0,0 +1,155 @@
+package tracer
+
+import (
+	"fmt"
+	"go-ray-tracing/imageio"
+	"math"
+)
+
+// EnvironmentMap is an equirectangular radiance image with the distribution used to
+// importance sample it. The top row looks up (+Y) and u = 0 looks along +X,
+// increasing towards +Z. It is immutable and can be shared by several lights.
+type EnvironmentMap struct {
+	Width, Height int
+	Pix           []Vec3
+
+	distrib *distribution2D
+	average float64 // solid angle weighted mean luminance
+}
+
+// NewEnvironmentMap builds a map from linear RGB floats (three per pixel, row-major
+// from the top-left), the layout returned by imageio.ReadHDR.
+func NewEnvironmentMap(width, height int, rgb []float32) (*EnvironmentMap, error) {
+	if width <= 0 || height <= 0 || len(rgb) != width*height*3 {
+		return nil, fmt.Errorf("environment map: %d values for %dx%d pixels", len(rgb), width, height)
+	}
+	m := &EnvironmentMap{Width: width, Height: height, Pix: make([]Vec3, width*height)}
+	weights := make([]float64, width*height)
+	sumSin := 0.0
+	for y := 0; y < height; y++ {
+		// Rows near the poles cover less solid angle
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(height))
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			m.Pix[i] = NewVec3(float64(rgb[3*i]), float64(rgb[3*i+1]), float64(rgb[3*i+2]))
+			weights[i] = m.Pix[i].Luminance() * sinTheta
+			m.average += weights[i]
+			sumSin += sinTheta
+		}
+	}
+	m.average /= sumSin
+	m.distrib = newDistribution2D(weights, width, height)
+	return m, nil
+}
+
+// LoadEnvironmentMap reads an equirectangular .hdr, .exr or .pfm image.
+func LoadEnvironmentMap(path string) (*EnvironmentMap, error) {
+	fb, err := imageio.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEnvironmentMap(fb.Width, fb.Height, fb.RGB())
+}
+
+// RGB returns the pixels as interleaved float32 triples, the layout NewEnvironmentMap
+// takes.
+func (m *EnvironmentMap) RGB() []float32 {
+	rgb := make([]float32, len(m.Pix)*3)
+	for i, c := range m.Pix {
+		rgb[i*3] = float32(c.X)
+		rgb[i*3+1] = float32(c.Y)
+		rgb[i*3+2] = float32(c.Z)
+	}
+	return rgb
+}
+
+// lookup returns the pixel seen along a local direction. Nearest neighbour keeps the
+// radiance in step with the piecewise constant sampling density.
+func (m *EnvironmentMap) lookup(dir Vec3) Vec3 {
+	u, v := equirectUV(dir)
+	x := min(int(u*float64(m.Width)), m.Width-1)
+	y := min(int(v*float64(m.Height)), m.Height-1)
+	return m.Pix[y*m.Width+x]
+}
+
+func equirectUV(dir Vec3) (float64, float64) {
+	theta := math.Acos(clamp(dir.Y, -1, 1))
+	phi := math.Atan2(dir.Z, dir.X)
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	return phi / (2 * math.Pi), theta / math.Pi
+}
+
+func equirectDir(u, v float64) (Vec3, float64) {
+	sinTheta, cosTheta := math.Sincos(v * math.Pi)
+	sinPhi, cosPhi := math.Sincos(u * 2 * math.Pi)
+	return NewVec3(sinTheta*cosPhi, cosTheta, sinTheta*sinPhi), sinTheta
+}
+
+// EnvironmentLight surrounds the scene with an environment map, rotated about +Y and
+// scaled by Intensity. It is compared by value to detect changes, so build a new
+// light rather than editing one a scene is rendering with.
+type EnvironmentLight struct {
+	Map       *EnvironmentMap
+	Rotation  float64 // degrees about +Y
+	Intensity float64
+
+	sceneRadius float64 // for Power, set when the scene builds its lights
+}
+
+func NewEnvironmentLight(m *EnvironmentMap) *EnvironmentLight {
+	return &EnvironmentLight{Map: m, Intensity: 1}
+}
+
+// toLocal rotates a world direction into the map's frame.
+func (l *EnvironmentLight) toLocal(dir Vec3) Vec3 {
+	s, c := math.Sincos(-l.Rotation * math.Pi / 180)
+	return NewVec3(c*dir.X+s*dir.Z, dir.Y, -s*dir.X+c*dir.Z)
+}
+
+func (l *EnvironmentLight) toWorld(dir Vec3) Vec3 {
+	s, c := math.Sincos(l.Rotation * math.Pi / 180)
+	return NewVec3(c*dir.X+s*dir.Z, dir.Y, -s*dir.X+c*dir.Z)
+}
+
+// Le is the radiance arriving from the environment along the reverse of dir, the
+// direction of a ray leaving the scene.
+func (l *EnvironmentLight) Le(dir Vec3) Vec3 {
+	return l.Map.lookup(l.toLocal(dir)).Scale(l.Intensity)
+}
+
+func (l *EnvironmentLight) SampleLi(p Vec3, u [2]float64) (LightSample, bool) {
+	mu, mv, mapPDF := l.Map.distrib.sample(u[0], u[1])
+	if mapPDF == 0 {
+		return LightSample{}, false
+	}
+	local, sinTheta := equirectDir(mu, mv)
+	if sinTheta == 0 {
+		return LightSample{}, false
+	}
+	wi := l.toWorld(local)
+	return LightSample{
+		Wi:   wi,
+		Dist: math.Inf(1),
+		L:    l.Map.lookup(local).Scale(l.Intensity),
+		// The map covers 2*pi by pi radians
+		PDF: mapPDF / (2 * math.Pi * math.Pi * sinTheta),
+	}, true
+}
+
+func (l *EnvironmentLight) PDFLi(p, wi Vec3) float64 {
+	local := l.toLocal(wi)
+	u, v := equirectUV(local)
+	sinTheta := math.Sqrt(math.Max(0, 1-local.Y*local.Y))
+	if sinTheta == 0 {
+		return 0
+	}
+	return l.Map.distrib.pdf(u, v) / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+// Power is the flux through a disk of the scene's radius.
+func (l *EnvironmentLight) Power() float64 {
+	r := l.sceneRadius
+	return math.Pi * r * r * math.Pi * l.Map.average * l.Intensity
+}
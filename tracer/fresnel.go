@@ -0,0 +1,64 @@
+package tracer
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fresnelDielectric is the unpolarized reflectance of a smooth dielectric interface.
+// cosI is measured on the incident side and eta is the index on the far side over
+// the index on the incident side. Total internal reflection returns 1.
+func fresnelDielectric(cosI, eta float64) float64 {
+	cosI = clamp(cosI, 0, 1)
+	sin2T := (1 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return 1
+	}
+	cosT := math.Sqrt(1 - sin2T)
+	rParl := (eta*cosI - cosT) / (eta*cosI + cosT)
+	rPerp := (cosI - eta*cosT) / (cosI + eta*cosT)
+	return (rParl*rParl + rPerp*rPerp) / 2
+}
+
+// fresnelComplex is the reflectance of a conductor with complex index eta + ik.
+func fresnelComplex(cosI float64, eta complex128) float64 {
+	cosI = clamp(cosI, 0, 1)
+	ci := complex(cosI, 0)
+	sin2T := complex(1-cosI*cosI, 0) / (eta * eta)
+	cosT := cmplx.Sqrt(1 - sin2T)
+	rParl := (eta*ci - cosT) / (eta*ci + cosT)
+	rPerp := (ci - eta*cosT) / (ci + eta*cosT)
+	return (sqAbs(rParl) + sqAbs(rPerp)) / 2
+}
+
+func sqAbs(c complex128) float64 {
+	return real(c)*real(c) + imag(c)*imag(c)
+}
+
+// ConductorIOR is a complex index of refraction sampled at the red, green and blue
+// primaries (roughly 650, 550 and 450 nm).
+type ConductorIOR struct {
+	Eta Vec3
+	K   Vec3
+}
+
+// Fresnel returns the RGB reflectance at the given incident cosine.
+func (c ConductorIOR) Fresnel(cosI float64) Vec3 {
+	return NewVec3(
+		fresnelComplex(cosI, complex(c.Eta.X, c.K.X)),
+		fresnelComplex(cosI, complex(c.Eta.Y, c.K.Y)),
+		fresnelComplex(cosI, complex(c.Eta.Z, c.K.Z)),
+	)
+}
+
+// ConductorPresets holds measured indices of common metals.
+var ConductorPresets = map[string]ConductorIOR{
+	"gold":      {Eta: NewVec3(0.143, 0.374, 1.442), K: NewVec3(3.983, 2.385, 1.603)},
+	"silver":    {Eta: NewVec3(0.155, 0.117, 0.138), K: NewVec3(4.828, 3.122, 2.147)},
+	"copper":    {Eta: NewVec3(0.200, 0.924, 1.102), K: NewVec3(3.912, 2.452, 2.142)},
+	"aluminium": {Eta: NewVec3(1.657, 0.880, 0.521), K: NewVec3(9.224, 6.270, 4.837)},
+	"iron":      {Eta: NewVec3(2.911, 2.950, 2.585), K: NewVec3(3.089, 2.932, 2.767)},
+	"chromium":  {Eta: NewVec3(3.110, 3.180, 2.300), K: NewVec3(3.330, 3.330, 3.140)},
+	"titanium":  {Eta: NewVec3(2.741, 2.542, 2.267), K: NewVec3(3.814, 3.435, 3.039)},
+	"platinum":  {Eta: NewVec3(2.375, 2.085, 1.845), K: NewVec3(4.265, 3.715, 3.137)},
+}
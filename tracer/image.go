@@ -0,0 +1,42 @@
+package tracer
+
+import "go-ray-tracing/imageio"
+
+// Image holds linear radiance, one Vec3 per pixel in row-major order starting top-left.
+type Image struct {
+	Width  int
+	Height int
+	Pix    []Vec3
+}
+
+func NewImage(width, height int) *Image {
+	return &Image{
+		Width:  width,
+		Height: height,
+		Pix:    make([]Vec3, width*height),
+	}
+}
+
+func (img *Image) At(x, y int) Vec3 {
+	return img.Pix[y*img.Width+x]
+}
+
+func (img *Image) Set(x, y int, c Vec3) {
+	img.Pix[y*img.Width+x] = c
+}
+
+// RGB returns the pixels as interleaved float32 triples for image writers.
+func (img *Image) RGB() []float32 {
+	rgb := make([]float32, len(img.Pix)*3)
+	for i, c := range img.Pix {
+		rgb[i*3] = float32(c.X)
+		rgb[i*3+1] = float32(c.Y)
+		rgb[i*3+2] = float32(c.Z)
+	}
+	return rgb
+}
+
+// Framebuffer returns the pixels as an opaque float32 RGBA framebuffer for imageio.Save.
+func (img *Image) Framebuffer() *imageio.Framebuffer {
+	return imageio.FramebufferFromRGB(img.Width, img.Height, img.RGB())
+}
@@ -0,0 +1,169 @@
+package tracer
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultTileSize = 32
+
+// Tile is a rectangle of the frame, [X0, X1) x [Y0, Y1), rendered as one unit of work.
+type Tile struct {
+	Index  int
+	X0, Y0 int
+	X1, Y1 int
+}
+
+// TileProgress is reported once per finished tile.
+type TileProgress struct {
+	Tile  Tile
+	Image *Image // the tile's pixels are final once reported
+	Done  int    // tiles finished so far, including this one
+	Total int
+}
+
+// RenderJob renders one frame split into tiles over a pool of goroutines. Every
+// sampler is keyed by pixel and sample index, so the image is identical for any
+// worker count or tile size.
+type RenderJob struct {
+	Scene    *Scene
+	Options  Options
+	TileSize int // edge length of a tile in pixels, DefaultTileSize when 0
+	Workers  int // number of goroutines, GOMAXPROCS when 0
+
+	// OnProgress is called after each tile. Calls are serialized, but come from
+	// worker goroutines, so keep it short.
+	OnProgress func(TileProgress)
+	// Progress, when set, receives the same updates as OnProgress and is closed
+	// when Run returns. Sends block, so the receiver must keep draining it.
+	Progress chan<- TileProgress
+
+	// AOVs holds the passes listed in Options.AOVs once Run returns, nil when none
+	// were requested.
+	AOVs *AOVBuffers
+	// Caustics is the photon map the frame was rendered with. Run traces a new one
+	// unless it is handed the map of an earlier job, which it keeps while Commit
+	// finds nothing changed. Progressive renders pass it on so photons are traced
+	// once, not every pass; set it back to nil when the photon options change.
+	Caustics *PhotonMap
+
+	samples    atomic.Int64
+	integrator integrator
+}
+
+func NewRenderJob(scene *Scene, opts Options) *RenderJob {
+	return &RenderJob{
+		Scene:    scene,
+		Options:  opts,
+		TileSize: DefaultTileSize,
+		Workers:  runtime.GOMAXPROCS(0),
+	}
+}
+
+// Tiles returns the tiles of the frame in scanline order.
+func (j *RenderJob) Tiles() []Tile {
+	size := j.TileSize
+	if size <= 0 {
+		size = DefaultTileSize
+	}
+	var tiles []Tile
+	for y := 0; y < j.Options.Height; y += size {
+		for x := 0; x < j.Options.Width; x += size {
+			tiles = append(tiles, Tile{
+				Index: len(tiles),
+				X0:    x,
+				Y0:    y,
+				X1:    min(x+size, j.Options.Width),
+				Y1:    min(y+size, j.Options.Height),
+			})
+		}
+	}
+	return tiles
+}
+
+// Run renders the frame. When ctx is cancelled the workers stop after their current
+// tile and Run returns the partially rendered image with ctx.Err().
+func (j *RenderJob) Run(ctx context.Context) (*Image, error) {
+	if j.Progress != nil {
+		defer close(j.Progress)
+	}
+
+	if j.Scene.Commit() {
+		j.Caustics = nil
+	}
+	if j.Caustics == nil && j.Options.Integrator == IntegratorPath {
+		j.Caustics = buildPhotonMap(j.Scene, &j.Options)
+	}
+	j.integrator = newIntegrator(j.Scene, &j.Options, j.Caustics)
+	img := NewImage(j.Options.Width, j.Options.Height)
+	j.AOVs = nil
+	j.samples.Store(0)
+	if len(j.Options.AOVs) > 0 {
+		j.AOVs = newAOVBuffers(j.Scene, j.Options.Width, j.Options.Height, j.Options.AOVs)
+	}
+	tiles := j.Tiles()
+
+	workers := j.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var next atomic.Int64
+	var progressMutex sync.Mutex
+	done := 0
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= len(tiles) {
+					return
+				}
+				tile := tiles[i]
+				j.renderTile(img, tile)
+
+				progressMutex.Lock()
+				done++
+				p := TileProgress{Tile: tile, Image: img, Done: done, Total: len(tiles)}
+				if j.OnProgress != nil {
+					j.OnProgress(p)
+				}
+				if j.Progress != nil {
+					j.Progress <- p
+				}
+				progressMutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return img, ctx.Err()
+}
+
+// TotalSamples returns the number of camera samples taken so far, fewer than
+// Width*Height*Samples when adaptive sampling stopped pixels early.
+func (j *RenderJob) TotalSamples() int64 {
+	return j.samples.Load()
+}
+
+func (j *RenderJob) renderTile(img *Image, tile Tile) {
+	spp := j.Options.SampleTarget
+	if spp <= 0 {
+		spp = j.Options.Samples
+	}
+	sampler := NewSampler(j.Options.Sampler, spp, j.Options.Seed)
+	samples := 0
+	for y := tile.Y0; y < tile.Y1; y++ {
+		for x := tile.X0; x < tile.X1; x++ {
+			c, n := renderPixel(j.Scene, &j.Options, j.integrator, sampler, x, y, j.AOVs)
+			img.Set(x, y, c)
+			samples += n
+		}
+	}
+	j.samples.Add(int64(samples))
+}
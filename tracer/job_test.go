@@ -0,0 +1,169 @@
+package tracer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// glassBall is a boxScene geometry that casts caustics.
+const glassBall = `{"name": "glass", "primitive": "sphere", "radius": 0.35, "position": [0.3, 0.35, 0.2],
+	"material": {"baseColor": [1, 1, 1], "transmission": 1, "roughness": 0, "ior": 1.5}}`
+
+// boxScene is a small closed diffuse box lit by an area light under the ceiling,
+// with the geometries given as JSON added.
+func boxScene(t *testing.T, geometries ...string) *Scene {
+	const box = `{
+	  "camera": {"position": [0, 1, 3.2], "target": [0, 1, 0], "fovy": 45},
+	  "sky": [0, 0, 0],
+	  "lights": [{"type": "quad", "position": [0, 1.98, 0], "size": [0.6, 0.6], "intensity": 10}],
+	  "geometries": [
+	    {"name": "floor", "primitive": "plane", "size": [2, 0, 2]},
+	    {"name": "ceiling", "primitive": "plane", "size": [2, 0, 2], "position": [0, 2, 0], "rotation": [1, 0, 0, 0]},
+	    {"name": "back", "primitive": "plane", "size": [2, 0, 2], "position": [0, 1, -1], "rotation": [0.70710678, 0, 0, 0.70710678]},
+	    {"name": "left", "primitive": "plane", "size": [2, 0, 2], "position": [-1, 1, 0], "rotation": [0, 0, -0.70710678, 0.70710678],
+	     "material": {"baseColor": [0.7, 0.1, 0.1], "roughness": 0.8}},
+	    {"name": "right", "primitive": "plane", "size": [2, 0, 2], "position": [1, 1, 0], "rotation": [0, 0, 0.70710678, 0.70710678],
+	     "material": {"baseColor": [0.1, 0.7, 0.1], "roughness": 0.8}}
+	  ]
+	}`
+	var sf SceneFile
+	if err := json.Unmarshal([]byte(box), &sf); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range geometries {
+		var geom SceneFileGeometry
+		if err := json.Unmarshal([]byte(g), &geom); err != nil {
+			t.Fatal(err)
+		}
+		sf.Geometries = append(sf.Geometries, geom)
+	}
+	scene, err := sf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scene
+}
+
+// The sampler is keyed by pixel and sample index, so neither the number of workers
+// nor the tile size may change a single pixel.
+func TestRenderJobDeterministic(t *testing.T) {
+	scene := boxScene(t, glassBall)
+	for _, sampler := range []SamplerType{SamplerIndependent, SamplerStratified, SamplerSobol, SamplerBlueNoise} {
+		opts := DefaultOptions()
+		opts.Width, opts.Height, opts.Samples = 40, 30, 8
+		opts.Sampler = sampler
+		opts.Photons = 5000
+		opts.NoiseThreshold, opts.MinSamples = 0.05, 4
+
+		var want *Image
+		for _, layout := range []struct{ workers, tileSize int }{{1, 32}, {4, 7}, {3, 16}} {
+			job := NewRenderJob(scene, opts)
+			job.Workers, job.TileSize = layout.workers, layout.tileSize
+			img, err := job.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want == nil {
+				want = img
+				continue
+			}
+			for y := 0; y < img.Height; y++ {
+				for x := 0; x < img.Width; x++ {
+					if got := img.At(x, y); got != want.At(x, y) {
+						t.Fatalf("%v with %d workers and %d pixel tiles: pixel (%d, %d) is %v, want %v",
+							sampler, layout.workers, layout.tileSize, x, y, got, want.At(x, y))
+					}
+				}
+			}
+		}
+	}
+}
+
+// A job handed the photon map of an earlier one keeps it while the scene is
+// unchanged, and traces a new one once it changes.
+func TestRenderJobReusesCaustics(t *testing.T) {
+	scene := boxScene(t, glassBall)
+	opts := DefaultOptions()
+	opts.Width, opts.Height, opts.Samples = 8, 6, 1
+	opts.Photons = 2000
+
+	run := func(caustics *PhotonMap) *PhotonMap {
+		job := NewRenderJob(scene, opts)
+		job.Caustics = caustics
+		if _, err := job.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return job.Caustics
+	}
+	first := run(nil)
+	if first == nil {
+		t.Fatal("no photon map traced")
+	}
+	if got := run(first); got != first {
+		t.Error("photon map traced again for an unchanged scene")
+	}
+	scene.RemoveObject(scene.Objects[len(scene.Objects)-1])
+	if got := run(first); got == first {
+		t.Error("photon map reused after the scene changed")
+	}
+}
+
+// Progressive passes of one sample that continue each other's sample sequence must
+// add up to the render that takes all the samples at once.
+func TestRenderJobPasses(t *testing.T) {
+	scene := boxScene(t, glassBall)
+	for _, sampler := range []SamplerType{SamplerStratified, SamplerSobol, SamplerBlueNoise} {
+		opts := DefaultOptions()
+		opts.Width, opts.Height, opts.Samples = 12, 9, 8
+		opts.Sampler = sampler
+		want, err := NewRenderJob(scene, opts).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum := NewImage(opts.Width, opts.Height)
+		pass := opts
+		pass.Samples, pass.SampleTarget = 1, opts.Samples
+		for i := range opts.Samples {
+			pass.FirstSample = i
+			img, err := NewRenderJob(scene, pass).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := 0; y < img.Height; y++ {
+				for x := 0; x < img.Width; x++ {
+					sum.Set(x, y, sum.At(x, y).Add(img.At(x, y)))
+				}
+			}
+		}
+		for y := 0; y < want.Height; y++ {
+			for x := 0; x < want.Width; x++ {
+				got := sum.At(x, y).Scale(1 / float64(opts.Samples))
+				if d := got.Sub(want.At(x, y)); d.Length() > 1e-9*(1+want.At(x, y).Length()) {
+					t.Fatalf("%v: pixel (%d, %d) of the passes is %v, want %v", sampler, x, y, got, want.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+// With a single sample there is no noise estimate, the convergence pass must still
+// hold finite values for the EXR.
+func TestConvergenceSingleSample(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Width, opts.Height, opts.Samples = 8, 6, 1
+	opts.AOVs = []AOV{AOVConvergence}
+	job := NewRenderJob(boxScene(t), opts)
+	if _, err := job.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	img := job.AOVs.Layers[AOVConvergence]
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if v := img.At(x, y); v != NewVec3(1, 0, 0) {
+				t.Fatalf("pixel (%d, %d) is %v, want one sample and no error", x, y, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package tracer
+
+import "math"
+
+// DirectionalLight is an infinitely distant light such as the sun.
+type DirectionalLight struct {
+	Direction  Vec3 // direction the light travels in
+	Irradiance Vec3 // irradiance arriving on a surface facing the light
+}
+
+// Light is an emitter that can be sampled for next-event estimation. Area lights
+// emit from their front side only unless they say otherwise.
+type Light interface {
+	// SampleLi picks a point on the light as seen from p.
+	SampleLi(p Vec3, u [2]float64) (LightSample, bool)
+	// PDFLi is the solid angle density of SampleLi choosing the unit direction wi
+	// from p, zero if a ray along wi misses the light.
+	PDFLi(p, wi Vec3) float64
+	// Power is the total emitted flux, used to pick bright lights more often.
+	Power() float64
+}
+
+// LightSample is a direction towards a light picked by Light.SampleLi.
+type LightSample struct {
+	Wi   Vec3    // unit direction from the shading point to the light
+	Dist float64 // distance to the sampled point
+	L    Vec3    // radiance arriving along Wi
+	PDF  float64 // solid angle density
+}
+
+// emitter is a light photons and light subpaths can leave from. sampleEmission
+// picks a point on it uniformly by area and emissionAt describes a point on it
+// found some other way.
+type emitter interface {
+	sampleEmission(u [2]float64) (emission, bool)
+	emissionAt(p Vec3) emission
+}
+
+// emission is a point on an emitter. The light leaves it with radiance L on the side
+// n points to, or on both sides when twoSided is set.
+type emission struct {
+	p, n     Vec3
+	pdf      float64 // area density
+	L        Vec3
+	twoSided bool
+}
+
+// areaLight is a light with its own shape, visible to camera and BSDF rays. Emissive
+// triangles are part of the scene geometry and are found by Scene.Intersect instead.
+type areaLight interface {
+	Light
+	// hit returns the distance to the light along r and the radiance it sends back.
+	hit(r Ray, tMax float64) (float64, Vec3, bool)
+}
+
+// solidAnglePDF converts a density over area to one over solid angle.
+func solidAnglePDF(dist, cosLight, area float64) float64 {
+	if cosLight <= 0 || area <= 0 {
+		return 0
+	}
+	return dist * dist / (cosLight * area)
+}
+
+// QuadLight is a parallelogram light. It emits towards Edge1 x Edge2.
+type QuadLight struct {
+	Corner   Vec3
+	Edge1    Vec3
+	Edge2    Vec3
+	Radiance Vec3
+	TwoSided bool
+}
+
+// NewQuadLight places a width by length rectangle centered on the origin of the
+// transform, facing down (-Y) before the transform is applied.
+func NewQuadLight(transform Mat4, width, length float64, radiance Vec3) *QuadLight {
+	return &QuadLight{
+		Corner:   transform.MulPoint(NewVec3(-width/2, 0, -length/2)),
+		Edge1:    transform.MulDir(NewVec3(width, 0, 0)),
+		Edge2:    transform.MulDir(NewVec3(0, 0, length)),
+		Radiance: radiance,
+	}
+}
+
+func (l *QuadLight) normal() (Vec3, float64) {
+	n := l.Edge1.Cross(l.Edge2)
+	area := n.Length()
+	return n.Scale(1 / area), area
+}
+
+// facing returns the cosine at the light for a direction leaving it towards the
+// viewer, respecting the emitting side.
+func facing(n, toViewer Vec3, twoSided bool) float64 {
+	c := n.Dot(toViewer)
+	if twoSided {
+		return math.Abs(c)
+	}
+	return c
+}
+
+func (l *QuadLight) SampleLi(p Vec3, u [2]float64) (LightSample, bool) {
+	n, area := l.normal()
+	q := l.Corner.Add(l.Edge1.Scale(u[0])).Add(l.Edge2.Scale(u[1]))
+	d := q.Sub(p)
+	dist := d.Length()
+	if dist == 0 {
+		return LightSample{}, false
+	}
+	wi := d.Scale(1 / dist)
+	pdf := solidAnglePDF(dist, facing(n, wi.Neg(), l.TwoSided), area)
+	if pdf == 0 {
+		return LightSample{}, false
+	}
+	return LightSample{Wi: wi, Dist: dist, L: l.Radiance, PDF: pdf}, true
+}
+
+func (l *QuadLight) PDFLi(p, wi Vec3) float64 {
+	t, _, ok := l.hit(Ray{Origin: p, Dir: wi}, math.Inf(1))
+	if !ok {
+		return 0
+	}
+	n, area := l.normal()
+	return solidAnglePDF(t, facing(n, wi.Neg(), l.TwoSided), area)
+}
+
+func (l *QuadLight) Power() float64 {
+	_, area := l.normal()
+	power := l.Radiance.Luminance() * area * math.Pi
+	if l.TwoSided {
+		power *= 2
+	}
+	return power
+}
+
+func (l *QuadLight) bounds() lightBounds {
+	n, _ := l.normal()
+	box := EmptyAABB().Extend(l.Corner).Extend(l.Corner.Add(l.Edge1)).Extend(l.Corner.Add(l.Edge2)).
+		Extend(l.Corner.Add(l.Edge1).Add(l.Edge2))
+	return lightBounds{bounds: box, w: n, phi: l.Power(), cosO: 1, cosE: 0, twoSided: l.TwoSided}
+}
+
+func (l *QuadLight) sampleEmission(u [2]float64) (emission, bool) {
+	_, area := l.normal()
+	return l.emissionAt(l.Corner.Add(l.Edge1.Scale(u[0])).Add(l.Edge2.Scale(u[1]))), area > 0
+}
+
+func (l *QuadLight) emissionAt(p Vec3) emission {
+	n, area := l.normal()
+	return emission{p: p, n: n, pdf: 1 / area, L: l.Radiance, twoSided: l.TwoSided}
+}
+
+func (l *QuadLight) hit(r Ray, tMax float64) (float64, Vec3, bool) {
+	normal := l.Edge1.Cross(l.Edge2)
+	denom := r.Dir.Dot(normal)
+	if denom == 0 {
+		return 0, Vec3{}, false
+	}
+	t := l.Corner.Sub(r.Origin).Dot(normal) / denom
+	if t <= 0 || t >= tMax {
+		return 0, Vec3{}, false
+	}
+	// Coordinates of the hit along both edges, valid for any parallelogram
+	a := r.At(t).Sub(l.Corner)
+	n2 := normal.LengthSquared()
+	u := a.Cross(l.Edge2).Dot(normal) / n2
+	v := l.Edge1.Cross(a).Dot(normal) / n2
+	if u < 0 || u > 1 || v < 0 || v > 1 {
+		return 0, Vec3{}, false
+	}
+	if !l.TwoSided && denom > 0 {
+		return t, Vec3{}, true
+	}
+	return t, l.Radiance, true
+}
+
+// DiskLight is a circular light emitting along Normal.
+type DiskLight struct {
+	Center   Vec3
+	Normal   Vec3 // unit length
+	Radius   float64
+	Radiance Vec3
+	TwoSided bool
+}
+
+// NewDiskLight places a disk at the origin of the transform, facing down (-Y) before
+// the transform is applied. Scaling is ignored, use the radius.
+func NewDiskLight(transform Mat4, radius float64, radiance Vec3) *DiskLight {
+	return &DiskLight{
+		Center:   transform.MulPoint(Vec3{}),
+		Normal:   transform.MulDir(NewVec3(0, -1, 0)).Normalize(),
+		Radius:   radius,
+		Radiance: radiance,
+	}
+}
+
+func (l *DiskLight) area() float64 {
+	return math.Pi * l.Radius * l.Radius
+}
+
+func (l *DiskLight) SampleLi(p Vec3, u [2]float64) (LightSample, bool) {
+	dx, dy := uniformDisk(u[0], u[1])
+	q := l.Center.Add(NewFrame(l.Normal).ToWorld(NewVec3(dx*l.Radius, dy*l.Radius, 0)))
+	d := q.Sub(p)
+	dist := d.Length()
+	if dist == 0 {
+		return LightSample{}, false
+	}
+	wi := d.Scale(1 / dist)
+	pdf := solidAnglePDF(dist, facing(l.Normal, wi.Neg(), l.TwoSided), l.area())
+	if pdf == 0 {
+		return LightSample{}, false
+	}
+	return LightSample{Wi: wi, Dist: dist, L: l.Radiance, PDF: pdf}, true
+}
+
+func (l *DiskLight) PDFLi(p, wi Vec3) float64 {
+	t, _, ok := l.hit(Ray{Origin: p, Dir: wi}, math.Inf(1))
+	if !ok {
+		return 0
+	}
+	return solidAnglePDF(t, facing(l.Normal, wi.Neg(), l.TwoSided), l.area())
+}
+
+func (l *DiskLight) Power() float64 {
+	power := l.Radiance.Luminance() * l.area() * math.Pi
+	if l.TwoSided {
+		power *= 2
+	}
+	return power
+}
+
+func (l *DiskLight) bounds() lightBounds {
+	// A disk reaches out along an axis as far as the axis leans into its plane
+	n := l.Normal
+	extent := NewVec3(
+		math.Sqrt(math.Max(0, 1-n.X*n.X)),
+		math.Sqrt(math.Max(0, 1-n.Y*n.Y)),
+		math.Sqrt(math.Max(0, 1-n.Z*n.Z)),
+	).Scale(l.Radius)
+	box := AABB{Min: l.Center.Sub(extent), Max: l.Center.Add(extent)}
+	return lightBounds{bounds: box, w: n, phi: l.Power(), cosO: 1, cosE: 0, twoSided: l.TwoSided}
+}
+
+func (l *DiskLight) sampleEmission(u [2]float64) (emission, bool) {
+	dx, dy := uniformDisk(u[0], u[1])
+	p := l.Center.Add(NewFrame(l.Normal).ToWorld(NewVec3(dx*l.Radius, dy*l.Radius, 0)))
+	return l.emissionAt(p), l.Radius > 0
+}
+
+func (l *DiskLight) emissionAt(p Vec3) emission {
+	return emission{p: p, n: l.Normal, pdf: 1 / l.area(), L: l.Radiance, twoSided: l.TwoSided}
+}
+
+func (l *DiskLight) hit(r Ray, tMax float64) (float64, Vec3, bool) {
+	denom := r.Dir.Dot(l.Normal)
+	if denom == 0 {
+		return 0, Vec3{}, false
+	}
+	t := l.Center.Sub(r.Origin).Dot(l.Normal) / denom
+	if t <= 0 || t >= tMax || r.At(t).Sub(l.Center).LengthSquared() > l.Radius*l.Radius {
+		return 0, Vec3{}, false
+	}
+	if !l.TwoSided && denom > 0 {
+		return t, Vec3{}, true
+	}
+	return t, l.Radiance, true
+}
+
+// SphereLight is a spherical light emitting outwards. It is sampled by the cone it
+// subtends, so small distant spheres stay noise free.
+type SphereLight struct {
+	Center   Vec3
+	Radius   float64
+	Radiance Vec3
+}
+
+// cone returns the frame towards the sphere's center and the cosine of the cone it
+// subtends from p, or false when p is inside the sphere.
+func (l *SphereLight) cone(p Vec3) (Frame, float64, bool) {
+	d := l.Center.Sub(p)
+	dist2 := d.LengthSquared()
+	r2 := l.Radius * l.Radius
+	if dist2 <= r2 {
+		return Frame{}, 0, false
+	}
+	sin2 := r2 / dist2
+	cosMax := math.Sqrt(1 - sin2)
+	return NewFrame(d.Scale(1 / math.Sqrt(dist2))), cosMax, true
+}
+
+// conePDF is 1/(2*pi*(1-cosMax)); for tiny cones 1-cosMax is computed from sin^2
+// to avoid cancellation.
+func conePDF(cosMax float64) float64 {
+	oneMinus := 1 - cosMax
+	if sin2 := 1 - cosMax*cosMax; sin2 < 1e-4 {
+		oneMinus = sin2 / 2
+	}
+	return 1 / (2 * math.Pi * oneMinus)
+}
+
+func (l *SphereLight) SampleLi(p Vec3, u [2]float64) (LightSample, bool) {
+	frame, cosMax, ok := l.cone(p)
+	if !ok {
+		return LightSample{}, false
+	}
+	wi := frame.ToWorld(uniformCone(u[0], u[1], cosMax)).Normalize()
+	dist, ok := l.intersect(Ray{Origin: p, Dir: wi})
+	if !ok {
+		// Grazing the silhouette, fall back to the tangent distance
+		dist = l.Center.Sub(p).Dot(wi)
+	}
+	return LightSample{Wi: wi, Dist: dist, L: l.Radiance, PDF: conePDF(cosMax)}, true
+}
+
+func (l *SphereLight) PDFLi(p, wi Vec3) float64 {
+	_, cosMax, ok := l.cone(p)
+	if !ok {
+		return 0
+	}
+	if _, hit := l.intersect(Ray{Origin: p, Dir: wi}); !hit {
+		return 0
+	}
+	return conePDF(cosMax)
+}
+
+func (l *SphereLight) Power() float64 {
+	return l.Radiance.Luminance() * 4 * math.Pi * l.Radius * l.Radius * math.Pi
+}
+
+func (l *SphereLight) bounds() lightBounds {
+	r := NewVec3(l.Radius, l.Radius, l.Radius)
+	box := AABB{Min: l.Center.Sub(r), Max: l.Center.Add(r)}
+	// Normals point every way
+	return lightBounds{bounds: box, w: NewVec3(0, 0, 1), phi: l.Power(), cosO: -1, cosE: 0}
+}
+
+func (l *SphereLight) sampleEmission(u [2]float64) (emission, bool) {
+	n := uniformCone(u[0], u[1], -1)
+	return l.emissionAt(l.Center.Add(n.Scale(l.Radius))), l.Radius > 0
+}
+
+func (l *SphereLight) emissionAt(p Vec3) emission {
+	n := p.Sub(l.Center).Normalize()
+	return emission{p: p, n: n, pdf: 1 / (4 * math.Pi * l.Radius * l.Radius), L: l.Radiance}
+}
+
+// intersect returns the nearest positive distance to the sphere along r.
+func (l *SphereLight) intersect(r Ray) (float64, bool) {
+	oc := r.Origin.Sub(l.Center)
+	b := oc.Dot(r.Dir)
+	c := oc.LengthSquared() - l.Radius*l.Radius
+	disc := b*b - c
+	if disc < 0 {
+		return 0, false
+	}
+	sq := math.Sqrt(disc)
+	if t := -b - sq; t > 0 {
+		return t, true
+	}
+	if t := -b + sq; t > 0 {
+		return t, true
+	}
+	return 0, false
+}
+
+func (l *SphereLight) hit(r Ray, tMax float64) (float64, Vec3, bool) {
+	t, ok := l.intersect(r)
+	if !ok || t >= tMax {
+		return 0, Vec3{}, false
+	}
+	if r.At(t).Sub(l.Center).Dot(r.Dir) > 0 {
+		// Seen from inside
+		return t, Vec3{}, true
+	}
+	return t, l.Radiance, true
+}
+
+// triangleLight is one triangle of an emissive object, in world space. Commit builds
+// one per triangle so emissive meshes are sampled like any other area light.
+type triangleLight struct {
+	p0, p1, p2 Vec3
+	normal     Vec3 // front side, same as the hit's geometric normal
+	area       float64
+	radiance   Vec3
+}
+
+func newTriangleLight(obj *Object, prim int, radiance Vec3) *triangleLight {
+	a, b, c := obj.Mesh.triangle(prim)
+	p0, p1, p2 := obj.transform.MulPoint(a), obj.transform.MulPoint(b), obj.transform.MulPoint(c)
+	ng := b.Sub(a).Cross(c.Sub(a))
+	return &triangleLight{
+		p0: p0, p1: p1, p2: p2,
+		normal:   obj.inverse.MulNormal(ng).Normalize(),
+		area:     p1.Sub(p0).Cross(p2.Sub(p0)).Length() / 2,
+		radiance: radiance,
+	}
+}
+
+func (l *triangleLight) SampleLi(p Vec3, u [2]float64) (LightSample, bool) {
+	b0, b1 := uniformTriangle(u[0], u[1])
+	q := l.p0.Scale(b0).Add(l.p1.Scale(b1)).Add(l.p2.Scale(1 - b0 - b1))
+	d := q.Sub(p)
+	dist := d.Length()
+	if dist == 0 {
+		return LightSample{}, false
+	}
+	wi := d.Scale(1 / dist)
+	pdf := solidAnglePDF(dist, -l.normal.Dot(wi), l.area)
+	if pdf == 0 {
+		return LightSample{}, false
+	}
+	return LightSample{Wi: wi, Dist: dist, L: l.radiance, PDF: pdf}, true
+}
+
+func (l *triangleLight) PDFLi(p, wi Vec3) float64 {
+	t, ok := l.intersect(Ray{Origin: p, Dir: wi})
+	if !ok {
+		return 0
+	}
+	return solidAnglePDF(t, -l.normal.Dot(wi), l.area)
+}
+
+func (l *triangleLight) Power() float64 {
+	return l.radiance.Luminance() * l.area * math.Pi
+}
+
+func (l *triangleLight) bounds() lightBounds {
+	box := EmptyAABB().Extend(l.p0).Extend(l.p1).Extend(l.p2)
+	return lightBounds{bounds: box, w: l.normal, phi: l.Power(), cosO: 1, cosE: 0}
+}
+
+func (l *triangleLight) sampleEmission(u [2]float64) (emission, bool) {
+	b0, b1 := uniformTriangle(u[0], u[1])
+	return l.emissionAt(l.p0.Scale(b0).Add(l.p1.Scale(b1)).Add(l.p2.Scale(1 - b0 - b1))), true
+}
+
+func (l *triangleLight) emissionAt(p Vec3) emission {
+	return emission{p: p, n: l.normal, pdf: 1 / l.area, L: l.radiance}
+}
+
+// intersect is the Möller-Trumbore test against the world space triangle.
+func (l *triangleLight) intersect(r Ray) (float64, bool) {
+	e1 := l.p1.Sub(l.p0)
+	e2 := l.p2.Sub(l.p0)
+	pv := r.Dir.Cross(e2)
+	det := e1.Dot(pv)
+	if math.Abs(det) < 1e-12 {
+		return 0, false
+	}
+	invDet := 1 / det
+	tv := r.Origin.Sub(l.p0)
+	u := tv.Dot(pv) * invDet
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+	qv := tv.Cross(e1)
+	v := r.Dir.Dot(qv) * invDet
+	if v < 0 || u+v > 1 {
+		return 0, false
+	}
+	t := e2.Dot(qv) * invDet
+	return t, t > 0
+}
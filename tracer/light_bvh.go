@@ -0,0 +1,341 @@
+package tracer
+
+import "math"
+
+const lightBVHBucketCount = 12
+
+// lightBounds bounds where a group of lights is and where it sends its light: the
+// emitting normals lie within thetaO of w and light leaves each of them at up to
+// thetaE, which is 90 degrees for the area lights here.
+type lightBounds struct {
+	bounds   AABB
+	w        Vec3    // axis of the normal cone
+	phi      float64 // total power
+	cosO     float64 // cosine of thetaO
+	cosE     float64 // cosine of thetaE
+	twoSided bool
+}
+
+// boundedLight is a light with a place in the scene, which the light BVH can group
+// with its neighbours. The environment has none, it is sampled apart from the tree.
+type boundedLight interface {
+	Light
+	bounds() lightBounds
+}
+
+// union returns bounds around both. Lights without power add nothing.
+func (a lightBounds) union(b lightBounds) lightBounds {
+	if a.phi == 0 {
+		return b
+	}
+	if b.phi == 0 {
+		return a
+	}
+	w, cosO := coneUnion(a.w, a.cosO, b.w, b.cosO)
+	return lightBounds{
+		bounds:   a.bounds.Union(b.bounds),
+		w:        w,
+		phi:      a.phi + b.phi,
+		cosO:     cosO,
+		cosE:     math.Min(a.cosE, b.cosE),
+		twoSided: a.twoSided || b.twoSided,
+	}
+}
+
+// coneUnion returns the smallest cone of directions around the cones about wa and wb
+// with the given cosines of their half angles.
+func coneUnion(wa Vec3, cosA float64, wb Vec3, cosB float64) (Vec3, float64) {
+	thetaA, thetaB := safeAcos(cosA), safeAcos(cosB)
+	thetaD := safeAcos(wa.Dot(wb))
+	if math.Min(thetaD+thetaB, math.Pi) <= thetaA {
+		return wa, cosA
+	}
+	if math.Min(thetaD+thetaA, math.Pi) <= thetaB {
+		return wb, cosB
+	}
+	thetaO := (thetaA + thetaD + thetaB) / 2
+	axis := wa.Cross(wb)
+	if thetaO >= math.Pi || axis.LengthSquared() == 0 {
+		return wa, -1
+	}
+	// Turn wa towards wb until the cone reaches around both, Rodrigues' formula
+	k := axis.Normalize()
+	thetaR := thetaO - thetaA
+	sin, cos := math.Sincos(thetaR)
+	w := wa.Scale(cos).Add(k.Cross(wa).Scale(sin)).Add(k.Scale(k.Dot(wa) * (1 - cos)))
+	return w.Normalize(), math.Cos(thetaO)
+}
+
+func safeAcos(x float64) float64 {
+	return math.Acos(clamp(x, -1, 1))
+}
+
+// importance estimates how much light the bounded lights can send to p: their power
+// over the squared distance, times the cosine of the smallest angle between a normal
+// in the cone and a direction from the box to p. It is zero when every light faces
+// away from p.
+func (l *lightBounds) importance(p Vec3) float64 {
+	if l.phi == 0 {
+		return 0
+	}
+	center := l.bounds.Centroid()
+	dist2 := p.Sub(center).LengthSquared()
+	// Near the box distance says little, clamp it so close lights do not blow up
+	d2 := math.Max(dist2, l.bounds.Diagonal().Length()/2)
+	r2 := l.bounds.Diagonal().LengthSquared() / 4
+	if dist2 <= r2 {
+		// p is in the box's bounding sphere, some light may face it head on
+		return l.phi / d2
+	}
+
+	wi := p.Sub(center).Scale(1 / math.Sqrt(dist2))
+	cosW := l.w.Dot(wi)
+	if l.twoSided {
+		cosW = math.Abs(cosW)
+	}
+	sinW := math.Sqrt(math.Max(0, 1-cosW*cosW))
+
+	// The cone of directions from the box's bounding sphere to p
+	cosB := math.Sqrt(1 - r2/dist2)
+	sinB := math.Sqrt(math.Max(0, 1-cosB*cosB))
+	sinO := math.Sqrt(math.Max(0, 1-l.cosO*l.cosO))
+
+	// Angle between w and wi less the normal cone, less the box's cone, at least 0
+	cosX, sinX := cosWithout(sinW, cosW, sinO, l.cosO), sinWithout(sinW, cosW, sinO, l.cosO)
+	cosP := cosWithout(sinX, cosX, sinB, cosB)
+	if cosP <= l.cosE {
+		return 0
+	}
+	return l.phi * cosP / d2
+}
+
+// cosWithout is the cosine of angle a less angle b, 1 when b is the larger.
+func cosWithout(sinA, cosA, sinB, cosB float64) float64 {
+	if cosA > cosB {
+		return 1
+	}
+	return cosA*cosB + sinA*sinB
+}
+
+// sinWithout is the sine of angle a less angle b, 0 when b is the larger.
+func sinWithout(sinA, cosA, sinB, cosB float64) float64 {
+	if cosA > cosB {
+		return 0
+	}
+	return sinA*cosB - cosA*sinB
+}
+
+type lightBVHNode struct {
+	bounds lightBounds
+	offset int32 // the light for leaves, index of the second child for interior nodes
+	parent int32 // -1 at the root
+	leaf   bool
+}
+
+// bvhLightSampler picks lights for next-event estimation by walking down a
+// hierarchy of light bounds, choosing each child by its importance at the shading
+// point. Lights that are far away, faint or facing away are rarely picked, so
+// scenes with many emitters cost about as much noise as scenes with a few near ones.
+// Lights without bounds, the environment, are picked uniformly with the tree as one
+// more choice among them.
+type bvhLightSampler struct {
+	nodes    []lightBVHNode
+	leaves   []int32 // node of each light in the tree, -1 for the others
+	infinite []int   // lights without bounds
+}
+
+func newBVHLightSampler(lights []Light) *bvhLightSampler {
+	s := &bvhLightSampler{leaves: make([]int32, len(lights))}
+	var bounded []int
+	var bounds []lightBounds
+	for i, l := range lights {
+		s.leaves[i] = -1
+		bl, ok := l.(boundedLight)
+		if !ok {
+			s.infinite = append(s.infinite, i)
+			continue
+		}
+		// Lights that send nothing are never picked
+		if b := bl.bounds(); b.phi > 0 {
+			bounded = append(bounded, i)
+			bounds = append(bounds, b)
+		}
+	}
+	if len(bounded) > 0 {
+		s.nodes = make([]lightBVHNode, 0, 2*len(bounded)-1)
+		s.build(bounded, bounds, -1)
+	}
+	return s
+}
+
+// build appends the subtree over the given lights and returns its root. Children
+// follow their parent, the first one right after it.
+func (s *bvhLightSampler) build(lights []int, bounds []lightBounds, parent int32) int32 {
+	index := int32(len(s.nodes))
+	if len(lights) == 1 {
+		s.nodes = append(s.nodes, lightBVHNode{bounds: bounds[0], offset: int32(lights[0]), parent: parent, leaf: true})
+		s.leaves[lights[0]] = index
+		return index
+	}
+
+	var total lightBounds
+	centroids := EmptyAABB()
+	for _, b := range bounds {
+		total = total.union(b)
+		centroids = centroids.Extend(b.bounds.Centroid())
+	}
+	mid := len(lights) / 2
+	if axis, bucket, ok := findLightSplit(bounds, total.bounds, centroids); ok {
+		if m := partitionLights(lights, bounds, axis, bucket, centroids); m > 0 && m < len(lights) {
+			mid = m
+		}
+	}
+
+	s.nodes = append(s.nodes, lightBVHNode{bounds: total, parent: parent})
+	s.build(lights[:mid], bounds[:mid], index)
+	s.nodes[index].offset = s.build(lights[mid:], bounds[mid:], index)
+	return index
+}
+
+// lightBucket is the bucket the centroid coordinate c falls in along an axis.
+func lightBucket(c, lo, extent float64) int {
+	return min(int(lightBVHBucketCount*(c-lo)/extent), lightBVHBucketCount-1)
+}
+
+// findLightSplit picks the axis and bucket to split the lights after, the one with
+// the least cost summed over both sides. ok is false when the centroids coincide.
+func findLightSplit(bounds []lightBounds, box, centroids AABB) (int, int, bool) {
+	bestAxis, bestBucket := -1, 0
+	bestCost := math.Inf(1)
+	for axis := 0; axis < 3; axis++ {
+		lo := centroids.Min.Axis(axis)
+		extent := centroids.Max.Axis(axis) - lo
+		if extent <= 0 {
+			continue
+		}
+		var buckets [lightBVHBucketCount]lightBounds
+		for _, b := range bounds {
+			i := lightBucket(b.bounds.Centroid().Axis(axis), lo, extent)
+			buckets[i] = buckets[i].union(b)
+		}
+		for split := 0; split < lightBVHBucketCount-1; split++ {
+			var below, above lightBounds
+			for i := 0; i <= split; i++ {
+				below = below.union(buckets[i])
+			}
+			for i := split + 1; i < lightBVHBucketCount; i++ {
+				above = above.union(buckets[i])
+			}
+			if cost := splitCost(below, box, axis) + splitCost(above, box, axis); cost < bestCost {
+				bestAxis, bestBucket, bestCost = axis, split, cost
+			}
+		}
+	}
+	return bestAxis, bestBucket, bestAxis >= 0
+}
+
+// splitCost is the surface area orientation heuristic of Conty and Kulla: power
+// times the solid angle the lights can emit into times the area of their box. Long
+// thin boxes split across are penalized, since the children stay as long.
+func splitCost(b lightBounds, box AABB, axis int) float64 {
+	if b.phi == 0 {
+		return 0
+	}
+	thetaO, thetaE := safeAcos(b.cosO), safeAcos(b.cosE)
+	thetaW := math.Min(thetaO+thetaE, math.Pi)
+	sinO := math.Sqrt(math.Max(0, 1-b.cosO*b.cosO))
+	omega := 2*math.Pi*(1-b.cosO) +
+		math.Pi/2*(2*thetaW*sinO-math.Cos(thetaO-2*thetaW)-2*thetaO*sinO+b.cosO)
+	d := box.Diagonal()
+	stretch := 1.0
+	if along := d.Axis(axis); along > 0 {
+		stretch = d.MaxComponent() / along
+	}
+	return b.phi * omega * stretch * b.bounds.SurfaceArea()
+}
+
+// partitionLights moves the lights in buckets up to and including bucket on the
+// axis to the front, keeping bounds in step, and returns how many there are.
+func partitionLights(lights []int, bounds []lightBounds, axis, bucket int, centroids AABB) int {
+	lo := centroids.Min.Axis(axis)
+	extent := centroids.Max.Axis(axis) - lo
+	mid := 0
+	for i := range lights {
+		if lightBucket(bounds[i].bounds.Centroid().Axis(axis), lo, extent) <= bucket {
+			lights[i], lights[mid] = lights[mid], lights[i]
+			bounds[i], bounds[mid] = bounds[mid], bounds[i]
+			mid++
+		}
+	}
+	return mid
+}
+
+// infiniteProbability is the probability of picking among the lights outside the
+// tree. The tree counts as one light.
+func (s *bvhLightSampler) infiniteProbability() float64 {
+	if len(s.nodes) == 0 {
+		return 1
+	}
+	return float64(len(s.infinite)) / float64(len(s.infinite)+1)
+}
+
+func (s *bvhLightSampler) sample(p Vec3, u float64) (int, float64) {
+	pInfinite := s.infiniteProbability()
+	if u < pInfinite {
+		n := len(s.infinite)
+		if n == 0 {
+			return 0, 0
+		}
+		return s.infinite[min(int(u/pInfinite*float64(n)), n-1)], pInfinite / float64(n)
+	}
+	u = math.Min((u-pInfinite)/(1-pInfinite), oneMinusEpsilon)
+
+	pmf := 1 - pInfinite
+	node := int32(0)
+	for !s.nodes[node].leaf {
+		left, right := node+1, s.nodes[node].offset
+		wl, wr := s.nodes[left].bounds.importance(p), s.nodes[right].bounds.importance(p)
+		if wl == 0 && wr == 0 {
+			return 0, 0
+		}
+		// Reuse u for the choices further down
+		if pl := wl / (wl + wr); u < pl {
+			node, u, pmf = left, math.Min(u/pl, oneMinusEpsilon), pmf*pl
+		} else {
+			node, u, pmf = right, math.Min((u-pl)/(1-pl), oneMinusEpsilon), pmf*(1-pl)
+		}
+	}
+	// Interior nodes only lead to lights that may reach p, a lone light is checked
+	if node == 0 && s.nodes[0].bounds.importance(p) == 0 {
+		return 0, 0
+	}
+	return int(s.nodes[node].offset), pmf
+}
+
+func (s *bvhLightSampler) pmf(p Vec3, i int) float64 {
+	node := s.leaves[i]
+	if node < 0 {
+		for _, j := range s.infinite {
+			if j == i {
+				return s.infiniteProbability() / float64(len(s.infinite))
+			}
+		}
+		return 0
+	}
+	if node == 0 && s.nodes[0].bounds.importance(p) == 0 {
+		return 0
+	}
+	// The choices on the way down, from the light up to the root
+	pmf := 1 - s.infiniteProbability()
+	for node != 0 {
+		parent := s.nodes[node].parent
+		left, right := parent+1, s.nodes[parent].offset
+		wl, wr := s.nodes[left].bounds.importance(p), s.nodes[right].bounds.importance(p)
+		if wl+wr == 0 {
+			return 0
+		}
+		pmf *= s.nodes[node].bounds.importance(p) / (wl + wr)
+		node = parent
+	}
+	return pmf
+}
@@ -0,0 +1,139 @@
+package tracer
+
+import "math"
+
+// lightSampler picks which light to sample for next-event estimation at point p.
+type lightSampler interface {
+	// sample returns a light and the probability of picking it, 0 when no light
+	// can reach p.
+	sample(p Vec3, u float64) (int, float64)
+	// pmf is the probability that sample returns light i at p.
+	pmf(p Vec3, i int) float64
+}
+
+// lightKey identifies an emissive triangle.
+type lightKey struct {
+	obj  *Object
+	prim int
+}
+
+// buildLights collects the user lights and one triangle light per emissive triangle.
+// Moving emitters have no single position to sample, BSDF rays still find them.
+func (s *Scene) buildLights() {
+	s.lights = append(s.lights[:0], s.Lights...)
+	s.lightIndex = make(map[lightKey]int)
+	s.lightMaterials = s.lightMaterials[:0]
+	for _, obj := range s.Objects {
+		s.lightMaterials = append(s.lightMaterials, obj.Material)
+		if obj.NullSurface || !obj.Material.IsEmissive() || obj.IsMoving() {
+			continue
+		}
+		radiance := obj.Material.Emitted()
+		for prim := 0; prim < obj.Mesh.TriangleCount(); prim++ {
+			light := newTriangleLight(obj, prim, radiance)
+			if light.area == 0 {
+				continue
+			}
+			s.lightIndex[lightKey{obj, prim}] = len(s.lights)
+			s.lights = append(s.lights, light)
+		}
+	}
+	s.envIndex = -1
+	s.userEnv = nil
+	if s.Environment != nil {
+		env := *s.Environment
+		env.sceneRadius = s.boundingRadius()
+		s.envIndex = len(s.lights)
+		s.lights = append(s.lights, &env)
+		user := *s.Environment
+		s.userEnv = &user
+	}
+	s.userLights = append(s.userLights[:0], s.Lights...)
+	s.lightSampler = newBVHLightSampler(s.lights)
+}
+
+// objectBounds is the box around all objects.
+func (s *Scene) objectBounds() AABB {
+	b := EmptyAABB()
+	for _, obj := range s.Objects {
+		b = b.Union(obj.bounds)
+	}
+	return b
+}
+
+// boundingRadius is the radius of a sphere around all objects.
+func (s *Scene) boundingRadius() float64 {
+	b := s.objectBounds()
+	if b.IsEmpty() {
+		return 1
+	}
+	return math.Max(b.Diagonal().Length()/2, 1e-3)
+}
+
+// lightsStale reports whether user lights or object materials changed since the
+// light list was built. Materials are swapped, not edited, when they change.
+func (s *Scene) lightsStale() bool {
+	if s.lightSampler == nil || len(s.userLights) != len(s.Lights) || len(s.lightMaterials) != len(s.Objects) {
+		return true
+	}
+	if (s.userEnv == nil) != (s.Environment == nil) || (s.userEnv != nil && *s.userEnv != *s.Environment) {
+		return true
+	}
+	for i, l := range s.Lights {
+		if s.userLights[i] != l {
+			return true
+		}
+	}
+	for i, obj := range s.Objects {
+		if s.lightMaterials[i] != obj.Material {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleLight picks a light for next-event estimation at p. Returns nil when the
+// scene has no lights.
+func (s *Scene) sampleLight(p Vec3, u float64) (Light, float64) {
+	if len(s.lights) == 0 {
+		return nil, 0
+	}
+	i, pmf := s.lightSampler.sample(p, u)
+	return s.lights[i], pmf
+}
+
+// lightPDF is the density with which next-event estimation at p would have sampled
+// direction wi towards light i.
+func (s *Scene) lightPDF(i int, p, wi Vec3) float64 {
+	return s.lightSampler.pmf(p, i) * s.lights[i].PDFLi(p, wi)
+}
+
+// emissiveLight returns the light index of a hit emissive triangle.
+func (s *Scene) emissiveLight(hit *Hit) (int, bool) {
+	i, ok := s.lightIndex[lightKey{hit.Object, hit.Prim}]
+	return i, ok
+}
+
+// intersectLights finds the closest area light along r before tMax. Returns its
+// index in the light list, the distance and the radiance it sends back.
+func (s *Scene) intersectLights(r Ray, tMax float64) (int, float64, Vec3, bool) {
+	found := -1
+	var radiance Vec3
+	for i, l := range s.lights[:len(s.userLights)] {
+		if al, ok := l.(areaLight); ok {
+			if t, L, hit := al.hit(r, tMax); hit {
+				found, tMax, radiance = i, t, L
+			}
+		}
+	}
+	return found, tMax, radiance, found >= 0
+}
+
+// escaped returns the radiance arriving along a ray that left the scene in direction
+// dir, and the light index of the environment if one is lit.
+func (s *Scene) escaped(dir Vec3) (Vec3, int) {
+	if s.Environment == nil {
+		return s.Sky, -1
+	}
+	return s.Environment.Le(dir), s.envIndex
+}
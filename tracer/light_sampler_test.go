@@ -0,0 +1,154 @@
+package tracer
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// uniformLightSampler picks every light equally often, the baseline the light BVH is
+// measured against.
+type uniformLightSampler struct {
+	n int
+}
+
+func (s uniformLightSampler) sample(p Vec3, u float64) (int, float64) {
+	return min(int(u*float64(s.n)), s.n-1), 1 / float64(s.n)
+}
+
+func (s uniformLightSampler) pmf(p Vec3, i int) float64 {
+	return 1 / float64(s.n)
+}
+
+// skyLight stands in for the environment, a light without bounds.
+type skyLight struct{}
+
+func (skyLight) SampleLi(p Vec3, u [2]float64) (LightSample, bool) {
+	return LightSample{Wi: uniformSphere(u[0], u[1]), Dist: math.Inf(1), L: NewVec3(0.1, 0.1, 0.1), PDF: 1 / (4 * math.Pi)}, true
+}
+
+func (skyLight) PDFLi(p, wi Vec3) float64 {
+	return 1 / (4 * math.Pi)
+}
+
+func (skyLight) Power() float64 {
+	return 1
+}
+
+// ceilingLights scatters n lamps of different sizes and brightness over a ceiling,
+// most facing down onto the floor at y = 0, some facing up and some round.
+func ceilingLights(n int, rng *rand.Rand) []Light {
+	lights := make([]Light, 0, n)
+	for i := 0; i < n; i++ {
+		pos := NewVec3(rng.Float64()*100-50, 3+rng.Float64()*3, rng.Float64()*100-50)
+		radiance := NewVec3(1, 0.8, 0.6).Scale(1 + rng.Float64()*20)
+		size := 0.2 + rng.Float64()*0.3
+		switch transform := Translate(pos); i % 8 {
+		case 0:
+			lights = append(lights, &SphereLight{Center: pos, Radius: size / 2, Radiance: radiance})
+		case 1:
+			up := transform.Mul(RotateAxisAngle(NewVec3(1, 0, 0), math.Pi))
+			lights = append(lights, NewDiskLight(up, size/2, radiance))
+		default:
+			lights = append(lights, NewQuadLight(transform, size, size, radiance))
+		}
+	}
+	return lights
+}
+
+// The probability sample reports, pmf and how often sample picks each light all
+// have to agree, or next-event estimation weighted by pmf is biased.
+func TestBVHLightSamplerPMF(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	lights := append(ceilingLights(200, rng), skyLight{})
+	s := newBVHLightSampler(lights)
+	for _, p := range []Vec3{NewVec3(0, 0, 0), NewVec3(40, 1, -30), NewVec3(-10, 4.5, 5), NewVec3(0, 20, 0)} {
+		counts := make([]int, len(lights))
+		picked := 0
+		const n = 400000
+		for k := 0; k < n; k++ {
+			i, pmf := s.sample(p, rng.Float64())
+			if pmf == 0 {
+				continue
+			}
+			if want := s.pmf(p, i); math.Abs(pmf-want) > 1e-9*want {
+				t.Fatalf("at %v: sample gives light %d probability %v, pmf says %v", p, i, pmf, want)
+			}
+			counts[i]++
+			picked++
+		}
+		// Samples fail where every light of a subtree faces away from p
+		sum := 0.0
+		for i := range lights {
+			sum += s.pmf(p, i)
+		}
+		if got := float64(picked) / n; math.Abs(sum-got) > 0.005 {
+			t.Errorf("at %v: pmf sums to %v, sample picks a light %v of the time", p, sum, got)
+		}
+		for i, c := range counts {
+			want := s.pmf(p, i)
+			if got := float64(c) / n; math.Abs(got-want) > 4*math.Sqrt(want/n)+1e-4 {
+				t.Errorf("at %v: light %d picked %v of the time, pmf %v", p, i, got, want)
+			}
+		}
+	}
+}
+
+// BenchmarkLightSelection estimates the direct irradiance on the floor below a
+// thousand lamps with one light sample per estimate. Besides the time per estimate
+// it reports the variance relative to the squared mean, which is what the time
+// buys: the light BVH should need far fewer samples for the same noise.
+func BenchmarkLightSelection(b *testing.B) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	lights := ceilingLights(1024, rng)
+	points := make([]Vec3, 64)
+	for i := range points {
+		points[i] = NewVec3(rng.Float64()*100-50, 0, rng.Float64()*100-50)
+	}
+
+	for _, c := range []struct {
+		name    string
+		sampler lightSampler
+	}{
+		{"uniform", uniformLightSampler{len(lights)}},
+		{"bvh", newBVHLightSampler(lights)},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			rng := rand.New(rand.NewPCG(9, 10))
+			sum := make([]float64, len(points))
+			sum2 := make([]float64, len(points))
+			count := make([]int, len(points))
+			b.ResetTimer()
+			for k := 0; k < b.N; k++ {
+				j := k % len(points)
+				p := points[j]
+				count[j]++
+				i, pmf := c.sampler.sample(p, rng.Float64())
+				if pmf == 0 {
+					continue
+				}
+				ls, ok := lights[i].SampleLi(p, [2]float64{rng.Float64(), rng.Float64()})
+				if !ok || ls.PDF == 0 {
+					continue
+				}
+				e := ls.L.Luminance() * math.Max(ls.Wi.Y, 0) / (pmf * ls.PDF)
+				sum[j] += e
+				sum2[j] += e * e
+			}
+			b.StopTimer()
+
+			relVar, n := 0.0, 0
+			for j := range points {
+				if count[j] < 2 || sum[j] == 0 {
+					continue
+				}
+				mean := sum[j] / float64(count[j])
+				relVar += (sum2[j]/float64(count[j]) - mean*mean) / (mean * mean)
+				n++
+			}
+			if n > 0 {
+				b.ReportMetric(relVar/float64(n), "relvar")
+			}
+		})
+	}
+}
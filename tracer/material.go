@@ -0,0 +1,163 @@
+package tracer
+
+import "math"
+
+// Material is the metallic/roughness PBR description shared by the tracer and the
+// raster shader. It follows the glTF 2.0 metallic-roughness model: dielectrics mix a
+// diffuse (or transmissive) base with GGX specular by Fresnel, metals tint the GGX
+// specular with the base color, and Metallic blends between the two.
+type Material struct {
+	BaseColor        Vec3
+	Metallic         float64 // 0 dielectric, 1 metal
+	Roughness        float64 // perceptual roughness, GGX alpha is Roughness^2
+	Emission         Vec3    // emitted color
+	EmissionStrength float64 // multiplier on Emission, radiance = Emission * EmissionStrength
+	IOR              float64 // index of refraction of the dielectric part
+	Transmission     float64 // fraction of the dielectric base that refracts instead of scattering diffusely
+	Conductor        string  // name in ConductorPresets, gives the metal part a measured complex IOR instead of BaseColor
+
+	// Subsurface is the fraction of the diffuse base that enters the surface and
+	// scatters beneath it before coming back out, found by a random walk inside the
+	// mesh, which must be closed. SubsurfaceRadius is the mean free path per color
+	// channel and SubsurfaceScale multiplies it; BaseColor is the color a thick
+	// enough object ends up with.
+	Subsurface       float64
+	SubsurfaceRadius Vec3
+	SubsurfaceScale  float64
+}
+
+// DefaultMaterial matches the flat grey objectColor the raster renderer used before
+// materials were assignable.
+func DefaultMaterial() *Material {
+	return &Material{
+		BaseColor:        NewVec3(0.7, 0.7, 0.7),
+		Metallic:         0,
+		Roughness:        0.5,
+		Emission:         NewVec3(0, 0, 0),
+		EmissionStrength: 0,
+		IOR:              1.5,
+		Transmission:     0,
+		SubsurfaceRadius: NewVec3(1, 0.2, 0.1),
+		SubsurfaceScale:  0.05,
+	}
+}
+
+// Emitted returns the radiance leaving the front side of the surface.
+func (m *Material) Emitted() Vec3 {
+	return m.Emission.Scale(m.EmissionStrength)
+}
+
+func (m *Material) IsEmissive() bool {
+	return m.EmissionStrength > 0 && !m.Emission.IsBlack()
+}
+
+// BSDF returns the scattering function of the material. entering tells whether the
+// ray arrived on the front side, which decides the direction of refraction. Pure
+// metals with a measured index, pure glass and plain diffuse surfaces get their
+// dedicated models, everything in between the principled mix of them.
+func (m *Material) BSDF(entering bool) BSDF {
+	eta := m.IOR
+	if !entering {
+		eta = 1 / m.IOR
+	}
+	metallic := clamp(m.Metallic, 0, 1)
+	switch ior := m.conductorIOR(); {
+	case metallic >= 1 && ior != nil:
+		return NewConductorBSDF(*ior, m.Roughness)
+	case metallic <= 0 && m.Transmission >= 1:
+		b := NewDielectricBSDF(eta, m.Roughness)
+		b.Tint = m.BaseColor
+		return b
+	case metallic <= 0 && m.Transmission <= 0 && m.Subsurface <= 0 && m.IOR == 1:
+		// Without a change of index there is no specular layer
+		return &LambertianBSDF{R: m.BaseColor}
+	}
+	return m.principled(entering)
+}
+
+// principled returns the principledBSDF of the material, whatever BSDF picks.
+func (m *Material) principled(entering bool) *principledBSDF {
+	eta := m.IOR
+	if !entering {
+		eta = 1 / m.IOR
+	}
+	return &principledBSDF{
+		baseColor:    m.BaseColor,
+		metallic:     clamp(m.Metallic, 0, 1),
+		alpha:        roughnessToAlpha(m.Roughness),
+		smooth:       m.Roughness*m.Roughness < smoothAlpha,
+		eta:          eta,
+		transmission: clamp(m.Transmission, 0, 1),
+		subsurface:   clamp(m.Subsurface, 0, 1),
+		conductor:    m.conductorIOR(),
+	}
+}
+
+// causticRoughness is the roughest glass or metal can be for the light it focuses
+// to be left to the photon map.
+const causticRoughness = 0.3
+
+// isSpecular reports whether the material is smooth glass or metal, which casts the
+// caustics the photon map collects.
+func (m *Material) isSpecular() bool {
+	return m.Roughness <= causticRoughness && (m.Metallic >= 1 || m.Transmission >= 1)
+}
+
+// subsurfaceExit is the BSDF light leaves through at the end of a subsurface random
+// walk, in place of the one BSDF(false) returns.
+func (m *Material) subsurfaceExit() BSDF {
+	return &subsurfaceExitBSDF{eta: 1 / m.IOR}
+}
+
+// subsurfaceMedium is the medium the random walk of the subsurface lobe runs in, nil
+// without one. Its single scattering albedo is inverted from BaseColor with van de
+// Hulst's formula, so that the multiple scattering albedo comes out as BaseColor.
+func (m *Material) subsurfaceMedium() *HomogeneousMedium {
+	if m.Subsurface <= 0 || m.Metallic >= 1 || m.Transmission >= 1 {
+		return nil
+	}
+	var sigmaA, sigmaS [3]float64
+	for i := range sigmaA {
+		a := clamp(m.BaseColor.Axis(i), 0, 1)
+		s := 4.09712 + 4.20863*a - math.Sqrt(9.59217+41.6808*a+17.7126*a*a)
+		albedo := clamp(1-s*s, 0, 1)
+		sigmaT := 1 / math.Max(m.SubsurfaceRadius.Axis(i)*m.SubsurfaceScale, 1e-6)
+		sigmaS[i] = albedo * sigmaT
+		sigmaA[i] = sigmaT - sigmaS[i]
+	}
+	return &HomogeneousMedium{
+		SigmaA: NewVec3(sigmaA[0], sigmaA[1], sigmaA[2]),
+		SigmaS: NewVec3(sigmaS[0], sigmaS[1], sigmaS[2]),
+	}
+}
+
+func (m *Material) conductorIOR() *ConductorIOR {
+	if ior, ok := ConductorPresets[m.Conductor]; ok {
+		return &ior
+	}
+	return nil
+}
+
+// MetalColor is the normal incidence reflectance of the metal part, the base color
+// unless a conductor preset is set.
+func (m *Material) MetalColor() Vec3 {
+	if ior := m.conductorIOR(); ior != nil {
+		return ior.Fresnel(1)
+	}
+	return m.BaseColor
+}
+
+// Albedo is the surface color without lighting, blending the base and metal colors.
+func (m *Material) Albedo() Vec3 {
+	return Lerp(m.BaseColor, m.MetalColor(), clamp(m.Metallic, 0, 1))
+}
+
+func clamp(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,117 @@
+package tracer
+
+import "math"
+
+// Mat4 is a row-major affine transform applied to column vectors, matching the
+// element order of raylib's Matrix (M0, M4, M8, M12 is the first row).
+type Mat4 [4][4]float64
+
+func Identity() Mat4 {
+	return Mat4{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+func Translate(v Vec3) Mat4 {
+	m := Identity()
+	m[0][3] = v.X
+	m[1][3] = v.Y
+	m[2][3] = v.Z
+	return m
+}
+
+func ScaleMatrix(v Vec3) Mat4 {
+	m := Identity()
+	m[0][0] = v.X
+	m[1][1] = v.Y
+	m[2][2] = v.Z
+	return m
+}
+
+// RotateAxisAngle builds a rotation of angle radians around axis.
+func RotateAxisAngle(axis Vec3, angle float64) Mat4 {
+	a := axis.Normalize()
+	s, c := math.Sincos(angle)
+	t := 1 - c
+	return Mat4{
+		{a.X*a.X*t + c, a.X*a.Y*t - a.Z*s, a.X*a.Z*t + a.Y*s, 0},
+		{a.Y*a.X*t + a.Z*s, a.Y*a.Y*t + c, a.Y*a.Z*t - a.X*s, 0},
+		{a.Z*a.X*t - a.Y*s, a.Z*a.Y*t + a.X*s, a.Z*a.Z*t + c, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Mul returns m*o, i.e. o is applied first.
+func (m Mat4) Mul(o Mat4) Mat4 {
+	var r Mat4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			r[i][j] = m[i][0]*o[0][j] + m[i][1]*o[1][j] + m[i][2]*o[2][j] + m[i][3]*o[3][j]
+		}
+	}
+	return r
+}
+
+func (m Mat4) MulPoint(p Vec3) Vec3 {
+	return Vec3{
+		m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z + m[0][3],
+		m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z + m[1][3],
+		m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z + m[2][3],
+	}
+}
+
+func (m Mat4) MulDir(d Vec3) Vec3 {
+	return Vec3{
+		m[0][0]*d.X + m[0][1]*d.Y + m[0][2]*d.Z,
+		m[1][0]*d.X + m[1][1]*d.Y + m[1][2]*d.Z,
+		m[2][0]*d.X + m[2][1]*d.Y + m[2][2]*d.Z,
+	}
+}
+
+// MulNormal transforms a normal with the transpose of m, so m must be the
+// inverse of the object-to-world transform.
+func (m Mat4) MulNormal(n Vec3) Vec3 {
+	return Vec3{
+		m[0][0]*n.X + m[1][0]*n.Y + m[2][0]*n.Z,
+		m[0][1]*n.X + m[1][1]*n.Y + m[2][1]*n.Z,
+		m[0][2]*n.X + m[1][2]*n.Y + m[2][2]*n.Z,
+	}
+}
+
+// Inverse inverts an affine transform. Singular matrices (zero scale) return the identity.
+func (m Mat4) Inverse() Mat4 {
+	a, b, c := m[0][0], m[0][1], m[0][2]
+	d, e, f := m[1][0], m[1][1], m[1][2]
+	g, h, i := m[2][0], m[2][1], m[2][2]
+
+	co00 := e*i - f*h
+	co01 := f*g - d*i
+	co02 := d*h - e*g
+	det := a*co00 + b*co01 + c*co02
+	if det == 0 {
+		return Identity()
+	}
+	inv := 1 / det
+
+	var r Mat4
+	r[0][0] = co00 * inv
+	r[0][1] = (c*h - b*i) * inv
+	r[0][2] = (b*f - c*e) * inv
+	r[1][0] = co01 * inv
+	r[1][1] = (a*i - c*g) * inv
+	r[1][2] = (c*d - a*f) * inv
+	r[2][0] = co02 * inv
+	r[2][1] = (b*g - a*h) * inv
+	r[2][2] = (a*e - b*d) * inv
+
+	t := Vec3{m[0][3], m[1][3], m[2][3]}
+	rt := r.MulDir(t)
+	r[0][3] = -rt.X
+	r[1][3] = -rt.Y
+	r[2][3] = -rt.Z
+	r[3][3] = 1
+	return r
+}
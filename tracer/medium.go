@@ -0,0 +1,306 @@
+package tracer
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Medium is a participating medium: a volume that absorbs and scatters light passing
+// through it. Media are rendered with delta tracking, which samples tentative
+// collisions against a majorant, an upper bound of the extinction coefficient, and
+// turns the excess into null collisions; shadow rays use ratio tracking over the
+// same collisions.
+type Medium interface {
+	// Phase is the medium's phase function.
+	Phase() HenyeyGreenstein
+	// majorants walks r from t0 to t1 in consecutive segments, calling visit with
+	// each and the majorant over it until visit returns false. r is in the medium's
+	// space with its direction not renormalized, so t and the coefficients stay in
+	// world units.
+	majorants(r Ray, t0, t1 float64, visit func(s0, s1, sigmaMaj float64) bool)
+	// at returns the coefficients at p, a point in the medium's space.
+	at(p Vec3) mediumPoint
+}
+
+// mediumPoint holds the coefficients of a medium at one point.
+type mediumPoint struct {
+	sigmaA   Vec3 // absorption
+	sigmaS   Vec3 // scattering
+	emission Vec3 // radiance emitted where light is absorbed
+}
+
+// HomogeneousMedium has the same coefficients everywhere, like fog or tinted glass.
+type HomogeneousMedium struct {
+	SigmaA Vec3    // absorption coefficient per unit length
+	SigmaS Vec3    // scattering coefficient per unit length
+	G      float64 // Henyey-Greenstein asymmetry
+}
+
+func (m *HomogeneousMedium) Phase() HenyeyGreenstein {
+	return HenyeyGreenstein{G: m.G}
+}
+
+func (m *HomogeneousMedium) majorants(r Ray, t0, t1 float64, visit func(s0, s1, sigmaMaj float64) bool) {
+	visit(t0, t1, m.SigmaA.Add(m.SigmaS).MaxComponent())
+}
+
+func (m *HomogeneousMedium) at(p Vec3) mediumPoint {
+	return mediumPoint{sigmaA: m.SigmaA, sigmaS: m.SigmaS}
+}
+
+// trackingRNG drives delta and ratio tracking, which take a different number of
+// steps on every path. It is seeded from a single sampler value so the sampler's
+// dimensions stay aligned between paths.
+type trackingRNG struct {
+	pcg rand.PCG
+}
+
+func newTrackingRNG(u float64) trackingRNG {
+	var rng trackingRNG
+	rng.pcg.Seed(math.Float64bits(u), 0x9e3779b97f4a7c15)
+	return rng
+}
+
+func (rng *trackingRNG) float() float64 {
+	return float64(rng.pcg.Uint64()>>11) * 0x1p-53
+}
+
+// mediumEvent is where delta tracking stopped along a ray.
+type mediumEvent struct {
+	t         float64 // distance to the scattering point
+	scattered bool
+	absorbed  bool
+	weight    Vec3 // throughput factor of the tracked segment
+	emitted   Vec3 // emission gathered on the way, already weighted
+}
+
+// trackMedium runs delta tracking along r from t0 to t1. At every tentative
+// collision it picks absorption, scattering or a null collision in proportion to the
+// channel averaged coefficients; weight corrects the colored ones.
+func trackMedium(m Medium, r Ray, t0, t1 float64, rng *trackingRNG) mediumEvent {
+	ev := mediumEvent{weight: NewVec3(1, 1, 1)}
+	m.majorants(r, t0, t1, func(s0, s1, sigmaMaj float64) bool {
+		if sigmaMaj <= 0 {
+			return true
+		}
+		t := s0
+		for {
+			t -= math.Log(1-rng.float()) / sigmaMaj
+			if t >= s1 {
+				return true
+			}
+			mp := m.at(r.At(t))
+			ev.emitted = ev.emitted.Add(ev.weight.Mul(mp.sigmaA).Mul(mp.emission).Scale(1 / sigmaMaj))
+
+			sigmaN := NewVec3(sigmaMaj, sigmaMaj, sigmaMaj).Sub(mp.sigmaA).Sub(mp.sigmaS).Max(Vec3{})
+			pA, pS, pN := channelMean(mp.sigmaA), channelMean(mp.sigmaS), channelMean(sigmaN)
+			total := pA + pS + pN
+			if total <= 0 {
+				continue
+			}
+			u := rng.float() * total
+			switch {
+			case u < pA:
+				ev.absorbed = true
+				return false
+			case u < pA+pS:
+				ev.weight = ev.weight.Mul(mp.sigmaS).Scale(total / (sigmaMaj * pS))
+				ev.t, ev.scattered = t, true
+				return false
+			}
+			ev.weight = ev.weight.Mul(sigmaN).Scale(total / (sigmaMaj * pN))
+			if ev.weight.IsBlack() {
+				ev.absorbed = true
+				return false
+			}
+		}
+	})
+	return ev
+}
+
+// ratioTrack estimates the transmittance of m along r from t0 to t1: every
+// tentative collision keeps the null fraction of the majorant.
+func ratioTrack(m Medium, r Ray, t0, t1 float64, rng *trackingRNG) Vec3 {
+	T := NewVec3(1, 1, 1)
+	m.majorants(r, t0, t1, func(s0, s1, sigmaMaj float64) bool {
+		if sigmaMaj <= 0 {
+			return true
+		}
+		t := s0
+		for {
+			t -= math.Log(1-rng.float()) / sigmaMaj
+			if t >= s1 {
+				return true
+			}
+			mp := m.at(r.At(t))
+			sigmaT := mp.sigmaA.Add(mp.sigmaS)
+			T = T.Mul(NewVec3(sigmaMaj, sigmaMaj, sigmaMaj).Sub(sigmaT).Max(Vec3{})).Scale(1 / sigmaMaj)
+			// Stop tracking paths that carry next to nothing, keeping the estimate unbiased
+			if q := T.MaxComponent(); q < 0.05 {
+				if q == 0 || rng.float() > q {
+					T = Vec3{}
+					return false
+				}
+				T = T.Scale(1 / q)
+			}
+		}
+	})
+	return T
+}
+
+func channelMean(v Vec3) float64 {
+	return (v.X + v.Y + v.Z) / 3
+}
+
+// maxNullCrossings bounds how many null surfaces a single ray segment may cross, so
+// a ray caught between coincident boundaries cannot loop forever.
+const maxNullCrossings = 64
+
+// mediumRef is the medium a ray travels in. An object's interior lives in the
+// object's space, so it moves with the object; obj is nil for the scene's medium,
+// which lives in world space. walk marks the medium below a subsurface material.
+type mediumRef struct {
+	Medium
+	obj  *Object
+	walk bool
+}
+
+// mediumSegment returns r in the space of medium m and the part of it before tMax
+// where m can be. An object's interior is bounded by its mesh already; the scene's
+// medium stops at its bounds.
+func (s *Scene) mediumSegment(m mediumRef, r Ray, tMax float64) (Ray, float64, float64, bool) {
+	if m.obj != nil {
+		_, inverse := m.obj.transformAt(r.Time)
+		return Ray{Origin: inverse.MulPoint(r.Origin), Dir: inverse.MulDir(r.Dir), Time: r.Time}, 0, tMax, true
+	}
+	t0, t1, ok := s.mediumBounds.clip(r, tMax)
+	return r, t0, t1, ok
+}
+
+// sampleMedium runs delta tracking for a ray travelling in m up to tMax.
+func (s *Scene) sampleMedium(m mediumRef, r Ray, tMax float64, sampler Sampler) mediumEvent {
+	rng := newTrackingRNG(sampler.Get1D())
+	local, t0, t1, ok := s.mediumSegment(m, r, tMax)
+	if !ok {
+		return mediumEvent{weight: NewVec3(1, 1, 1)}
+	}
+	return trackMedium(m.Medium, local, t0, t1, &rng)
+}
+
+// transmittance is the fraction of light leaving the point at tMax along r that
+// arrives at its origin, r travelling in medium (no Medium for vacuum). Null
+// surfaces are crossed, any other surface blocks the light.
+func (s *Scene) transmittance(r Ray, tMax float64, medium mediumRef, sampler Sampler) Vec3 {
+	if medium.Medium == nil && !s.hasMedia {
+		if s.Occluded(r, tMax) {
+			return Vec3{}
+		}
+		return NewVec3(1, 1, 1)
+	}
+	rng := newTrackingRNG(sampler.Get1D())
+	T := NewVec3(1, 1, 1)
+	for crossings := 0; ; crossings++ {
+		hit, ok := s.Intersect(r)
+		end := tMax
+		if ok && hit.T < tMax {
+			if !hit.Object.NullSurface {
+				return Vec3{}
+			}
+			end = hit.T
+		}
+		if medium.Medium != nil {
+			if local, t0, t1, inside := s.mediumSegment(medium, r, end); inside {
+				T = T.Mul(ratioTrack(medium.Medium, local, t0, t1, &rng))
+			}
+			if T.IsBlack() {
+				return T
+			}
+		}
+		if !ok || hit.T >= tMax || crossings >= maxNullCrossings {
+			return T
+		}
+		medium = s.mediumBeyond(&hit, r.Dir, medium)
+		r = spawnRay(hit.Point, hit.GeoNormal, r.Dir, r.Time)
+		tMax -= hit.T
+	}
+}
+
+// sceneMedium is the medium outside every object.
+func (s *Scene) sceneMedium() mediumRef {
+	return mediumRef{Medium: s.Medium}
+}
+
+// mediumBeyond returns the medium on the far side of a surface that a ray in medium
+// cur crosses along dir. Geometric normals of closed meshes point outwards. Media do
+// not nest: leaving an interior goes back to the scene's medium. An object's
+// Interior takes precedence over the subsurface medium of its material.
+func (s *Scene) mediumBeyond(hit *Hit, dir Vec3, cur mediumRef) mediumRef {
+	inside := mediumRef{Medium: hit.Object.Interior, obj: hit.Object}
+	if inside.Medium == nil {
+		if hit.Object.subsurface == nil {
+			return cur
+		}
+		inside.Medium, inside.walk = hit.Object.subsurface, true
+	}
+	if hit.GeoNormal.Dot(dir) < 0 {
+		return inside
+	}
+	return s.sceneMedium()
+}
+
+// maxWalkSteps bounds the scattering events of a subsurface random walk. Light still
+// beneath the surface after that many is taken as absorbed.
+const maxWalkSteps = 256
+
+// randomWalk follows light beneath the subsurface material of m's object from r until
+// it reaches a surface again, scattering isotropically. It returns the surface hit,
+// the ray that found it and the walk's throughput, or false if the light was
+// absorbed. The walk takes any number of steps, so it runs on its own random numbers.
+//
+// Mean free paths often differ several times between channels, which makes delta
+// tracking against the largest coefficient very noisy in the others. Instead every
+// walk samples distances for one channel picked at random and weights the three
+// with the balance heuristic over the channels.
+func (s *Scene) randomWalk(m mediumRef, r Ray, sampler Sampler) (Hit, Ray, Vec3, bool) {
+	sss := m.obj.subsurface
+	sigmaT := sss.SigmaA.Add(sss.SigmaS)
+	rng := newTrackingRNG(sampler.Get1D())
+	channel := min(int(rng.float()*3), 2)
+	if sigmaT.Axis(channel) <= 0 {
+		return Hit{}, r, Vec3{}, false
+	}
+	// f is the walk's throughput per channel and pdf its density under each channel's
+	// distance sampling
+	f, pdf := NewVec3(1, 1, 1), NewVec3(1, 1, 1)
+	for step := 0; step < maxWalkSteps; step++ {
+		hit, ok := s.Intersect(r)
+		if !ok {
+			// The mesh is not closed
+			return Hit{}, r, Vec3{}, false
+		}
+		t := -math.Log(1-rng.float()) / sigmaT.Axis(channel)
+		if t >= hit.T {
+			T := expNeg(sigmaT.Scale(hit.T))
+			f, pdf = f.Mul(T), pdf.Mul(T)
+			return hit, r, f.Scale(1 / channelMean(pdf)), true
+		}
+		T := expNeg(sigmaT.Scale(t))
+		f, pdf = f.Mul(T).Mul(sss.SigmaS), pdf.Mul(T).Mul(sigmaT)
+
+		// Stop walks that carry next to nothing, keeping the estimate unbiased
+		if q := f.MaxComponent() / channelMean(pdf); q < 0.1 {
+			if q == 0 || rng.float() > q {
+				return Hit{}, r, Vec3{}, false
+			}
+			f = f.Scale(1 / q)
+		}
+		wi, _ := HenyeyGreenstein{}.Sample(r.Dir.Neg(), [2]float64{rng.float(), rng.float()})
+		r = Ray{Origin: r.At(t), Dir: wi, Time: r.Time}
+	}
+	return Hit{}, r, Vec3{}, false
+}
+
+// expNeg returns e^-v per channel.
+func expNeg(v Vec3) Vec3 {
+	return NewVec3(math.Exp(-v.X), math.Exp(-v.Y), math.Exp(-v.Z))
+}
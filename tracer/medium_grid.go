@@ -0,0 +1,180 @@
+package tracer
+
+import (
+	"go-ray-tracing/imageio"
+	"math"
+	"sync"
+)
+
+// GridMedium is a heterogeneous medium read from a voxel grid, such as a smoke or
+// fire cache from a simulation. Its density scales the coefficients and its
+// temperature and emission channels make it glow. It lives in the grid's space, so
+// it is meant to be an object's Interior with the object placing it in the world.
+//
+// Delta tracking runs against a majorant per brick, so empty bricks are skipped
+// outright and thin ones cost few null collisions. Build it with NewGridMedium and
+// build a new one after editing the grid.
+type GridMedium struct {
+	Grid   *VoxelGrid
+	SigmaA Vec3    // absorption coefficient per unit length at density 1
+	SigmaS Vec3    // scattering coefficient per unit length at density 1
+	G      float64 // Henyey-Greenstein asymmetry
+
+	// Emission is the radiance emitted per unit of the emission channel. Emitted
+	// light, blackbody included, comes from absorbing density, so a medium that does
+	// not absorb does not glow either.
+	Emission Vec3
+	// BlackbodyScale is the luminance emitted at 1500 K, about that of a candle
+	// flame. Hotter voxels are brighter and bluer, cooler ones dimmer and redder, as
+	// Planck's law has it. TemperatureScale converts the grid's temperatures to
+	// Kelvin.
+	BlackbodyScale   float64
+	TemperatureScale float64
+
+	space          imageio.ColorSpace // of the blackbody colors, set by InSpace
+	densityMax     []float64          // largest density in each brick cell
+	hasTemperature bool
+	hasEmission    bool
+}
+
+// NewGridMedium returns a white scattering medium of the grid's density.
+func NewGridMedium(grid *VoxelGrid) *GridMedium {
+	return &GridMedium{
+		Grid:             grid,
+		SigmaS:           NewVec3(1, 1, 1),
+		TemperatureScale: 1,
+		densityMax:       grid.brickMaxima(GridDensity),
+		hasTemperature:   grid.HasChannel(GridTemperature),
+		hasEmission:      grid.HasChannel(GridEmission),
+	}
+}
+
+func (m *GridMedium) Phase() HenyeyGreenstein {
+	return HenyeyGreenstein{G: m.G}
+}
+
+// majorants walks the bricks r passes through with a 3D DDA.
+func (m *GridMedium) majorants(r Ray, t0, t1 float64, visit func(s0, s1, sigmaMaj float64) bool) {
+	g := m.Grid
+	c0, c1, ok := g.Bounds.clip(r, t1)
+	if !ok {
+		return
+	}
+	t0, t1 = math.Max(t0, c0), c1
+	if t0 >= t1 {
+		return
+	}
+	sigmaT := m.SigmaA.Add(m.SigmaS).MaxComponent()
+	size := g.VoxelSize().Scale(float64(g.BrickSize))
+	p := r.At(t0)
+
+	var cell, step [3]int
+	var next, delta [3]float64
+	for a := 0; a < 3; a++ {
+		o, d := r.Origin.Axis(a), r.Dir.Axis(a)
+		lo, s := g.Bounds.Min.Axis(a), size.Axis(a)
+		cell[a] = min(max(int(math.Floor((p.Axis(a)-lo)/s)), 0), g.bricks[a]-1)
+		switch {
+		case d > 0:
+			step[a], next[a], delta[a] = 1, (lo+float64(cell[a]+1)*s-o)/d, s/d
+		case d < 0:
+			step[a], next[a], delta[a] = -1, (lo+float64(cell[a])*s-o)/d, -s/d
+		default:
+			next[a] = math.Inf(1)
+		}
+	}
+	for t := t0; t < t1; {
+		a := 0
+		if next[1] < next[a] {
+			a = 1
+		}
+		if next[2] < next[a] {
+			a = 2
+		}
+		end := math.Min(next[a], t1)
+		if end > t {
+			if !visit(t, end, sigmaT*m.densityMax[g.brickCell(cell[0], cell[1], cell[2])]) {
+				return
+			}
+			t = end
+		}
+		if cell[a] += step[a]; cell[a] < 0 || cell[a] >= g.bricks[a] {
+			return
+		}
+		next[a] += delta[a]
+	}
+}
+
+func (m *GridMedium) at(p Vec3) mediumPoint {
+	d := m.Grid.Sample(GridDensity, p)
+	mp := mediumPoint{sigmaA: m.SigmaA.Scale(d), sigmaS: m.SigmaS.Scale(d)}
+	if d <= 0 {
+		return mp
+	}
+	if m.hasEmission && !m.Emission.IsBlack() {
+		mp.emission = m.Emission.Scale(m.Grid.Sample(GridEmission, p))
+	}
+	if m.hasTemperature && m.BlackbodyScale > 0 {
+		bb := blackbody(m.Grid.Sample(GridTemperature, p) * m.TemperatureScale)
+		if m.space != imageio.LinearSRGB {
+			bb = ConvertColor(m.space.FromLinearSRGB(), bb)
+		}
+		mp.emission = mp.emission.Add(bb.Scale(m.BlackbodyScale))
+	}
+	return mp
+}
+
+const (
+	blackbodyStep = 25    // Kelvin between table entries
+	blackbodyMax  = 12000 // hotter temperatures use the last entry
+)
+
+// blackbody returns the linear sRGB radiance of a blackbody at the temperature in
+// Kelvin, relative to one at 1500 K, which has unit luminance.
+func blackbody(kelvin float64) Vec3 {
+	if kelvin <= 0 {
+		return Vec3{}
+	}
+	table := blackbodyTable()
+	x := math.Min(kelvin, blackbodyMax) / blackbodyStep
+	i := min(int(x), len(table)-2)
+	return Lerp(table[i], table[i+1], x-float64(i))
+}
+
+// blackbodyTable integrates Planck's law against the CIE 1931 color matching
+// functions, in the multi-lobe Gaussian fit of Wyman et al. 2013.
+var blackbodyTable = sync.OnceValue(func() []Vec3 {
+	lobe := func(x, mu, s1, s2 float64) float64 {
+		s := s2
+		if x < mu {
+			s = s1
+		}
+		t := (x - mu) / s
+		return math.Exp(-0.5 * t * t)
+	}
+	xyz := func(kelvin float64) Vec3 {
+		var c Vec3
+		for nm := 360.0; nm <= 830; nm += 5 {
+			// Planck's law up to a constant factor, with the wavelength in micrometres
+			um := nm / 1000
+			b := 1 / (um * um * um * um * um * math.Expm1(14387.77/(um*kelvin)))
+			c = c.Add(NewVec3(
+				1.056*lobe(nm, 599.8, 37.9, 31.0)+0.362*lobe(nm, 442.0, 16.0, 26.7)-0.065*lobe(nm, 501.1, 20.4, 26.2),
+				0.821*lobe(nm, 568.8, 46.9, 40.5)+0.286*lobe(nm, 530.9, 16.3, 31.1),
+				1.217*lobe(nm, 437.0, 11.8, 36.0)+0.681*lobe(nm, 459.0, 26.0, 13.8),
+			).Scale(b))
+		}
+		return c
+	}
+	norm := 1 / xyz(1500).Y
+	table := make([]Vec3, blackbodyMax/blackbodyStep+1)
+	for i := 1; i < len(table); i++ {
+		c := xyz(float64(i * blackbodyStep)).Scale(norm)
+		table[i] = NewVec3(
+			3.2404542*c.X-1.5371385*c.Y-0.4985314*c.Z,
+			-0.9692660*c.X+1.8760108*c.Y+0.0415560*c.Z,
+			0.0556434*c.X-0.2040259*c.Y+1.0572252*c.Z,
+		).Max(Vec3{})
+	}
+	return table
+})
@@ -0,0 +1,189 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestHenyeyGreensteinNormalized(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	wo := NewVec3(0.3, -0.5, 0.8).Normalize()
+	for _, g := range []float64{-0.7, 0, 0.3, 0.9} {
+		h := HenyeyGreenstein{G: g}
+		// Uniform sphere sampling has density 1/(4 pi)
+		sum := 0.0
+		const n = 200000
+		for i := 0; i < n; i++ {
+			sum += h.Eval(wo, uniformSphere(rng.Float64(), rng.Float64())) * 4 * math.Pi
+		}
+		if got := sum / n; math.Abs(got-1) > 0.02 {
+			t.Errorf("g=%v: integrates to %v, want 1", g, got)
+		}
+	}
+}
+
+func TestHenyeyGreensteinSampling(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	wo := NewVec3(0, 0.6, 0.8)
+	for _, g := range []float64{-0.5, 0, 0.7} {
+		h := HenyeyGreenstein{G: g}
+		meanCos := 0.0
+		const n = 100000
+		for i := 0; i < n; i++ {
+			wi, pdf := h.Sample(wo, [2]float64{rng.Float64(), rng.Float64()})
+			if want := h.PDF(wo, wi); math.Abs(pdf-want) > 1e-6*want {
+				t.Fatalf("g=%v: sample density %v, PDF says %v", g, pdf, want)
+			}
+			meanCos += wi.Dot(wo.Neg())
+		}
+		// The mean cosine of the scattering angle is g
+		if got := meanCos / n; math.Abs(got-g) > 0.01 {
+			t.Errorf("g=%v: mean cosine %v", g, got)
+		}
+	}
+}
+
+// A colored medium is tracked against its largest channel, so every other channel
+// relies on null collisions to come out right.
+func TestTrackingTransmittance(t *testing.T) {
+	m := &HomogeneousMedium{SigmaA: NewVec3(0.1, 0.4, 0.9), SigmaS: NewVec3(0.3, 0.2, 0.1)}
+	r := Ray{Dir: NewVec3(0, 0, 1)}
+	const dist = 2.0
+	want := NewVec3(math.Exp(-0.4*dist), math.Exp(-0.6*dist), math.Exp(-1.0*dist))
+
+	rng := newTrackingRNG(0.5)
+	var ratio, delta Vec3
+	const n = 200000
+	for i := 0; i < n; i++ {
+		ratio = ratio.Add(ratioTrack(m, r, 0, dist, &rng))
+		if ev := trackMedium(m, r, 0, dist, &rng); !ev.absorbed && !ev.scattered {
+			delta = delta.Add(ev.weight)
+		}
+	}
+	for _, c := range []struct {
+		name string
+		got  Vec3
+	}{{"ratio", ratio.Scale(1.0 / n)}, {"delta", delta.Scale(1.0 / n)}} {
+		for i := 0; i < 3; i++ {
+			if math.Abs(c.got.Axis(i)-want.Axis(i)) > 0.01 {
+				t.Errorf("%s tracking: transmittance %v, want %v", c.name, c.got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestVoxelGridRoundTrip(t *testing.T) {
+	g := NewVoxelGrid([3]int{20, 9, 13}, AABB{Min: NewVec3(-1, 0, -2), Max: NewVec3(1, 3, 2)})
+	g.Set(GridDensity, 0, 0, 0, 0.5)
+	g.Set(GridDensity, 19, 8, 12, 2)
+	g.Set(GridTemperature, 19, 8, 12, 1800)
+	g.Set(GridEmission, 10, 4, 6, 3)
+	var buf bytes.Buffer
+	if err := WriteVoxelGrid(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadVoxelGrid(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Resolution != g.Resolution || got.Bounds != g.Bounds || got.BrickCount() != g.BrickCount() {
+		t.Fatalf("header %v %v %d bricks, want %v %v %d", got.Resolution, got.Bounds, got.BrickCount(), g.Resolution, g.Bounds, g.BrickCount())
+	}
+	for z := 0; z < 13; z++ {
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 20; x++ {
+				for c := GridChannel(0); c < gridChannelCount; c++ {
+					if a, b := got.Voxel(c, x, y, z), g.Voxel(c, x, y, z); a != b {
+						t.Fatalf("%v at (%d, %d, %d) is %v, want %v", c, x, y, z, a, b)
+					}
+				}
+			}
+		}
+	}
+}
+
+// A header claiming a huge grid, or more bricks than the file holds, must fail
+// before the reader allocates for it.
+func TestVoxelGridCorruptHeader(t *testing.T) {
+	header := func(res, brickSize, bricks uint32) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(voxelGridMagic)
+		for _, v := range []any{
+			uint32(voxelGridVersion), [3]uint32{res, res, res}, brickSize,
+			[6]float32{0, 0, 0, 1, 1, 1}, uint32(1 << GridDensity), bricks,
+		} {
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+		return buf.Bytes()
+	}
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"4096^3 voxels", header(4096, 1, 0)},
+		{"bricks past the end", header(64, 8, 100)},
+	} {
+		if _, err := ReadVoxelGrid(bytes.NewReader(c.data)); err == nil {
+			t.Errorf("%s: read without error", c.name)
+		}
+	}
+}
+
+// Densities that are negative or not finite must be rejected when the grid loads.
+func TestVoxelGridBadDensity(t *testing.T) {
+	for _, v := range []float32{-0.5, float32(math.NaN()), float32(math.Inf(1))} {
+		g := NewVoxelGrid([3]int{4, 4, 4}, AABB{Min: NewVec3(0, 0, 0), Max: NewVec3(1, 1, 1)})
+		g.Set(GridDensity, 1, 2, 3, v)
+		var buf bytes.Buffer
+		if err := WriteVoxelGrid(&buf, g); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadVoxelGrid(&buf); err == nil {
+			t.Errorf("density %v read without error", v)
+		}
+	}
+}
+
+// A grid with a slab of constant density has a known transmittance through it, which
+// tracking against the per brick majorants must reproduce.
+func TestGridMediumTransmittance(t *testing.T) {
+	g := NewVoxelGrid([3]int{32, 32, 32}, AABB{Min: NewVec3(0, 0, 0), Max: NewVec3(4, 4, 4)})
+	// Voxels 8 to 23 along z, from z 1 to 3
+	for z := 8; z < 24; z++ {
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				g.Set(GridDensity, x, y, z, 1)
+			}
+		}
+	}
+	m := NewGridMedium(g)
+	m.SigmaA, m.SigmaS = NewVec3(0.2, 0.4, 0.6), NewVec3(0.3, 0.3, 0.3)
+	// Interpolation ramps in and out over a voxel, which keeps the 2 units of density
+	r := Ray{Origin: NewVec3(1.3, 2.1, -1), Dir: NewVec3(0.1, -0.05, 1)}
+	depth := 2.0 / r.Dir.Z
+	want := NewVec3(math.Exp(-0.5*depth), math.Exp(-0.7*depth), math.Exp(-0.9*depth))
+	rng := newTrackingRNG(0.25)
+	var ratio, delta Vec3
+	const n = 100000
+	for i := 0; i < n; i++ {
+		ratio = ratio.Add(ratioTrack(m, r, 0, 10, &rng))
+		if ev := trackMedium(m, r, 0, 10, &rng); !ev.absorbed && !ev.scattered {
+			delta = delta.Add(ev.weight)
+		}
+	}
+	for _, c := range []struct {
+		name string
+		got  Vec3
+	}{{"ratio", ratio.Scale(1.0 / n)}, {"delta", delta.Scale(1.0 / n)}} {
+		for i := 0; i < 3; i++ {
+			if math.Abs(c.got.Axis(i)-want.Axis(i)) > 0.01 {
+				t.Errorf("%s tracking: transmittance %v, want %v", c.name, c.got, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tracer
+
+import (
+	"math"
+	"sync"
+)
+
+// Mesh is an indexed triangle mesh in object space. Normals are optional per-vertex
+// shading normals; when missing the geometric normal is used.
+type Mesh struct {
+	Positions []Vec3
+	Normals   []Vec3
+	Indices   []int32
+
+	bvhOnce sync.Once
+	bvh     *BVH
+}
+
+// BVH returns the mesh's acceleration structure, building it on first use.
+func (m *Mesh) BVH() *BVH {
+	m.bvhOnce.Do(func() {
+		m.bvh = BuildMeshBVH(m)
+	})
+	return m.bvh
+}
+
+// SetVertices replaces the positions and normals of a mesh whose topology did not
+// change and refits its BVH instead of rebuilding it. Must not run during a render.
+func (m *Mesh) SetVertices(positions, normals []Vec3) {
+	m.Positions = positions
+	m.Normals = normals
+	if m.bvh != nil {
+		m.bvh.Refit()
+	}
+}
+
+func sameTopology(a, b *Mesh) bool {
+	if len(a.Positions) != len(b.Positions) || len(a.Indices) != len(b.Indices) {
+		return false
+	}
+	for i := range a.Indices {
+		if a.Indices[i] != b.Indices[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Mesh) TriangleCount() int {
+	return len(m.Indices) / 3
+}
+
+func (m *Mesh) Bounds() AABB {
+	b := EmptyAABB()
+	for _, p := range m.Positions {
+		b = b.Extend(p)
+	}
+	return b
+}
+
+func (m *Mesh) triangle(i int) (Vec3, Vec3, Vec3) {
+	return m.Positions[m.Indices[3*i]], m.Positions[m.Indices[3*i+1]], m.Positions[m.Indices[3*i+2]]
+}
+
+func (m *Mesh) triangleBounds(i int) AABB {
+	p0, p1, p2 := m.triangle(i)
+	return EmptyAABB().Extend(p0).Extend(p1).Extend(p2)
+}
+
+// intersectTriangle is the Möller-Trumbore test. It returns the distance and the
+// barycentric coordinates of p1 and p2.
+func (m *Mesh) intersectTriangle(i int, r Ray, tMax float64) (float64, float64, float64, bool) {
+	p0, p1, p2 := m.triangle(i)
+	e1 := p1.Sub(p0)
+	e2 := p2.Sub(p0)
+	pv := r.Dir.Cross(e2)
+	det := e1.Dot(pv)
+	if math.Abs(det) < 1e-12 {
+		return 0, 0, 0, false
+	}
+	invDet := 1 / det
+	tv := r.Origin.Sub(p0)
+	u := tv.Dot(pv) * invDet
+	if u < 0 || u > 1 {
+		return 0, 0, 0, false
+	}
+	qv := tv.Cross(e1)
+	v := r.Dir.Dot(qv) * invDet
+	if v < 0 || u+v > 1 {
+		return 0, 0, 0, false
+	}
+	t := e2.Dot(qv) * invDet
+	if t <= 0 || t >= tMax {
+		return 0, 0, 0, false
+	}
+	return t, u, v, true
+}
+
+// surface returns the object space geometric and shading normals at a barycentric point.
+func (m *Mesh) surface(i int, u, v float64) (Vec3, Vec3) {
+	p0, p1, p2 := m.triangle(i)
+	ng := p1.Sub(p0).Cross(p2.Sub(p0)).Normalize()
+	if len(m.Normals) != len(m.Positions) {
+		return ng, ng
+	}
+	n0 := m.Normals[m.Indices[3*i]]
+	n1 := m.Normals[m.Indices[3*i+1]]
+	n2 := m.Normals[m.Indices[3*i+2]]
+	ns := n0.Scale(1 - u - v).Add(n1.Scale(u)).Add(n2.Scale(v)).Normalize()
+	if ns.IsBlack() {
+		ns = ng
+	}
+	return ng, ns
+}
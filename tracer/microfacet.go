@@ -0,0 +1,141 @@
+package tracer
+
+import "math"
+
+// Minimum GGX alpha, rougher than a perfect mirror to keep the distribution finite.
+const minAlpha = 1e-3
+
+func roughnessToAlpha(roughness float64) float64 {
+	return math.Max(roughness*roughness, minAlpha)
+}
+
+// ggxD is the GGX (Trowbridge-Reitz) normal distribution for a local half vector.
+func ggxD(h Vec3, alpha float64) float64 {
+	if h.Z <= 0 {
+		return 0
+	}
+	a2 := alpha * alpha
+	d := h.Z*h.Z*(a2-1) + 1
+	return a2 / (math.Pi * d * d)
+}
+
+// ggxLambda is Smith's auxiliary function for direction w.
+func ggxLambda(w Vec3, alpha float64) float64 {
+	cos2 := w.Z * w.Z
+	if cos2 == 0 {
+		return math.Inf(1)
+	}
+	tan2 := math.Max(1-cos2, 0) / cos2
+	return 0.5 * (-1 + math.Sqrt(1+alpha*alpha*tan2))
+}
+
+func ggxG1(w Vec3, alpha float64) float64 {
+	return 1 / (1 + ggxLambda(w, alpha))
+}
+
+// ggxG2 is the height-correlated masking-shadowing term.
+func ggxG2(wo, wi Vec3, alpha float64) float64 {
+	return 1 / (1 + ggxLambda(wo, alpha) + ggxLambda(wi, alpha))
+}
+
+// ggxSampleVisible samples a half vector from the distribution of normals visible
+// from wo (Heitz 2018). wo must be in the upper hemisphere.
+func ggxSampleVisible(wo Vec3, alpha, u1, u2 float64) Vec3 {
+	vh := NewVec3(alpha*wo.X, alpha*wo.Y, wo.Z).Normalize()
+
+	lensq := vh.X*vh.X + vh.Y*vh.Y
+	t1 := NewVec3(1, 0, 0)
+	if lensq > 0 {
+		t1 = NewVec3(-vh.Y, vh.X, 0).Scale(1 / math.Sqrt(lensq))
+	}
+	t2 := vh.Cross(t1)
+
+	r := math.Sqrt(u1)
+	sinPhi, cosPhi := math.Sincos(2 * math.Pi * u2)
+	p1 := r * cosPhi
+	p2 := r * sinPhi
+	s := 0.5 * (1 + vh.Z)
+	p2 = (1-s)*math.Sqrt(math.Max(0, 1-p1*p1)) + s*p2
+
+	nh := t1.Scale(p1).Add(t2.Scale(p2)).Add(vh.Scale(math.Sqrt(math.Max(0, 1-p1*p1-p2*p2))))
+	return NewVec3(alpha*nh.X, alpha*nh.Y, math.Max(1e-6, nh.Z)).Normalize()
+}
+
+// ggxPDFVisible is the density of ggxSampleVisible returning h.
+func ggxPDFVisible(wo, h Vec3, alpha float64) float64 {
+	if wo.Z <= 0 {
+		return 0
+	}
+	return ggxG1(wo, alpha) * math.Max(0, wo.Dot(h)) * ggxD(h, alpha) / wo.Z
+}
+
+func reflect(wo, n Vec3) Vec3 {
+	return n.Scale(2 * wo.Dot(n)).Sub(wo)
+}
+
+// refract bends wo through the surface with normal n (on wo's side). eta is the
+// ratio of the index on the far side to the index on wo's side. Returns false on
+// total internal reflection.
+func refract(wo, n Vec3, eta float64) (Vec3, bool) {
+	cosI := wo.Dot(n)
+	sin2T := math.Max(0, 1-cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return Vec3{}, false
+	}
+	cosT := math.Sqrt(1 - sin2T)
+	return wo.Neg().Scale(1 / eta).Add(n.Scale(cosI/eta - cosT)), true
+}
+
+func schlickWeight(cosTheta float64) float64 {
+	m := clamp(1-cosTheta, 0, 1)
+	m2 := m * m
+	return m2 * m2 * m
+}
+
+// microfacetReflection evaluates the GGX reflection lobe without its Fresnel term
+// and the density of sampling wi through a visible normal. Returns ok=false when wi
+// is not a reflection of wo.
+func microfacetReflection(wo, wi Vec3, alpha float64) (f, pdf float64, wm Vec3, ok bool) {
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return 0, 0, Vec3{}, false
+	}
+	wm = wo.Add(wi)
+	if wm.IsBlack() {
+		return 0, 0, Vec3{}, false
+	}
+	wm = wm.Normalize()
+	f = ggxD(wm, alpha) * ggxG2(wo, wi, alpha) / (4 * wo.Z * wi.Z)
+	pdf = ggxPDFVisible(wo, wm, alpha) / (4 * wo.Dot(wm))
+	return f, pdf, wm, true
+}
+
+// microfacetTransmission evaluates the GGX refraction lobe without its Fresnel term
+// (Walter et al. 2007) and the density of sampling wi through a visible normal. eta
+// is the index on wi's side over the index on wo's side. The 1/eta^2 factor accounts
+// for radiance being compressed when it enters a denser medium.
+func microfacetTransmission(wo, wi Vec3, eta, alpha float64) (f, pdf float64, wm Vec3, ok bool) {
+	if wo.Z <= 0 || wi.Z >= 0 {
+		return 0, 0, Vec3{}, false
+	}
+	wm = wi.Scale(eta).Add(wo)
+	if wm.IsBlack() {
+		return 0, 0, Vec3{}, false
+	}
+	wm = wm.Normalize()
+	if wm.Z < 0 {
+		wm = wm.Neg()
+	}
+	// Discard microfacets seen from behind by either direction
+	if wm.Dot(wi)*wi.Z < 0 || wm.Dot(wo)*wo.Z < 0 {
+		return 0, 0, Vec3{}, false
+	}
+
+	denom := wi.Dot(wm) + wo.Dot(wm)/eta
+	denom2 := denom * denom
+	if denom2 == 0 {
+		return 0, 0, Vec3{}, false
+	}
+	f = ggxD(wm, alpha) * ggxG2(wo, wi, alpha) * math.Abs(wi.Dot(wm)*wo.Dot(wm)/(wi.Z*wo.Z*denom2)) / (eta * eta)
+	pdf = ggxPDFVisible(wo, wm, alpha) * math.Abs(wi.Dot(wm)) / denom2
+	return f, pdf, wm, true
+}
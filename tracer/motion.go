@@ -0,0 +1,125 @@
+package tracer
+
+import "math"
+
+// TRS is a transform split into translation, rotation and scale, applied as T*R*S.
+// Motion blur interpolates between two of them.
+type TRS struct {
+	Translation Vec3
+	Rotation    Quat
+	Scale       Vec3
+}
+
+func (t TRS) Matrix() Mat4 {
+	return Translate(t.Translation).Mul(t.Rotation.Matrix()).Mul(ScaleMatrix(t.Scale))
+}
+
+// LerpTRS interpolates translation and scale linearly and rotation with slerp.
+func LerpTRS(a, b TRS, t float64) TRS {
+	return TRS{
+		Translation: Lerp(a.Translation, b.Translation, t),
+		Rotation:    a.Rotation.Slerp(b.Rotation, t),
+		Scale:       Lerp(a.Scale, b.Scale, t),
+	}
+}
+
+// QuatFromAxisAngle returns the rotation of angle degrees about axis.
+func QuatFromAxisAngle(axis Vec3, degrees float64) Quat {
+	if axis.IsBlack() {
+		return Quat{W: 1}
+	}
+	axis = axis.Normalize()
+	s, c := math.Sincos(degrees * math.Pi / 360)
+	return Quat{axis.X * s, axis.Y * s, axis.Z * s, c}
+}
+
+func (q Quat) Dot(o Quat) float64 {
+	return q.X*o.X + q.Y*o.Y + q.Z*o.Z + q.W*o.W
+}
+
+// Slerp interpolates along the shortest arc between two rotations.
+func (q Quat) Slerp(o Quat, t float64) Quat {
+	q, o = q.Normalize(), o.Normalize()
+	cos := q.Dot(o)
+	if cos < 0 {
+		// q and -q are the same rotation, take the short way round
+		o = Quat{-o.X, -o.Y, -o.Z, -o.W}
+		cos = -cos
+	}
+	if cos > 0.9995 {
+		// Nearly parallel, a normalized lerp is accurate and stable
+		return Quat{
+			q.X + (o.X-q.X)*t,
+			q.Y + (o.Y-q.Y)*t,
+			q.Z + (o.Z-q.Z)*t,
+			q.W + (o.W-q.W)*t,
+		}.Normalize()
+	}
+	theta := math.Acos(cos)
+	sinTheta := math.Sin(theta)
+	a := math.Sin((1-t)*theta) / sinTheta
+	b := math.Sin(t*theta) / sinTheta
+	return Quat{a*q.X + b*o.X, a*q.Y + b*o.Y, a*q.Z + b*o.Z, a*q.W + b*o.W}
+}
+
+// angle returns the rotation angle in radians between two rotations.
+func (q Quat) angle(o Quat) float64 {
+	return 2 * math.Acos(math.Min(math.Abs(q.Normalize().Dot(o.Normalize())), 1))
+}
+
+// objectMotion moves an object from one pose at shutter open to another at shutter
+// close. base is applied first, like a model's own transform.
+type objectMotion struct {
+	open, close TRS
+	base        Mat4
+}
+
+func (m *objectMotion) at(time float64) Mat4 {
+	return LerpTRS(m.open, m.close, time).Matrix().Mul(m.base)
+}
+
+// SetMotion makes the object move from open to close while the shutter is open.
+// Rays pick their time uniformly over the shutter interval. The object is drawn at
+// its open pose by anything that ignores time. SetTransform removes the motion.
+func (o *Object) SetMotion(open, close TRS, base Mat4) {
+	o.motion = &objectMotion{open: open, close: close, base: base}
+	o.transform = o.motion.at(0)
+	o.inverse = o.transform.Inverse()
+	o.bounds = o.motionBounds()
+	o.moved = true
+}
+
+// IsMoving reports whether the object has motion blur.
+func (o *Object) IsMoving() bool {
+	return o.motion != nil
+}
+
+// transformAt returns the object to world matrix and its inverse at a shutter time.
+func (o *Object) transformAt(time float64) (Mat4, Mat4) {
+	if o.motion == nil {
+		return o.transform, o.inverse
+	}
+	m := o.motion.at(time)
+	return m, m.Inverse()
+}
+
+// motionBounds covers the object over the whole shutter interval. The poses are
+// sampled finely enough that points between samples stay within a small padding
+// of the sampled boxes.
+func (o *Object) motionBounds() AABB {
+	m := o.motion
+	angle := m.open.Rotation.angle(m.close.Rotation)
+	steps := max(2, int(math.Ceil(angle/(math.Pi/32))))
+
+	b := EmptyAABB()
+	for i := 0; i <= steps; i++ {
+		b = b.Union(o.local.Transform(m.at(float64(i) / float64(steps))))
+	}
+
+	// A point rotating by angle/steps strays at most r*(1-cos(step/2)) from the chord
+	// between the sampled positions
+	r := b.Diagonal().Length()
+	pad := r*(1-math.Cos(angle/float64(steps)/2)) + 1e-6*r
+	padding := NewVec3(pad, pad, pad)
+	return AABB{Min: b.Min.Sub(padding), Max: b.Max.Add(padding)}
+}
@@ -0,0 +1,110 @@
+package tracer
+
+// Object places a mesh in the world with a material. It mirrors one mesh of a
+// core.Geometry.
+type Object struct {
+	Name      string
+	Mesh      *Mesh
+	Material  *Material
+	transform Mat4
+	inverse   Mat4
+	bounds    AABB
+	local     AABB
+	moved     bool          // bounds changed since the scene's last Commit
+	motion    *objectMotion // nil for objects that stay put while the shutter is open
+
+	// Interior is the medium inside the mesh, which must be closed. Rays that enter
+	// through the surface travel in it until they leave again.
+	Interior Medium
+	// NullSurface hides the surface itself, so the mesh only bounds its Interior.
+	NullSurface bool
+
+	subsurface *HomogeneousMedium // below the material's subsurface lobe, set on Commit
+}
+
+func NewObject(name string, mesh *Mesh, transform Mat4) *Object {
+	obj := &Object{
+		Name:     name,
+		Mesh:     mesh,
+		Material: DefaultMaterial(),
+	}
+	obj.local = mesh.Bounds()
+	obj.SetTransform(transform)
+	return obj
+}
+
+func (o *Object) Transform() Mat4 {
+	return o.transform
+}
+
+func (o *Object) SetTransform(m Mat4) {
+	o.motion = nil
+	o.transform = m
+	o.inverse = m.Inverse()
+	o.bounds = o.local.Transform(m)
+	o.moved = true
+}
+
+// UpdateMesh swaps in new vertex data. When the topology is unchanged the existing
+// BVH is refit in place, otherwise the new mesh builds its own. Returns true on refit.
+func (o *Object) UpdateMesh(mesh *Mesh) bool {
+	refit := sameTopology(o.Mesh, mesh)
+	if refit {
+		o.Mesh.SetVertices(mesh.Positions, mesh.Normals)
+	} else {
+		o.Mesh = mesh
+	}
+	o.local = o.Mesh.Bounds()
+	if o.motion != nil {
+		o.bounds = o.motionBounds()
+		o.moved = true
+	} else {
+		o.SetTransform(o.transform)
+	}
+	return refit
+}
+
+func (o *Object) Bounds() AABB {
+	return o.bounds
+}
+
+// Hit describes the closest intersection found along a ray.
+type Hit struct {
+	T         float64
+	Point     Vec3
+	Normal    Vec3 // shading normal, world space, unit length
+	GeoNormal Vec3 // geometric normal, world space, unit length
+	Object    *Object
+	Prim      int
+	U, V      float64
+}
+
+// intersect tests the ray in object space. The ray direction is not renormalized so
+// distances stay comparable with world space hits.
+func (o *Object) intersect(r Ray, tMax float64, hit *Hit) bool {
+	_, inverse := o.transformAt(r.Time)
+	local := Ray{Origin: inverse.MulPoint(r.Origin), Dir: inverse.MulDir(r.Dir), Time: r.Time}
+	th, ok := o.Mesh.BVH().ClosestHit(local, tMax)
+	if !ok {
+		return false
+	}
+	hit.T, hit.Prim, hit.U, hit.V = th.T, th.Prim, th.U, th.V
+	hit.Object = o
+	return true
+}
+
+// occluded is the any-hit version of intersect.
+func (o *Object) occluded(r Ray, tMax float64) bool {
+	_, inverse := o.transformAt(r.Time)
+	local := Ray{Origin: inverse.MulPoint(r.Origin), Dir: inverse.MulDir(r.Dir), Time: r.Time}
+	return o.Mesh.BVH().AnyHit(local, tMax)
+}
+
+// finalize fills in the world space point and normals for a hit found by intersect.
+func (o *Object) finalize(r Ray, hit *Hit) {
+	ng, ns := o.Mesh.surface(hit.Prim, hit.U, hit.V)
+	_, inverse := o.transformAt(r.Time)
+	hit.Point = r.At(hit.T)
+	hit.GeoNormal = inverse.MulNormal(ng).Normalize()
+	hit.Normal = inverse.MulNormal(ns).Normalize()
+}
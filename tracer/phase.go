@@ -0,0 +1,48 @@
+package tracer
+
+import "math"
+
+// HenyeyGreenstein is the phase function of a medium: how scattered light is spread
+// over directions. Both directions point away from the scattering point, wo back
+// towards where the light goes next, as for BSDFs.
+type HenyeyGreenstein struct {
+	G float64 // asymmetry, -1 scatters straight back, 0 evenly, 1 straight on
+}
+
+// Eval returns the phase function value, which is also its density.
+func (h HenyeyGreenstein) Eval(wo, wi Vec3) float64 {
+	return henyeyGreenstein(wo.Dot(wi), h.g())
+}
+
+func (h HenyeyGreenstein) PDF(wo, wi Vec3) float64 {
+	return h.Eval(wo, wi)
+}
+
+// Sample picks wi proportionally to the phase function and returns its density.
+func (h HenyeyGreenstein) Sample(wo Vec3, u [2]float64) (Vec3, float64) {
+	g := h.g()
+	var cosTheta float64
+	if math.Abs(g) < 1e-3 {
+		cosTheta = 1 - 2*u[0]
+	} else {
+		s := (1 - g*g) / (1 + g - 2*g*u[0])
+		cosTheta = -(1 + g*g - s*s) / (2 * g)
+	}
+	cosTheta = clamp(cosTheta, -1, 1)
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	sinPhi, cosPhi := math.Sincos(2 * math.Pi * u[1])
+	wi := NewFrame(wo).ToWorld(NewVec3(sinTheta*cosPhi, sinTheta*sinPhi, cosTheta))
+	return wi, henyeyGreenstein(cosTheta, g)
+}
+
+// g keeps the asymmetry away from the singular +-1.
+func (h HenyeyGreenstein) g() float64 {
+	return clamp(h.G, -0.999, 0.999)
+}
+
+// henyeyGreenstein evaluates the phase function for the cosine between wo and wi.
+// Forward scattering sends light on along -wo, where the cosine is -1.
+func henyeyGreenstein(cosTheta, g float64) float64 {
+	denom := 1 + g*g + 2*g*cosTheta
+	return (1 - g*g) / (4 * math.Pi * denom * math.Sqrt(math.Max(denom, 0)))
+}
@@ -0,0 +1,432 @@
+package tracer
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Caustics are light focused by smooth glass or mirrors onto a diffuse surface. A
+// path tracer only finds them when a path bounced off the surface happens to hit
+// the light through the glass, which is hopeless for small lights and impossible
+// for the sun. Before a frame is rendered, photons are traced from the lights
+// through the smooth objects instead and stored where they land on a diffuse
+// surface. Camera paths gather them at their first diffuse hit, and drop the paths
+// that reach a light through the same smooth objects from there, so no light is
+// counted twice.
+
+// photonChunk is the number of photons traced from one random stream, so the map
+// does not depend on how many goroutines traced it.
+const photonChunk = 4096
+
+// defaultPhotonRadius is the gather radius, relative to the radius of the scene's
+// bounds, used when Options leaves it at zero.
+const defaultPhotonRadius = 0.005
+
+// photon is light that reached a diffuse surface after one or more specular
+// bounces.
+type photon struct {
+	pos    Vec3
+	wi     Vec3 // unit direction it arrived from
+	normal Vec3 // geometric normal on the side it arrived at
+	power  Vec3
+}
+
+// PhotonMap is a kd-tree of caustic photons. It is stored implicitly: the photons
+// of a node's subtree are a range whose middle element is the node itself, split
+// across axes[mid], with the two halves its children.
+type PhotonMap struct {
+	photons []photon
+	axes    []uint8
+	radius  float64
+}
+
+func newPhotonMap(photons []photon, radius float64) *PhotonMap {
+	m := &PhotonMap{photons: photons, axes: make([]uint8, len(photons)), radius: radius}
+	m.build(0, len(photons))
+	return m
+}
+
+// build splits photons[lo:hi] at its median along the axis it is widest in.
+func (m *PhotonMap) build(lo, hi int) {
+	if hi-lo <= 1 {
+		return
+	}
+	b := EmptyAABB()
+	for i := lo; i < hi; i++ {
+		b = b.Extend(m.photons[i].pos)
+	}
+	d := b.Diagonal()
+	axis := 0
+	if d.Y > d.Axis(axis) {
+		axis = 1
+	}
+	if d.Z > d.Axis(axis) {
+		axis = 2
+	}
+	slices.SortFunc(m.photons[lo:hi], func(a, b photon) int {
+		return cmp.Compare(a.pos.Axis(axis), b.pos.Axis(axis))
+	})
+	mid := (lo + hi) / 2
+	m.axes[mid] = uint8(axis)
+	m.build(lo, mid)
+	m.build(mid+1, hi)
+}
+
+// lookup calls visit for every photon within the gather radius of p.
+func (m *PhotonMap) lookup(p Vec3, visit func(*photon)) {
+	m.lookupRange(0, len(m.photons), p, m.radius*m.radius, visit)
+}
+
+func (m *PhotonMap) lookupRange(lo, hi int, p Vec3, r2 float64, visit func(*photon)) {
+	for lo < hi {
+		mid := (lo + hi) / 2
+		ph := &m.photons[mid]
+		if ph.pos.Sub(p).LengthSquared() <= r2 {
+			visit(ph)
+		}
+		if hi-lo == 1 {
+			return
+		}
+		axis := int(m.axes[mid])
+		d := p.Axis(axis) - ph.pos.Axis(axis)
+		// Descend into the near side, and into the far one only if the disk reaches it
+		nearLo, nearHi, farLo, farHi := lo, mid, mid+1, hi
+		if d > 0 {
+			nearLo, nearHi, farLo, farHi = mid+1, hi, lo, mid
+		}
+		if d*d <= r2 {
+			m.lookupRange(farLo, farHi, p, r2, visit)
+		}
+		lo, hi = nearLo, nearHi
+	}
+}
+
+// estimate is the caustic radiance leaving a diffuse surface towards wo: the BSDF
+// applied to the power of the photons around p, over the area of the gather disk.
+// It also returns the part the diffuse lobe scattered.
+func (m *PhotonMap) estimate(p, ng Vec3, frame Frame, wo Vec3, bsdf BSDF) (Vec3, Vec3) {
+	d, split := bsdf.(diffuseLobe)
+	var L, Ld Vec3
+	m.lookup(p, func(ph *photon) {
+		// Photons that landed on the other side of a thin surface do not count
+		if ph.normal.Dot(ng) <= 0 {
+			return
+		}
+		wi := frame.ToLocal(ph.wi)
+		L = L.Add(bsdf.Eval(wo, wi).Mul(ph.power))
+		if split {
+			Ld = Ld.Add(d.evalDiffuse(wo, wi).Mul(ph.power))
+		}
+	})
+	area := math.Pi * m.radius * m.radius
+	return L.Scale(1 / area), Ld.Scale(1 / area)
+}
+
+// causticTarget is the bounding sphere of a smooth glass or mirror object. Photons
+// are aimed at the targets, since the light that misses them is no caustic and the
+// path tracer finds it anyway.
+type causticTarget struct {
+	center Vec3
+	radius float64
+}
+
+func (s *Scene) causticTargets() []causticTarget {
+	var targets []causticTarget
+	for _, obj := range s.Objects {
+		if obj.NullSurface || !obj.Material.isSpecular() || obj.bounds.IsEmpty() {
+			continue
+		}
+		targets = append(targets, causticTarget{
+			center: obj.bounds.Centroid(),
+			radius: obj.bounds.Diagonal().Length() / 2,
+		})
+	}
+	return targets
+}
+
+// targetCone is the cone of directions from a point towards a target. A point
+// inside the target sees it in every direction, which cosMax -1 stands for.
+type targetCone struct {
+	frame  Frame
+	cosMax float64
+	solid  float64 // solid angle
+}
+
+func newTargetCone(p Vec3, t causticTarget) targetCone {
+	d := t.center.Sub(p)
+	dist2 := d.LengthSquared()
+	r2 := t.radius * t.radius
+	if dist2 <= r2 {
+		return targetCone{frame: NewFrame(NewVec3(0, 0, 1)), cosMax: -1, solid: 4 * math.Pi}
+	}
+	sin2 := r2 / dist2
+	cosMax := math.Sqrt(1 - sin2)
+	// 1 - cosMax without the cancellation for small cones
+	return targetCone{frame: NewFrame(d.Scale(1 / math.Sqrt(dist2))), cosMax: cosMax, solid: 2 * math.Pi * sin2 / (1 + cosMax)}
+}
+
+func (c targetCone) contains(dir Vec3) bool {
+	return c.cosMax <= -1 || c.frame.ToLocal(dir).Z >= c.cosMax
+}
+
+// photonTracer traces the photons of one frame.
+type photonTracer struct {
+	scene    *Scene
+	targets  []causticTarget
+	sources  []emitter // lights photons leave from, the sun after them
+	distrib  *distribution1D
+	maxDepth int
+	scale    float64 // 1 over the number of photons
+
+	// Sun photons are aimed at the disks the targets cast across its direction
+	sunFrame Frame
+	sunArea  float64 // of all the disks
+	center   Vec3    // of the scene's bounding sphere
+	radius   float64
+}
+
+// buildPhotonMap traces opts.Photons photons for a frame of the committed scene. It
+// returns nil when photon mapping is off or nothing in the scene casts caustics.
+func buildPhotonMap(s *Scene, opts *Options) *PhotonMap {
+	if opts.Photons <= 0 {
+		return nil
+	}
+	targets := s.causticTargets()
+	if len(targets) == 0 {
+		return nil
+	}
+	t := &photonTracer{scene: s, targets: targets, maxDepth: max(opts.MaxDepth, 1), scale: 1 / float64(opts.Photons)}
+
+	// Lights are picked by power; the sun by the power it sends onto the targets
+	var power []float64
+	for _, l := range s.lights {
+		if e, ok := l.(emitter); ok {
+			t.sources = append(t.sources, e)
+			power = append(power, l.Power())
+		}
+	}
+	if s.Sun != nil && !s.Sun.Irradiance.IsBlack() {
+		t.sunFrame = NewFrame(s.Sun.Direction.Normalize())
+		for _, target := range targets {
+			t.sunArea += math.Pi * target.radius * target.radius
+		}
+		b := s.objectBounds()
+		t.center, t.radius = b.Centroid(), b.Diagonal().Length()/2
+		power = append(power, s.Sun.Irradiance.Luminance()*t.sunArea)
+	}
+	if len(power) == 0 {
+		return nil
+	}
+	t.distrib = newDistribution1D(power)
+
+	chunks := (opts.Photons + photonChunk - 1) / photonChunk
+	found := make([][]photon, chunks)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), chunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cones := make([]targetCone, len(t.targets))
+			for {
+				c := int(next.Add(1) - 1)
+				if c >= chunks {
+					return
+				}
+				rng := rand.New(rand.NewPCG(opts.Seed, uint64(c)))
+				n := min(photonChunk, opts.Photons-c*photonChunk)
+				for i := 0; i < n; i++ {
+					found[c] = t.trace(rng, cones, found[c])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	radius := opts.PhotonRadius
+	if radius <= 0 {
+		radius = defaultPhotonRadius * s.boundingRadius()
+	}
+	return newPhotonMap(slices.Concat(found...), radius)
+}
+
+// trace emits one photon and appends it to found if it lands on a diffuse surface
+// through the targets. cones is scratch space for aim, one per target.
+func (t *photonTracer) trace(rng *rand.Rand, cones []targetCone, found []photon) []photon {
+	i, pmf, _ := t.distrib.sample(rng.Float64())
+	if pmf == 0 {
+		return found
+	}
+	u := [2]float64{rng.Float64(), rng.Float64()}
+	time := rng.Float64()
+	var r Ray
+	var power Vec3
+	if i < len(t.sources) {
+		em, ok := t.sources[i].sampleEmission(u)
+		if !ok {
+			return found
+		}
+		dir, pdf := t.aim(em.p, rng, cones)
+		cos := facing(em.n, dir, em.twoSided)
+		if pdf == 0 || cos <= 0 {
+			return found
+		}
+		r = spawnRay(em.p, em.n, dir, time)
+		power = em.L.Scale(cos / (em.pdf * pdf))
+	} else {
+		var ok bool
+		if r, power, ok = t.emitSun(rng); !ok {
+			return found
+		}
+		r.Time = time
+	}
+	return t.scene.tracePhoton(r, power.Scale(t.scale/pmf), t.maxDepth, rng, found)
+}
+
+// aim picks a direction from p towards a target chosen by the solid angle it
+// subtends, and returns it with its density over all the targets. The cones from
+// p are stored in cones, which the caller allocates once per emission pass.
+func (t *photonTracer) aim(p Vec3, rng *rand.Rand, cones []targetCone) (Vec3, float64) {
+	total := 0.0
+	for i, target := range t.targets {
+		cones[i] = newTargetCone(p, target)
+		total += cones[i].solid
+	}
+	u := rng.Float64() * total
+	pick := len(cones) - 1
+	for i, c := range cones {
+		if u < c.solid {
+			pick = i
+			break
+		}
+		u -= c.solid
+	}
+	c := cones[pick]
+	dir := c.frame.ToWorld(uniformCone(rng.Float64(), rng.Float64(), c.cosMax)).Normalize()
+	// The cones overlap, each one containing dir adds its density
+	covered := 0
+	for _, c := range cones {
+		if c.contains(dir) {
+			covered++
+		}
+	}
+	return dir, float64(max(covered, 1)) / total
+}
+
+// emitSun starts a sun photon from a point on one of the targets' disks, behind
+// the scene. The sun's irradiance over the density of that point is its power.
+func (t *photonTracer) emitSun(rng *rand.Rand) (Ray, Vec3, bool) {
+	u := rng.Float64() * t.sunArea
+	pick := len(t.targets) - 1
+	for i, target := range t.targets {
+		a := math.Pi * target.radius * target.radius
+		if u < a {
+			pick = i
+			break
+		}
+		u -= a
+	}
+	target := t.targets[pick]
+	dx, dy := uniformDisk(rng.Float64(), rng.Float64())
+	q := target.center.Add(t.sunFrame.ToWorld(NewVec3(dx*target.radius, dy*target.radius, 0)))
+	// The disks overlap, each one containing q adds its density
+	covered := 0
+	for i, other := range t.targets {
+		d := t.sunFrame.ToLocal(q.Sub(other.center))
+		if i == pick || d.X*d.X+d.Y*d.Y <= other.radius*other.radius {
+			covered++
+		}
+	}
+	// Back q up against the sun until it is outside the scene
+	dir := t.sunFrame.ToWorld(NewVec3(0, 0, 1))
+	back := q.Sub(t.center).Dot(dir) + t.radius + rayEpsilon
+	return Ray{Origin: q.Sub(dir.Scale(back)), Dir: dir}, t.scene.Sun.Irradiance.Scale(t.sunArea / float64(covered)), true
+}
+
+// tracePhoton follows a photon through smooth glass and mirrors and stores it where
+// it first lands on a diffuse surface, if it went through one of them on the way.
+// Media on the way absorb and scatter photons away, none is scattered towards the
+// surface.
+func (s *Scene) tracePhoton(r Ray, power Vec3, maxDepth int, rng *rand.Rand, found []photon) []photon {
+	medium := s.sceneMedium()
+	specular := false
+	crossings := 0
+	for depth := 0; depth <= maxDepth; {
+		hit, ok := s.Intersect(r)
+		if !ok {
+			return found
+		}
+		if medium.Medium != nil && !medium.walk {
+			if local, t0, t1, inside := s.mediumSegment(medium, r, hit.T); inside {
+				tracking := newTrackingRNG(rng.Float64())
+				if power = power.Mul(ratioTrack(medium.Medium, local, t0, t1, &tracking)); power.IsBlack() {
+					return found
+				}
+			}
+		}
+		if hit.Object.NullSurface {
+			medium = s.mediumBeyond(&hit, r.Dir, medium)
+			r = spawnRay(hit.Point, hit.GeoNormal, r.Dir, r.Time)
+			if crossings++; crossings > maxNullCrossings {
+				return found
+			}
+			continue
+		}
+
+		n, ng := hit.Normal, hit.GeoNormal
+		entering := ng.Dot(r.Dir) < 0
+		if !entering {
+			ng = ng.Neg()
+		}
+		material := hit.Object.Material
+		if !material.isSpecular() {
+			if specular {
+				found = append(found, photon{pos: hit.Point, wi: r.Dir.Neg(), normal: ng, power: power})
+			}
+			return found
+		}
+
+		if n.Dot(ng) < 0 {
+			n = n.Neg()
+		}
+		frame := NewFrame(n)
+		wo := frame.ToLocal(r.Dir.Neg())
+		if wo.Z <= 0 {
+			frame = NewFrame(ng)
+			wo = frame.ToLocal(r.Dir.Neg())
+		}
+		sample, ok := material.BSDF(entering).Sample(wo, rng.Float64(), [2]float64{rng.Float64(), rng.Float64()})
+		if !ok {
+			return found
+		}
+		wi := frame.ToWorld(sample.Wi)
+		if (wi.Dot(ng) > 0) != (sample.Wi.Z > 0) {
+			return found
+		}
+		w := sample.Weight()
+		if sample.Wi.Z < 0 {
+			// Photons carry flux, which unlike radiance is not compressed by refraction
+			eta := material.IOR
+			if !entering {
+				eta = 1 / eta
+			}
+			w = w.Scale(eta * eta)
+			medium = s.mediumBeyond(&hit, wi, medium)
+		}
+		// Keep the photon's power about constant, ending it instead of dimming it
+		q := math.Min(w.MaxComponent(), 1)
+		if q <= 0 || rng.Float64() >= q {
+			return found
+		}
+		power = power.Mul(w.Scale(1 / q))
+		r = spawnRay(hit.Point, ng, wi, r.Time)
+		specular = true
+		depth++
+	}
+	return found
+}
@@ -0,0 +1,48 @@
+package tracer
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// The kd-tree lookup must find exactly the photons a linear scan finds, including
+// clumped ones with equal coordinates that land on both sides of a split.
+func TestPhotonMapLookup(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	photons := make([]photon, 5000)
+	for i := range photons {
+		p := NewVec3(rng.Float64()*10, rng.Float64()*2, rng.Float64()*10)
+		if i%4 == 0 {
+			// A caustic on the floor, all at the same height
+			p = NewVec3(5+rng.NormFloat64()*0.3, 0, 5+rng.NormFloat64()*0.3)
+		}
+		photons[i] = photon{pos: p, power: NewVec3(float64(i), 0, 0)}
+	}
+	all := slices.Clone(photons)
+	m := newPhotonMap(photons, 0)
+
+	for q := 0; q < 200; q++ {
+		p := NewVec3(rng.Float64()*10, rng.Float64()*2, rng.Float64()*10)
+		if q%2 == 0 {
+			p = NewVec3(5+rng.NormFloat64()*0.3, 0, 5+rng.NormFloat64()*0.3)
+		}
+		m.radius = 0.01 + rng.Float64()*rng.Float64()*2
+
+		var want []int
+		for _, ph := range all {
+			if ph.pos.Sub(p).LengthSquared() <= m.radius*m.radius {
+				want = append(want, int(ph.power.X))
+			}
+		}
+		var got []int
+		m.lookup(p, func(ph *photon) {
+			got = append(got, int(ph.power.X))
+		})
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("radius %v around %v: found %d photons, want %d", m.radius, p, len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package tracer
+
+import "math"
+
+// The generators below follow raylib's GenMesh* layouts so headless renders, which
+// cannot call into raylib without a GL context, see the same shapes as the window.
+
+// NewSphereMesh builds a UV sphere like rl.GenMeshSphere.
+func NewSphereMesh(radius float64, rings, slices int) *Mesh {
+	m := &Mesh{}
+	for i := 0; i <= rings; i++ {
+		theta := math.Pi * float64(i) / float64(rings)
+		sinTheta, cosTheta := math.Sincos(theta)
+		for j := 0; j <= slices; j++ {
+			phi := 2 * math.Pi * float64(j) / float64(slices)
+			sinPhi, cosPhi := math.Sincos(phi)
+			n := NewVec3(sinTheta*cosPhi, cosTheta, sinTheta*sinPhi)
+			m.Positions = append(m.Positions, n.Scale(radius))
+			m.Normals = append(m.Normals, n)
+		}
+	}
+	stride := int32(slices + 1)
+	for i := int32(0); i < int32(rings); i++ {
+		for j := int32(0); j < int32(slices); j++ {
+			a := i*stride + j
+			b := a + stride
+			m.Indices = append(m.Indices, a, a+1, b, a+1, b+1, b)
+		}
+	}
+	return m
+}
+
+// NewPlaneMesh builds a plane in XZ facing +Y like rl.GenMeshPlane.
+func NewPlaneMesh(width, length float64, resX, resZ int) *Mesh {
+	m := &Mesh{}
+	for z := 0; z <= resZ; z++ {
+		pz := (float64(z)/float64(resZ) - 0.5) * length
+		for x := 0; x <= resX; x++ {
+			px := (float64(x)/float64(resX) - 0.5) * width
+			m.Positions = append(m.Positions, NewVec3(px, 0, pz))
+			m.Normals = append(m.Normals, NewVec3(0, 1, 0))
+		}
+	}
+	stride := int32(resX + 1)
+	for z := int32(0); z < int32(resZ); z++ {
+		for x := int32(0); x < int32(resX); x++ {
+			v00 := z*stride + x
+			v10 := v00 + 1
+			v01 := v00 + stride
+			v11 := v01 + 1
+			m.Indices = append(m.Indices, v00, v01, v10, v10, v01, v11)
+		}
+	}
+	return m
+}
+
+// NewBoxMesh builds a box filling b, such as the bounds of a voxel grid.
+func NewBoxMesh(b AABB) *Mesh {
+	d, c := b.Diagonal(), b.Centroid()
+	m := NewCubeMesh(d.X, d.Y, d.Z)
+	for i, p := range m.Positions {
+		m.Positions[i] = p.Add(c)
+	}
+	return m
+}
+
+// NewCubeMesh builds a box centered on the origin like rl.GenMeshCube.
+func NewCubeMesh(width, height, length float64) *Mesh {
+	h := NewVec3(width/2, height/2, length/2)
+	faces := []struct{ n, u, v Vec3 }{
+		{NewVec3(1, 0, 0), NewVec3(0, 0, -1), NewVec3(0, 1, 0)},
+		{NewVec3(-1, 0, 0), NewVec3(0, 0, 1), NewVec3(0, 1, 0)},
+		{NewVec3(0, 1, 0), NewVec3(1, 0, 0), NewVec3(0, 0, -1)},
+		{NewVec3(0, -1, 0), NewVec3(1, 0, 0), NewVec3(0, 0, 1)},
+		{NewVec3(0, 0, 1), NewVec3(1, 0, 0), NewVec3(0, 1, 0)},
+		{NewVec3(0, 0, -1), NewVec3(-1, 0, 0), NewVec3(0, 1, 0)},
+	}
+	m := &Mesh{}
+	for _, f := range faces {
+		base := int32(len(m.Positions))
+		for _, c := range [4][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+			p := f.n.Add(f.u.Scale(c[0])).Add(f.v.Scale(c[1])).Mul(h)
+			m.Positions = append(m.Positions, p)
+			m.Normals = append(m.Normals, f.n)
+		}
+		m.Indices = append(m.Indices, base, base+1, base+2, base, base+2, base+3)
+	}
+	return m
+}
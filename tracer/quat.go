@@ -0,0 +1,28 @@
+package tracer
+
+import "math"
+
+// Quat is a rotation quaternion (x, y, z, w), the layout live-link TRNS messages use.
+type Quat struct {
+	X, Y, Z, W float64
+}
+
+func (q Quat) Normalize() Quat {
+	l := math.Sqrt(q.X*q.X + q.Y*q.Y + q.Z*q.Z + q.W*q.W)
+	if l == 0 {
+		return Quat{W: 1}
+	}
+	return Quat{q.X / l, q.Y / l, q.Z / l, q.W / l}
+}
+
+// Matrix returns the rotation matrix of the normalized quaternion.
+func (q Quat) Matrix() Mat4 {
+	q = q.Normalize()
+	x, y, z, w := q.X, q.Y, q.Z, q.W
+	return Mat4{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w), 0},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w), 0},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y), 0},
+		{0, 0, 0, 1},
+	}
+}
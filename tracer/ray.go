@@ -0,0 +1,29 @@
+package tracer
+
+// Ray epsilon used to offset secondary rays from the surface they leave.
+const rayEpsilon = 1e-4
+
+type Ray struct {
+	Origin Vec3
+	Dir    Vec3
+	Time   float64 // point in the shutter interval, 0 at open and 1 at close
+}
+
+func NewRay(origin, dir Vec3) Ray {
+	return Ray{Origin: origin, Dir: dir}
+}
+
+func (r Ray) At(t float64) Vec3 {
+	return r.Origin.Add(r.Dir.Scale(t))
+}
+
+// spawnRay offsets the origin along the geometric normal so the new ray does not
+// immediately re-hit the surface it starts on. The new ray keeps the time of the
+// path it continues.
+func spawnRay(p, n, dir Vec3, time float64) Ray {
+	offset := n.Scale(rayEpsilon)
+	if dir.Dot(n) < 0 {
+		offset = offset.Neg()
+	}
+	return Ray{Origin: p.Add(offset), Dir: dir, Time: time}
+}
@@ -0,0 +1,456 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Options controls a render.
+type Options struct {
+	Width    int
+	Height   int
+	Samples  int    // samples per pixel, the most a pixel gets with adaptive sampling
+	MaxDepth int    // maximum number of bounces
+	Seed     uint64 // base seed, the same seed always gives the same image
+	AOVs     []AOV  // extra passes a RenderJob collects in its AOVBuffers
+	Sampler  SamplerType
+
+	// FirstSample is the index of the first sample of every pixel, so a progressive
+	// pass picks up the sample sequence where the passes before it left off.
+	// SampleTarget is the number of samples all passes add up to, which the
+	// stratified and Sobol samplers spread their strata over; Samples when 0.
+	FirstSample  int
+	SampleTarget int
+
+	// Integrator picks the light transport algorithm. The photon map below only
+	// serves the path tracer.
+	Integrator Integrator
+
+	// NoiseThreshold enables adaptive sampling: a pixel stops once the standard error
+	// of its mean, relative to the square root of its brightness, falls below it.
+	// 0.01 is a good start, 0 always takes Samples.
+	NoiseThreshold float64
+	// MinSamples is the least a pixel gets before adaptive sampling may stop it.
+	MinSamples int
+
+	// Photons is the number of photons traced for the caustics of smooth glass and
+	// metal before each frame, 0 leaves caustics to the path tracer. PhotonRadius is
+	// the radius photons are gathered in, picked from the scene's size when 0. A
+	// larger radius gives smoother but blurrier caustics.
+	Photons      int
+	PhotonRadius float64
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Width:    800,
+		Height:   600,
+		Samples:  16,
+		MaxDepth: 5,
+		Seed:     0,
+
+		MinSamples: 16,
+	}
+}
+
+// Integrator is a light transport algorithm a render can use.
+type Integrator int
+
+const (
+	IntegratorPath          Integrator = iota // unidirectional path tracing with next-event estimation
+	IntegratorBidirectional                   // bidirectional path tracing, for light that comes through small openings
+)
+
+var integratorNames = [...]string{
+	IntegratorPath:          "path",
+	IntegratorBidirectional: "bidirectional",
+}
+
+func (i Integrator) String() string {
+	if i < 0 || int(i) >= len(integratorNames) {
+		return fmt.Sprintf("Integrator(%d)", int(i))
+	}
+	return integratorNames[i]
+}
+
+func ParseIntegrator(name string) (Integrator, error) {
+	for i, n := range integratorNames {
+		if strings.EqualFold(n, name) {
+			return Integrator(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown integrator %q, want one of %s", name, strings.Join(integratorNames[:], ", "))
+}
+
+// integrator estimates the light arriving along camera rays. RenderJob.Run sets one
+// up for every frame. With split set the light is also broken down into the AOV
+// passes.
+type integrator interface {
+	radiance(r Ray, sampler Sampler, split bool) pathSample
+}
+
+func newIntegrator(scene *Scene, opts *Options, caustics *PhotonMap) integrator {
+	if opts.Integrator == IntegratorBidirectional {
+		return newBidirectional(scene, opts)
+	}
+	return &pathTracer{scene: scene, maxDepth: opts.MaxDepth, caustics: caustics}
+}
+
+// pathTracer is the unidirectional integrator, see radiance. caustics is the
+// frame's photon map, nil without one.
+type pathTracer struct {
+	scene    *Scene
+	maxDepth int
+	caustics *PhotonMap
+}
+
+func (p *pathTracer) radiance(r Ray, sampler Sampler, split bool) pathSample {
+	return radiance(p.scene, r, p.maxDepth, p.caustics, sampler, split)
+}
+
+// Render path traces the scene on all cores and returns the linear radiance image.
+// Use a RenderJob for progress reporting or cancellation.
+func Render(scene *Scene, opts Options) *Image {
+	img, _ := NewRenderJob(scene, opts).Run(context.Background())
+	return img
+}
+
+// renderPixel averages the samples of one pixel and returns how many it took. The
+// sampler is restarted for every sample, so the result does not depend on which
+// goroutine rendered the pixel. When aovs is set the pixel's passes are stored in it
+// as well.
+func renderPixel(scene *Scene, opts *Options, integ integrator, sampler Sampler, x, y int, aovs *AOVBuffers) (Vec3, int) {
+	exposure := scene.Camera.exposureScale()
+	sum := Vec3{}
+	pixel := newAOVPixel()
+	var stats pixelStats
+	for !stats.done(opts) && stats.n < opts.Samples {
+		sampler.StartPixelSample(x, y, opts.FirstSample+stats.n)
+		film := sampler.Get2D()
+		lens := sampler.Get2D()
+		r := scene.Camera.generateRay(float64(x)+film[0], float64(y)+film[1], opts.Width, opts.Height, lens)
+		r.Time = sampler.Get1D()
+		sample := integ.radiance(r, sampler, aovs != nil)
+		sum = sum.Add(sample.L)
+		stats.add(sample.L.Scale(exposure))
+		if aovs != nil {
+			pixel.add(&sample)
+		}
+	}
+	if aovs != nil {
+		pixel.store(aovs, x, y, &stats, exposure)
+	}
+	return sum.Scale(exposure / float64(stats.n)), stats.n
+}
+
+// radiance estimates the light arriving along r with a unidirectional path tracer.
+// Area and emissive lights are reached both by next-event estimation and by BSDF
+// or phase function sampling, and the two are combined with the power heuristic.
+// Media along the way are delta tracked and light beneath subsurface materials
+// takes a random walk. With a photon map, caustics come from it instead. With split
+// set the light is also broken down into the AOV passes.
+func radiance(scene *Scene, r Ray, maxDepth int, caustics *PhotonMap, sampler Sampler, split bool) pathSample {
+	var out pathSample
+	beta := NewVec3(1, 1, 1)
+
+	// The medium the ray travels in. The camera is outside every object.
+	medium := scene.sceneMedium()
+	crossings := 0
+
+	// The vertex the current ray left and the BSDF density it was sampled with. A
+	// delta (or camera) vertex could not have sampled a light, so it gets no MIS.
+	var prevPoint Vec3
+	prevPDF := 0.0
+	specular := true
+
+	// Part of the path's throughput that left the first hit through the diffuse lobe
+	var share Vec3
+
+	// Caustics are gathered from the photon map at the first diffuse hit. The paths
+	// that go on from there to a light through smooth glass and metal only are the
+	// photons' paths, so their light is dropped. sinceGather counts the smooth
+	// surfaces since the gathering hit, -1 when a rough one or a medium came between.
+	gathered := caustics == nil
+	sinceGather := -1
+
+	// misWeight weights light i found by the BSDF sampled ray r
+	misWeight := func(i int) float64 {
+		if specular {
+			return 1
+		}
+		return powerHeuristic(prevPDF, scene.lightPDF(i, prevPoint, r.Dir))
+	}
+
+	for depth := 0; depth <= maxDepth; depth++ {
+		var hit Hit
+		var ok bool
+		walked := medium.walk
+		if walked {
+			// Light beneath a subsurface material walks to where it leaves again
+			var w Vec3
+			if hit, r, w, ok = scene.randomWalk(medium, r, sampler); !ok {
+				break
+			}
+			beta = beta.Mul(w)
+		} else {
+			hit, ok = scene.Intersect(r)
+		}
+		tMax := math.Inf(1)
+		if ok {
+			tMax = hit.T
+		}
+		i, tLight, Le, hitLight := scene.intersectLights(r, tMax)
+		if hitLight {
+			tMax = tLight
+		}
+
+		// The ray may scatter in the medium before it gets there
+		if medium.Medium != nil && !medium.walk {
+			ev := scene.sampleMedium(medium, r, tMax, sampler)
+			out.add(beta.Mul(ev.emitted), depth, share)
+			beta = beta.Mul(ev.weight)
+			if ev.absorbed {
+				break
+			}
+			if ev.scattered {
+				if depth == 0 && split {
+					// Phase functions scatter like a diffuse lobe
+					share = NewVec3(1, 1, 1)
+				}
+				p := r.At(ev.t)
+				wo := r.Dir.Neg()
+				if Ld := sampleDirectMedium(scene, p, r.Time, wo, medium, sampler); !Ld.IsBlack() {
+					out.add(beta.Mul(Ld), depth+1, share)
+				}
+				// Sampling the phase function exactly leaves beta unchanged
+				wi, pdf := medium.Phase().Sample(wo, sampler.Get2D())
+				r = Ray{Origin: p, Dir: wi, Time: r.Time}
+				prevPoint, prevPDF, specular = p, pdf, false
+				sinceGather = -1
+				if beta, ok = russianRoulette(beta, depth, sampler); !ok {
+					break
+				}
+				continue
+			}
+		}
+
+		if hitLight {
+			if sinceGather <= 0 {
+				out.add(beta.Mul(Le).Scale(misWeight(i)), depth, share)
+			}
+			break
+		}
+		if !ok {
+			Le, i := scene.escaped(r.Dir)
+			w := 1.0
+			if i >= 0 {
+				w = misWeight(i)
+			}
+			out.add(beta.Mul(Le).Scale(w), depth, share)
+			break
+		}
+		if hit.Object.NullSurface {
+			// Crossing the boundary of a medium is not a bounce
+			medium = scene.mediumBeyond(&hit, r.Dir, medium)
+			r = spawnRay(hit.Point, hit.GeoNormal, r.Dir, r.Time)
+			if crossings++; crossings <= maxNullCrossings {
+				depth--
+			}
+			continue
+		}
+
+		// Shade the side facing the incoming ray
+		n, ng := hit.Normal, hit.GeoNormal
+		entering := ng.Dot(r.Dir) < 0
+		if !entering {
+			ng = ng.Neg()
+		}
+		if n.Dot(ng) < 0 {
+			n = n.Neg()
+		}
+		material := hit.Object.Material
+		if depth == 0 {
+			out.hit, out.object = true, hit.Object
+			out.albedo, out.normal, out.position, out.depth = material.Albedo(), n, hit.Point, hit.T
+		}
+
+		// Light emitted by the surface itself
+		if entering && material.IsEmissive() && sinceGather <= 0 {
+			w := 1.0
+			if i, ok := scene.emissiveLight(&hit); ok {
+				w = misWeight(i)
+			}
+			out.add(beta.Mul(material.Emitted()).Scale(w), depth, share)
+		}
+
+		frame := NewFrame(n)
+		wo := frame.ToLocal(r.Dir.Neg())
+		if wo.Z <= 0 {
+			// Shading normal points away from the viewer, fall back to the geometric one
+			frame = NewFrame(ng)
+			wo = frame.ToLocal(r.Dir.Neg())
+		}
+		bsdf := material.BSDF(entering)
+		if walked {
+			bsdf = material.subsurfaceExit()
+		}
+		smooth := material.isSpecular() && !walked
+		if sinceGather >= 0 {
+			if smooth {
+				sinceGather++
+			} else {
+				sinceGather = -1
+			}
+		}
+		// Direct light seen through the smooth surfaces is in the photon map as well
+		covered := sinceGather > 0
+
+		// directShare is the diffuse part of light arriving along wi at this vertex
+		directShare := func(wi, f Vec3) Vec3 {
+			if depth == 0 && split {
+				return diffuseShare(bsdf, wo, wi, f)
+			}
+			return share
+		}
+
+		// Direct light from the sun
+		if scene.Sun != nil && !covered {
+			wi := scene.Sun.Direction.Neg().Normalize()
+			wiLocal := frame.ToLocal(wi)
+			// It may come through the surface, as it does where a subsurface walk leaves
+			if f := bsdf.Eval(wo, wiLocal); (wiLocal.Z > 0) == (wi.Dot(ng) > 0) && !f.IsBlack() {
+				sunMedium := medium
+				if wi.Dot(ng) < 0 {
+					sunMedium = scene.mediumBeyond(&hit, wi, medium)
+				}
+				if T := scene.transmittance(spawnRay(hit.Point, ng, wi, r.Time), math.Inf(1), sunMedium, sampler); !T.IsBlack() {
+					out.add(beta.Mul(f).Mul(scene.Sun.Irradiance).Mul(T).Scale(math.Abs(wiLocal.Z)), depth+1, directShare(wiLocal, f))
+				}
+			}
+		}
+
+		// Direct light from one area or emissive light
+		if !covered {
+			if Ld, wiLocal, f := sampleDirect(scene, &hit, ng, r.Time, frame, wo, bsdf, medium, sampler); !Ld.IsBlack() {
+				out.add(beta.Mul(Ld), depth+1, directShare(wiLocal, f))
+			}
+		}
+
+		// Light focused onto the surface by glass and metal, bounced twice or more
+		if !gathered && !smooth {
+			Lc, Ld := caustics.estimate(hit.Point, ng, frame, wo, bsdf)
+			causticShare := share
+			if depth == 0 && split {
+				causticShare = partShare(Ld, Lc)
+			}
+			out.add(beta.Mul(Lc), depth+2, causticShare)
+			gathered, sinceGather = true, 0
+		}
+
+		// Continue the path in a direction picked by the BSDF
+		sample, ok := bsdf.Sample(wo, sampler.Get1D(), sampler.Get2D())
+		if !ok {
+			break
+		}
+		wi := frame.ToWorld(sample.Wi)
+		if (wi.Dot(ng) > 0) != (sample.Wi.Z > 0) {
+			// The shading normal sent the ray through the wrong side of the surface
+			break
+		}
+		if depth == 0 && split && !sample.Delta {
+			share = diffuseShare(bsdf, wo, sample.Wi, sample.F)
+		}
+		beta = beta.Mul(sample.Weight())
+		if wi.Dot(ng) < 0 {
+			medium = scene.mediumBeyond(&hit, wi, medium)
+		}
+		r = spawnRay(hit.Point, ng, wi, r.Time)
+		// Without next-event estimation here, light found by the BSDF gets no MIS
+		prevPoint, prevPDF, specular = hit.Point, sample.PDF, sample.Delta || covered
+
+		if beta, ok = russianRoulette(beta, depth, sampler); !ok {
+			break
+		}
+	}
+	return out
+}
+
+// russianRoulette ends paths that have bounced a few times with a probability that
+// grows as their throughput drops, and scales up the ones that survive.
+func russianRoulette(beta Vec3, depth int, sampler Sampler) (Vec3, bool) {
+	if depth < 3 {
+		return beta, true
+	}
+	q := math.Min(beta.MaxComponent(), 0.95)
+	if sampler.Get1D() > q {
+		return beta, false
+	}
+	return beta.Scale(1 / q), true
+}
+
+// sampleDirect is next-event estimation towards one light picked by the scene's
+// light sampler, weighted against BSDF sampling with the power heuristic. medium is
+// the one the ray that hit the surface came through. It also returns the local
+// light direction and the BSDF value there.
+func sampleDirect(scene *Scene, hit *Hit, ng Vec3, time float64, frame Frame, wo Vec3, bsdf BSDF, medium mediumRef, sampler Sampler) (Vec3, Vec3, Vec3) {
+	p := hit.Point
+	light, pmf := scene.sampleLight(p, sampler.Get1D())
+	u := sampler.Get2D()
+	if light == nil || pmf == 0 {
+		return Vec3{}, Vec3{}, Vec3{}
+	}
+	ls, ok := light.SampleLi(p, u)
+	if !ok || ls.PDF == 0 || ls.L.IsBlack() {
+		return Vec3{}, Vec3{}, Vec3{}
+	}
+	wiLocal := frame.ToLocal(ls.Wi)
+	if (ls.Wi.Dot(ng) > 0) != (wiLocal.Z > 0) {
+		return Vec3{}, Vec3{}, Vec3{}
+	}
+	f := bsdf.Eval(wo, wiLocal)
+	if f.IsBlack() {
+		return Vec3{}, Vec3{}, Vec3{}
+	}
+	if ls.Wi.Dot(ng) < 0 {
+		medium = scene.mediumBeyond(hit, ls.Wi, medium)
+	}
+	T := scene.transmittance(spawnRay(p, ng, ls.Wi, time), ls.Dist-2*rayEpsilon, medium, sampler)
+	if T.IsBlack() {
+		return Vec3{}, Vec3{}, Vec3{}
+	}
+	lightPDF := pmf * ls.PDF
+	w := powerHeuristic(lightPDF, bsdf.PDF(wo, wiLocal))
+	return f.Mul(ls.L).Mul(T).Scale(math.Abs(wiLocal.Z) * w / lightPDF), wiLocal, f
+}
+
+// sampleDirectMedium is next-event estimation from a scattering point p in a
+// medium: the sun, and one light weighted against phase function sampling.
+func sampleDirectMedium(scene *Scene, p Vec3, time float64, wo Vec3, medium mediumRef, sampler Sampler) Vec3 {
+	phase := medium.Phase()
+	var Ld Vec3
+	if scene.Sun != nil {
+		wi := scene.Sun.Direction.Neg().Normalize()
+		T := scene.transmittance(Ray{Origin: p, Dir: wi, Time: time}, math.Inf(1), medium, sampler)
+		Ld = scene.Sun.Irradiance.Mul(T).Scale(phase.Eval(wo, wi))
+	}
+
+	light, pmf := scene.sampleLight(p, sampler.Get1D())
+	u := sampler.Get2D()
+	if light == nil || pmf == 0 {
+		return Ld
+	}
+	ls, ok := light.SampleLi(p, u)
+	if !ok || ls.PDF == 0 || ls.L.IsBlack() {
+		return Ld
+	}
+	T := scene.transmittance(Ray{Origin: p, Dir: ls.Wi, Time: time}, ls.Dist-rayEpsilon, medium, sampler)
+	if T.IsBlack() {
+		return Ld
+	}
+	f := phase.Eval(wo, ls.Wi)
+	lightPDF := pmf * ls.PDF
+	w := powerHeuristic(lightPDF, f)
+	return Ld.Add(ls.L.Mul(T).Scale(f * w / lightPDF))
+}
@@ -0,0 +1,314 @@
+package tracer
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"strings"
+)
+
+// Sampler produces the random numbers of one pixel sample: the film position, lens,
+// time and every choice along the path, in that order. The values depend only on the
+// pixel, the sample index, the seed and how many values were taken before them, so a
+// render is bit-identical whatever the thread count or tile size.
+type Sampler interface {
+	// StartPixelSample positions the sampler at sample index of pixel (x, y) and
+	// rewinds it to the first dimension.
+	StartPixelSample(x, y, index int)
+	Get1D() float64
+	Get2D() [2]float64
+}
+
+// SamplerType picks the Sampler implementation used by a render.
+type SamplerType int
+
+const (
+	SamplerIndependent SamplerType = iota // uniform random numbers
+	SamplerStratified                     // jittered strata, one per sample, in every dimension
+	SamplerSobol                          // Owen-scrambled Sobol points, shuffled per dimension
+	SamplerBlueNoise                      // rank-1 lattice shifted per pixel by a blue noise tile
+)
+
+var samplerNames = [...]string{
+	SamplerIndependent: "independent",
+	SamplerStratified:  "stratified",
+	SamplerSobol:       "sobol",
+	SamplerBlueNoise:   "bluenoise",
+}
+
+func (t SamplerType) String() string {
+	if t < 0 || int(t) >= len(samplerNames) {
+		return fmt.Sprintf("SamplerType(%d)", int(t))
+	}
+	return samplerNames[t]
+}
+
+func ParseSamplerType(name string) (SamplerType, error) {
+	for i, n := range samplerNames {
+		if strings.EqualFold(n, name) {
+			return SamplerType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sampler %q, want one of %s", name, strings.Join(samplerNames[:], ", "))
+}
+
+// NewSampler returns a sampler of the given type for renders of samplesPerPixel
+// samples. Stratified and Sobol samplers stratify over that many samples and are best
+// with a power of two.
+func NewSampler(t SamplerType, samplesPerPixel int, seed uint64) Sampler {
+	spp := max(samplesPerPixel, 1)
+	switch t {
+	case SamplerStratified:
+		xs := 1
+		for d := 1; d*d <= spp; d++ {
+			if spp%d == 0 {
+				xs = d
+			}
+		}
+		return &StratifiedSampler{spp: spp, xs: xs, ys: spp / xs, seed: seed}
+	case SamplerSobol:
+		return &SobolSampler{spp: spp, seed: seed}
+	case SamplerBlueNoise:
+		return &BlueNoiseSampler{seed: seed}
+	}
+	return &IndependentSampler{seed: seed}
+}
+
+// oneMinusEpsilon is the largest float64 below 1.
+const oneMinusEpsilon = 0x1.fffffffffffffp-1
+
+// mixBits is the splitmix64 finalizer, a cheap hash with good avalanche.
+func mixBits(v uint64) uint64 {
+	v ^= v >> 31
+	v *= 0x7fb5d329728ea185
+	v ^= v >> 27
+	v *= 0x81dadef4bc2dd44d
+	v ^= v >> 33
+	return v
+}
+
+func hash3(a, b, c uint64) uint64 {
+	return mixBits(a ^ mixBits(b^mixBits(c)))
+}
+
+// pixelKey packs a pixel position for hashing.
+func pixelKey(x, y int) uint64 {
+	return uint64(uint32(x)) | uint64(uint32(y))<<32
+}
+
+// IndependentSampler returns uniform random numbers from a PCG stream keyed by the
+// pixel and sample index.
+type IndependentSampler struct {
+	seed uint64
+	rng  rand.PCG
+}
+
+func (s *IndependentSampler) StartPixelSample(x, y, index int) {
+	s.rng.Seed(hash3(pixelKey(x, y), s.seed, 0), uint64(index))
+}
+
+func (s *IndependentSampler) Get1D() float64 {
+	return float64(s.rng.Uint64()>>11) * 0x1p-53
+}
+
+func (s *IndependentSampler) Get2D() [2]float64 {
+	return [2]float64{s.Get1D(), s.Get1D()}
+}
+
+// StratifiedSampler gives every sample of a pixel its own stratum in each dimension,
+// with the strata shuffled independently per dimension and jittered inside.
+type StratifiedSampler struct {
+	spp, xs, ys int
+	seed        uint64
+
+	pixel uint64
+	index int
+	dim   uint64
+	rng   rand.PCG
+}
+
+func (s *StratifiedSampler) StartPixelSample(x, y, index int) {
+	s.pixel, s.index, s.dim = pixelKey(x, y), index, 0
+	s.rng.Seed(hash3(s.pixel, s.seed, 1), uint64(index))
+}
+
+func (s *StratifiedSampler) jitter() float64 {
+	return float64(s.rng.Uint64()>>11) * 0x1p-53
+}
+
+func (s *StratifiedSampler) Get1D() float64 {
+	h := hash3(s.pixel, s.dim, s.seed)
+	s.dim++
+	stratum := permutationElement(uint32(s.index%s.spp), uint32(s.spp), uint32(h))
+	return math.Min((float64(stratum)+s.jitter())/float64(s.spp), oneMinusEpsilon)
+}
+
+func (s *StratifiedSampler) Get2D() [2]float64 {
+	h := hash3(s.pixel, s.dim, s.seed)
+	s.dim += 2
+	stratum := int(permutationElement(uint32(s.index%s.spp), uint32(s.spp), uint32(h)))
+	x, y := stratum%s.xs, stratum/s.xs
+	return [2]float64{
+		math.Min((float64(x)+s.jitter())/float64(s.xs), oneMinusEpsilon),
+		math.Min((float64(y)+s.jitter())/float64(s.ys), oneMinusEpsilon),
+	}
+}
+
+// SobolSampler takes the first two dimensions of the Sobol sequence for every pair
+// of dimensions, shuffling the sample order and Owen scrambling the points with
+// different seeds each time. The pairs stay well stratified on their own while
+// being decorrelated from each other.
+type SobolSampler struct {
+	spp  int
+	seed uint64
+
+	pixel uint64
+	index int
+	dim   uint64
+}
+
+func (s *SobolSampler) StartPixelSample(x, y, index int) {
+	s.pixel, s.index, s.dim = pixelKey(x, y), index, 0
+}
+
+// shuffled returns the dimension's hash and the sample index after shuffling.
+func (s *SobolSampler) shuffled() (uint64, uint32) {
+	h := hash3(s.pixel, s.dim, s.seed)
+	return h, permutationElement(uint32(s.index%s.spp), uint32(s.spp), uint32(h))
+}
+
+func (s *SobolSampler) Get1D() float64 {
+	h, i := s.shuffled()
+	s.dim++
+	return sobolFloat(owenScramble(bits.Reverse32(i), uint32(h>>32)))
+}
+
+func (s *SobolSampler) Get2D() [2]float64 {
+	h, i := s.shuffled()
+	s.dim += 2
+	h2 := mixBits(h)
+	return [2]float64{
+		sobolFloat(owenScramble(bits.Reverse32(i), uint32(h>>32))),
+		sobolFloat(owenScramble(sobolDimension1(i), uint32(h2))),
+	}
+}
+
+// sobolMatrix1 holds the direction numbers of the second Sobol dimension, from the
+// primitive polynomial x+1.
+var sobolMatrix1 = func() (m [32]uint32) {
+	v := uint32(1) << 31
+	for k := range m {
+		m[k] = v
+		v ^= v >> 1
+	}
+	return m
+}()
+
+func sobolDimension1(i uint32) uint32 {
+	var v uint32
+	for k := 0; i != 0; k, i = k+1, i>>1 {
+		if i&1 != 0 {
+			v ^= sobolMatrix1[k]
+		}
+	}
+	return v
+}
+
+func sobolFloat(v uint32) float64 {
+	return math.Min(float64(v)*0x1p-32, oneMinusEpsilon)
+}
+
+// owenScramble is a hash based approximation of nested uniform scrambling: each bit
+// is flipped depending only on the bits above it (Burley 2020, "Practical Hash-based
+// Owen Scrambling").
+func owenScramble(v, seed uint32) uint32 {
+	v = bits.Reverse32(v)
+	v ^= v * 0x3d20adea
+	v += seed
+	v *= (seed >> 16) | 1
+	v ^= v * 0x05526c56
+	v ^= v * 0x53a22864
+	return bits.Reverse32(v)
+}
+
+// permutationElement returns element i of a pseudo-random permutation of [0, n)
+// picked by seed, without building the permutation (Kensler 2013, "Correlated
+// Multi-Jittered Sampling").
+func permutationElement(i, n, seed uint32) uint32 {
+	w := n - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	for {
+		i ^= seed
+		i *= 0xe170893d
+		i ^= seed >> 16
+		i ^= (i & w) >> 4
+		i ^= seed >> 8
+		i *= 0x0929eb3f
+		i ^= seed >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | seed>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < n {
+			break
+		}
+	}
+	return (i + seed) % n
+}
+
+// BlueNoiseSampler walks a rank-1 lattice (golden ratio in 1D, the R2 sequence in
+// 2D) from a starting point read from a blue noise tile. Neighbouring pixels start
+// far apart, so the remaining error looks like fine grain instead of blotches, which
+// also suits the denoiser. Every dimension reads the tile at its own offset.
+type BlueNoiseSampler struct {
+	seed uint64
+
+	x, y  int
+	index int
+	dim   uint64
+}
+
+func (s *BlueNoiseSampler) StartPixelSample(x, y, index int) {
+	s.x, s.y, s.index, s.dim = x, y, index, 0
+}
+
+// offset reads the blue noise tile at a shift picked by the dimension.
+func (s *BlueNoiseSampler) offset(dim uint64) float64 {
+	h := hash3(dim, s.seed, 2)
+	x := (s.x + int(h%blueNoiseSize)) % blueNoiseSize
+	y := (s.y + int((h>>32)%blueNoiseSize)) % blueNoiseSize
+	return blueNoiseTile()[y*blueNoiseSize+x]
+}
+
+func (s *BlueNoiseSampler) Get1D() float64 {
+	u := s.offset(s.dim) + float64(s.index)*golden1
+	s.dim++
+	return math.Min(u-math.Floor(u), oneMinusEpsilon)
+}
+
+func (s *BlueNoiseSampler) Get2D() [2]float64 {
+	u := s.offset(s.dim) + float64(s.index)*r2Alpha1
+	v := s.offset(s.dim+1) + float64(s.index)*r2Alpha2
+	s.dim += 2
+	return [2]float64{math.Min(u-math.Floor(u), oneMinusEpsilon), math.Min(v-math.Floor(v), oneMinusEpsilon)}
+}
+
+// Steps of the rank-1 lattices, 1/phi in 1D and the inverse powers of the plastic
+// number in 2D.
+const (
+	golden1  = 0.6180339887498949
+	r2Alpha1 = 0.7548776662466927
+	r2Alpha2 = 0.5698402909980532
+)
@@ -0,0 +1,57 @@
+package tracer
+
+import "math"
+
+// cosineHemisphere maps two uniform numbers to a cosine weighted direction around +Z.
+func cosineHemisphere(u1, u2 float64) Vec3 {
+	r := math.Sqrt(u1)
+	phi := 2 * math.Pi * u2
+	s, c := math.Sincos(phi)
+	return Vec3{r * c, r * s, math.Sqrt(math.Max(0, 1-u1))}
+}
+
+// uniformDisk maps two uniform numbers to the unit disk with Shirley's concentric
+// mapping, which keeps strata compact.
+func uniformDisk(u1, u2 float64) (float64, float64) {
+	a := 2*u1 - 1
+	b := 2*u2 - 1
+	if a == 0 && b == 0 {
+		return 0, 0
+	}
+	var r, phi float64
+	if math.Abs(a) > math.Abs(b) {
+		r, phi = a, math.Pi/4*(b/a)
+	} else {
+		r, phi = b, math.Pi/2-math.Pi/4*(a/b)
+	}
+	s, c := math.Sincos(phi)
+	return r * c, r * s
+}
+
+// uniformCone samples a direction around +Z within the cone of the given cosine.
+// The density is 1/(2*pi*(1-cosMax)).
+func uniformCone(u1, u2, cosMax float64) Vec3 {
+	cosTheta := 1 - u1*(1-cosMax)
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	s, c := math.Sincos(2 * math.Pi * u2)
+	return Vec3{sinTheta * c, sinTheta * s, cosTheta}
+}
+
+// uniformTriangle returns barycentric coordinates uniformly distributed over a triangle.
+func uniformTriangle(u1, u2 float64) (float64, float64) {
+	su := math.Sqrt(u1)
+	return 1 - su, u2 * su
+}
+
+// powerHeuristic is Veach's multiple importance sampling weight with beta = 2 for
+// a sample drawn from the strategy with density f, when g could also have drawn it.
+func powerHeuristic(f, g float64) float64 {
+	f2, g2 := f*f, g*g
+	if f2+g2 == 0 {
+		return 0
+	}
+	if math.IsInf(f2, 1) {
+		return 1
+	}
+	return f2 / (f2 + g2)
+}
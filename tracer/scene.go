@@ -0,0 +1,150 @@
+package tracer
+
+import (
+	"go-ray-tracing/imageio"
+	"math"
+)
+
+// Scene is everything the tracer needs to render a frame. Objects sit in a two-level
+// hierarchy: each mesh has its own BVH in object space and a small top level BVH
+// is built over the objects' world bounds. Moving an object only rebuilds the top level.
+type Scene struct {
+	Objects []*Object
+	Camera  Camera
+	Sun     *DirectionalLight
+	Lights  []Light // area lights; emissive objects become lights on Commit
+	Sky     Vec3    // constant radiance for rays escaping the scene
+
+	// Environment replaces Sky when set and is importance sampled like a light
+	Environment *EnvironmentLight
+
+	// Medium fills the space outside objects, such as atmospheric fog. It ends at
+	// MediumBounds, or at the bounds of the objects when those are empty, so rays
+	// leaving it still reach the sky and the sun.
+	Medium       Medium
+	MediumBounds AABB
+
+	// WorkingSpace is the color space of every color in the scene and so of the
+	// rendered image. The tracer itself does not depend on it; scene builders convert
+	// their linear sRGB inputs, see SceneFile.
+	WorkingSpace imageio.ColorSpace
+
+	topLevel *BVH
+	dirty    bool
+
+	mediumBounds AABB // where Medium is, set on Commit
+	hasMedia     bool // some object has an interior or a null surface
+
+	// Lights sampled by next-event estimation: the user lights followed by one light
+	// per emissive triangle
+	lights         []Light
+	lightIndex     map[lightKey]int
+	lightSampler   lightSampler
+	envIndex       int     // index of the environment in lights, or -1
+	userLights     []Light // Lights at the last build
+	userEnv        *EnvironmentLight
+	lightMaterials []*Material // object materials at the last build
+}
+
+func NewScene() *Scene {
+	return &Scene{
+		Objects: make([]*Object, 0),
+		Camera: Camera{
+			Position: NewVec3(4, 4, 4),
+			Target:   NewVec3(0, 1, 0),
+			Up:       NewVec3(0, 1, 0),
+			Fovy:     45,
+		},
+		Sky:          NewVec3(0.2, 0.2, 0.2),
+		MediumBounds: EmptyAABB(),
+	}
+}
+
+func (s *Scene) AddObject(obj *Object) {
+	s.Objects = append(s.Objects, obj)
+	s.dirty = true
+}
+
+func (s *Scene) RemoveObject(obj *Object) {
+	for i, o := range s.Objects {
+		if o == obj {
+			s.Objects = append(s.Objects[:i], s.Objects[i+1:]...)
+			s.dirty = true
+			return
+		}
+	}
+}
+
+// Commit rebuilds the top level hierarchy if objects were added, removed, moved or
+// had their mesh updated since the last call, and the light list if any light or
+// material changed too. It must be called after editing the scene and before tracing
+// rays; Render does this itself. Returns true if anything was rebuilt.
+func (s *Scene) Commit() bool {
+	dirty := s.dirty || s.topLevel == nil
+	s.hasMedia = false
+	for _, obj := range s.Objects {
+		if obj.moved {
+			dirty = true
+			obj.moved = false
+		}
+		obj.subsurface = obj.Material.subsurfaceMedium()
+		if obj.Interior != nil || obj.NullSurface || obj.subsurface != nil {
+			s.hasMedia = true
+		}
+	}
+	s.mediumBounds = s.MediumBounds
+	if s.mediumBounds.IsEmpty() {
+		s.mediumBounds = s.objectBounds()
+	}
+	if !dirty {
+		if s.lightsStale() {
+			s.buildLights()
+			return true
+		}
+		return false
+	}
+	s.buildLights()
+
+	bounds := make([]AABB, len(s.Objects))
+	for i, obj := range s.Objects {
+		bounds[i] = obj.bounds
+	}
+	s.topLevel = NewBVH(bounds)
+	s.dirty = false
+	return true
+}
+
+// TopLevelStats describes the last top level build.
+func (s *Scene) TopLevelStats() BVHStats {
+	if s.topLevel == nil {
+		return BVHStats{}
+	}
+	return s.topLevel.Stats
+}
+
+// Intersect returns the closest hit along r.
+func (s *Scene) Intersect(r Ray) (Hit, bool) {
+	var hit Hit
+	found := false
+	s.topLevel.traverse(r, math.Inf(1), func(prim int32, tMax float64) (float64, bool) {
+		if s.Objects[prim].intersect(r, tMax, &hit) {
+			found = true
+			return hit.T, false
+		}
+		return tMax, false
+	})
+	if found {
+		hit.Object.finalize(r, &hit)
+	}
+	return hit, found
+}
+
+// Occluded reports whether anything blocks r before tMax.
+func (s *Scene) Occluded(r Ray, tMax float64) bool {
+	blocked := false
+	s.topLevel.traverse(r, tMax, func(prim int32, tMax float64) (float64, bool) {
+		blocked = s.Objects[prim].occluded(r, tMax)
+		return tMax, blocked
+	})
+	return blocked
+}
@@ -0,0 +1,559 @@
+package tracer
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-ray-tracing/imageio"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// SceneFile is the JSON scene description used for offline renders. Transforms use
+// the same position / quaternion / scale layout as live-link TRNS messages and inline
+// meshes the same layout as MESH messages, so a DCC export can be replayed headless.
+//
+//	{
+//	  "camera": {"position": [4, 4, 4], "target": [0, 1, 0], "up": [0, 1, 0], "fovy": 45,
+//	             "fStop": 2.8, "focusDistance": 5, "shutter": 0.01, "iso": 100},
+//	  "light": {"position": [-2, 4, -1], "target": [0, 0, 0]},
+//	  "sky": [0.2, 0.2, 0.2],
+//	  "workingSpace": "acescg",
+//	  "environment": {"path": "studio.hdr", "rotation": 90, "intensity": 1},
+//	  "fog": {"scattering": [0.05, 0.05, 0.05], "g": 0.6, "bounds": [[-5, 0, -5], [5, 3, 5]]},
+//	  "lights": [
+//	    {"type": "quad", "position": [0, 3, 0], "size": [1, 1], "color": [1, 0.9, 0.8], "intensity": 10},
+//	    {"type": "sphere", "position": [2, 2, -2], "radius": 0.25, "intensity": 40}
+//	  ],
+//	  "geometries": [
+//	    {"name": "Plane", "primitive": "plane", "size": [10, 0, 10]},
+//	    {"name": "pSphere1", "primitive": "sphere", "radius": 1, "position": [0, 0.8, 0],
+//	     "material": {"baseColor": [0.8, 0.1, 0.1], "metallic": 0, "roughness": 0.3}},
+//	    {"name": "pSphere2", "primitive": "sphere", "position": [-2, 0.5, 0],
+//	     "material": {"metallic": 1, "roughness": 0.2, "conductor": "gold"}},
+//	    {"name": "wax", "primitive": "sphere", "radius": 0.5, "position": [0, 0.5, 2],
+//	     "material": {"baseColor": [0.9, 0.7, 0.5], "subsurface": 1, "subsurfaceRadius": [1, 0.5, 0.25], "subsurfaceScale": 0.1}},
+//	    {"name": "pCube1", "mesh": {"vertices": [...], "normals": [...], "indices": [...]},
+//	     "position": [2, 0, 0], "rotation": [0, 0, 0, 1], "scale": [1, 1, 1]},
+//	    {"name": "smoke", "primitive": "cube", "size": [1, 2, 1], "position": [-2, 1, 2],
+//	     "nullSurface": true, "medium": {"absorption": [0.2, 0.2, 0.2], "scattering": [2, 2, 2], "g": 0.3}},
+//	    {"name": "fire", "position": [2, 0, 2], "nullSurface": true,
+//	     "medium": {"grid": "fire.vgrid", "absorption": [1, 1, 1], "scattering": [4, 4, 4], "blackbody": 2}}
+//	  ]
+//	}
+type SceneFile struct {
+	Camera      SceneFileCamera       `json:"camera"`
+	Light       *SceneFileLight       `json:"light"`
+	Sky         *[3]float64           `json:"sky"`
+	Lights      []SceneFileAreaLight  `json:"lights"`
+	Environment *SceneFileEnvironment `json:"environment"`
+	Geometries  []SceneFileGeometry   `json:"geometries"`
+	Fog         *SceneFileMedium      `json:"fog"`
+
+	// WorkingSpace is the color space the scene renders in, "linear-srgb" (the
+	// default) or "acescg". Colors in the file are linear sRGB and are converted.
+	WorkingSpace string `json:"workingSpace"`
+
+	// Dir resolves relative paths such as the environment map, LoadSceneFile sets it
+	// to the scene file's directory
+	Dir string `json:"-"`
+}
+
+// SceneFileEnvironment is an equirectangular .hdr, .exr or .pfm image lighting the scene.
+type SceneFileEnvironment struct {
+	Path      string   `json:"path"`
+	Rotation  float64  `json:"rotation"` // degrees about +Y
+	Intensity *float64 `json:"intensity"`
+}
+
+type SceneFileCamera struct {
+	Position [3]float64 `json:"position"`
+	Target   [3]float64 `json:"target"`
+	Up       [3]float64 `json:"up"`
+	Fovy     float64    `json:"fovy"`
+
+	// Physical parameters, see PhysicalCamera. Giving any of them enables depth of
+	// field and exposure, starting from DefaultPhysicalCamera for the rest.
+	FocalLength   *float64 `json:"focalLength"`
+	SensorHeight  *float64 `json:"sensorHeight"`
+	FStop         *float64 `json:"fStop"`
+	FocusDistance *float64 `json:"focusDistance"`
+	ShutterTime   *float64 `json:"shutter"`
+	ISO           *float64 `json:"iso"`
+}
+
+// physical returns the camera's physical parameters, or false if none were given.
+func (c *SceneFileCamera) physical(fovy float64) (PhysicalCamera, bool) {
+	p := DefaultPhysicalCamera()
+	p.FocalLength = FocalLengthFromFovy(fovy, p.SensorHeight)
+	set := false
+	for _, f := range []struct {
+		src *float64
+		dst *float64
+	}{
+		{c.SensorHeight, &p.SensorHeight},
+		{c.FocalLength, &p.FocalLength},
+		{c.FStop, &p.FStop},
+		{c.FocusDistance, &p.FocusDistance},
+		{c.ShutterTime, &p.ShutterTime},
+		{c.ISO, &p.ISO},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+			set = true
+		}
+	}
+	if c.SensorHeight != nil && c.FocalLength == nil {
+		// Keep the field of view on the new sensor
+		p.FocalLength = FocalLengthFromFovy(fovy, p.SensorHeight)
+	}
+	return p, set
+}
+
+// SceneFileLight is the sun, placed like the light camera used for shadow mapping.
+type SceneFileLight struct {
+	Position   [3]float64  `json:"position"`
+	Target     [3]float64  `json:"target"`
+	Irradiance *[3]float64 `json:"irradiance"`
+}
+
+// SceneFileAreaLight is a quad, disk or sphere light. Quads and disks face down (-Y)
+// before the rotation is applied; their radiance is color * intensity.
+type SceneFileAreaLight struct {
+	Type      string      `json:"type"` // "quad", "disk" or "sphere"
+	Position  [3]float64  `json:"position"`
+	Rotation  *[4]float64 `json:"rotation"` // quaternion (x, y, z, w)
+	Size      [2]float64  `json:"size"`     // quad width and length
+	Radius    float64     `json:"radius"`   // disk and sphere
+	Color     *[3]float64 `json:"color"`
+	Intensity float64     `json:"intensity"`
+	TwoSided  bool        `json:"twoSided"`
+}
+
+func (l *SceneFileAreaLight) build(toWorking imageio.Matrix3) (Light, error) {
+	radiance := NewVec3(1, 1, 1)
+	if l.Color != nil {
+		radiance = vec3From(*l.Color)
+	}
+	radiance = ConvertColor(toWorking, radiance.Scale(l.Intensity))
+
+	transform := Translate(vec3From(l.Position))
+	if l.Rotation != nil {
+		q := Quat{X: l.Rotation[0], Y: l.Rotation[1], Z: l.Rotation[2], W: l.Rotation[3]}
+		transform = transform.Mul(q.Matrix())
+	}
+
+	switch l.Type {
+	case "quad":
+		if l.Size[0] <= 0 || l.Size[1] <= 0 {
+			return nil, fmt.Errorf("quad light needs a positive size")
+		}
+		quad := NewQuadLight(transform, l.Size[0], l.Size[1], radiance)
+		quad.TwoSided = l.TwoSided
+		return quad, nil
+	case "disk":
+		if l.Radius <= 0 {
+			return nil, fmt.Errorf("disk light needs a positive radius")
+		}
+		disk := NewDiskLight(transform, l.Radius, radiance)
+		disk.TwoSided = l.TwoSided
+		return disk, nil
+	case "sphere":
+		if l.Radius <= 0 {
+			return nil, fmt.Errorf("sphere light needs a positive radius")
+		}
+		return &SphereLight{Center: vec3From(l.Position), Radius: l.Radius, Radiance: radiance}, nil
+	default:
+		return nil, fmt.Errorf("unknown light type %q", l.Type)
+	}
+}
+
+type SceneFileGeometry struct {
+	Name      string             `json:"name"`
+	Primitive string             `json:"primitive"` // "sphere", "plane" or "cube" when no mesh is given
+	Radius    float64            `json:"radius"`
+	Size      [3]float64         `json:"size"`
+	Mesh      *SceneFileMesh     `json:"mesh"`
+	Position  [3]float64         `json:"position"`
+	Rotation  *[4]float64        `json:"rotation"` // quaternion (x, y, z, w)
+	Scale     *[3]float64        `json:"scale"`
+	Hidden    bool               `json:"hidden"`
+	Material  *SceneFileMaterial `json:"material"`
+	Motion    *SceneFileMotion   `json:"motion"` // pose at shutter close
+
+	// Medium fills the inside of the mesh, which must be closed. With NullSurface
+	// the surface is invisible and only bounds the medium. A geometry with a grid
+	// medium and neither mesh nor primitive is a box around the grid.
+	Medium      *SceneFileMedium `json:"medium"`
+	NullSurface bool             `json:"nullSurface"`
+}
+
+// SceneFileMedium is a homogeneous medium, or a heterogeneous one read from a voxel
+// grid file. Absorption and scattering are per unit length and scaled by density,
+// and by the grid's density channel too for a grid.
+type SceneFileMedium struct {
+	Absorption *[3]float64 `json:"absorption"`
+	Scattering *[3]float64 `json:"scattering"`
+	Density    *float64    `json:"density"` // 1 when missing
+	G          float64     `json:"g"`       // Henyey-Greenstein asymmetry, -1 to 1
+
+	// Grid is the path of a voxel grid file. Its voxels lie in the grid's own space,
+	// placed by the geometry's transform. Emission is the color emitted per unit of
+	// the grid's emission channel and Blackbody the luminance of its temperature
+	// channel at 1500 K, with TemperatureScale converting the channel to Kelvin.
+	Grid             string      `json:"grid"`
+	Emission         *[3]float64 `json:"emission"`
+	Blackbody        float64     `json:"blackbody"`
+	TemperatureScale *float64    `json:"temperatureScale"` // 1 when missing
+
+	// Bounds limits the fog to a box, given as its min and max corners. Without it
+	// the fog fills the bounds of the geometries.
+	Bounds *[2][3]float64 `json:"bounds"`
+}
+
+// build loads a grid relative to dir and converts its emission into the working
+// space. A missing medium builds as none.
+func (sm *SceneFileMedium) build(dir string, space imageio.ColorSpace) (Medium, error) {
+	if sm == nil {
+		return nil, nil
+	}
+	density := 1.0
+	if sm.Density != nil {
+		density = *sm.Density
+	}
+	var sigmaA, sigmaS Vec3
+	if sm.Absorption != nil {
+		sigmaA = vec3From(*sm.Absorption).Scale(density)
+	}
+	if sm.Scattering != nil {
+		sigmaS = vec3From(*sm.Scattering).Scale(density)
+	}
+	if sigmaA.Min(sigmaS).Min(Vec3{}) != (Vec3{}) {
+		return nil, fmt.Errorf("medium coefficients must not be negative")
+	}
+	if sm.G <= -1 || sm.G >= 1 {
+		return nil, fmt.Errorf("medium g %v is outside (-1, 1)", sm.G)
+	}
+	if sm.Grid == "" {
+		return &HomogeneousMedium{SigmaA: sigmaA, SigmaS: sigmaS, G: sm.G}, nil
+	}
+
+	path := sm.Grid
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	grid, err := LoadVoxelGrid(path)
+	if err != nil {
+		return nil, err
+	}
+	m := NewGridMedium(grid)
+	if sm.Absorption != nil || sm.Scattering != nil {
+		m.SigmaA, m.SigmaS = sigmaA, sigmaS
+	} else {
+		m.SigmaS = m.SigmaS.Scale(density)
+	}
+	m.G = sm.G
+	if sm.Emission != nil {
+		m.Emission = vec3From(*sm.Emission)
+	}
+	if sm.Blackbody < 0 {
+		return nil, fmt.Errorf("medium blackbody %v must not be negative", sm.Blackbody)
+	}
+	m.BlackbodyScale = sm.Blackbody
+	if sm.TemperatureScale != nil {
+		m.TemperatureScale = *sm.TemperatureScale
+	}
+	return m.InSpace(space), nil
+}
+
+// SceneFileMotion is where a geometry ends up when the shutter closes. The tracer
+// blurs it along the way from its position, rotation and scale at shutter open.
+type SceneFileMotion struct {
+	Position *[3]float64 `json:"position"`
+	Rotation *[4]float64 `json:"rotation"` // quaternion (x, y, z, w)
+	Scale    *[3]float64 `json:"scale"`
+}
+
+// SceneFileMaterial overrides fields of DefaultMaterial; missing fields keep their default.
+type SceneFileMaterial struct {
+	BaseColor        *[3]float64 `json:"baseColor"`
+	Metallic         *float64    `json:"metallic"`
+	Roughness        *float64    `json:"roughness"`
+	Emission         *[3]float64 `json:"emission"`
+	EmissionStrength *float64    `json:"emissionStrength"`
+	IOR              *float64    `json:"ior"`
+	Transmission     *float64    `json:"transmission"`
+	Conductor        *string     `json:"conductor"` // key of ConductorPresets, e.g. "gold"
+	Subsurface       *float64    `json:"subsurface"`
+	SubsurfaceRadius *[3]float64 `json:"subsurfaceRadius"` // mean free path per channel
+	SubsurfaceScale  *float64    `json:"subsurfaceScale"`
+}
+
+// Build returns DefaultMaterial with the fields that are set applied. The live link
+// uses it for materials sent along with meshes.
+func (sm *SceneFileMaterial) Build() (*Material, error) {
+	m := DefaultMaterial()
+	if sm == nil {
+		return m, nil
+	}
+	if sm.BaseColor != nil {
+		m.BaseColor = vec3From(*sm.BaseColor)
+	}
+	if sm.Metallic != nil {
+		m.Metallic = *sm.Metallic
+	}
+	if sm.Roughness != nil {
+		m.Roughness = *sm.Roughness
+	}
+	if sm.Emission != nil {
+		m.Emission = vec3From(*sm.Emission)
+		if sm.EmissionStrength == nil {
+			m.EmissionStrength = 1
+		}
+	}
+	if sm.EmissionStrength != nil {
+		m.EmissionStrength = *sm.EmissionStrength
+	}
+	if sm.IOR != nil {
+		m.IOR = *sm.IOR
+	}
+	if sm.Transmission != nil {
+		m.Transmission = *sm.Transmission
+	}
+	if sm.Conductor != nil {
+		if _, ok := ConductorPresets[*sm.Conductor]; !ok {
+			return nil, fmt.Errorf("unknown conductor %q", *sm.Conductor)
+		}
+		m.Conductor = *sm.Conductor
+	}
+	if sm.Subsurface != nil {
+		m.Subsurface = *sm.Subsurface
+	}
+	if sm.SubsurfaceRadius != nil {
+		m.SubsurfaceRadius = vec3From(*sm.SubsurfaceRadius)
+	}
+	if sm.SubsurfaceScale != nil {
+		m.SubsurfaceScale = *sm.SubsurfaceScale
+	}
+	if m.SubsurfaceRadius.Min(Vec3{}) != (Vec3{}) || m.SubsurfaceScale < 0 {
+		return nil, fmt.Errorf("subsurface radius and scale must not be negative")
+	}
+	return m, nil
+}
+
+type SceneFileMesh struct {
+	Vertices [][3]float64 `json:"vertices"`
+	Normals  [][3]float64 `json:"normals"`
+	Indices  []int32      `json:"indices"`
+}
+
+// LoadSceneFile reads a JSON scene description into a tracer scene.
+func LoadSceneFile(path string) (*Scene, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sf SceneFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, fmt.Errorf("parse scene %s: %v", path, err)
+	}
+	sf.Dir = filepath.Dir(path)
+	return sf.Build()
+}
+
+// Build creates the tracer scene described by the file.
+func (sf *SceneFile) Build() (*Scene, error) {
+	scene := NewScene()
+	if sf.WorkingSpace != "" {
+		space, err := imageio.ParseColorSpace(sf.WorkingSpace)
+		if err != nil {
+			return nil, err
+		}
+		scene.WorkingSpace = space
+	}
+	toWorking := scene.WorkingSpace.FromLinearSRGB()
+
+	if sf.Camera.Fovy > 0 {
+		scene.Camera = Camera{
+			Position: vec3From(sf.Camera.Position),
+			Target:   vec3From(sf.Camera.Target),
+			Up:       vec3From(sf.Camera.Up),
+			Fovy:     sf.Camera.Fovy,
+		}
+		if scene.Camera.Up.IsBlack() {
+			scene.Camera.Up = NewVec3(0, 1, 0)
+		}
+	}
+	if p, ok := sf.Camera.physical(scene.Camera.Fovy); ok {
+		p.Apply(&scene.Camera)
+	}
+
+	if sf.Light != nil {
+		dir := vec3From(sf.Light.Target).Sub(vec3From(sf.Light.Position))
+		if dir.IsBlack() {
+			return nil, fmt.Errorf("light position and target coincide")
+		}
+		irradiance := NewVec3(math.Pi, math.Pi, math.Pi)
+		if sf.Light.Irradiance != nil {
+			irradiance = vec3From(*sf.Light.Irradiance)
+		}
+		scene.Sun = &DirectionalLight{Direction: dir.Normalize(), Irradiance: ConvertColor(toWorking, irradiance)}
+	}
+
+	if sf.Sky != nil {
+		scene.Sky = vec3From(*sf.Sky)
+	}
+	scene.Sky = ConvertColor(toWorking, scene.Sky)
+
+	if env := sf.Environment; env != nil {
+		path := env.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(sf.Dir, path)
+		}
+		m, err := LoadEnvironmentMap(path)
+		if err != nil {
+			return nil, fmt.Errorf("environment: %v", err)
+		}
+		scene.Environment = NewEnvironmentLight(m.InSpace(scene.WorkingSpace))
+		scene.Environment.Rotation = env.Rotation
+		if env.Intensity != nil {
+			scene.Environment.Intensity = *env.Intensity
+		}
+	}
+
+	if sf.Fog != nil {
+		fog, err := sf.Fog.build(sf.Dir, scene.WorkingSpace)
+		if err != nil {
+			return nil, fmt.Errorf("fog: %v", err)
+		}
+		scene.Medium = fog
+		if b := sf.Fog.Bounds; b != nil {
+			scene.MediumBounds = EmptyAABB().Extend(vec3From(b[0])).Extend(vec3From(b[1]))
+		}
+	}
+
+	for i, l := range sf.Lights {
+		light, err := l.build(toWorking)
+		if err != nil {
+			return nil, fmt.Errorf("light %d: %v", i, err)
+		}
+		scene.Lights = append(scene.Lights, light)
+	}
+
+	for i, g := range sf.Geometries {
+		if g.Hidden {
+			continue
+		}
+		interior, err := g.Medium.build(sf.Dir, scene.WorkingSpace)
+		if err != nil {
+			return nil, fmt.Errorf("geometry %d (%s): %v", i, g.Name, err)
+		}
+		mesh, err := g.buildMesh(interior)
+		if err != nil {
+			return nil, fmt.Errorf("geometry %d (%s): %v", i, g.Name, err)
+		}
+		material, err := g.Material.Build()
+		if err != nil {
+			return nil, fmt.Errorf("geometry %d (%s): %v", i, g.Name, err)
+		}
+		obj := NewObject(g.Name, mesh, g.pose().Matrix())
+		if g.Motion != nil {
+			obj.SetMotion(g.pose(), g.closePose(), Identity())
+		}
+		obj.Material = material.InSpace(scene.WorkingSpace)
+		obj.Interior, obj.NullSurface = interior, g.NullSurface
+		scene.AddObject(obj)
+	}
+	return scene, nil
+}
+
+// buildMesh builds the geometry's mesh, a box around the grid of a grid interior
+// when there is no other.
+func (g *SceneFileGeometry) buildMesh(interior Medium) (*Mesh, error) {
+	if g.Mesh != nil {
+		if len(g.Mesh.Vertices) == 0 || len(g.Mesh.Indices) == 0 || len(g.Mesh.Indices)%3 != 0 {
+			return nil, fmt.Errorf("invalid mesh: %d vertices, %d indices", len(g.Mesh.Vertices), len(g.Mesh.Indices))
+		}
+		m := &Mesh{
+			Positions: make([]Vec3, len(g.Mesh.Vertices)),
+			Indices:   g.Mesh.Indices,
+		}
+		for i, v := range g.Mesh.Vertices {
+			m.Positions[i] = vec3From(v)
+		}
+		if len(g.Mesh.Normals) == len(g.Mesh.Vertices) {
+			m.Normals = make([]Vec3, len(g.Mesh.Normals))
+			for i, n := range g.Mesh.Normals {
+				m.Normals[i] = vec3From(n)
+			}
+		}
+		for _, idx := range m.Indices {
+			if idx < 0 || int(idx) >= len(m.Positions) {
+				return nil, fmt.Errorf("index %d out of range", idx)
+			}
+		}
+		return m, nil
+	}
+
+	switch g.Primitive {
+	case "sphere":
+		radius := g.Radius
+		if radius == 0 {
+			radius = 1
+		}
+		return NewSphereMesh(radius, 20, 20), nil
+	case "plane":
+		width, length := g.Size[0], g.Size[2]
+		if width == 0 || length == 0 {
+			width, length = 10, 10
+		}
+		return NewPlaneMesh(width, length, 10, 10), nil
+	case "cube":
+		size := vec3From(g.Size)
+		if size.IsBlack() {
+			size = NewVec3(1, 1, 1)
+		}
+		return NewCubeMesh(size.X, size.Y, size.Z), nil
+	case "":
+		if grid, ok := interior.(*GridMedium); ok {
+			return NewBoxMesh(grid.Grid.Bounds), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown primitive %q", g.Primitive)
+}
+
+// pose returns the geometry's transform at shutter open.
+func (g *SceneFileGeometry) pose() TRS {
+	t := TRS{Translation: vec3From(g.Position), Rotation: Quat{W: 1}, Scale: NewVec3(1, 1, 1)}
+	if g.Rotation != nil {
+		t.Rotation = quatFrom(*g.Rotation)
+	}
+	if g.Scale != nil {
+		t.Scale = vec3From(*g.Scale)
+	}
+	return t
+}
+
+// closePose returns the transform at shutter close, fields missing from the motion
+// keep their shutter open value.
+func (g *SceneFileGeometry) closePose() TRS {
+	t := g.pose()
+	if g.Motion.Position != nil {
+		t.Translation = vec3From(*g.Motion.Position)
+	}
+	if g.Motion.Rotation != nil {
+		t.Rotation = quatFrom(*g.Motion.Rotation)
+	}
+	if g.Motion.Scale != nil {
+		t.Scale = vec3From(*g.Motion.Scale)
+	}
+	return t
+}
+
+func quatFrom(q [4]float64) Quat {
+	return Quat{X: q[0], Y: q[1], Z: q[2], W: q[3]}
+}
+
+func vec3From(v [3]float64) Vec3 {
+	return NewVec3(v[0], v[1], v[2])
+}
@@ -0,0 +1,121 @@
+package tracer
+
+import "math"
+
+// Vec3 is used for points, directions and linear RGB radiance alike.
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+func NewVec3(x, y, z float64) Vec3 {
+	return Vec3{X: x, Y: y, Z: z}
+}
+
+func (a Vec3) Add(b Vec3) Vec3 {
+	return Vec3{a.X + b.X, a.Y + b.Y, a.Z + b.Z}
+}
+
+func (a Vec3) Sub(b Vec3) Vec3 {
+	return Vec3{a.X - b.X, a.Y - b.Y, a.Z - b.Z}
+}
+
+// Mul multiplies component-wise, mostly used to filter radiance by a color.
+func (a Vec3) Mul(b Vec3) Vec3 {
+	return Vec3{a.X * b.X, a.Y * b.Y, a.Z * b.Z}
+}
+
+func (a Vec3) Scale(s float64) Vec3 {
+	return Vec3{a.X * s, a.Y * s, a.Z * s}
+}
+
+func (a Vec3) Neg() Vec3 {
+	return Vec3{-a.X, -a.Y, -a.Z}
+}
+
+func (a Vec3) Dot(b Vec3) float64 {
+	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
+}
+
+func (a Vec3) Cross(b Vec3) Vec3 {
+	return Vec3{
+		a.Y*b.Z - a.Z*b.Y,
+		a.Z*b.X - a.X*b.Z,
+		a.X*b.Y - a.Y*b.X,
+	}
+}
+
+func (a Vec3) LengthSquared() float64 {
+	return a.Dot(a)
+}
+
+func (a Vec3) Length() float64 {
+	return math.Sqrt(a.Dot(a))
+}
+
+func (a Vec3) Normalize() Vec3 {
+	l := a.Length()
+	if l == 0 {
+		return a
+	}
+	return a.Scale(1 / l)
+}
+
+func (a Vec3) Min(b Vec3) Vec3 {
+	return Vec3{math.Min(a.X, b.X), math.Min(a.Y, b.Y), math.Min(a.Z, b.Z)}
+}
+
+func (a Vec3) Max(b Vec3) Vec3 {
+	return Vec3{math.Max(a.X, b.X), math.Max(a.Y, b.Y), math.Max(a.Z, b.Z)}
+}
+
+// Axis returns the component for axis 0 (X), 1 (Y) or 2 (Z).
+func (a Vec3) Axis(i int) float64 {
+	switch i {
+	case 0:
+		return a.X
+	case 1:
+		return a.Y
+	}
+	return a.Z
+}
+
+func (a Vec3) MaxComponent() float64 {
+	return math.Max(a.X, math.Max(a.Y, a.Z))
+}
+
+// Luminance uses the Rec.709 weights of the linear sRGB working space.
+func (a Vec3) Luminance() float64 {
+	return 0.2126*a.X + 0.7152*a.Y + 0.0722*a.Z
+}
+
+func (a Vec3) IsBlack() bool {
+	return a.X == 0 && a.Y == 0 && a.Z == 0
+}
+
+func Lerp(a, b Vec3, t float64) Vec3 {
+	return a.Scale(1 - t).Add(b.Scale(t))
+}
+
+// Frame is an orthonormal basis used to move directions in and out of shading space,
+// where the normal is the local Z axis.
+type Frame struct {
+	T, B, N Vec3
+}
+
+// NewFrame builds a basis around n (Duff et al. branchless construction).
+func NewFrame(n Vec3) Frame {
+	sign := math.Copysign(1, n.Z)
+	a := -1 / (sign + n.Z)
+	b := n.X * n.Y * a
+	t := Vec3{1 + sign*n.X*n.X*a, sign * b, -sign * n.X}
+	bt := Vec3{b, sign + n.Y*n.Y*a, -n.Y}
+	return Frame{T: t, B: bt, N: n}
+}
+
+func (f Frame) ToLocal(v Vec3) Vec3 {
+	return Vec3{v.Dot(f.T), v.Dot(f.B), v.Dot(f.N)}
+}
+
+func (f Frame) ToWorld(v Vec3) Vec3 {
+	return f.T.Scale(v.X).Add(f.B.Scale(v.Y)).Add(f.N.Scale(v.Z))
+}
@@ -0,0 +1,431 @@
+package tracer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// GridChannel names one of the values stored per voxel.
+type GridChannel int
+
+const (
+	GridDensity     GridChannel = iota // scales the medium's coefficients
+	GridTemperature                    // in Kelvin, glows as a blackbody
+	GridEmission                       // scales the medium's emission color
+	gridChannelCount
+)
+
+func (c GridChannel) String() string {
+	switch c {
+	case GridDensity:
+		return "density"
+	case GridTemperature:
+		return "temperature"
+	case GridEmission:
+		return "emission"
+	}
+	return fmt.Sprintf("GridChannel(%d)", int(c))
+}
+
+// DefaultBrickSize is the edge length of a brick in voxels.
+const DefaultBrickSize = 8
+
+// VoxelGrid is a sparse voxel grid stored as a brick map: the grid is cut into
+// cubic bricks and only bricks holding a non-zero value are allocated. Voxels fill
+// Bounds evenly, with their values at the voxel centers.
+type VoxelGrid struct {
+	Resolution [3]int // voxels along each axis
+	BrickSize  int
+	Bounds     AABB
+
+	bricks  [3]int   // bricks along each axis
+	index   []int32  // brick slot of every brick cell, -1 when empty
+	storage []*brick // allocated bricks
+}
+
+// brick holds one channel per slice, nil for a channel that is zero throughout.
+type brick struct {
+	coord    [3]int
+	channels [gridChannelCount][]float32
+}
+
+// NewVoxelGrid returns an empty grid of the given resolution filling bounds.
+func NewVoxelGrid(resolution [3]int, bounds AABB) *VoxelGrid {
+	return newVoxelGrid(resolution, DefaultBrickSize, bounds)
+}
+
+func newVoxelGrid(resolution [3]int, brickSize int, bounds AABB) *VoxelGrid {
+	g := &VoxelGrid{Resolution: resolution, BrickSize: brickSize, Bounds: bounds}
+	cells := 1
+	for i, n := range resolution {
+		g.bricks[i] = (n + brickSize - 1) / brickSize
+		cells *= g.bricks[i]
+	}
+	g.index = make([]int32, cells)
+	for i := range g.index {
+		g.index[i] = -1
+	}
+	return g
+}
+
+// BrickCount returns the number of allocated bricks.
+func (g *VoxelGrid) BrickCount() int {
+	return len(g.storage)
+}
+
+// VoxelSize returns the extent of one voxel.
+func (g *VoxelGrid) VoxelSize() Vec3 {
+	d := g.Bounds.Diagonal()
+	return NewVec3(d.X/float64(g.Resolution[0]), d.Y/float64(g.Resolution[1]), d.Z/float64(g.Resolution[2]))
+}
+
+func (g *VoxelGrid) brickCell(bx, by, bz int) int {
+	return (bz*g.bricks[1]+by)*g.bricks[0] + bx
+}
+
+func (g *VoxelGrid) inside(x, y, z int) bool {
+	return x >= 0 && y >= 0 && z >= 0 && x < g.Resolution[0] && y < g.Resolution[1] && z < g.Resolution[2]
+}
+
+// Voxel returns a channel's value at voxel (x, y, z), zero outside the grid.
+func (g *VoxelGrid) Voxel(c GridChannel, x, y, z int) float32 {
+	if !g.inside(x, y, z) {
+		return 0
+	}
+	bs := g.BrickSize
+	slot := g.index[g.brickCell(x/bs, y/bs, z/bs)]
+	if slot < 0 {
+		return 0
+	}
+	values := g.storage[slot].channels[c]
+	if values == nil {
+		return 0
+	}
+	return values[((z%bs)*bs+y%bs)*bs+x%bs]
+}
+
+// Set stores a channel's value at voxel (x, y, z), allocating its brick on the
+// first non-zero value. Voxels outside the grid are ignored.
+func (g *VoxelGrid) Set(c GridChannel, x, y, z int, v float32) {
+	if !g.inside(x, y, z) {
+		return
+	}
+	bs := g.BrickSize
+	cell := g.brickCell(x/bs, y/bs, z/bs)
+	slot := g.index[cell]
+	if slot < 0 {
+		if v == 0 {
+			return
+		}
+		slot = int32(len(g.storage))
+		g.index[cell] = slot
+		g.storage = append(g.storage, &brick{coord: [3]int{x / bs, y / bs, z / bs}})
+	}
+	b := g.storage[slot]
+	if b.channels[c] == nil {
+		if v == 0 {
+			return
+		}
+		b.channels[c] = make([]float32, bs*bs*bs)
+	}
+	b.channels[c][((z%bs)*bs+y%bs)*bs+x%bs] = v
+}
+
+// HasChannel reports whether any brick stores the channel.
+func (g *VoxelGrid) HasChannel(c GridChannel) bool {
+	for _, b := range g.storage {
+		if b.channels[c] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Sample interpolates a channel trilinearly at p, a point in the grid's space.
+func (g *VoxelGrid) Sample(c GridChannel, p Vec3) float64 {
+	size := g.VoxelSize()
+	q := p.Sub(g.Bounds.Min)
+	u := [3]float64{q.X/size.X - 0.5, q.Y/size.Y - 0.5, q.Z/size.Z - 0.5}
+	var i [3]int
+	var f [3]float64
+	for a := range u {
+		fl := math.Floor(u[a])
+		i[a], f[a] = int(fl), u[a]-fl
+	}
+	v := 0.0
+	for corner := 0; corner < 8; corner++ {
+		w := 1.0
+		var at [3]int
+		for a := 0; a < 3; a++ {
+			if corner>>a&1 == 1 {
+				at[a], w = i[a]+1, w*f[a]
+			} else {
+				at[a], w = i[a], w*(1-f[a])
+			}
+		}
+		if w > 0 {
+			v += w * float64(g.Voxel(c, at[0], at[1], at[2]))
+		}
+	}
+	return v
+}
+
+// brickMaxima returns, for every brick cell, the largest value of a channel that
+// interpolation can produce inside it. Interpolation reaches half a voxel into the
+// neighbouring bricks, so each cell takes the maximum over its neighbours too.
+func (g *VoxelGrid) brickMaxima(c GridChannel) []float64 {
+	own := make([]float64, len(g.index))
+	for _, b := range g.storage {
+		m := 0.0
+		for _, v := range b.channels[c] {
+			m = math.Max(m, float64(v))
+		}
+		own[g.brickCell(b.coord[0], b.coord[1], b.coord[2])] = m
+	}
+	maxima := make([]float64, len(g.index))
+	for _, b := range g.storage {
+		m := own[g.brickCell(b.coord[0], b.coord[1], b.coord[2])]
+		if m == 0 {
+			continue
+		}
+		for dz := -1; dz <= 1; dz++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					x, y, z := b.coord[0]+dx, b.coord[1]+dy, b.coord[2]+dz
+					if x < 0 || y < 0 || z < 0 || x >= g.bricks[0] || y >= g.bricks[1] || z >= g.bricks[2] {
+						continue
+					}
+					cell := g.brickCell(x, y, z)
+					maxima[cell] = math.Max(maxima[cell], m)
+				}
+			}
+		}
+	}
+	return maxima
+}
+
+// Voxel grid files hold a VoxelGrid as written by WriteVoxelGrid. All values are
+// little-endian:
+//
+//	magic        "VGRD"
+//	version      uint32, 1
+//	resolution   3 x uint32, voxels along x, y and z
+//	brick size   uint32, edge length of a brick in voxels
+//	bounds       6 x float32, min x, y, z then max x, y, z of the box the voxels fill
+//	channels     uint32, bit mask of the stored channels: 1 density, 2 temperature,
+//	             4 emission
+//	brick count  uint32
+//
+// followed by the bricks, each as
+//
+//	coordinate   3 x uint32, brick position in bricks along x, y and z
+//	values       brick size^3 float32 per stored channel, in mask bit order, with x
+//	             varying fastest, then y, then z
+//
+// Bricks that are not listed are zero. Voxels of edge bricks beyond the resolution
+// are stored but ignored.
+const voxelGridMagic = "VGRD"
+
+const voxelGridVersion = 1
+
+// maxVoxelGridSide and maxVoxelGridCells bound the resolution a file may claim, per
+// side and in voxels, so a corrupt header cannot make the reader allocate without
+// limit. Bricks are only allocated as their data is read, and are checked against
+// the length of the file first when it is known.
+const (
+	maxVoxelGridSide  = 1 << 14
+	maxVoxelGridCells = 1 << 27
+)
+
+// LoadVoxelGrid reads a voxel grid file from disk.
+func LoadVoxelGrid(path string) (*VoxelGrid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readVoxelGrid(f, info.Size())
+}
+
+// ReadVoxelGrid decodes a voxel grid file.
+func ReadVoxelGrid(r io.Reader) (*VoxelGrid, error) {
+	size := int64(-1)
+	if lr, ok := r.(interface{ Len() int }); ok {
+		size = int64(lr.Len())
+	}
+	return readVoxelGrid(r, size)
+}
+
+// readVoxelGrid decodes a voxel grid file of size bytes, -1 when not known.
+func readVoxelGrid(r io.Reader, size int64) (*VoxelGrid, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("voxel grid: read header: %v", err)
+	}
+	if string(magic) != voxelGridMagic {
+		return nil, fmt.Errorf("voxel grid: not a voxel grid file")
+	}
+	var header struct {
+		Version    uint32
+		Resolution [3]uint32
+		BrickSize  uint32
+		Bounds     [6]float32
+		Channels   uint32
+		Bricks     uint32
+	}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("voxel grid: read header: %v", err)
+	}
+	if header.Version != voxelGridVersion {
+		return nil, fmt.Errorf("voxel grid: unsupported version %d", header.Version)
+	}
+	if header.Channels == 0 || header.Channels >= 1<<gridChannelCount {
+		return nil, fmt.Errorf("voxel grid: invalid channel mask %#x", header.Channels)
+	}
+	var resolution [3]int
+	voxels := 1
+	for i, n := range header.Resolution {
+		if n == 0 || n > maxVoxelGridSide {
+			return nil, fmt.Errorf("voxel grid: invalid resolution %v", header.Resolution)
+		}
+		resolution[i] = int(n)
+		voxels *= int(n)
+	}
+	if voxels > maxVoxelGridCells {
+		return nil, fmt.Errorf("voxel grid: resolution %v is too large", header.Resolution)
+	}
+	bs := int(header.BrickSize)
+	if bs == 0 || bs > 64 {
+		return nil, fmt.Errorf("voxel grid: invalid brick size %d", bs)
+	}
+	cells := 1
+	for _, n := range resolution {
+		cells *= (n + bs - 1) / bs
+	}
+	if cells > maxVoxelGridCells || int(header.Bricks) > cells {
+		return nil, fmt.Errorf("voxel grid: %d bricks in a grid of %d", header.Bricks, cells)
+	}
+	values := bs * bs * bs
+	channels := 0
+	for c := GridChannel(0); c < gridChannelCount; c++ {
+		if header.Channels&(1<<c) != 0 {
+			channels++
+		}
+	}
+	b := header.Bounds
+	bounds := AABB{
+		Min: NewVec3(float64(b[0]), float64(b[1]), float64(b[2])),
+		Max: NewVec3(float64(b[3]), float64(b[4]), float64(b[5])),
+	}
+	if !(bounds.Min.X < bounds.Max.X && bounds.Min.Y < bounds.Max.Y && bounds.Min.Z < bounds.Max.Z) {
+		return nil, fmt.Errorf("voxel grid: invalid bounds %v", b)
+	}
+
+	if size >= 0 {
+		brickSize := int64(12 + channels*values*4)
+		if remaining := size - int64(len(voxelGridMagic)+binary.Size(header)); int64(header.Bricks)*brickSize > remaining {
+			return nil, fmt.Errorf("voxel grid: %d bricks do not fit in %d bytes", header.Bricks, remaining)
+		}
+	}
+
+	g := newVoxelGrid(resolution, bs, bounds)
+	raw := make([]byte, values*4)
+	for n := 0; n < int(header.Bricks); n++ {
+		var coord [3]uint32
+		if err := binary.Read(br, binary.LittleEndian, &coord); err != nil {
+			return nil, fmt.Errorf("voxel grid: read brick %d: %v", n, err)
+		}
+		if int(coord[0]) >= g.bricks[0] || int(coord[1]) >= g.bricks[1] || int(coord[2]) >= g.bricks[2] {
+			return nil, fmt.Errorf("voxel grid: brick %v outside the grid", coord)
+		}
+		cell := g.brickCell(int(coord[0]), int(coord[1]), int(coord[2]))
+		if g.index[cell] >= 0 {
+			return nil, fmt.Errorf("voxel grid: brick %v listed twice", coord)
+		}
+		bk := &brick{coord: [3]int{int(coord[0]), int(coord[1]), int(coord[2])}}
+		for c := GridChannel(0); c < gridChannelCount; c++ {
+			if header.Channels&(1<<c) == 0 {
+				continue
+			}
+			if _, err := io.ReadFull(br, raw); err != nil {
+				return nil, fmt.Errorf("voxel grid: read brick %d: %v", n, err)
+			}
+			ch := make([]float32, values)
+			for i := range ch {
+				v := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+				// A bad value would poison the brick's majorant and every path through it
+				if f := float64(v); math.IsNaN(f) || math.IsInf(f, 0) || c == GridDensity && f < 0 {
+					return nil, fmt.Errorf("voxel grid: brick %v has %v %v", coord, c, v)
+				}
+				ch[i] = v
+			}
+			bk.channels[c] = ch
+		}
+		g.index[cell] = int32(len(g.storage))
+		g.storage = append(g.storage, bk)
+	}
+	return g, nil
+}
+
+// WriteVoxelGrid encodes a grid as a voxel grid file. Every channel any brick holds
+// is stored for all bricks.
+func WriteVoxelGrid(w io.Writer, g *VoxelGrid) error {
+	var mask uint32
+	for c := GridChannel(0); c < gridChannelCount; c++ {
+		if g.HasChannel(c) {
+			mask |= 1 << c
+		}
+	}
+	if mask == 0 {
+		// An empty grid still needs a channel to be a valid file
+		mask = 1 << GridDensity
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(voxelGridMagic)
+	header := []any{
+		uint32(voxelGridVersion),
+		[3]uint32{uint32(g.Resolution[0]), uint32(g.Resolution[1]), uint32(g.Resolution[2])},
+		uint32(g.BrickSize),
+		[6]float32{
+			float32(g.Bounds.Min.X), float32(g.Bounds.Min.Y), float32(g.Bounds.Min.Z),
+			float32(g.Bounds.Max.X), float32(g.Bounds.Max.Y), float32(g.Bounds.Max.Z),
+		},
+		mask,
+		uint32(len(g.storage)),
+	}
+	for _, v := range header {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	values := g.BrickSize * g.BrickSize * g.BrickSize
+	raw := make([]byte, values*4)
+	for _, b := range g.storage {
+		coord := [3]uint32{uint32(b.coord[0]), uint32(b.coord[1]), uint32(b.coord[2])}
+		if err := binary.Write(bw, binary.LittleEndian, coord); err != nil {
+			return err
+		}
+		for c := GridChannel(0); c < gridChannelCount; c++ {
+			if mask&(1<<c) == 0 {
+				continue
+			}
+			clear(raw)
+			for i, v := range b.channels[c] {
+				binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(v))
+			}
+			if _, err := bw.Write(raw); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}